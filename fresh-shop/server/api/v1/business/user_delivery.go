@@ -44,7 +44,7 @@ func (userDeliveryApi *UserDeliveryApi) CreateUserDelivery(c *gin.Context) {
 	}
 	if err := userDeliveryService.CreateUserDelivery(userDelivery); err != nil {
 		global.Log.Error("创建失败!", zap.Error(err))
-		response.FailWithMessage("创建失败", c)
+		response.FailWithMessage(err.Error(), c)
 	} else {
 		response.OkWithMessage("创建成功", c)
 	}
@@ -125,7 +125,7 @@ func (userDeliveryApi *UserDeliveryApi) UpdateUserDelivery(c *gin.Context) {
 	}
 	if err := userDeliveryService.UpdateUserDelivery(userDelivery); err != nil {
 		global.Log.Error("更新失败!", zap.Error(err))
-		response.FailWithMessage("更新失败", c)
+		response.FailWithMessage(err.Error(), c)
 	} else {
 		response.OkWithMessage("更新成功", c)
 	}
@@ -48,6 +48,35 @@ func (cartApi *CartApi) CreateCart(c *gin.Context) {
 	}
 }
 
+// BuyAgain 再次购买 将订单商品重新加入购物车
+// @Tags Cart
+// @Summary 再次购买
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shopReq.BuyAgainReq true "订单id"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /cart/buyAgain [post]
+func (cartApi *CartApi) BuyAgain(c *gin.Context) {
+	var req shopReq.BuyAgainReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if req.OrderId == 0 {
+		response.FailWithMessage("参数错误", c)
+		return
+	}
+	userId := utils.GetUserID(c)
+	if resp, err := cartService.BuyAgain(req.OrderId, userId); err != nil {
+		global.Log.Error("再次购买失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithData(resp, c)
+	}
+}
+
 // DeleteCart 删除Cart
 // @Tags Cart
 // @Summary 删除Cart
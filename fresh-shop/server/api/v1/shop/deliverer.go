@@ -0,0 +1,178 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/common/response"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service"
+	"fresh-shop/server/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type DelivererApi struct {
+}
+
+var delivererService = service.ServiceGroupApp.ShopServiceGroup.DelivererService
+
+// GetDeliveryTaskList 送货员分页获取自己的配送任务
+// @Tags Deliverer
+// @Summary 送货员分页获取自己的配送任务
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query shopReq.DeliveryTaskSearch true "分页获取配送任务"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /deliverer/getDeliveryTaskList [get]
+func (delivererApi *DelivererApi) GetDeliveryTaskList(c *gin.Context) {
+	var pageInfo shopReq.DeliveryTaskSearch
+	err := c.ShouldBindQuery(&pageInfo)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if list, total, err := delivererService.GetDeliveryTaskList(utils.GetUserID(c), pageInfo); err != nil {
+		global.Log.Error("获取失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithDetailed(response.PageResult{
+			List:     list,
+			Total:    total,
+			Page:     pageInfo.Page,
+			PageSize: pageInfo.PageSize,
+		}, "获取成功", c)
+	}
+}
+
+// FindDeliveryTask 送货员查看配送任务的收货信息和订单详情
+// @Tags Deliverer
+// @Summary 送货员查看配送任务
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query request.GetById true "配送单id"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"查询成功"}"
+// @Router /deliverer/findDeliveryTask [get]
+func (delivererApi *DelivererApi) FindDeliveryTask(c *gin.Context) {
+	var req request.GetById
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if task, err := delivererService.GetDeliveryTask(utils.GetUserID(c), req.Uint()); err != nil {
+		global.Log.Error("查询失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithData(gin.H{"task": task}, c)
+	}
+}
+
+// PickUpDelivery 送货员确认取货
+// @Tags Deliverer
+// @Summary 送货员确认取货
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body request.GetById true "配送单id"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"取货成功"}"
+// @Router /deliverer/pickUpDelivery [post]
+func (delivererApi *DelivererApi) PickUpDelivery(c *gin.Context) {
+	var req request.GetById
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := delivererService.PickUpDelivery(utils.GetUserID(c), req.Uint()); err != nil {
+		global.Log.Error("确认取货失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithMessage("取货成功", c)
+	}
+}
+
+// ArriveDelivery 送货员确认到达收货地址
+// @Tags Deliverer
+// @Summary 送货员确认到达
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body request.GetById true "配送单id"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"已到达"}"
+// @Router /deliverer/arriveDelivery [post]
+func (delivererApi *DelivererApi) ArriveDelivery(c *gin.Context) {
+	var req request.GetById
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := delivererService.ArriveDelivery(utils.GetUserID(c), req.Uint()); err != nil {
+		global.Log.Error("确认到达失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithMessage("已到达", c)
+	}
+}
+
+// DeliverDelivery 送货员确认送达 订单确认收货并发放赠送积分
+// @Tags Deliverer
+// @Summary 送货员确认送达
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body request.GetById true "配送单id"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"已送达"}"
+// @Router /deliverer/deliverDelivery [post]
+func (delivererApi *DelivererApi) DeliverDelivery(c *gin.Context) {
+	var req request.GetById
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := delivererService.DeliverDelivery(utils.GetUserID(c), req.Uint()); err != nil {
+		global.Log.Error("确认送达失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithMessage("已送达", c)
+	}
+}
+
+// UploadDeliveryProof 送货员上传送达凭证
+// @Tags Deliverer
+// @Summary 送货员上传送达凭证
+// @Security ApiKeyAuth
+// @accept multipart/form-data
+// @Produce application/json
+// @Param id formData int true "配送单id"
+// @Param longitude formData number true "经度"
+// @Param latitude formData number true "纬度"
+// @Param photo formData file true "包裹照片"
+// @Param sign formData file false "收货人签名"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"上传成功"}"
+// @Router /deliverer/uploadDeliveryProof [post]
+func (delivererApi *DelivererApi) UploadDeliveryProof(c *gin.Context) {
+	var req shopReq.DeliveryProofReq
+	err := c.ShouldBind(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	_, photo, err := c.Request.FormFile("photo")
+	if err != nil {
+		global.Log.Error("接收文件失败!", zap.Error(err))
+		response.FailWithMessage("请上传包裹照片", c)
+		return
+	}
+	// 签名为可选项
+	_, sign, _ := c.Request.FormFile("sign")
+	if delivery, err := delivererService.UploadDeliveryProof(utils.GetUserID(c), req, photo, sign); err != nil {
+		global.Log.Error("上传送达凭证失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithDetailed(gin.H{"delivery": delivery}, "上传成功", c)
+	}
+}
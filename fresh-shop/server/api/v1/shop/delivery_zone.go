@@ -0,0 +1,207 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/common/response"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service"
+	"fresh-shop/server/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type DeliveryZoneApi struct {
+}
+
+var deliveryZoneService = service.ServiceGroupApp.ShopServiceGroup.DeliveryZoneService
+
+// CreateDeliveryZone 创建DeliveryZone
+// @Tags DeliveryZone
+// @Summary 创建DeliveryZone
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shop.DeliveryZone true "创建DeliveryZone"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /deliveryZone/createDeliveryZone [post]
+func (deliveryZoneApi *DeliveryZoneApi) CreateDeliveryZone(c *gin.Context) {
+	var deliveryZone shop.DeliveryZone
+	err := c.ShouldBindJSON(&deliveryZone)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	verify := utils.Rules{
+		"Name": {utils.NotEmpty()},
+		"Type": {utils.NotEmpty()},
+	}
+	if err := utils.Verify(deliveryZone, verify); err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := deliveryZoneService.CreateDeliveryZone(deliveryZone); err != nil {
+		global.Log.Error("创建失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithMessage("创建成功", c)
+	}
+}
+
+// DeleteDeliveryZone 删除DeliveryZone
+// @Tags DeliveryZone
+// @Summary 删除DeliveryZone
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shop.DeliveryZone true "删除DeliveryZone"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"删除成功"}"
+// @Router /deliveryZone/deleteDeliveryZone [delete]
+func (deliveryZoneApi *DeliveryZoneApi) DeleteDeliveryZone(c *gin.Context) {
+	var deliveryZone shop.DeliveryZone
+	err := c.ShouldBindJSON(&deliveryZone)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := deliveryZoneService.DeleteDeliveryZone(deliveryZone); err != nil {
+		global.Log.Error("删除失败!", zap.Error(err))
+		response.FailWithMessage("删除失败", c)
+	} else {
+		response.OkWithMessage("删除成功", c)
+	}
+}
+
+// DeleteDeliveryZoneByIds 批量删除DeliveryZone
+// @Tags DeliveryZone
+// @Summary 批量删除DeliveryZone
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body request.IdsReq true "批量删除DeliveryZone"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"批量删除成功"}"
+// @Router /deliveryZone/deleteDeliveryZoneByIds [delete]
+func (deliveryZoneApi *DeliveryZoneApi) DeleteDeliveryZoneByIds(c *gin.Context) {
+	var IDS request.IdsReq
+	err := c.ShouldBindJSON(&IDS)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := deliveryZoneService.DeleteDeliveryZoneByIds(IDS); err != nil {
+		global.Log.Error("批量删除失败!", zap.Error(err))
+		response.FailWithMessage("批量删除失败", c)
+	} else {
+		response.OkWithMessage("批量删除成功", c)
+	}
+}
+
+// UpdateDeliveryZone 更新DeliveryZone
+// @Tags DeliveryZone
+// @Summary 更新DeliveryZone
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shop.DeliveryZone true "更新DeliveryZone"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"更新成功"}"
+// @Router /deliveryZone/updateDeliveryZone [put]
+func (deliveryZoneApi *DeliveryZoneApi) UpdateDeliveryZone(c *gin.Context) {
+	var deliveryZone shop.DeliveryZone
+	err := c.ShouldBindJSON(&deliveryZone)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	verify := utils.Rules{
+		"Name": {utils.NotEmpty()},
+		"Type": {utils.NotEmpty()},
+	}
+	if err := utils.Verify(deliveryZone, verify); err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := deliveryZoneService.UpdateDeliveryZone(deliveryZone); err != nil {
+		global.Log.Error("更新失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithMessage("更新成功", c)
+	}
+}
+
+// FindDeliveryZone 用id查询DeliveryZone
+// @Tags DeliveryZone
+// @Summary 用id查询DeliveryZone
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query shop.DeliveryZone true "用id查询DeliveryZone"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"查询成功"}"
+// @Router /deliveryZone/findDeliveryZone [get]
+func (deliveryZoneApi *DeliveryZoneApi) FindDeliveryZone(c *gin.Context) {
+	var deliveryZone shop.DeliveryZone
+	err := c.ShouldBindQuery(&deliveryZone)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if redeliveryZone, err := deliveryZoneService.GetDeliveryZone(deliveryZone.ID); err != nil {
+		global.Log.Error("查询失败!", zap.Error(err))
+		response.FailWithMessage("查询失败", c)
+	} else {
+		response.OkWithData(gin.H{"redeliveryZone": redeliveryZone}, c)
+	}
+}
+
+// GetDeliveryZoneList 分页获取DeliveryZone列表
+// @Tags DeliveryZone
+// @Summary 分页获取DeliveryZone列表
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query shopReq.DeliveryZoneSearch true "分页获取DeliveryZone列表"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /deliveryZone/getDeliveryZoneList [get]
+func (deliveryZoneApi *DeliveryZoneApi) GetDeliveryZoneList(c *gin.Context) {
+	var pageInfo shopReq.DeliveryZoneSearch
+	err := c.ShouldBindQuery(&pageInfo)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if list, total, err := deliveryZoneService.GetDeliveryZoneInfoList(pageInfo); err != nil {
+		global.Log.Error("获取失败!", zap.Error(err))
+		response.FailWithMessage("获取失败", c)
+	} else {
+		response.OkWithDetailed(response.PageResult{
+			List:     list,
+			Total:    total,
+			Page:     pageInfo.Page,
+			PageSize: pageInfo.PageSize,
+		}, "获取成功", c)
+	}
+}
+
+// CheckAddress 校验收货地址是否在配送范围内
+// @Tags DeliveryZone
+// @Summary 校验收货地址是否在配送范围内
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shop.UserAddress true "收货地址 需要经纬度"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /deliveryZone/checkAddress [post]
+func (deliveryZoneApi *DeliveryZoneApi) CheckAddress(c *gin.Context) {
+	var address shop.UserAddress
+	err := c.ShouldBindJSON(&address)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if resp, err := deliveryZoneService.CheckAddress(address); err != nil {
+		global.Log.Error("校验失败!", zap.Error(err))
+		response.FailWithMessage("校验失败", c)
+	} else {
+		response.OkWithData(resp, c)
+	}
+}
@@ -13,4 +13,10 @@ type ApiGroup struct {
 	FavoritesApi
 	CartApi
 	UserAddressApi
+	PostageRuleApi
+	DeliverySlotApi
+	DeliveryZoneApi
+	DelivererApi
+	PayBillApi
+	StockLogApi
 }
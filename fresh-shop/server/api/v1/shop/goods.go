@@ -41,7 +41,7 @@ func (goodsApi *GoodsApi) CreateGoods(c *gin.Context) {
 		return
 	}
 
-	if err := goodsService.CreateGoods(goods); err != nil {
+	if err := goodsService.CreateGoods(goods, adminOrderActor(c)); err != nil {
 		global.Log.Error("创建失败!", zap.Error(err))
 		response.FailWithMessage(err.Error(), c)
 	} else {
@@ -65,7 +65,7 @@ func (goodsApi *GoodsApi) BatchCreateGoodsByExcel(c *gin.Context) {
 		response.FailWithMessage("接收文件失败", c)
 		return
 	}
-	if err := goodsService.BatchCreateGoodsByExcel(header); err != nil {
+	if err := goodsService.BatchCreateGoodsByExcel(header, adminOrderActor(c)); err != nil {
 		global.Log.Error("导入失败!", zap.Error(err))
 		response.FailWithMessage(err.Error(), c)
 	} else {
@@ -183,7 +183,7 @@ func (goodsApi *GoodsApi) UpdateGoods(c *gin.Context) {
 		response.FailWithMessage(err.Error(), c)
 		return
 	}
-	if err := goodsService.UpdateGoods(goods); err != nil {
+	if err := goodsService.UpdateGoods(goods, adminOrderActor(c)); err != nil {
 		global.Log.Error("更新失败!", zap.Error(err))
 		response.FailWithMessage(err.Error(), c)
 	} else {
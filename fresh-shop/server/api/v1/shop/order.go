@@ -1,6 +1,7 @@
 package shop
 
 import (
+	"fmt"
 	"fresh-shop/server/global"
 	"fresh-shop/server/model/common/request"
 	"fresh-shop/server/model/common/response"
@@ -10,6 +11,7 @@ import (
 	"fresh-shop/server/utils"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+	"time"
 )
 
 type OrderApi struct {
@@ -48,30 +50,54 @@ func (orderApi *OrderApi) CreateOrder(c *gin.Context) {
 	}
 }
 
-// OrderPay 支付 Order, 返回微信支付所需要的参数
+// PreviewOrder 订单预览 计算商品价格、邮费、赠送积分和应付金额，不创建订单
 // @Tags Order
-// @Summary 支付 Order, 返回微信支付所需要的参数
+// @Summary 订单预览
 // @Security ApiKeyAuth
 // @accept application/json
 // @Produce application/json
-// @Param data body shop.Order true "支付 Order, 返回微信支付所需要的参数"
+// @Param data body shop.Order true "收货地址、收货方式、积分商品id、积分抵扣数量"
 // @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
-// @Router /order/orderPay [post]
-func (orderApi *OrderApi) OrderPay(c *gin.Context) {
+// @Router /order/previewOrder [post]
+func (orderApi *OrderApi) PreviewOrder(c *gin.Context) {
 	var order shop.Order
 	err := c.ShouldBindJSON(&order)
 	if err != nil {
 		response.FailWithMessage(err.Error(), c)
 		return
 	}
-	if order.ID == 0 {
+	userId := utils.GetUserID(c)
+	order.UserId = utils.Pointer(int(userId))
+	if resp, err := orderService.PreviewOrder(order); err != nil {
+		global.Log.Error("获取失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithData(resp, c)
+	}
+}
+
+// OrderPay 支付 Order, 微信支付返回微信支付所需要的参数，余额支付直接完成支付
+// @Tags Order
+// @Summary 支付 Order, 微信支付返回微信支付所需要的参数，余额支付直接完成支付
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shopReq.OrderPayReq true "支付 Order"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /order/orderPay [post]
+func (orderApi *OrderApi) OrderPay(c *gin.Context) {
+	var req shopReq.OrderPayReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if req.ID == 0 {
 		response.FailWithMessage("订单ID不能为空", c)
 		return
 	}
-	userId := utils.GetUserID(c)
-	order.UserId = utils.Pointer(int(userId))
 	userClaims := utils.GetUserInfo(c)
-	if orderResp, err := orderService.OrderPay(order, userClaims, c.ClientIP()); err != nil {
+	if orderResp, err := orderService.OrderPay(req, userClaims, c.ClientIP()); err != nil {
 		global.Log.Error("创建失败!", zap.Error(err))
 		response.FailWithMessage(err.Error(), c)
 	} else {
@@ -119,7 +145,31 @@ func (orderApi *OrderApi) CancelOrder(c *gin.Context) {
 		response.FailWithMessage(err.Error(), c)
 		return
 	}
-	if err := orderService.CancelOrder(order); err != nil {
+	if err := orderService.CancelOrder(order, utils.GetUserID(c), utils.GetUserInfo(c).Username); err != nil {
+		global.Log.Error("取消失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithMessage("取消成功", c)
+	}
+}
+
+// AdminCancelOrder 后台取消订单
+// @Tags Order
+// @Summary 后台取消订单
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shop.Order true "取消订单"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"取消成功"}"
+// @Router /order/adminCancelOrder [post]
+func (orderApi *OrderApi) AdminCancelOrder(c *gin.Context) {
+	var order shop.Order
+	err := c.ShouldBindJSON(&order)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := orderService.AdminCancelOrder(order, adminOrderActor(c)); err != nil {
 		global.Log.Error("取消失败!", zap.Error(err))
 		response.FailWithMessage(err.Error(), c)
 	} else {
@@ -275,6 +325,36 @@ func (orderApi *OrderApi) GetOrderList(c *gin.Context) {
 	}
 }
 
+// ExportOrders 按订单列表的搜索条件导出订单
+// @Tags Order
+// @Summary 导出订单Excel
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/octet-stream
+// @Param data query shopReq.OrderSearch true "导出订单"
+// @Success 200 {file} file "订单Excel"
+// @Router /order/exportOrders [get]
+func (orderApi *OrderApi) ExportOrders(c *gin.Context) {
+	var pageInfo shopReq.OrderSearch
+	err := c.ShouldBindQuery(&pageInfo)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	// 直接写入响应 导出失败时文件还未开始写入，移除下载头后返回错误信息
+	fileName := fmt.Sprintf("orders_%s.xlsx", time.Now().Format("20060102150405"))
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	if err := orderService.ExportOrders(pageInfo, c.Writer); err != nil {
+		global.Log.Error("导出失败!", zap.Error(err))
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.Writer.Header().Del("Content-Type")
+			response.FailWithMessage(err.Error(), c)
+		}
+	}
+}
+
 // GetUserOrderList 分页获取登录用户获取Order列表
 // @Tags Order
 // @Summary 页获取登录用户获取Order列表
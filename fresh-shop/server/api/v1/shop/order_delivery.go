@@ -7,6 +7,8 @@ import (
 	"fresh-shop/server/model/shop"
 	shopReq "fresh-shop/server/model/shop/request"
 	"fresh-shop/server/service"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/utils"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -16,6 +18,16 @@ type OrderDeliveryApi struct {
 
 var orderDeliveryService = service.ServiceGroupApp.ShopServiceGroup.OrderDeliveryService
 
+// adminOrderActor 后台操作订单的操作人
+func adminOrderActor(c *gin.Context) common.OrderActor {
+	actor := common.OrderActor{Type: common.ActorAdmin}
+	if claims := utils.GetUserInfo(c); claims != nil {
+		actor.Id = claims.ID
+		actor.Name = claims.Username
+	}
+	return actor
+}
+
 // CreateOrderDelivery 创建OrderDelivery
 // @Tags OrderDelivery
 // @Summary 创建OrderDelivery
@@ -37,7 +49,7 @@ func (orderDeliveryApi *OrderDeliveryApi) CreateOrderDelivery(c *gin.Context) {
 		global.SugarLog.Errorf("发货失败! 订单id参数错误 orderId: %d", *orderDelivery.OrderId)
 		response.FailWithMessage("参数错误", c)
 	}
-	if err := orderDeliveryService.CreateOrderDelivery(orderDelivery); err != nil {
+	if err := orderDeliveryService.CreateOrderDelivery(orderDelivery, adminOrderActor(c)); err != nil {
 		global.Log.Error("发货失败!", zap.Error(err))
 		response.FailWithMessage("发货失败", c)
 	} else {
@@ -109,7 +121,7 @@ func (orderDeliveryApi *OrderDeliveryApi) UpdateOrderDelivery(c *gin.Context) {
 		response.FailWithMessage(err.Error(), c)
 		return
 	}
-	if err := orderDeliveryService.UpdateOrderDelivery(orderDelivery); err != nil {
+	if err := orderDeliveryService.UpdateOrderDelivery(orderDelivery, adminOrderActor(c)); err != nil {
 		global.Log.Error("更新失败!", zap.Error(err))
 		response.FailWithMessage("更新失败", c)
 	} else {
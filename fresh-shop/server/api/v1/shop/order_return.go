@@ -2,13 +2,14 @@ package shop
 
 import (
 	"fresh-shop/server/global"
-    "fresh-shop/server/model/shop"
-    "fresh-shop/server/model/common/request"
-    shopReq "fresh-shop/server/model/shop/request"
-    "fresh-shop/server/model/common/response"
-    "fresh-shop/server/service"
-    "github.com/gin-gonic/gin"
-    "go.uber.org/zap"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/common/response"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service"
+	"fresh-shop/server/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
 )
 
 type OrderReturnApi struct {
@@ -16,7 +17,6 @@ type OrderReturnApi struct {
 
 var orderReturnService = service.ServiceGroupApp.ShopServiceGroup.OrderReturnService
 
-
 // CreateOrderReturn 创建OrderReturn
 // @Tags OrderReturn
 // @Summary 创建OrderReturn
@@ -34,7 +34,7 @@ func (orderReturnApi *OrderReturnApi) CreateOrderReturn(c *gin.Context) {
 		return
 	}
 	if err := orderReturnService.CreateOrderReturn(orderReturn); err != nil {
-        global.Log.Error("创建失败!", zap.Error(err))
+		global.Log.Error("创建失败!", zap.Error(err))
 		response.FailWithMessage("创建失败", c)
 	} else {
 		response.OkWithMessage("创建成功", c)
@@ -58,7 +58,7 @@ func (orderReturnApi *OrderReturnApi) DeleteOrderReturn(c *gin.Context) {
 		return
 	}
 	if err := orderReturnService.DeleteOrderReturn(orderReturn); err != nil {
-        global.Log.Error("删除失败!", zap.Error(err))
+		global.Log.Error("删除失败!", zap.Error(err))
 		response.FailWithMessage("删除失败", c)
 	} else {
 		response.OkWithMessage("删除成功", c)
@@ -76,13 +76,13 @@ func (orderReturnApi *OrderReturnApi) DeleteOrderReturn(c *gin.Context) {
 // @Router /orderReturn/deleteOrderReturnByIds [delete]
 func (orderReturnApi *OrderReturnApi) DeleteOrderReturnByIds(c *gin.Context) {
 	var IDS request.IdsReq
-    err := c.ShouldBindJSON(&IDS)
+	err := c.ShouldBindJSON(&IDS)
 	if err != nil {
 		response.FailWithMessage(err.Error(), c)
 		return
 	}
 	if err := orderReturnService.DeleteOrderReturnByIds(IDS); err != nil {
-        global.Log.Error("批量删除失败!", zap.Error(err))
+		global.Log.Error("批量删除失败!", zap.Error(err))
 		response.FailWithMessage("批量删除失败", c)
 	} else {
 		response.OkWithMessage("批量删除成功", c)
@@ -106,7 +106,7 @@ func (orderReturnApi *OrderReturnApi) UpdateOrderReturn(c *gin.Context) {
 		return
 	}
 	if err := orderReturnService.UpdateOrderReturn(orderReturn); err != nil {
-        global.Log.Error("更新失败!", zap.Error(err))
+		global.Log.Error("更新失败!", zap.Error(err))
 		response.FailWithMessage("更新失败", c)
 	} else {
 		response.OkWithMessage("更新成功", c)
@@ -130,7 +130,7 @@ func (orderReturnApi *OrderReturnApi) FindOrderReturn(c *gin.Context) {
 		return
 	}
 	if reorderReturn, err := orderReturnService.GetOrderReturn(orderReturn.ID); err != nil {
-        global.Log.Error("查询失败!", zap.Error(err))
+		global.Log.Error("查询失败!", zap.Error(err))
 		response.FailWithMessage("查询失败", c)
 	} else {
 		response.OkWithData(gin.H{"reorderReturn": reorderReturn}, c)
@@ -154,14 +154,94 @@ func (orderReturnApi *OrderReturnApi) GetOrderReturnList(c *gin.Context) {
 		return
 	}
 	if list, total, err := orderReturnService.GetOrderReturnInfoList(pageInfo); err != nil {
-	    global.Log.Error("获取失败!", zap.Error(err))
-        response.FailWithMessage("获取失败", c)
-    } else {
-        response.OkWithDetailed(response.PageResult{
-            List:     list,
-            Total:    total,
-            Page:     pageInfo.Page,
-            PageSize: pageInfo.PageSize,
-        }, "获取成功", c)
-    }
+		global.Log.Error("获取失败!", zap.Error(err))
+		response.FailWithMessage("获取失败", c)
+	} else {
+		response.OkWithDetailed(response.PageResult{
+			List:     list,
+			Total:    total,
+			Page:     pageInfo.Page,
+			PageSize: pageInfo.PageSize,
+		}, "获取成功", c)
+	}
+}
+
+// ApplyOrderReturn 用户申请售后
+// @Tags OrderReturn
+// @Summary 用户申请售后
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shopReq.OrderReturnApplyReq true "申请售后"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"申请成功"}"
+// @Router /orderReturn/applyOrderReturn [post]
+func (orderReturnApi *OrderReturnApi) ApplyOrderReturn(c *gin.Context) {
+	var req shopReq.OrderReturnApplyReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	verify := utils.Rules{
+		"OrderId": {utils.NotEmpty()},
+		"Reason":  {utils.NotEmpty()},
+	}
+	if err := utils.Verify(req, verify); err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if orderReturn, err := orderReturnService.ApplyOrderReturn(req, utils.GetUserID(c)); err != nil {
+		global.Log.Error("申请售后失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithDetailed(gin.H{"orderReturn": orderReturn}, "申请成功", c)
+	}
+}
+
+// UploadReturnImage 上传售后图片
+// @Tags OrderReturn
+// @Summary 上传售后图片
+// @Security ApiKeyAuth
+// @accept multipart/form-data
+// @Produce application/json
+// @Param file formData file true "售后图片"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"上传成功"}"
+// @Router /orderReturn/uploadReturnImage [post]
+func (orderReturnApi *OrderReturnApi) UploadReturnImage(c *gin.Context) {
+	_, header, err := c.Request.FormFile("file")
+	if err != nil {
+		global.Log.Error("接收文件失败!", zap.Error(err))
+		response.FailWithMessage("接收文件失败", c)
+		return
+	}
+	if url, err := orderReturnService.UploadReturnImage(header); err != nil {
+		global.Log.Error("上传售后图片失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithDetailed(gin.H{"url": url}, "上传成功", c)
+	}
+}
+
+// ProcessOrderReturn 处理售后申请 同意后按原支付方式退款
+// @Tags OrderReturn
+// @Summary 处理售后申请
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shopReq.OrderReturnProcessReq true "处理售后申请"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"处理成功"}"
+// @Router /orderReturn/processOrderReturn [put]
+func (orderReturnApi *OrderReturnApi) ProcessOrderReturn(c *gin.Context) {
+	var req shopReq.OrderReturnProcessReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := orderReturnService.ProcessOrderReturn(req, adminOrderActor(c)); err != nil {
+		global.Log.Error("处理售后申请失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithMessage("处理成功", c)
+	}
 }
@@ -0,0 +1,119 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/response"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type PayBillApi struct {
+}
+
+var payBillService = service.ServiceGroupApp.ShopServiceGroup.PayBillService
+
+// ReconcileWechatBill 下载微信支付对账单并核对
+// @Tags PayBill
+// @Summary 下载微信支付对账单并核对
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shopReq.PayBillReq true "账单日期"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"对账完成"}"
+// @Router /payBill/reconcileWechatBill [post]
+func (payBillApi *PayBillApi) ReconcileWechatBill(c *gin.Context) {
+	var req shopReq.PayBillReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if bill, err := payBillService.ReconcileWechatBill(req.BillDate); err != nil {
+		global.Log.Error("对账失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithDetailed(gin.H{"rebill": bill}, "对账完成", c)
+	}
+}
+
+// UploadWechatBill 上传微信支付对账单文件并核对
+// @Tags PayBill
+// @Summary 上传微信支付对账单文件并核对
+// @Security ApiKeyAuth
+// @accept multipart/form-data
+// @Produce application/json
+// @Param billDate formData string true "账单日期"
+// @Param file formData file true "商户平台下载的对账单 csv 文件"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"对账完成"}"
+// @Router /payBill/uploadWechatBill [post]
+func (payBillApi *PayBillApi) UploadWechatBill(c *gin.Context) {
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		global.Log.Error("接收文件失败!", zap.Error(err))
+		response.FailWithMessage("接收文件失败", c)
+		return
+	}
+	defer file.Close()
+	if bill, err := payBillService.ImportWechatBill(c.PostForm("billDate"), file); err != nil {
+		global.Log.Error("对账失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithDetailed(gin.H{"rebill": bill}, "对账完成", c)
+	}
+}
+
+// FindPayBill 用id查询对账结果和差异明细
+// @Tags PayBill
+// @Summary 用id查询对账结果和差异明细
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query shop.PayBill true "用id查询对账结果"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"查询成功"}"
+// @Router /payBill/findPayBill [get]
+func (payBillApi *PayBillApi) FindPayBill(c *gin.Context) {
+	var payBill shop.PayBill
+	err := c.ShouldBindQuery(&payBill)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if rebill, err := payBillService.GetPayBill(payBill.ID); err != nil {
+		global.Log.Error("查询失败!", zap.Error(err))
+		response.FailWithMessage("查询失败", c)
+	} else {
+		response.OkWithData(gin.H{"rebill": rebill}, c)
+	}
+}
+
+// GetPayBillList 分页获取对账结果列表
+// @Tags PayBill
+// @Summary 分页获取对账结果列表
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query shopReq.PayBillSearch true "分页获取对账结果列表"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /payBill/getPayBillList [get]
+func (payBillApi *PayBillApi) GetPayBillList(c *gin.Context) {
+	var pageInfo shopReq.PayBillSearch
+	err := c.ShouldBindQuery(&pageInfo)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if list, total, err := payBillService.GetPayBillInfoList(pageInfo); err != nil {
+		global.Log.Error("获取失败!", zap.Error(err))
+		response.FailWithMessage("获取失败", c)
+	} else {
+		response.OkWithDetailed(response.PageResult{
+			List:     list,
+			Total:    total,
+			Page:     pageInfo.Page,
+			PageSize: pageInfo.PageSize,
+		}, "获取成功", c)
+	}
+}
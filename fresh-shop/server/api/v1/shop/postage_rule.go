@@ -0,0 +1,181 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/common/response"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service"
+	"fresh-shop/server/utils"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type PostageRuleApi struct {
+}
+
+var postageRuleService = service.ServiceGroupApp.ShopServiceGroup.PostageRuleService
+
+// CreatePostageRule 创建PostageRule
+// @Tags PostageRule
+// @Summary 创建PostageRule
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shop.PostageRule true "创建PostageRule"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /postageRule/createPostageRule [post]
+func (postageRuleApi *PostageRuleApi) CreatePostageRule(c *gin.Context) {
+	var postageRule shop.PostageRule
+	err := c.ShouldBindJSON(&postageRule)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	verify := utils.Rules{
+		"Name": {utils.NotEmpty()},
+	}
+	if err := utils.Verify(postageRule, verify); err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := postageRuleService.CreatePostageRule(postageRule); err != nil {
+		global.Log.Error("创建失败!", zap.Error(err))
+		response.FailWithMessage("创建失败", c)
+	} else {
+		response.OkWithMessage("创建成功", c)
+	}
+}
+
+// DeletePostageRule 删除PostageRule
+// @Tags PostageRule
+// @Summary 删除PostageRule
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shop.PostageRule true "删除PostageRule"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"删除成功"}"
+// @Router /postageRule/deletePostageRule [delete]
+func (postageRuleApi *PostageRuleApi) DeletePostageRule(c *gin.Context) {
+	var postageRule shop.PostageRule
+	err := c.ShouldBindJSON(&postageRule)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := postageRuleService.DeletePostageRule(postageRule); err != nil {
+		global.Log.Error("删除失败!", zap.Error(err))
+		response.FailWithMessage("删除失败", c)
+	} else {
+		response.OkWithMessage("删除成功", c)
+	}
+}
+
+// DeletePostageRuleByIds 批量删除PostageRule
+// @Tags PostageRule
+// @Summary 批量删除PostageRule
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body request.IdsReq true "批量删除PostageRule"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"批量删除成功"}"
+// @Router /postageRule/deletePostageRuleByIds [delete]
+func (postageRuleApi *PostageRuleApi) DeletePostageRuleByIds(c *gin.Context) {
+	var IDS request.IdsReq
+	err := c.ShouldBindJSON(&IDS)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := postageRuleService.DeletePostageRuleByIds(IDS); err != nil {
+		global.Log.Error("批量删除失败!", zap.Error(err))
+		response.FailWithMessage("批量删除失败", c)
+	} else {
+		response.OkWithMessage("批量删除成功", c)
+	}
+}
+
+// UpdatePostageRule 更新PostageRule
+// @Tags PostageRule
+// @Summary 更新PostageRule
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shop.PostageRule true "更新PostageRule"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"更新成功"}"
+// @Router /postageRule/updatePostageRule [put]
+func (postageRuleApi *PostageRuleApi) UpdatePostageRule(c *gin.Context) {
+	var postageRule shop.PostageRule
+	err := c.ShouldBindJSON(&postageRule)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	verify := utils.Rules{
+		"Name": {utils.NotEmpty()},
+	}
+	if err := utils.Verify(postageRule, verify); err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if err := postageRuleService.UpdatePostageRule(postageRule); err != nil {
+		global.Log.Error("更新失败!", zap.Error(err))
+		response.FailWithMessage("更新失败", c)
+	} else {
+		response.OkWithMessage("更新成功", c)
+	}
+}
+
+// FindPostageRule 用id查询PostageRule
+// @Tags PostageRule
+// @Summary 用id查询PostageRule
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query shop.PostageRule true "用id查询PostageRule"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"查询成功"}"
+// @Router /postageRule/findPostageRule [get]
+func (postageRuleApi *PostageRuleApi) FindPostageRule(c *gin.Context) {
+	var postageRule shop.PostageRule
+	err := c.ShouldBindQuery(&postageRule)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if repostageRule, err := postageRuleService.GetPostageRule(postageRule.ID); err != nil {
+		global.Log.Error("查询失败!", zap.Error(err))
+		response.FailWithMessage("查询失败", c)
+	} else {
+		response.OkWithData(gin.H{"repostageRule": repostageRule}, c)
+	}
+}
+
+// GetPostageRuleList 分页获取PostageRule列表
+// @Tags PostageRule
+// @Summary 分页获取PostageRule列表
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query shopReq.PostageRuleSearch true "分页获取PostageRule列表"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /postageRule/getPostageRuleList [get]
+func (postageRuleApi *PostageRuleApi) GetPostageRuleList(c *gin.Context) {
+	var pageInfo shopReq.PostageRuleSearch
+	err := c.ShouldBindQuery(&pageInfo)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if list, total, err := postageRuleService.GetPostageRuleInfoList(pageInfo); err != nil {
+		global.Log.Error("获取失败!", zap.Error(err))
+		response.FailWithMessage("获取失败", c)
+	} else {
+		response.OkWithDetailed(response.PageResult{
+			List:     list,
+			Total:    total,
+			Page:     pageInfo.Page,
+			PageSize: pageInfo.PageSize,
+		}, "获取成功", c)
+	}
+}
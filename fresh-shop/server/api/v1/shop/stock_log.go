@@ -0,0 +1,99 @@
+package shop
+
+import (
+	"fmt"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/response"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"time"
+)
+
+type StockLogApi struct {
+}
+
+var stockLogService = service.ServiceGroupApp.ShopServiceGroup.StockLogService
+
+// GetStockLogList 分页获取库存流水
+// @Tags StockLog
+// @Summary 分页获取库存流水
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data query shopReq.StockLogSearch true "分页获取库存流水"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"获取成功"}"
+// @Router /stockLog/getStockLogList [get]
+func (stockLogApi *StockLogApi) GetStockLogList(c *gin.Context) {
+	var pageInfo shopReq.StockLogSearch
+	err := c.ShouldBindQuery(&pageInfo)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if list, total, err := stockLogService.GetStockLogInfoList(pageInfo); err != nil {
+		global.Log.Error("获取失败!", zap.Error(err))
+		response.FailWithMessage("获取失败", c)
+	} else {
+		response.OkWithDetailed(response.PageResult{
+			List:     list,
+			Total:    total,
+			Page:     pageInfo.Page,
+			PageSize: pageInfo.PageSize,
+		}, "获取成功", c)
+	}
+}
+
+// ExportStockLogs 按库存流水的搜索条件导出
+// @Tags StockLog
+// @Summary 导出库存流水Excel
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/octet-stream
+// @Param data query shopReq.StockLogSearch true "导出库存流水"
+// @Success 200 {file} file "库存流水Excel"
+// @Router /stockLog/exportStockLogs [get]
+func (stockLogApi *StockLogApi) ExportStockLogs(c *gin.Context) {
+	var pageInfo shopReq.StockLogSearch
+	err := c.ShouldBindQuery(&pageInfo)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	fileName := fmt.Sprintf("stock_logs_%s.xlsx", time.Now().Format("20060102150405"))
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	if err := stockLogService.ExportStockLogs(pageInfo, c.Writer); err != nil {
+		global.Log.Error("导出失败!", zap.Error(err))
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Disposition")
+			c.Writer.Header().Del("Content-Type")
+			response.FailWithMessage(err.Error(), c)
+		}
+	}
+}
+
+// Stocktake 盘点 按实际盘点数量修改库存
+// @Tags StockLog
+// @Summary 盘点
+// @Security ApiKeyAuth
+// @accept application/json
+// @Produce application/json
+// @Param data body shopReq.StocktakeReq true "盘点明细"
+// @Success 200 {string} string "{"success":true,"data":{},"msg":"盘点完成"}"
+// @Router /stockLog/stocktake [post]
+func (stockLogApi *StockLogApi) Stocktake(c *gin.Context) {
+	var req shopReq.StocktakeReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	if refId, err := stockLogService.Stocktake(req, adminOrderActor(c)); err != nil {
+		global.Log.Error("盘点失败!", zap.Error(err))
+		response.FailWithMessage(err.Error(), c)
+	} else {
+		response.OkWithDetailed(gin.H{"refId": refId}, "盘点完成", c)
+	}
+}
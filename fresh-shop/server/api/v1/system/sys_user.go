@@ -243,6 +243,35 @@ func (b *BaseApi) ChangePassword(c *gin.Context) {
 	response.OkWithMessage("修改成功", c)
 }
 
+// SetSafePassword
+// @Tags      SysUser
+// @Summary   用户设置安全密码
+// @Security  ApiKeyAuth
+// @Produce  application/json
+// @Param     data  body      systemReq.SetSafePasswordReq    true  "原安全密码, 新安全密码"
+// @Success   200   {object}  response.Response{msg=string}  "用户设置安全密码"
+// @Router    /user/setSafePassword [post]
+func (b *BaseApi) SetSafePassword(c *gin.Context) {
+	var req systemReq.SetSafePasswordReq
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	err = utils.Verify(req, utils.SafePasswordVerify)
+	if err != nil {
+		response.FailWithMessage(err.Error(), c)
+		return
+	}
+	err = userService.SetSafePassword(utils.GetUserID(c), req.OldSafePassword, req.SafePassword)
+	if err != nil {
+		global.Log.Error("设置失败!", zap.Error(err))
+		response.FailWithMessage("设置失败，"+err.Error(), c)
+		return
+	}
+	response.OkWithMessage("设置成功", c)
+}
+
 // GetUserList
 // @Tags      SysUser
 // @Summary   分页获取用户列表
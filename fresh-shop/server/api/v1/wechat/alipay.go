@@ -0,0 +1,31 @@
+package wechat
+
+import (
+	"fresh-shop/server/global"
+	"fresh-shop/server/service/payment"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type AlipayApi struct {
+}
+
+// PayNotify 支付宝异步通知 交易支付成功、交易关闭时都会通知
+// 原始报文在解析前保存，验证和处理由支付方式的实现完成
+func (a *AlipayApi) PayNotify(c *gin.Context) {
+	global.SugarLog.Infof("支付宝支付回调 开始 \n")
+	body, err := c.GetRawData()
+	if err != nil {
+		c.String(http.StatusOK, "fail")
+		return
+	}
+	provider, err := payment.HandlePayNotify(payment.Alipay, c.Request.Header, body)
+	if err != nil {
+		global.SugarLog.Errorf("支付宝支付回调失败! err: %v \n", err)
+	}
+	if provider == nil {
+		c.String(http.StatusOK, "fail")
+		return
+	}
+	c.Data(provider.NotifyResponse(err))
+}
@@ -4,6 +4,7 @@ import "fresh-shop/server/service"
 
 type ApiGroup struct {
 	WeChatApi
+	AlipayApi
 }
 
 var (
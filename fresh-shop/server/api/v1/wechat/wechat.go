@@ -4,8 +4,8 @@ import (
 	"fresh-shop/server/global"
 	"fresh-shop/server/model/common/response"
 	"fresh-shop/server/model/wechat/request"
+	"fresh-shop/server/service/payment"
 	"github.com/gin-gonic/gin"
-	"github.com/silenceper/wechat/v2/pay/notify"
 	"go.uber.org/zap"
 )
 
@@ -58,38 +58,43 @@ func (w *WeChatApi) CreatePayData(c *gin.Context) {
 	}
 }
 
-// PayNotify 支付完成回调
-func (w *WeChatApi) PayNotify(c *gin.Context) {
-	global.SugarLog.Infof("微信支付回调 开始 \n")
-	var req notify.PaidResult
-	err := c.ShouldBindXML(&req)
+// RefundNotify 退款结果回调
+// 原始报文在解析前保存，验证和处理由支付方式的实现完成
+func (w *WeChatApi) RefundNotify(c *gin.Context) {
+	global.SugarLog.Infof("微信退款回调 开始 \n")
+	body, err := c.GetRawData()
 	if err != nil {
-		response.FailWithMessage(err.Error(), c)
+		response.WxpayNotify("FAIL", "读取通知失败", c)
 		return
 	}
-	n := global.WxPay.GetNotify()
-	if ok := n.PaidVerifySign(req); !ok {
-		global.SugarLog.Errorf("支付回调签名验证失败! %#v \n", req)
-		response.WxpayNotify("FAIL", "签名失败", c)
-		return
+	provider, err := payment.HandleRefundNotify(payment.Wechat, c.Request.Header, body)
+	if err != nil {
+		global.SugarLog.Errorf("退款回调失败! err: %v \n", err)
 	}
+	payNotifyResponse(provider, err, c)
+}
 
-	if *req.ReturnCode != "SUCCESS" {
-		global.SugarLog.Errorf("支付回调失败! ReturnCode:%s, ReturnMsg: %s \n", *req.ReturnCode, *req.ReturnMsg)
-		response.WxpayNotify("FAIL", "参数格式校验错误", c)
-		return
-	}
-	if *req.ResultCode != "SUCCESS" {
-		global.SugarLog.Errorf("支付回调失败! ResultCode:%s, ResultMsg: %s \n", *req.ResultCode, *req.ErrCodeDes)
-		response.WxpayNotify("FAIL", "ResultMsg: "+*req.ReturnMsg, c)
+// PayNotify 支付完成回调
+// 原始报文在解析前保存，验证和处理由支付方式的实现完成
+func (w *WeChatApi) PayNotify(c *gin.Context) {
+	global.SugarLog.Infof("微信支付回调 开始 \n")
+	body, err := c.GetRawData()
+	if err != nil {
+		response.WxpayNotify("FAIL", "读取通知失败", c)
 		return
 	}
-	global.SugarLog.Infof("微信支付回调 验证通过开始执行业务逻辑\n")
-	err = wechatService.NotifyLogic(&req)
+	provider, err := payment.HandlePayNotify(payment.Wechat, c.Request.Header, body)
 	if err != nil {
 		global.SugarLog.Errorf("支付回调失败! err: %v \n", err)
-		response.WxpayNotify("FAIL", "参数格式校验错误", c)
-	} else {
-		response.WxpayNotify("SUCCESS", "OK", c)
 	}
+	payNotifyResponse(provider, err, c)
+}
+
+// payNotifyResponse 按支付方式要求的格式返回通知处理结果，未配置支付方式时按微信支付格式返回
+func payNotifyResponse(provider payment.Provider, err error, c *gin.Context) {
+	if provider == nil {
+		response.WxpayNotify("FAIL", err.Error(), c)
+		return
+	}
+	c.Data(provider.NotifyResponse(err))
 }
@@ -0,0 +1,13 @@
+package config
+
+// Alipay 支付宝支付 使用 RSA2 签名，密钥为支付宝开放平台生成的 base64 内容或 PEM 格式
+type Alipay struct {
+	Provider   string `mapstructure:"provider" json:"provider" yaml:"provider"`       // 支付实现 默认请求支付宝，mock 为本地模拟支付
+	AppId      string `mapstructure:"appId" json:"appId" yaml:"appId"`                // 支付宝应用 id，为空时不启用支付宝支付
+	PrivateKey string `mapstructure:"privateKey" json:"privateKey" yaml:"privateKey"` // 应用私钥
+	PublicKey  string `mapstructure:"publicKey" json:"publicKey" yaml:"publicKey"`    // 支付宝公钥，用于验证通知和接口返回的签名
+	Gateway    string `mapstructure:"gateway" json:"gateway" yaml:"gateway"`          // 支付宝网关 为空时使用正式环境网关
+	NotifyURL  string `mapstructure:"notifyUrl" json:"notifyUrl" yaml:"notifyUrl"`    // 支付结果异步通知地址
+	ReturnURL  string `mapstructure:"returnUrl" json:"returnUrl" yaml:"returnUrl"`    // H5 支付完成后跳转的页面
+	Debug      bool   `mapstructure:"debug" json:"debug" yaml:"debug"`                // 测试模式 只支付 0.01 元
+}
@@ -31,4 +31,6 @@ type Server struct {
 
 	Wechat    Wechat    `mapstructure:"wechat" json:"wechat" yaml:"wechat"`
 	WechatPay WechatPay `mapstructure:"wechatPay" json:"wechatPay" yaml:"wechatPay"`
+	Alipay    Alipay    `mapstructure:"alipay" json:"alipay" yaml:"alipay"`
+	PayMock   PayMock   `mapstructure:"payMock" json:"payMock" yaml:"payMock"`
 }
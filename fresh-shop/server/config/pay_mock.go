@@ -0,0 +1,8 @@
+package config
+
+// PayMock 本地模拟支付，支付方式的 provider 配置为 mock 时使用，不会请求第三方支付
+type PayMock struct {
+	Result      string `mapstructure:"result" json:"result" yaml:"result"`                // 支付结果 success 成功 fail 失败
+	NotifyDelay int    `mapstructure:"notifyDelay" json:"notifyDelay" yaml:"notifyDelay"` // 支付和退款通知延迟的秒数
+	SkipNotify  bool   `mapstructure:"skipNotify" json:"skipNotify" yaml:"skipNotify"`    // 不发送通知，模拟通知丢失，只能通过查询订单获取结果
+}
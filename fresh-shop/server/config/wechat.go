@@ -7,10 +7,18 @@ type Wechat struct {
 }
 
 type WechatPay struct {
+	Provider  string `mapstructure:"provider" json:"provider" yaml:"provider"`    // 支付实现 默认 v2，v3 为微信支付 API v3，mock 为本地模拟支付
 	MchId     string `mapstructure:"mchId" json:"mchId" yaml:"mchId"`             // 商户号
 	ApiV2Key  string `mapstructure:"apiV2Key" json:"apiV2Key" yaml:"apiV2Key"`    // 商户号
 	NotifyURL string `mapstructure:"notifyUrl" json:"notifyUrl" yaml:"notifyUrl"` // 微信支付通知地址
 	Debug     bool   `mapstructure:"debug" json:"debug" yaml:"debug"`
-	CertPath  string `mapstructure:"certPath" json:"certPath" yaml:"certPath"`
-	KeyPath   string `mapstructure:"keyPath" json:"keyPath" yaml:"keyPath"`
+	CertPath  string `mapstructure:"certPath" json:"certPath" yaml:"certPath"` // 商户 API 证书 apiclient_cert.pem，API v3 未配置证书序列号时从证书读取
+	KeyPath   string `mapstructure:"keyPath" json:"keyPath" yaml:"keyPath"`    // 商户 API 私钥 apiclient_key.pem，API v3 请求签名使用
+	P12Path   string `mapstructure:"p12Path" json:"p12Path" yaml:"p12Path"`    // 退款使用的 apiclient_cert.p12 证书路径
+
+	RefundNotifyURL string `mapstructure:"refundNotifyUrl" json:"refundNotifyUrl" yaml:"refundNotifyUrl"` // 微信退款结果通知地址
+
+	ApiV3Key         string `mapstructure:"apiV3Key" json:"apiV3Key" yaml:"apiV3Key"`                         // APIv3 密钥，用于解密通知和平台证书
+	SerialNo         string `mapstructure:"serialNo" json:"serialNo" yaml:"serialNo"`                         // 商户 API 证书序列号
+	PlatformCertPath string `mapstructure:"platformCertPath" json:"platformCertPath" yaml:"platformCertPath"` // 微信支付平台证书，为空时从微信支付下载
 }
@@ -0,0 +1,17 @@
+package initialize
+
+import (
+	"fresh-shop/server/global"
+	"fresh-shop/server/service/common"
+	"go.uber.org/zap"
+)
+
+// EnsureData 补齐程序依赖的流水类型和配置参数，已有的安装升级后启动时写入缺失的数据
+func EnsureData() {
+	if err := common.EnsureFinanceTypes(global.DB); err != nil {
+		global.Log.Error("流水类型检查失败", zap.Error(err))
+	}
+	if err := common.EnsureSysConfigs(global.DB); err != nil {
+		global.Log.Error("配置参数检查失败", zap.Error(err))
+	}
+}
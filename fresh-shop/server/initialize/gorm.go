@@ -56,7 +56,10 @@ func RegisterTables() {
 		shop.GoodsImage{}, shop.GoodsSpec{}, shop.GoodsSpecItem{}, shop.GoodsSpecValue{},
 		shop.Order{}, shop.OrderDetails{}, shop.OrderDelivery{}, business.UserDelivery{},
 		shop.OrderReturn{}, shop.OrderReturnDetails{}, shop.Favorites{}, shop.Cart{},
-		shop.UserAddress{}, system.SysConfig{},
+		shop.UserAddress{}, system.SysConfig{}, shop.PostageRule{},
+		shop.PickUpSequence{}, shop.OrderLog{}, shop.DeliverySlot{},
+		shop.DeliveryZone{}, shop.PayNotify{}, shop.PayBill{}, shop.PayBillItem{}, shop.StockLog{},
+		shop.PayDuplicate{},
 	)
 	if err != nil {
 		global.Log.Error("register table failed", zap.Error(err))
@@ -0,0 +1,49 @@
+package initialize
+
+import (
+	"fresh-shop/server/global"
+	"fresh-shop/server/service"
+	"fresh-shop/server/service/payment"
+	"go.uber.org/zap"
+)
+
+// Payment 根据配置注册支付方式，provider 配置为 mock 时使用本地模拟支付，必须在 Wechat 之后调用
+func Payment() {
+	switch global.Config.WechatPay.Provider {
+	case payment.ProviderMock:
+		payment.Register(payment.Wechat, payment.NewMock(payment.Wechat, global.Config.PayMock))
+		global.Log.Info("微信支付使用本地模拟支付")
+	case payment.ProviderWechatV3:
+		wechatV3, err := payment.NewWechatV3(global.Config.WechatPay, global.Config.Wechat.Appid)
+		if err != nil {
+			global.Log.Error("微信支付 API v3 配置错误", zap.Error(err))
+			break
+		}
+		payment.Register(payment.Wechat, wechatV3)
+	default:
+		if global.WxPay != nil {
+			payment.Register(payment.Wechat, &payment.WechatV2{})
+		}
+	}
+	// 定时对账需要下载对账单，使用 API v2 且未配置 APIv2 密钥时不会自动对账
+	if _, err := payment.Get(payment.Wechat); err == nil && global.Config.WechatPay.Provider != payment.ProviderMock &&
+		!service.ServiceGroupApp.ShopServiceGroup.PayBillService.BillDownloadEnabled() {
+		global.Log.Warn("微信支付未配置 APIv2 密钥，无法下载对账单，不会自动核对微信支付对账单")
+	}
+
+	switch global.Config.Alipay.Provider {
+	case payment.ProviderMock:
+		payment.Register(payment.Alipay, payment.NewMock(payment.Alipay, global.Config.PayMock))
+		global.Log.Info("支付宝支付使用本地模拟支付")
+	default:
+		if global.Config.Alipay.AppId == "" {
+			return
+		}
+		alipay, err := payment.NewAlipayProvider(global.Config.Alipay)
+		if err != nil {
+			global.Log.Error("支付宝支付配置错误", zap.Error(err))
+			return
+		}
+		payment.Register(payment.Alipay, alipay)
+	}
+}
@@ -1,6 +1,7 @@
 package initialize
 
 import (
+	_ "fresh-shop/server/source/account"
 	_ "fresh-shop/server/source/example"
 	_ "fresh-shop/server/source/system"
 )
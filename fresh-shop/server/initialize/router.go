@@ -112,6 +112,12 @@ func Routers() *gin.Engine {
 		shopRouter.InitFavoritesRouter(PrivateGroup)
 		shopRouter.InitCartRouter(PrivateGroup)
 		shopRouter.InitUserAddressRouter(PrivateGroup)
+		shopRouter.InitPostageRuleRouter(PrivateGroup)
+		shopRouter.InitDeliverySlotRouter(PrivateGroup)
+		shopRouter.InitDeliveryZoneRouter(PrivateGroup)
+		shopRouter.InitDelivererRouter(PrivateGroup)
+		shopRouter.InitPayBillRouter(PrivateGroup)
+		shopRouter.InitStockLogRouter(PrivateGroup)
 	}
 	{
 		wechatRoute := router.RouterGroupApp.Wechat
@@ -119,6 +125,7 @@ func Routers() *gin.Engine {
 		// 不进行鉴别权的路由
 		{
 			wechatRoute.InitWechatPublicRouter(PublicGroup)
+			wechatRoute.InitAlipayPublicRouter(PublicGroup)
 		}
 	}
 
@@ -7,6 +7,7 @@ import (
 
 	"fresh-shop/server/config"
 	"fresh-shop/server/global"
+	"fresh-shop/server/service"
 	"fresh-shop/server/utils"
 )
 
@@ -30,4 +31,32 @@ func Timer() {
 			}(global.Config.Timer.Detail[i])
 		}
 	}
+	// 订单超时未支付自动取消，超时时间读取系统配置 orderTimeout
+	_, err := global.Timer.AddTaskByFunc("OrderTimeout", "@every 1m", func() {
+		service.ServiceGroupApp.ShopServiceGroup.OrderService.CancelTimeoutOrders()
+	})
+	if err != nil {
+		fmt.Println("add timer error:", err)
+	}
+	// 主动查询支付时间内未支付的第三方支付订单，支付回调丢失时完成支付
+	_, err = global.Timer.AddTaskByFunc("OrderPaySync", "@every 1m", func() {
+		service.ServiceGroupApp.ShopServiceGroup.OrderService.SyncPayOrders()
+	})
+	if err != nil {
+		fmt.Println("add timer error:", err)
+	}
+	// 每天核对前一天的微信支付对账单，微信支付在每天 10 点后生成前一天的对账单
+	_, err = global.Timer.AddTaskByFunc("PayBillReconcile", "30 10 * * *", func() {
+		service.ServiceGroupApp.ShopServiceGroup.PayBillService.ReconcileYesterday()
+	})
+	if err != nil {
+		fmt.Println("add timer error:", err)
+	}
+	// 发货后超过配置天数自动确认收货，天数读取系统配置 autoReceiveDays
+	_, err = global.Timer.AddTaskByFunc("OrderAutoReceive", "@every 10m", func() {
+		service.ServiceGroupApp.ShopServiceGroup.OrderService.AutoReceiveOrders()
+	})
+	if err != nil {
+		fmt.Println("add timer error:", err)
+	}
 }
@@ -28,9 +28,13 @@ func main() {
 	// 获取 zap SugarLog
 	global.SugarLog = global.Log.Sugar()
 	global.DB = initialize.Gorm() // gorm连接数据库
+	if global.DB != nil {
+		initialize.EnsureData() // 补齐流水类型和配置参数
+	}
 	initialize.Timer()
 	initialize.DBList()
 	initialize.Wechat()
+	initialize.Payment()
 	// 自动迁移数据表 本平台不使用
 	//if global.DB != nil {
 	//	initialize.RegisterTables() // 初始化表
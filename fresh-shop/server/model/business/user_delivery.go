@@ -12,6 +12,7 @@ type UserDelivery struct {
       Mobile  string `json:"mobile" form:"mobile" gorm:"column:mobile;comment:送货人手机号;size:11;"`
       DeliverCount  *int `json:"deliverCount" form:"deliverCount" gorm:"column:deliver_count;comment:送货单数;size:10;"`
       Status  *int `json:"status" form:"status" gorm:"column:status;comment:状态（0禁用 1启用）;"`
+      UserId  *int `json:"userId" form:"userId" gorm:"column:user_id;comment:送货员登录账号的用户id;size:20;"`
 }
 
 
@@ -0,0 +1,42 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+	"time"
+)
+
+// DeliverySlot 配送时段 结构体
+// 用户下单时选择配送时段，已预约订单数达到最大订单数或超过截止下单时间后不能再选择
+type DeliverySlot struct {
+	global.DbModel
+	Day        string     `json:"day" form:"day" gorm:"column:day;comment:配送日期 2006-01-02;size:10;index;"`
+	StartTime  string     `json:"startTime" form:"startTime" gorm:"column:start_time;comment:开始时间 15:04;size:5;"`
+	EndTime    string     `json:"endTime" form:"endTime" gorm:"column:end_time;comment:结束时间 15:04;size:5;"`
+	Capacity   *int       `json:"capacity" form:"capacity" gorm:"column:capacity;default:0;comment:最大订单数;size:10;"`
+	Reserved   int        `json:"reserved" form:"reserved" gorm:"column:reserved;default:0;comment:已预约订单数;size:10;"`
+	CutoffTime *time.Time `json:"cutoffTime" form:"cutoffTime" gorm:"column:cutoff_time;comment:截止下单时间 为空时截止到开始时间;"`
+	Status     *int       `json:"status" form:"status" gorm:"column:status;default:1;comment:状态(0禁用 1启用);"`
+}
+
+// TableName DeliverySlot 表名
+func (DeliverySlot) TableName() string {
+	return "shop_delivery_slot"
+}
+
+// Window 配送时段的开始时间和结束时间
+func (s DeliverySlot) Window() (start time.Time, end time.Time, err error) {
+	if start, err = time.ParseInLocation("2006-01-02 15:04", s.Day+" "+s.StartTime, time.Local); err != nil {
+		return
+	}
+	end, err = time.ParseInLocation("2006-01-02 15:04", s.Day+" "+s.EndTime, time.Local)
+	return
+}
+
+// Cutoff 截止下单时间 未设置时截止到配送开始时间
+func (s DeliverySlot) Cutoff() (time.Time, error) {
+	if s.CutoffTime != nil {
+		return *s.CutoffTime, nil
+	}
+	start, _, err := s.Window()
+	return start, err
+}
@@ -0,0 +1,30 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+)
+
+// 配送区域类型
+const (
+	DeliveryZoneRadius  = 0 // 半径范围
+	DeliveryZonePolygon = 1 // 多边形
+)
+
+// DeliveryZone 配送区域 结构体
+// 配送订单的收货地址必须在任意一个启用的配送区域内，没有启用的配送区域时不限制
+type DeliveryZone struct {
+	global.DbModel
+	Name            string   `json:"name" form:"name" gorm:"column:name;comment:区域名称;size:50;"`
+	Type            *int     `json:"type" form:"type" gorm:"column:type;default:0;comment:区域类型(0半径范围 1多边形);"`
+	CenterLongitude *float64 `json:"centerLongitude" form:"centerLongitude" gorm:"column:center_longitude;default:0;comment:中心点经度 为0时使用店铺位置;size:20;"`
+	CenterLatitude  *float64 `json:"centerLatitude" form:"centerLatitude" gorm:"column:center_latitude;default:0;comment:中心点纬度 为0时使用店铺位置;size:20;"`
+	Radius          *float64 `json:"radius" form:"radius" gorm:"column:radius;default:0;comment:配送半径(公里);size:10;"`
+	Polygon         string   `json:"polygon" form:"polygon" gorm:"column:polygon;comment:多边形顶点 格式为 经度,纬度;经度,纬度;type:text;"`
+	Sort            *int     `json:"sort" form:"sort" gorm:"column:sort;default:0;comment:排序;size:10;"`
+	Status          *int     `json:"status" form:"status" gorm:"column:status;default:1;comment:状态(0禁用 1启用);"`
+}
+
+// TableName DeliveryZone 表名
+func (DeliveryZone) TableName() string {
+	return "shop_delivery_zone"
+}
@@ -2,6 +2,7 @@ package shop
 
 import (
 	"fresh-shop/server/global"
+	"math"
 	"time"
 )
 
@@ -18,9 +19,13 @@ type Order struct {
 	Num             int            `json:"num" form:"num" gorm:"column:num;comment:商品总数量;size:10;"`
 	Total           float64        `json:"total" form:"total" gorm:"column:total;comment:订单商品总金额;size:14;"`
 	Postage         float64        `json:"postage" form:"postage" gorm:"column:postage;comment:邮费;size:14;"`
+	DeductPoints    float64        `json:"deductPoints" form:"deductPoints" gorm:"column:deduct_points;comment:积分抵扣数量;size:14;"`
+	DeductAmount    float64        `json:"deductAmount" form:"deductAmount" gorm:"column:deduct_amount;comment:积分抵扣金额;size:14;"`
 	Finish          float64        `json:"finish" form:"finish" gorm:"column:finish;comment:实付金额;size:14;"`
 	Payment         *int           `json:"payment" form:"payment" gorm:"column:payment;comment:支付方式(1余额 2微信 3支付宝 4积分);"`
-	PickUpNumber    int            `json:"pickUpNumber" form:"pickUpNumber" gorm:"column:pick_up_number;comment:取餐号码;size:11;"`
+	PickUpNumber    int            `json:"pickUpNumber" form:"pickUpNumber" gorm:"column:pick_up_number;comment:取餐号码 支付后分配 每天每个自提点重新计数;size:11;"`
+	PickUpPointId   int            `json:"pickUpPointId" form:"pickUpPointId" gorm:"column:pick_up_point_id;default:0;comment:自提点id 0为默认门店;"`
+	DeliverySlotId  uint           `json:"deliverySlotId" form:"deliverySlotId" gorm:"column:delivery_slot_id;default:0;comment:预约的配送时段id 0为未预约;"`
 	PaymentInfo     string         `json:"paymentInfo" form:"paymentInfo" gorm:"column:payment_info;comment:支付详情信息;size:255;"`
 	PaymentOpenid   string         `json:"paymentOpenid" form:"paymentOpenid" gorm:"column:payment_openid;comment:支付openId;size:255;"`
 	TransationId    string         `json:"transationId" form:"transationId" gorm:"column:transation_id;comment:支付流水订单号;size:255;"`
@@ -32,11 +37,17 @@ type Order struct {
 	ShipmentTime    *time.Time     `json:"shipmentTime" form:"shipmentTime" gorm:"column:shipment_time;comment:发货时间;"`
 	ReceiveTime     *time.Time     `json:"receiveTime" form:"receiveTime" gorm:"column:receive_time;comment:收货时间;"`
 	CancelTime      *time.Time     `json:"cancelTime" form:"cancelTime" gorm:"column:cancel_time;comment:取消时间;"`
+	CancelBy        string         `json:"cancelBy" form:"cancelBy" gorm:"column:cancel_by;comment:取消操作人(用户名、管理员用户名或 system);size:191;"`
+	CancelReason    string         `json:"cancelReason" form:"cancelReason" gorm:"column:cancel_reason;comment:取消原因;size:255;"`
+	RefundSn        string         `json:"refundSn" form:"refundSn" gorm:"column:refund_sn;comment:退款单号;size:64;"`
+	RefundTime      *time.Time     `json:"refundTime" form:"refundTime" gorm:"column:refund_time;comment:退款完成时间;"`
 	GiftPoints      float64        `json:"giftPoints" form:"giftPoints" gorm:"column:gift_points;comment:赠送积分数量;size:10;"`
 	AddressId       int            `json:"addressId" form:"addressId" gorm:"-"`       // 收货地址id
 	OrderDetails    []OrderDetails `json:"details"`                                   // 订单详情
-	OrderReturn     OrderReturn    `json:"return"`                                    // 订单售后
+	OrderReturn     OrderReturn    `json:"return" gorm:"-"`                           // 最近一次售后 由 OrderReturns 填充
+	OrderReturns    []OrderReturn  `json:"returns" gorm:"foreignKey:OrderId"`         // 订单全部售后 按申请时间倒序
 	OrderDelivery   OrderDelivery  `json:"delivery" gorm:"foreignKey:order_id"`       // 订单发货信息
+	Logs            []OrderLog     `json:"logs" gorm:"foreignKey:OrderId"`            // 订单状态变更记录
 	PointGoodsId    int            `json:"pointGoodsId" form:"pointGoodsId" gorm:"-"` // 积分商品id 下单用
 }
 
@@ -44,3 +55,8 @@ type Order struct {
 func (Order) TableName() string {
 	return "shop_order"
 }
+
+// PayAmount 订单需要支付的现金金额 商品总金额 + 邮费 - 积分抵扣金额
+func (o Order) PayAmount() float64 {
+	return math.Round((o.Total+o.Postage-o.DeductAmount)*100) / 100
+}
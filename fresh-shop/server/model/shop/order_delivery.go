@@ -6,16 +6,36 @@ import (
 	"time"
 )
 
+// 配送状态
+const (
+	DeliveryStatusWaiting   = 0 // 待取货
+	DeliveryStatusPickedUp  = 1 // 已取货
+	DeliveryStatusArrived   = 2 // 已到达
+	DeliveryStatusDelivered = 3 // 已送达
+)
+
 // OrderDelivery 结构体
 type OrderDelivery struct {
 	global.DbModel
-	OrderId       *int                  `json:"orderId" form:"orderId" gorm:"column:order_id;comment:订单Id;size:20;"`
-	ScheduledTime time.Time             `json:"scheduledTime" form:"scheduledTime" gorm:"column:scheduled_time;comment:预计到达时间;"`
-	DeliverName   string                `json:"deliverName" form:"deliverName" gorm:"column:deliver_name;comment:送货人姓名;"`
-	DeliveryId    *int                  `json:"deliveryId" form:"deliveryId" gorm:"column:delivery_id;comment:送货人ID;size:11;"`
-	DeliverMobile string                `json:"deliverMobile" form:"deliverMobile" gorm:"column:deliver_mobile;comment:送货人联系电话;size:11;"`
-	ReceiptTime   *time.Time            `json:"receiptTime" form:"receiptTime" gorm:"column:receipt_time;comment:收货时间;"`
-	UserDelivery  business.UserDelivery `json:"user" gorm:"foreignKey:id;references:delivery_id"`
+	OrderId        *int                  `json:"orderId" form:"orderId" gorm:"column:order_id;comment:订单Id;size:20;"`
+	ScheduledTime  time.Time             `json:"scheduledTime" form:"scheduledTime" gorm:"column:scheduled_time;comment:预计到达时间;"`
+	DeliverName    string                `json:"deliverName" form:"deliverName" gorm:"column:deliver_name;comment:送货人姓名;"`
+	DeliveryId     *int                  `json:"deliveryId" form:"deliveryId" gorm:"column:delivery_id;comment:送货人ID;size:11;"`
+	DeliverMobile  string                `json:"deliverMobile" form:"deliverMobile" gorm:"column:deliver_mobile;comment:送货人联系电话;size:11;"`
+	Status         *int                  `json:"status" form:"status" gorm:"column:status;default:0;comment:配送状态(0待取货 1已取货 2已到达 3已送达);"`
+	PickUpTime     *time.Time            `json:"pickUpTime" form:"pickUpTime" gorm:"column:pick_up_time;comment:送货员取货时间;"`
+	ArriveTime     *time.Time            `json:"arriveTime" form:"arriveTime" gorm:"column:arrive_time;comment:送货员到达时间;"`
+	ReceiptTime    *time.Time            `json:"receiptTime" form:"receiptTime" gorm:"column:receipt_time;comment:收货时间;"`
+	ProofImage     string                `json:"proofImage" form:"proofImage" gorm:"column:proof_image;comment:送达凭证照片;size:255;"`
+	ProofImageKey  string                `json:"-" gorm:"column:proof_image_key;comment:送达凭证照片文件key;size:255;"`
+	SignImage      string                `json:"signImage" form:"signImage" gorm:"column:sign_image;comment:收货人签名图片;size:255;"`
+	SignImageKey   string                `json:"-" gorm:"column:sign_image_key;comment:收货人签名图片文件key;size:255;"`
+	ProofLongitude *float64              `json:"proofLongitude" form:"proofLongitude" gorm:"column:proof_longitude;comment:上传送达凭证时的经度;"`
+	ProofLatitude  *float64              `json:"proofLatitude" form:"proofLatitude" gorm:"column:proof_latitude;comment:上传送达凭证时的纬度;"`
+	ProofTime      *time.Time            `json:"proofTime" form:"proofTime" gorm:"column:proof_time;comment:上传送达凭证时间;"`
+	SlotStartTime  *time.Time            `json:"slotStartTime" form:"slotStartTime" gorm:"column:slot_start_time;comment:用户预约的配送开始时间;"`
+	SlotEndTime    *time.Time            `json:"slotEndTime" form:"slotEndTime" gorm:"column:slot_end_time;comment:用户预约的配送结束时间;"`
+	UserDelivery   business.UserDelivery `json:"user" gorm:"foreignKey:id;references:delivery_id"`
 }
 
 // TableName OrderDelivery 表名
@@ -0,0 +1,22 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+)
+
+// OrderLog 订单状态变更记录
+type OrderLog struct {
+	global.DbModel
+	OrderId    uint   `json:"orderId" form:"orderId" gorm:"column:order_id;comment:订单id;index;"`
+	FromState  string `json:"fromState" form:"fromState" gorm:"column:from_state;comment:变更前状态 创建订单时为空;size:20;"`
+	ToState    string `json:"toState" form:"toState" gorm:"column:to_state;comment:变更后状态;size:20;"`
+	Actor      string `json:"actor" form:"actor" gorm:"column:actor;comment:操作人类型(user用户 admin管理员 system系统回调 timer定时任务 deliverer送货员);size:20;"`
+	OperatorId uint   `json:"operatorId" form:"operatorId" gorm:"column:operator_id;comment:操作人id;"`
+	Operator   string `json:"operator" form:"operator" gorm:"column:operator;comment:操作人用户名;size:191;"`
+	Reason     string `json:"reason" form:"reason" gorm:"column:reason;comment:变更原因;size:255;"`
+}
+
+// TableName OrderLog 表名
+func (OrderLog) TableName() string {
+	return "shop_order_log"
+}
@@ -5,18 +5,31 @@ import (
 	"time"
 )
 
+// 售后状态 对应 shop_order_return.status
+const (
+	ReturnStatusRejected = -1 // 拒绝售后
+	ReturnStatusPending  = 0  // 待处理
+	ReturnStatusApproved = 1  // 同意售后并退款
+)
+
 // OrderReturn 结构体
 type OrderReturn struct {
 	global.DbModel
-	UserId       *int               `json:"userId" form:"userId" gorm:"column:user_id;comment:用户id;size:20;"`
-	OrderId      *int               `json:"orderId" form:"orderId" gorm:"column:order_id;comment:订单Id;size:20;"`
-	Reason       string             `json:"reason" form:"reason" gorm:"column:reason;comment:申请原因;size:255;"`
-	Amount       *float64           `json:"amount" form:"amount" gorm:"column:amount;comment:退款金额;size:14;"`
-	Status       *int               `json:"status" form:"status" gorm:"column:status;comment:售后状态(-1 拒绝售后 0未处理 1已退款);"`
-	RefundStatus *int               `json:"refundStatus" form:"refundStatus" gorm:"column:refund_status;comment:退款状态;"`
-	Reply        string             `json:"reply" form:"reply" gorm:"column:reply;comment:售后说明;size:255;"`
-	ProcessTime  *time.Time         `json:"processTime" form:"processTime" gorm:"column:process_time;comment:售后处理时间;"`
-	Details      OrderReturnDetails `json:"details" gorm:"foreignKey:return_id"`
+	UserId       *int                 `json:"userId" form:"userId" gorm:"column:user_id;comment:用户id;size:20;"`
+	OrderId      *int                 `json:"orderId" form:"orderId" gorm:"column:order_id;comment:订单Id;size:20;"`
+	Reason       string               `json:"reason" form:"reason" gorm:"column:reason;comment:申请原因;size:255;"`
+	Images       string               `json:"images" form:"images" gorm:"column:images;comment:售后图片 多张用逗号分隔;size:1000;"`
+	Amount       *float64             `json:"amount" form:"amount" gorm:"column:amount;comment:退款金额;size:14;"`
+	Points       float64              `json:"points" form:"points" gorm:"column:points;comment:退还的抵扣积分;size:14;"`
+	Status       *int                 `json:"status" form:"status" gorm:"column:status;comment:售后状态(-1 拒绝售后 0未处理 1已同意退款);"`
+	RefundStatus *int                 `json:"refundStatus" form:"refundStatus" gorm:"column:refund_status;comment:退款状态(0未退款 1退款中 2已退款 3退款失败);"`
+	RefundSn     string               `json:"refundSn" form:"refundSn" gorm:"column:refund_sn;comment:退款单号;size:64;"`
+	RefundTime   *time.Time           `json:"refundTime" form:"refundTime" gorm:"column:refund_time;comment:退款时间;"`
+	Restock      *int                 `json:"restock" form:"restock" gorm:"column:restock;comment:是否归还库存(0否 1是);"`
+	Reply        string               `json:"reply" form:"reply" gorm:"column:reply;comment:售后说明;size:255;"`
+	ProcessTime  *time.Time           `json:"processTime" form:"processTime" gorm:"column:process_time;comment:售后处理时间;"`
+	Details      []OrderReturnDetails `json:"details" gorm:"foreignKey:return_id"`
+	Delivery     *OrderDelivery       `json:"delivery,omitempty" form:"-" gorm:"-"` // 订单配送信息 包含送达凭证 售后审核时查看
 }
 
 // TableName OrderReturn 表名
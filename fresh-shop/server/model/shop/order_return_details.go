@@ -7,9 +7,11 @@ import (
 // OrderReturnDetails 结构体
 type OrderReturnDetails struct {
 	global.DbModel
-	OrderDetailId *int `json:"orderDetailId" form:"orderDetailId" gorm:"column:order_detail_id;comment:订单商品Id;size:20;"`
-	ReturnId      *int `json:"returnId" form:"returnId" gorm:"column:return_id;comment:售后订单Id;size:20;"`
-	Num           *int `json:"num" form:"num" gorm:"column:num;comment:申请售后的数量;size:10;"`
+	OrderDetailId *int    `json:"orderDetailId" form:"orderDetailId" gorm:"column:order_detail_id;comment:订单商品Id;size:20;"`
+	ReturnId      *int    `json:"returnId" form:"returnId" gorm:"column:return_id;comment:售后订单Id;size:20;"`
+	Num           *int    `json:"num" form:"num" gorm:"column:num;comment:申请售后的数量;size:10;"`
+	GoodsName     string  `json:"goodsName" form:"goodsName" gorm:"column:goods_name;comment:商品名称;size:255;"`
+	Amount        float64 `json:"amount" form:"amount" gorm:"column:amount;comment:退款金额 按比例分摊邮费和积分抵扣;size:14;"`
 }
 
 // TableName OrderReturnDetails 表名
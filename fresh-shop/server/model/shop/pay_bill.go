@@ -0,0 +1,59 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+)
+
+// 对账单来源
+const (
+	PayBillSourceDownload = "download" // 通过微信支付接口下载
+	PayBillSourceUpload   = "upload"   // 后台上传对账单文件
+)
+
+// PayBill 微信支付对账结果，每个账单日期只保留最后一次对账的结果
+type PayBill struct {
+	global.DbModel
+	BillDate      string        `json:"billDate" form:"billDate" gorm:"column:bill_date;comment:账单日期;size:10;uniqueIndex;"`
+	Source        string        `json:"source" form:"source" gorm:"column:source;comment:对账单来源(download下载 upload上传);size:20;"`
+	TradeCount    int           `json:"tradeCount" form:"tradeCount" gorm:"column:trade_count;comment:对账单支付笔数;"`
+	TradeAmount   float64       `json:"tradeAmount" form:"tradeAmount" gorm:"column:trade_amount;comment:对账单支付金额;size:14;"`
+	RefundCount   int           `json:"refundCount" form:"refundCount" gorm:"column:refund_count;comment:对账单退款笔数;"`
+	RefundAmount  float64       `json:"refundAmount" form:"refundAmount" gorm:"column:refund_amount;comment:对账单退款金额;size:14;"`
+	MatchedCount  int           `json:"matchedCount" form:"matchedCount" gorm:"column:matched_count;comment:核对一致笔数;"`
+	MissingCount  int           `json:"missingCount" form:"missingCount" gorm:"column:missing_count;comment:本地有对账单没有的笔数;"`
+	MismatchCount int           `json:"mismatchCount" form:"mismatchCount" gorm:"column:mismatch_count;comment:金额或状态不一致的笔数;"`
+	OrphanCount   int           `json:"orphanCount" form:"orphanCount" gorm:"column:orphan_count;comment:对账单有本地没有的笔数;"`
+	Items         []PayBillItem `json:"items,omitempty" form:"-" gorm:"foreignKey:BillId"`
+}
+
+// TableName PayBill 表名
+func (PayBill) TableName() string {
+	return "shop_pay_bill"
+}
+
+// 对账差异类型
+const (
+	PayBillItemMissing  = "missing"  // 本地已支付或已退款，对账单中没有
+	PayBillItemMismatch = "mismatch" // 金额或订单状态不一致
+	PayBillItemOrphan   = "orphan"   // 对账单中有，本地没有对应的订单
+)
+
+// PayBillItem 对账差异明细
+type PayBillItem struct {
+	global.DbModel
+	BillId        uint    `json:"billId" form:"billId" gorm:"column:bill_id;comment:对账结果id;index;"`
+	Type          string  `json:"type" form:"type" gorm:"column:type;comment:差异类型(missing本地有对账单没有 mismatch不一致 orphan对账单有本地没有);size:20;"`
+	TradeType     string  `json:"tradeType" form:"tradeType" gorm:"column:trade_type;comment:交易类型(pay支付 refund退款);size:20;"`
+	OrderId       uint    `json:"orderId" form:"orderId" gorm:"column:order_id;comment:订单id;"`
+	OrderSn       string  `json:"orderSn" form:"orderSn" gorm:"column:order_sn;comment:订单号;size:64;"`
+	TransactionId string  `json:"transactionId" form:"transactionId" gorm:"column:transaction_id;comment:微信交易号;size:64;"`
+	RefundSn      string  `json:"refundSn" form:"refundSn" gorm:"column:refund_sn;comment:退款单号;size:64;"`
+	BillAmount    float64 `json:"billAmount" form:"billAmount" gorm:"column:bill_amount;comment:对账单金额;size:14;"`
+	OrderAmount   float64 `json:"orderAmount" form:"orderAmount" gorm:"column:order_amount;comment:本地金额;size:14;"`
+	Remark        string  `json:"remark" form:"remark" gorm:"column:remark;comment:差异说明;size:255;"`
+}
+
+// TableName PayBillItem 表名
+func (PayBillItem) TableName() string {
+	return "shop_pay_bill_item"
+}
@@ -0,0 +1,26 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+	"time"
+)
+
+// PayDuplicate 订单已支付后又收到的第三方支付，例如余额支付后关闭第三方订单失败，用户又完成了第三方支付
+// 保存支付信息并原路退还，transaction_id 唯一，重复通知不会重复退款
+type PayDuplicate struct {
+	global.DbModel
+	OrderId       uint       `json:"orderId" form:"orderId" gorm:"column:order_id;comment:订单id;index;"`
+	OrderSn       string     `json:"orderSn" form:"orderSn" gorm:"column:order_sn;comment:订单号;size:64;index;"`
+	Payment       int        `json:"payment" form:"payment" gorm:"column:payment;comment:支付方式(2微信 3支付宝);"`
+	TransactionId string     `json:"transactionId" form:"transactionId" gorm:"column:transaction_id;comment:第三方交易号;size:64;uniqueIndex;"`
+	Amount        float64    `json:"amount" form:"amount" gorm:"column:amount;comment:支付金额;size:14;"`
+	PayTime       *time.Time `json:"payTime" form:"payTime" gorm:"column:pay_time;comment:支付时间;"`
+	RefundSn      string     `json:"refundSn" form:"refundSn" gorm:"column:refund_sn;comment:退款单号;size:64;index;"`
+	RefundStatus  *int       `json:"refundStatus" form:"refundStatus" gorm:"column:refund_status;comment:退款状态(1退款中 2已退款 3退款失败);"`
+	RefundTime    *time.Time `json:"refundTime" form:"refundTime" gorm:"column:refund_time;comment:退款时间;"`
+}
+
+// TableName PayDuplicate 表名
+func (PayDuplicate) TableName() string {
+	return "shop_pay_duplicate"
+}
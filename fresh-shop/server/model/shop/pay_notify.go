@@ -0,0 +1,35 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+)
+
+// 支付通知类型
+const (
+	PayNotifyTypePay    = "pay"    // 支付通知
+	PayNotifyTypeRefund = "refund" // 退款通知
+)
+
+// 支付通知处理结果
+const (
+	PayNotifyStatusPending = 0 // 已接收未处理完成
+	PayNotifyStatusSuccess = 1 // 处理成功
+	PayNotifyStatusFail    = 2 // 处理失败
+)
+
+// PayNotify 第三方支付的支付和退款通知原始报文，用于审计和排查问题
+type PayNotify struct {
+	global.DbModel
+	Payment       int    `json:"payment" form:"payment" gorm:"column:payment;comment:支付方式(2微信 3支付宝);"`
+	Type          string `json:"type" form:"type" gorm:"column:type;comment:通知类型(pay支付 refund退款);size:20;index;"`
+	OrderSn       string `json:"orderSn" form:"orderSn" gorm:"column:order_sn;comment:订单号;size:64;index;"`
+	TransactionId string `json:"transactionId" form:"transactionId" gorm:"column:transaction_id;comment:第三方交易号 退款通知为退款单号;size:64;"`
+	Body          string `json:"body" form:"body" gorm:"column:body;comment:原始报文;type:text;"`
+	Status        *int   `json:"status" form:"status" gorm:"column:status;comment:处理结果(0未完成 1成功 2失败);default:0;"`
+	Message       string `json:"message" form:"message" gorm:"column:message;comment:处理失败原因;size:255;"`
+}
+
+// TableName PayNotify 表名
+func (PayNotify) TableName() string {
+	return "shop_pay_notify"
+}
@@ -0,0 +1,18 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+)
+
+// PickUpSequence 取餐号码序列 每天每个自提点一条记录
+type PickUpSequence struct {
+	global.DbModel
+	Day           string `json:"day" form:"day" gorm:"column:day;comment:日期 20060102;size:8;uniqueIndex:idx_day_point;"`
+	PickUpPointId int    `json:"pickUpPointId" form:"pickUpPointId" gorm:"column:pick_up_point_id;comment:自提点id;uniqueIndex:idx_day_point;"`
+	Current       int    `json:"current" form:"current" gorm:"column:current;comment:当前已分配的号码;"`
+}
+
+// TableName PickUpSequence 表名
+func (PickUpSequence) TableName() string {
+	return "shop_pick_up_sequence"
+}
@@ -0,0 +1,35 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+)
+
+// 运费规则类型
+const (
+	PostageRuleDefault  = 0 // 默认规则
+	PostageRuleArea     = 1 // 指定地区
+	PostageRuleDistance = 2 // 距离区间
+)
+
+// PostageRule 运费规则 结构体
+// 匹配优先级 指定地区 > 距离区间 > 默认规则，同类型按排序值从小到大匹配第一条
+type PostageRule struct {
+	global.DbModel
+	Name             string   `json:"name" form:"name" gorm:"column:name;comment:规则名称;size:50;"`
+	Type             *int     `json:"type" form:"type" gorm:"column:type;default:0;comment:规则类型(0默认 1指定地区 2距离区间);"`
+	Area             string   `json:"area" form:"area" gorm:"column:area;comment:地区编码 多个用英文逗号分隔 按前缀匹配收货地址地区编码;size:500;"`
+	MinDistance      *float64 `json:"minDistance" form:"minDistance" gorm:"column:min_distance;default:0;comment:最小距离(公里 包含);size:10;"`
+	MaxDistance      *float64 `json:"maxDistance" form:"maxDistance" gorm:"column:max_distance;default:0;comment:最大距离(公里 不包含);size:10;"`
+	FreeAmount       *float64 `json:"freeAmount" form:"freeAmount" gorm:"column:free_amount;default:0;comment:包邮金额 0不包邮;size:14;"`
+	FirstWeight      *int     `json:"firstWeight" form:"firstWeight" gorm:"column:first_weight;default:0;comment:首重(克);size:10;"`
+	FirstFee         *float64 `json:"firstFee" form:"firstFee" gorm:"column:first_fee;default:0;comment:首重费用;size:14;"`
+	AdditionalWeight *int     `json:"additionalWeight" form:"additionalWeight" gorm:"column:additional_weight;default:0;comment:续重(克);size:10;"`
+	AdditionalFee    *float64 `json:"additionalFee" form:"additionalFee" gorm:"column:additional_fee;default:0;comment:续重费用;size:14;"`
+	Sort             *int     `json:"sort" form:"sort" gorm:"column:sort;default:0;comment:排序;size:10;"`
+	Status           *int     `json:"status" form:"status" gorm:"column:status;default:1;comment:状态(0禁用 1启用);"`
+}
+
+// TableName PostageRule 表名
+func (PostageRule) TableName() string {
+	return "shop_postage_rule"
+}
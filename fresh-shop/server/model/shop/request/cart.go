@@ -12,3 +12,8 @@ type CartSearch struct {
 	EndCreatedAt   *time.Time `json:"endCreatedAt" form:"endCreatedAt"`
 	request.PageInfo
 }
+
+// BuyAgainReq 再次购买参数
+type BuyAgainReq struct {
+	OrderId uint `json:"orderId"` // 订单id
+}
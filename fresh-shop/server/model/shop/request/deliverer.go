@@ -0,0 +1,18 @@
+package request
+
+import (
+	"fresh-shop/server/model/common/request"
+)
+
+// DeliveryTaskSearch 送货员查询自己的配送任务
+type DeliveryTaskSearch struct {
+	Status *int `json:"status" form:"status"` // 配送状态
+	request.PageInfo
+}
+
+// DeliveryProofReq 送货员上传送达凭证 照片和签名通过表单文件上传
+type DeliveryProofReq struct {
+	ID        uint     `json:"id" form:"id"`               // 配送单id
+	Longitude *float64 `json:"longitude" form:"longitude"` // 经度
+	Latitude  *float64 `json:"latitude" form:"latitude"`   // 纬度
+}
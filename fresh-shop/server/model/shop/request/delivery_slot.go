@@ -0,0 +1,16 @@
+package request
+
+import (
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
+	"time"
+)
+
+type DeliverySlotSearch struct {
+	shop.DeliverySlot
+	StartCreatedAt *time.Time `json:"startCreatedAt" form:"startCreatedAt"`
+	EndCreatedAt   *time.Time `json:"endCreatedAt" form:"endCreatedAt"`
+	StartDay       string     `json:"startDay" form:"startDay"`
+	EndDay         string     `json:"endDay" form:"endDay"`
+	request.PageInfo
+}
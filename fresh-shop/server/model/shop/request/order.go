@@ -1,20 +1,29 @@
 package request
 
 import (
-	"fresh-shop/server/model/shop"
 	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
 	"time"
 )
 
-type OrderSearch struct{
-    shop.Order
-    StartCreatedAt *time.Time `json:"startCreatedAt" form:"startCreatedAt"`
-    EndCreatedAt   *time.Time `json:"endCreatedAt" form:"endCreatedAt"`
-    StartShipmentTime  *time.Time  `json:"startShipmentTime" form:"startShipmentTime"`
-    EndShipmentTime  *time.Time  `json:"endShipmentTime" form:"endShipmentTime"`
-    StartReceiveTime  *time.Time  `json:"startReceiveTime" form:"startReceiveTime"`
-    EndReceiveTime  *time.Time  `json:"endReceiveTime" form:"endReceiveTime"`
-    StartCancelTime  *time.Time  `json:"startCancelTime" form:"startCancelTime"`
-    EndCancelTime  *time.Time  `json:"endCancelTime" form:"endCancelTime"`
-    request.PageInfo
+type OrderSearch struct {
+	shop.Order
+	StartCreatedAt    *time.Time `json:"startCreatedAt" form:"startCreatedAt"`
+	EndCreatedAt      *time.Time `json:"endCreatedAt" form:"endCreatedAt"`
+	StartShipmentTime *time.Time `json:"startShipmentTime" form:"startShipmentTime"`
+	EndShipmentTime   *time.Time `json:"endShipmentTime" form:"endShipmentTime"`
+	StartReceiveTime  *time.Time `json:"startReceiveTime" form:"startReceiveTime"`
+	EndReceiveTime    *time.Time `json:"endReceiveTime" form:"endReceiveTime"`
+	StartCancelTime   *time.Time `json:"startCancelTime" form:"startCancelTime"`
+	EndCancelTime     *time.Time `json:"endCancelTime" form:"endCancelTime"`
+	request.PageInfo
+}
+
+// OrderPayReq 订单支付参数
+type OrderPayReq struct {
+	ID           uint    `json:"id"`           // 订单id
+	Payment      int     `json:"payment"`      // 支付方式(1余额 2微信 3支付宝) 不传默认微信
+	BuyerId      string  `json:"buyerId"`      // 支付宝小程序用户的 user_id 支付宝 H5 支付时不传
+	SafePassword string  `json:"safePassword"` // 安全密码 余额支付时必填
+	DeductPoints float64 `json:"deductPoints"` // 积分抵扣数量 下单时未使用积分抵扣时可以在支付时使用
 }
@@ -14,3 +14,25 @@ type OrderReturnSearch struct{
     EndProcessTime  *time.Time  `json:"endProcessTime" form:"endProcessTime"`
     request.PageInfo
 }
+
+// OrderReturnApplyReq 用户申请售后
+type OrderReturnApplyReq struct {
+	OrderId uint              `json:"orderId"` // 订单id
+	Reason  string            `json:"reason"`  // 申请原因
+	Images  []string          `json:"images"`  // 售后图片
+	Details []OrderReturnLine `json:"details"` // 售后商品 为空时整单售后
+}
+
+// OrderReturnLine 申请售后的商品和数量
+type OrderReturnLine struct {
+	OrderDetailId uint `json:"orderDetailId"` // 订单商品id
+	Num           int  `json:"num"`           // 售后数量
+}
+
+// OrderReturnProcessReq 后台处理售后申请
+type OrderReturnProcessReq struct {
+	ID      uint   `json:"id"`      // 售后id
+	Approve bool   `json:"approve"` // 是否同意 同意后按原支付方式退款
+	Reply   string `json:"reply"`   // 售后说明
+	Restock bool   `json:"restock"` // 是否归还库存
+}
@@ -0,0 +1,16 @@
+package request
+
+import (
+	"fresh-shop/server/model/common/request"
+)
+
+type PayBillSearch struct {
+	StartDate string `json:"startDate" form:"startDate"` // 账单开始日期 2006-01-02
+	EndDate   string `json:"endDate" form:"endDate"`     // 账单结束日期 2006-01-02
+	request.PageInfo
+}
+
+// PayBillReq 下载或上传对账单核对
+type PayBillReq struct {
+	BillDate string `json:"billDate" form:"billDate"` // 账单日期 2006-01-02
+}
@@ -0,0 +1,14 @@
+package request
+
+import (
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
+	"time"
+)
+
+type PostageRuleSearch struct {
+	shop.PostageRule
+	StartCreatedAt *time.Time `json:"startCreatedAt" form:"startCreatedAt"`
+	EndCreatedAt   *time.Time `json:"endCreatedAt" form:"endCreatedAt"`
+	request.PageInfo
+}
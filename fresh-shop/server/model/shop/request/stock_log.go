@@ -0,0 +1,30 @@
+package request
+
+import (
+	"fresh-shop/server/model/common/request"
+	"time"
+)
+
+type StockLogSearch struct {
+	GoodsId        uint       `json:"goodsId" form:"goodsId"`
+	SpecValueId    *int       `json:"specValueId" form:"specValueId"`
+	GoodsName      string     `json:"goodsName" form:"goodsName"`
+	Reason         string     `json:"reason" form:"reason"` // 变更原因 order cancel return import manual stocktake
+	RefId          string     `json:"refId" form:"refId"`   // 关联单号
+	StartCreatedAt *time.Time `json:"startCreatedAt" form:"startCreatedAt"`
+	EndCreatedAt   *time.Time `json:"endCreatedAt" form:"endCreatedAt"`
+	request.PageInfo
+}
+
+// StocktakeReq 盘点 按实际盘点数量修改库存
+type StocktakeReq struct {
+	Items  []StocktakeItem `json:"items"`
+	Remark string          `json:"remark"` // 盘点说明
+}
+
+// StocktakeItem 盘点明细 多规格商品按规格明细盘点
+type StocktakeItem struct {
+	GoodsId     uint `json:"goodsId"`
+	SpecValueId int  `json:"specValueId"` // 单规格商品为 0
+	Store       int  `json:"store"`       // 实际盘点数量
+}
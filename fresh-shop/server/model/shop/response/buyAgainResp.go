@@ -0,0 +1,21 @@
+package response
+
+// BuyAgainResp 再次购买 原订单商品重新加入购物车的结果
+type BuyAgainResp struct {
+	Added    []BuyAgainLine `json:"added"`    // 按原数量加入购物车的商品
+	Adjusted []BuyAgainLine `json:"adjusted"` // 已加入购物车 但数量或价格有调整的商品
+	Skipped  []BuyAgainLine `json:"skipped"`  // 未加入购物车的商品
+}
+
+// BuyAgainLine 再次购买商品明细
+type BuyAgainLine struct {
+	GoodsId   uint    `json:"goodsId"`   // 商品id
+	SpecId    int     `json:"specId"`    // 规格明细id
+	GoodsName string  `json:"goodsName"` // 商品名称 多规格商品包含规格名称
+	Num       int     `json:"num"`       // 原订单购买数量
+	AddNum    int     `json:"addNum"`    // 本次加入购物车的数量
+	CartNum   int     `json:"cartNum"`   // 加入后购物车中的数量
+	OldPrice  float64 `json:"oldPrice"`  // 原订单单价
+	Price     float64 `json:"price"`     // 当前单价
+	Reason    string  `json:"reason"`    // 调整或跳过的原因
+}
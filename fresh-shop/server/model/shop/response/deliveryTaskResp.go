@@ -0,0 +1,15 @@
+package response
+
+import "fresh-shop/server/model/shop"
+
+// DeliveryTask 送货员的配送任务 只包含配送需要的订单信息
+type DeliveryTask struct {
+	shop.OrderDelivery
+	OrderSn         string              `json:"orderSn"`         // 订单编号
+	ShipmentName    string              `json:"shipmentName"`    // 收货人姓名
+	ShipmentMobile  string              `json:"shipmentMobile"`  // 收货人手机号
+	ShipmentAddress string              `json:"shipmentAddress"` // 收货人地址
+	Remarks         string              `json:"remarks"`         // 留言
+	Num             int                 `json:"num"`             // 商品总数量
+	Details         []shop.OrderDetails `json:"details"`         // 订单详情 仅查询单个配送任务时返回
+}
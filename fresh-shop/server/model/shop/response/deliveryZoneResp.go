@@ -0,0 +1,9 @@
+package response
+
+// DeliveryZoneCheckResp 收货地址配送范围校验结果
+type DeliveryZoneCheckResp struct {
+	Deliverable bool   `json:"deliverable"` // 是否可以配送
+	ZoneId      uint   `json:"zoneId"`      // 匹配的配送区域id
+	ZoneName    string `json:"zoneName"`    // 匹配的配送区域名称
+	Message     string `json:"message"`     // 不能配送的原因
+}
@@ -0,0 +1,28 @@
+package response
+
+import "fresh-shop/server/model/shop"
+
+// OrderPreviewResp 订单预览 与创建订单使用同一个计价方法
+type OrderPreviewResp struct {
+	Lines        []OrderPreviewLine `json:"lines"`        // 商品明细
+	Num          int                `json:"num"`          // 商品总数量
+	Total        float64            `json:"total"`        // 商品总金额
+	Discount     float64            `json:"discount"`     // 优惠金额
+	Postage      float64            `json:"postage"`      // 邮费
+	DeductPoints float64            `json:"deductPoints"` // 积分抵扣数量
+	DeductAmount float64            `json:"deductAmount"` // 积分抵扣金额
+	GiftPoints   float64            `json:"giftPoints"`   // 赠送积分
+	PayAmount    float64            `json:"payAmount"`    // 需要支付的金额 积分商品为需要的积分
+	Available    bool               `json:"available"`    // 是否可以下单 存在失效或库存不足的商品时为 false
+	Message      string             `json:"message"`      // 不可下单或积分抵扣失败的原因
+}
+
+// OrderPreviewLine 订单预览商品明细
+type OrderPreviewLine struct {
+	shop.OrderDetails
+	OriginalPrice float64 `json:"originalPrice"` // 原价
+	Discount      float64 `json:"discount"`      // 优惠金额
+	Store         int     `json:"store"`         // 当前库存
+	Available     bool    `json:"available"`     // 是否可以购买
+	Warning       string  `json:"warning"`       // 不可购买的原因
+}
@@ -0,0 +1,38 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+)
+
+// 库存变更原因
+const (
+	StockReasonOrder     = "order"     // 下单扣减
+	StockReasonCancel    = "cancel"    // 取消订单归还
+	StockReasonReturn    = "return"    // 售后退货归还
+	StockReasonImport    = "import"    // Excel 导入
+	StockReasonManual    = "manual"    // 后台编辑商品
+	StockReasonStocktake = "stocktake" // 盘点
+)
+
+// StockLog 库存变更流水，每次商品或规格明细的库存变化记录一条
+type StockLog struct {
+	global.DbModel
+	GoodsId     uint   `json:"goodsId" form:"goodsId" gorm:"column:goods_id;comment:商品id;index;"`
+	SpecValueId int    `json:"specValueId" form:"specValueId" gorm:"column:spec_value_id;comment:规格明细id 单规格商品为0;index;"`
+	GoodsName   string `json:"goodsName" form:"goodsName" gorm:"column:goods_name;comment:商品名称;size:191;"`
+	KeyName     string `json:"keyName" form:"keyName" gorm:"column:key_name;comment:规格名称;size:500;"`
+	Delta       int    `json:"delta" form:"delta" gorm:"column:delta;comment:变更数量 增加为正 减少为负;"`
+	Before      int    `json:"before" form:"before" gorm:"column:before_store;comment:变更前库存;"`
+	After       int    `json:"after" form:"after" gorm:"column:after_store;comment:变更后库存;"`
+	Reason      string `json:"reason" form:"reason" gorm:"column:reason;comment:变更原因(order下单 cancel取消订单 return售后退货 import导入 manual编辑商品 stocktake盘点);size:20;index;"`
+	RefId       string `json:"refId" form:"refId" gorm:"column:ref_id;comment:关联单号 订单号、售后id、导入文件名、盘点单号;size:191;index;"`
+	Actor       string `json:"actor" form:"actor" gorm:"column:actor;comment:操作人类型(user用户 admin管理员 system系统回调 timer定时任务);size:20;"`
+	OperatorId  uint   `json:"operatorId" form:"operatorId" gorm:"column:operator_id;comment:操作人id;"`
+	Operator    string `json:"operator" form:"operator" gorm:"column:operator;comment:操作人用户名;size:191;"`
+	Remark      string `json:"remark" form:"remark" gorm:"column:remark;comment:备注;size:255;"`
+}
+
+// TableName StockLog 表名
+func (StockLog) TableName() string {
+	return "shop_stock_log"
+}
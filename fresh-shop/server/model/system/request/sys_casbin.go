@@ -19,6 +19,7 @@ func DefaultCasbin() []CasbinInfo {
 		{Path: "/base/wechat", Method: "POST"},
 		{Path: "/user/admin_register", Method: "POST"},
 		{Path: "/user/changePassword", Method: "POST"},
+		{Path: "/user/setSafePassword", Method: "POST"},
 		{Path: "/user/setUserAuthority", Method: "POST"},
 		{Path: "/user/setUserInfo", Method: "PUT"},
 		{Path: "/user/getUserInfo", Method: "GET"},
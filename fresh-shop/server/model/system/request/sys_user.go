@@ -34,6 +34,12 @@ type ChangePasswordReq struct {
 	NewPassword string `json:"newPassword"` // 新密码
 }
 
+// SetSafePasswordReq 设置安全密码 首次设置时不需要原安全密码
+type SetSafePasswordReq struct {
+	OldSafePassword string `json:"oldSafePassword"` // 原安全密码
+	SafePassword    string `json:"safePassword"`    // 新安全密码
+}
+
 // Modify  user's auth structure
 type SetUserAuth struct {
 	AuthorityId uint `json:"authorityId"` // 角色ID
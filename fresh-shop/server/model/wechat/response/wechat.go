@@ -2,11 +2,10 @@ package response
 
 import (
 	"fresh-shop/server/model/shop"
-	orderPay "github.com/silenceper/wechat/v2/pay/order"
 )
 
-// CreateOrderResp 创建订单响应
+// CreateOrderResp 创建订单响应 Pay 为客户端调起支付需要的参数，不同支付方式的内容不同
 type CreateOrderResp struct {
-	Pay   orderPay.Config `json:"pay" form:"pay"`
-	Order shop.Order      `json:"order" form:"order"`
+	Pay   interface{} `json:"pay" form:"pay"`
+	Order shop.Order  `json:"order" form:"order"`
 }
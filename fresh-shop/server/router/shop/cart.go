@@ -19,6 +19,7 @@ func (s *CartRouter) InitCartRouter(Router *gin.RouterGroup) {
 		cartRouter.DELETE("deleteCart", cartApi.DeleteCart)           // 删除Cart
 		cartRouter.DELETE("deleteCartByIds", cartApi.DeleteCartByIds) // 批量删除Cart
 		cartRouter.PUT("updateCart", cartApi.UpdateCart)              // 更新Cart
+		cartRouter.POST("buyAgain", cartApi.BuyAgain)                 // 再次购买 订单商品重新加入购物车
 	}
 	{
 		cartRouterWithoutRecord.POST("selectAllChecked", cartApi.SelectAllChecked)               // 全选 Cart
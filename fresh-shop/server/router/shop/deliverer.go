@@ -0,0 +1,27 @@
+package shop
+
+import (
+	"fresh-shop/server/api/v1"
+	"fresh-shop/server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+type DelivererRouter struct {
+}
+
+// InitDelivererRouter 初始化 送货员工作台 路由信息
+func (s *DelivererRouter) InitDelivererRouter(Router *gin.RouterGroup) {
+	delivererRouter := Router.Group("deliverer").Use(middleware.OperationRecord())
+	delivererRouterWithoutRecord := Router.Group("deliverer")
+	var delivererApi = v1.ApiGroupApp.ShopApiGroup.DelivererApi
+	{
+		delivererRouter.POST("pickUpDelivery", delivererApi.PickUpDelivery)   // 确认取货
+		delivererRouter.POST("arriveDelivery", delivererApi.ArriveDelivery)   // 确认到达
+		delivererRouter.POST("deliverDelivery", delivererApi.DeliverDelivery) // 确认送达
+	}
+	{
+		delivererRouterWithoutRecord.POST("uploadDeliveryProof", delivererApi.UploadDeliveryProof) // 上传送达凭证 上传文件不记录操作日志
+		delivererRouterWithoutRecord.GET("getDeliveryTaskList", delivererApi.GetDeliveryTaskList)  // 获取配送任务列表
+		delivererRouterWithoutRecord.GET("findDeliveryTask", delivererApi.FindDeliveryTask)        // 查看配送任务
+	}
+}
@@ -0,0 +1,28 @@
+package shop
+
+import (
+	"fresh-shop/server/api/v1"
+	"fresh-shop/server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+type DeliveryZoneRouter struct {
+}
+
+// InitDeliveryZoneRouter 初始化 DeliveryZone 路由信息
+func (s *DeliveryZoneRouter) InitDeliveryZoneRouter(Router *gin.RouterGroup) {
+	deliveryZoneRouter := Router.Group("deliveryZone").Use(middleware.OperationRecord())
+	deliveryZoneRouterWithoutRecord := Router.Group("deliveryZone")
+	var deliveryZoneApi = v1.ApiGroupApp.ShopApiGroup.DeliveryZoneApi
+	{
+		deliveryZoneRouter.POST("createDeliveryZone", deliveryZoneApi.CreateDeliveryZone)             // 新建DeliveryZone
+		deliveryZoneRouter.DELETE("deleteDeliveryZone", deliveryZoneApi.DeleteDeliveryZone)           // 删除DeliveryZone
+		deliveryZoneRouter.DELETE("deleteDeliveryZoneByIds", deliveryZoneApi.DeleteDeliveryZoneByIds) // 批量删除DeliveryZone
+		deliveryZoneRouter.PUT("updateDeliveryZone", deliveryZoneApi.UpdateDeliveryZone)              // 更新DeliveryZone
+	}
+	{
+		deliveryZoneRouterWithoutRecord.GET("findDeliveryZone", deliveryZoneApi.FindDeliveryZone)       // 根据ID获取DeliveryZone
+		deliveryZoneRouterWithoutRecord.GET("getDeliveryZoneList", deliveryZoneApi.GetDeliveryZoneList) // 获取DeliveryZone列表
+		deliveryZoneRouterWithoutRecord.POST("checkAddress", deliveryZoneApi.CheckAddress)              // 校验收货地址是否在配送范围内
+	}
+}
@@ -13,4 +13,10 @@ type RouterGroup struct {
 	FavoritesRouter
 	CartRouter
 	UserAddressRouter
+	PostageRuleRouter
+	DeliverySlotRouter
+	DeliveryZoneRouter
+	DelivererRouter
+	PayBillRouter
+	StockLogRouter
 }
@@ -15,13 +15,16 @@ func (s *OrderReturnRouter) InitOrderReturnRouter(Router *gin.RouterGroup) {
 	orderReturnRouterWithoutRecord := Router.Group("orderReturn")
 	var orderReturnApi = v1.ApiGroupApp.ShopApiGroup.OrderReturnApi
 	{
-		orderReturnRouter.POST("createOrderReturn", orderReturnApi.CreateOrderReturn)   // 新建OrderReturn
-		orderReturnRouter.DELETE("deleteOrderReturn", orderReturnApi.DeleteOrderReturn) // 删除OrderReturn
+		orderReturnRouter.POST("createOrderReturn", orderReturnApi.CreateOrderReturn)             // 新建OrderReturn
+		orderReturnRouter.DELETE("deleteOrderReturn", orderReturnApi.DeleteOrderReturn)           // 删除OrderReturn
 		orderReturnRouter.DELETE("deleteOrderReturnByIds", orderReturnApi.DeleteOrderReturnByIds) // 批量删除OrderReturn
-		orderReturnRouter.PUT("updateOrderReturn", orderReturnApi.UpdateOrderReturn)    // 更新OrderReturn
+		orderReturnRouter.PUT("updateOrderReturn", orderReturnApi.UpdateOrderReturn)              // 更新OrderReturn
+		orderReturnRouter.POST("applyOrderReturn", orderReturnApi.ApplyOrderReturn)               // 用户申请售后
+		orderReturnRouter.PUT("processOrderReturn", orderReturnApi.ProcessOrderReturn)            // 处理售后申请
 	}
 	{
-		orderReturnRouterWithoutRecord.GET("findOrderReturn", orderReturnApi.FindOrderReturn)        // 根据ID获取OrderReturn
-		orderReturnRouterWithoutRecord.GET("getOrderReturnList", orderReturnApi.GetOrderReturnList)  // 获取OrderReturn列表
+		orderReturnRouterWithoutRecord.GET("findOrderReturn", orderReturnApi.FindOrderReturn)       // 根据ID获取OrderReturn
+		orderReturnRouterWithoutRecord.GET("getOrderReturnList", orderReturnApi.GetOrderReturnList) // 获取OrderReturn列表
+		orderReturnRouterWithoutRecord.POST("uploadReturnImage", orderReturnApi.UploadReturnImage)  // 上传售后图片
 	}
 }
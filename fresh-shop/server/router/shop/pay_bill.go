@@ -0,0 +1,25 @@
+package shop
+
+import (
+	"fresh-shop/server/api/v1"
+	"fresh-shop/server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+type PayBillRouter struct {
+}
+
+// InitPayBillRouter 初始化 微信支付对账 路由信息
+func (s *PayBillRouter) InitPayBillRouter(Router *gin.RouterGroup) {
+	payBillRouter := Router.Group("payBill").Use(middleware.OperationRecord())
+	payBillRouterWithoutRecord := Router.Group("payBill")
+	var payBillApi = v1.ApiGroupApp.ShopApiGroup.PayBillApi
+	{
+		payBillRouter.POST("reconcileWechatBill", payBillApi.ReconcileWechatBill) // 下载微信支付对账单并核对
+	}
+	{
+		payBillRouterWithoutRecord.POST("uploadWechatBill", payBillApi.UploadWechatBill) // 上传微信支付对账单文件并核对
+		payBillRouterWithoutRecord.GET("findPayBill", payBillApi.FindPayBill)            // 根据ID获取对账结果
+		payBillRouterWithoutRecord.GET("getPayBillList", payBillApi.GetPayBillList)      // 获取对账结果列表
+	}
+}
@@ -0,0 +1,27 @@
+package shop
+
+import (
+	"fresh-shop/server/api/v1"
+	"fresh-shop/server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+type PostageRuleRouter struct {
+}
+
+// InitPostageRuleRouter 初始化 PostageRule 路由信息
+func (s *PostageRuleRouter) InitPostageRuleRouter(Router *gin.RouterGroup) {
+	postageRuleRouter := Router.Group("postageRule").Use(middleware.OperationRecord())
+	postageRuleRouterWithoutRecord := Router.Group("postageRule")
+	var postageRuleApi = v1.ApiGroupApp.ShopApiGroup.PostageRuleApi
+	{
+		postageRuleRouter.POST("createPostageRule", postageRuleApi.CreatePostageRule)             // 新建PostageRule
+		postageRuleRouter.DELETE("deletePostageRule", postageRuleApi.DeletePostageRule)           // 删除PostageRule
+		postageRuleRouter.DELETE("deletePostageRuleByIds", postageRuleApi.DeletePostageRuleByIds) // 批量删除PostageRule
+		postageRuleRouter.PUT("updatePostageRule", postageRuleApi.UpdatePostageRule)              // 更新PostageRule
+	}
+	{
+		postageRuleRouterWithoutRecord.GET("findPostageRule", postageRuleApi.FindPostageRule)       // 根据ID获取PostageRule
+		postageRuleRouterWithoutRecord.GET("getPostageRuleList", postageRuleApi.GetPostageRuleList) // 获取PostageRule列表
+	}
+}
@@ -0,0 +1,24 @@
+package shop
+
+import (
+	"fresh-shop/server/api/v1"
+	"fresh-shop/server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+type StockLogRouter struct {
+}
+
+// InitStockLogRouter 初始化 库存流水 路由信息
+func (s *StockLogRouter) InitStockLogRouter(Router *gin.RouterGroup) {
+	stockLogRouter := Router.Group("stockLog").Use(middleware.OperationRecord())
+	stockLogRouterWithoutRecord := Router.Group("stockLog")
+	var stockLogApi = v1.ApiGroupApp.ShopApiGroup.StockLogApi
+	{
+		stockLogRouter.POST("stocktake", stockLogApi.Stocktake) // 盘点
+	}
+	{
+		stockLogRouterWithoutRecord.GET("getStockLogList", stockLogApi.GetStockLogList) // 获取库存流水列表
+		stockLogRouterWithoutRecord.GET("exportStockLogs", stockLogApi.ExportStockLogs) // 导出库存流水Excel
+	}
+}
@@ -0,0 +1,18 @@
+package wechat
+
+import (
+	v1 "fresh-shop/server/api/v1"
+	"github.com/gin-gonic/gin"
+)
+
+type AlipayRouter struct {
+}
+
+// InitAlipayPublicRouter 初始化公共的 AlipayRouter 路由信息
+func (s *AlipayRouter) InitAlipayPublicRouter(Router *gin.RouterGroup) {
+	alipayRouterWithoutRecord := Router.Group("alipay")
+	var alipayApi = v1.ApiGroupApp.WechatApiGroup.AlipayApi
+	{
+		alipayRouterWithoutRecord.POST("pay/notify", alipayApi.PayNotify) // 支付结果异步通知
+	}
+}
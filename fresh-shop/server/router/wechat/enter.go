@@ -2,4 +2,5 @@ package wechat
 
 type RouterGroup struct {
 	WechatRouter
+	AlipayRouter
 }
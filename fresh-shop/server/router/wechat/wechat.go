@@ -23,7 +23,8 @@ func (s *WechatRouter) InitWechatPublicRouter(Router *gin.RouterGroup) {
 	weChatRouterWithoutRecord := Router.Group("wechat")
 	var weChatApi = v1.ApiGroupApp.WechatApiGroup.WeChatApi
 	{
-		weChatRouterWithoutRecord.GET("code2Session", weChatApi.Code2Session) // 换取 Session
-		weChatRouterWithoutRecord.POST("pay/notify", weChatApi.PayNotify)     // 支付成功回调
+		weChatRouterWithoutRecord.GET("code2Session", weChatApi.Code2Session)      // 换取 Session
+		weChatRouterWithoutRecord.POST("pay/notify", weChatApi.PayNotify)          // 支付成功回调
+		weChatRouterWithoutRecord.POST("pay/refundNotify", weChatApi.RefundNotify) // 退款结果回调
 	}
 }
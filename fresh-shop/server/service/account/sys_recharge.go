@@ -29,7 +29,7 @@ func (sysRechargeService *SysRechargeService) CreateSysRecharge(recharge account
 		return errors.New("账户不存在")
 	}
 	finance := account.UserFinance{
-		TypeId:     utils.Pointer(8),
+		TypeId:     utils.Pointer(common.FinanceTypeRecharge),
 		Username:   user.Username,
 		UserId:     utils.Pointer(int(user.ID)),
 		OptionType: utils.Pointer(0), // 余额操作
@@ -1,6 +1,7 @@
 package business
 
 import (
+	"errors"
 	"fresh-shop/server/global"
 	"fresh-shop/server/model/business"
 	businessReq "fresh-shop/server/model/business/request"
@@ -13,6 +14,9 @@ type UserDeliveryService struct {
 // CreateUserDelivery 创建UserDelivery记录
 // Author [dalefeng](https://github.com/dalefeng)
 func (userDeliveryService *UserDeliveryService) CreateUserDelivery(userDelivery business.UserDelivery) (err error) {
+	if err = checkDeliveryUser(userDelivery); err != nil {
+		return err
+	}
 	err = global.DB.Create(&userDelivery).Error
 	return err
 }
@@ -34,6 +38,9 @@ func (userDeliveryService *UserDeliveryService) DeleteUserDeliveryByIds(ids requ
 // UpdateUserDelivery 更新UserDelivery记录
 // Author [dalefeng](https://github.com/dalefeng)
 func (userDeliveryService *UserDeliveryService) UpdateUserDelivery(userDelivery business.UserDelivery) (err error) {
+	if err = checkDeliveryUser(userDelivery); err != nil {
+		return err
+	}
 	err = global.DB.Save(&userDelivery).Error
 	return err
 }
@@ -111,3 +118,19 @@ func (userDeliveryService *UserDeliveryService) GetUserDeliveryAllList(info busi
 	err = db.Find(&userDeliverys).Error
 	return userDeliverys, err
 }
+
+// checkDeliveryUser 一个登录账号只能绑定一个送货员
+func checkDeliveryUser(userDelivery business.UserDelivery) error {
+	if userDelivery.UserId == nil || *userDelivery.UserId == 0 {
+		return nil
+	}
+	var count int64
+	err := global.DB.Model(&business.UserDelivery{}).Where("user_id = ? and id <> ?", userDelivery.UserId, userDelivery.ID).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该账号已绑定其他送货员")
+	}
+	return nil
+}
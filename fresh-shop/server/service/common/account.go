@@ -8,7 +8,9 @@ import (
 	sysModel "fresh-shop/server/model/system"
 	"fresh-shop/server/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"math"
+	"strings"
 )
 
 // 如果数据库中的ID发生改变这里也需要修改
@@ -17,12 +19,67 @@ const (
 	POINT = 2 // 积分
 )
 
+// 流水类型ID 对应 user_finance_type 表，初始化数据按这里的ID写入，启动时由 EnsureFinanceTypes 检查
+const (
+	FinanceTypeBuyPointGoods = 1  // 购买积分商品
+	FinanceTypeGiftPoints    = 6  // 确认收货赠送积分
+	FinanceTypeRecharge      = 8  // 后台充值
+	FinanceTypeOrderRefund   = 9  // 订单退款
+	FinanceTypeOrderPay      = 10 // 余额支付订单
+	FinanceTypePointFreeze   = 11 // 积分抵扣冻结
+	FinanceTypePointDeduct   = 12 // 积分抵扣
+	FinanceTypePointUnfreeze = 13 // 积分抵扣解冻
+	FinanceTypeWechatPay     = 14 // 微信支付订单
+	FinanceTypeAlipayPay     = 15 // 支付宝支付订单
+)
+
+// FinanceTypes 程序中使用的流水类型
+var FinanceTypes = []account.UserFinanceType{
+	{DbModel: global.DbModel{ID: FinanceTypeBuyPointGoods}, Name: "购买积分商品"},
+	{DbModel: global.DbModel{ID: FinanceTypeGiftPoints}, Name: "确认收货赠送积分"},
+	{DbModel: global.DbModel{ID: FinanceTypeRecharge}, Name: "后台充值"},
+	{DbModel: global.DbModel{ID: FinanceTypeOrderRefund}, Name: "订单退款"},
+	{DbModel: global.DbModel{ID: FinanceTypeOrderPay}, Name: "余额支付订单"},
+	{DbModel: global.DbModel{ID: FinanceTypePointFreeze}, Name: "积分抵扣冻结"},
+	{DbModel: global.DbModel{ID: FinanceTypePointDeduct}, Name: "积分抵扣"},
+	{DbModel: global.DbModel{ID: FinanceTypePointUnfreeze}, Name: "积分抵扣解冻"},
+	{DbModel: global.DbModel{ID: FinanceTypeWechatPay}, Name: "微信支付订单"},
+	{DbModel: global.DbModel{ID: FinanceTypeAlipayPay}, Name: "支付宝支付订单"},
+}
+
+// EnsureFinanceTypes 按固定ID补齐缺失的流水类型
+// ID 已被名称不同的流水类型占用时不做修改并返回错误，需要在后台调整后重启
+func EnsureFinanceTypes(db *gorm.DB) error {
+	var conflicts []string
+	for _, t := range FinanceTypes {
+		var exist account.UserFinanceType
+		err := db.Unscoped().Where("id = ?", t.ID).First(&exist).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			t.ParentId = utils.Pointer(0)
+			if err = db.Create(&t).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if exist.Name != t.Name || exist.DeletedAt.Valid {
+			conflicts = append(conflicts, fmt.Sprintf("ID %d 应为「%s」, 当前为「%s」", t.ID, t.Name, exist.Name))
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("流水类型ID冲突: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
 // 限定操作类型
 type optionType int
 
 var (
 	OptionTypeCASH = optionType(0) // 操作余额
-	OptionTypeLock = optionType(1) // 操作锁仓
+	OptionTypeLock = optionType(1) // 操作冻结
 )
 
 // NewFinance 构造函数
@@ -53,7 +110,16 @@ func NewFinance(optionType optionType, typeId int, userId uint, username string,
 // AccountUnifyDeduction 账户统一扣减
 // groupId 账户类型
 // finance 入账数据，需要填写完整
+// 在事务中执行，账户的行锁保持到写入流水后才释放
 func AccountUnifyDeduction(groupId int, finance account.UserFinance) error {
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		return AccountUnifyDeductionTx(tx, groupId, finance)
+	})
+}
+
+// AccountUnifyDeductionTx 在指定的 db 中进行账户统一扣减
+// 传入外部事务时，账户和流水的写入与外部事务一起提交或回滚
+func AccountUnifyDeductionTx(db *gorm.DB, groupId int, finance account.UserFinance) error {
 
 	if finance.FeeAmount == nil {
 		finance.FeeAmount = utils.Pointer(0.0)
@@ -64,7 +130,7 @@ func AccountUnifyDeduction(groupId int, finance account.UserFinance) error {
 	log := fmt.Sprintf("账户统一扣减 --- userId: %d, group: %d, typeId: %d, amount: %f, feeAmount: %f, optionType: %d",
 		finance.UserId, groupId, finance.TypeId, *finance.Amount, *finance.FeeAmount, finance.OptionType)
 	var user sysModel.SysUser
-	if errors.Is(global.DB.Where("username = ?", finance.Username).First(&user).Error, gorm.ErrRecordNotFound) {
+	if errors.Is(db.Where("username = ?", finance.Username).First(&user).Error, gorm.ErrRecordNotFound) {
 		global.SugarLog.Errorf(log + " 用户不存在")
 		return errors.New("用户不存在")
 	}
@@ -82,12 +148,12 @@ func AccountUnifyDeduction(groupId int, finance account.UserFinance) error {
 	}
 	// 获取账户配置
 	var group account.AccountGroup
-	if errors.Is(global.DB.Where("id = ?", groupId).First(&group).Error, gorm.ErrRecordNotFound) {
+	if errors.Is(db.Where("id = ?", groupId).First(&group).Error, gorm.ErrRecordNotFound) {
 		global.SugarLog.Errorf(log + " 账户配置不存在")
 		return errors.New("账户配置不存在")
 	}
-	// 获取该用户的账户信息
-	accountInfo, err := GetUserAccountInfo(*finance.UserId, groupId)
+	// 获取该用户的账户信息 加行锁防止并发扣减
+	accountInfo, err := getUserAccountInfo(db.Clauses(clause.Locking{Strength: "UPDATE"}), *finance.UserId, groupId)
 	// 如果账户不存在，应该创建
 	if err != nil {
 		global.SugarLog.Errorf(log + " 获取账户信息失败")
@@ -113,14 +179,14 @@ func AccountUnifyDeduction(groupId int, finance account.UserFinance) error {
 		*accountInfo.Amount += *finance.Amount
 		*finance.Balance = *accountInfo.Amount
 	case 1: // 操作冻结
-		if *finance.Amount < 0 && *accountInfo.FreezeAmount < *finance.Amount+*finance.FeeAmount { //  减操作且余额不足
+		if *finance.Amount < 0 && *accountInfo.FreezeAmount < math.Abs(*finance.Amount)+*finance.FeeAmount { //  减操作且余额不足
 			global.SugarLog.Errorf(log+"冻结%s不足，当前%s为：%f", group.NameCn, group.NameCn, *accountInfo.Amount)
 			return errors.New("冻结" + group.NameCn + "不足")
 		}
 		*accountInfo.FreezeAmount += *finance.Amount
 		*finance.Balance = *accountInfo.FreezeAmount
 	case 2: // 操作锁仓
-		if *finance.Amount < 0 && *accountInfo.LockAmount < *finance.Amount+*finance.FeeAmount { //  减操作且余额不足
+		if *finance.Amount < 0 && *accountInfo.LockAmount < math.Abs(*finance.Amount)+*finance.FeeAmount { //  减操作且余额不足
 			global.SugarLog.Errorf(log+"锁仓%s不足，当前%s为：%f", group.NameCn, group.NameCn, *accountInfo.Amount)
 			return errors.New("锁仓" + group.NameCn + "不足")
 		}
@@ -133,32 +199,59 @@ func AccountUnifyDeduction(groupId int, finance account.UserFinance) error {
 	} else {
 		*accountInfo.OutAmount += math.Abs(*finance.Amount)
 	}
-	// 开始事务
-	subTx := global.DB.Begin()
-	// 保存用户账户数据
-	err = subTx.Save(accountInfo).Error
-	if err != nil {
-		subTx.Rollback()
-		global.SugarLog.Errorf(log+" 更新用户账户失败, accountInfo: %#v, err: %s", accountInfo, err.Error())
-		return errors.New("更新用户账户失败")
+	// 开始事务 外部已经开启事务时为嵌套事务
+	return db.Transaction(func(subTx *gorm.DB) error {
+		// 保存用户账户数据
+		err = subTx.Save(accountInfo).Error
+		if err != nil {
+			global.SugarLog.Errorf(log+" 更新用户账户失败, accountInfo: %#v, err: %s", accountInfo, err.Error())
+			return errors.New("更新用户账户失败")
+		}
+		// 创建流水记录
+		err = subTx.Table("user_finance_" + group.NameEn).Create(&finance).Error
+		if err != nil {
+			global.SugarLog.Errorf(log+" 创建账户流水失败, finance: %#v, err: %s", finance, err.Error())
+			return errors.New("创建账户流水失败")
+		}
+		// TODO 增加团队累计金额
+		return nil
+	})
+}
+
+// AddFinanceRecordTx 只记录流水不变动账户，用于微信支付等不经过账户余额的交易
+// 流水中的余额为用户当前的账户余额，账户不存在时记为 0
+func AddFinanceRecordTx(db *gorm.DB, groupId int, finance account.UserFinance) error {
+	log := fmt.Sprintf("记录账户流水 --- userId: %d, group: %d, typeId: %d, fromId: %s", *finance.UserId, groupId, *finance.TypeId, finance.FromId)
+	var group account.AccountGroup
+	if errors.Is(db.Where("id = ?", groupId).First(&group).Error, gorm.ErrRecordNotFound) {
+		global.SugarLog.Errorf(log + " 账户配置不存在")
+		return errors.New("账户配置不存在")
 	}
-	// 创建流水记录
-	err = subTx.Table("user_finance_" + group.NameEn).Create(&finance).Error
-	if err != nil {
-		subTx.Rollback()
+	finance.FeeAmount = utils.Pointer(0.0)
+	finance.IsFee = utils.Pointer(0)
+	finance.Balance = utils.Pointer(0.0)
+	var accountInfo account.Account
+	if err := db.Where("user_id = ? and group_id = ?", finance.UserId, groupId).First(&accountInfo).Error; err == nil && accountInfo.Amount != nil {
+		finance.Balance = utils.Pointer(*accountInfo.Amount)
+	} else {
+		global.SugarLog.Warnf(log+" 获取账户信息失败, err: %v", err)
+	}
+	if err := db.Table("user_finance_" + group.NameEn).Create(&finance).Error; err != nil {
 		global.SugarLog.Errorf(log+" 创建账户流水失败, finance: %#v, err: %s", finance, err.Error())
 		return errors.New("创建账户流水失败")
 	}
-	// TODO 增加团队累计金额
-	subTx.Commit() // 提交事务
 	return nil
 }
 
 // GetUserAccountInfo 获取用户币种信息
 func GetUserAccountInfo(userId, groupId int) (*account.Account, error) {
+	return getUserAccountInfo(global.DB, userId, groupId)
+}
+
+func getUserAccountInfo(db *gorm.DB, userId, groupId int) (*account.Account, error) {
 	// 获取该用户的账户信息
 	var userAcount account.Account
-	if errors.Is(global.DB.Where("user_id = ? and group_id = ?", userId, groupId).Preload("Group").First(&userAcount).Error, gorm.ErrRecordNotFound) {
+	if errors.Is(db.Where("user_id = ? and group_id = ?", userId, groupId).Preload("Group").First(&userAcount).Error, gorm.ErrRecordNotFound) {
 		return nil, errors.New("账户配置不存在")
 	}
 	if *userAcount.Status == 0 {
@@ -4,6 +4,11 @@ import (
 	"errors"
 	"fresh-shop/server/global"
 	"fresh-shop/server/model/system"
+	"fresh-shop/server/utils"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // 公共获取配置信息方法
@@ -11,10 +16,43 @@ import (
 // ErrConfigDisabled 配置已经禁用错误
 var ErrConfigDisabled = errors.New("该配置禁用")
 
+// SysConfigs 程序中使用的配置参数及默认值
+var SysConfigs = []system.SysConfig{
+	{Name: "point", Value: "1", GroupType: "order", Desc: "确认收货赠送积分占订单金额的百分比", Status: utils.Pointer(1)},
+	{Name: "orderTimeout", Value: "30", GroupType: "order", Desc: "订单超时未支付自动取消(分钟)", Status: utils.Pointer(1)},
+	{Name: "autoReceiveDays", Value: "7", GroupType: "order", Desc: "发货后自动确认收货(天)", Status: utils.Pointer(1)},
+	{Name: "pointDeduction", Value: "100", GroupType: "order", Desc: "多少积分抵扣1元", Status: utils.Pointer(0)},
+	{Name: "pointDeductionMax", Value: "20", GroupType: "order", Desc: "积分最多抵扣商品金额的百分比", Status: utils.Pointer(1)},
+	{Name: "pickUpNumberBase", Value: "101", GroupType: "order", Desc: "每天第一个取餐号码", Status: utils.Pointer(1)},
+	{Name: "shopLocation", Value: "", GroupType: "shop", Desc: "店铺位置(经度,纬度)", Status: utils.Pointer(0)},
+}
+
+// EnsureSysConfigs 按参数名补齐缺失的配置参数，已存在的参数不做修改
+func EnsureSysConfigs(db *gorm.DB) error {
+	for _, c := range SysConfigs {
+		var count int64
+		if err := db.Model(&system.SysConfig{}).Where("name = ?", c.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := db.Create(&c).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetSysConfig 通过参数名获取配置参数信息
 func GetSysConfig(name string) (string, error) {
+	return getSysConfig(global.DB, name)
+}
+
+// getSysConfig 在指定的 db 中获取配置参数信息，事务中读取配置时使用
+func getSysConfig(db *gorm.DB, name string) (string, error) {
 	var config system.SysConfig
-	if err := global.DB.Where("name = ?", name).First(&config).Error; err != nil {
+	if err := db.Where("name = ?", name).First(&config).Error; err != nil {
 		return "", err
 	}
 	if *config.Status == 0 {
@@ -37,3 +75,87 @@ func GetAllSysConfig() map[string]string {
 	}
 	return result
 }
+
+// DefaultOrderTimeout 未配置 orderTimeout 参数时订单的支付超时时间
+const DefaultOrderTimeout = 30 * time.Minute
+
+// GetOrderTimeout 获取订单支付超时时间，配置参数 orderTimeout 单位为分钟
+// 配置不存在或格式错误时使用默认值，配置禁用时 enable 返回 false，不自动取消超时订单
+func GetOrderTimeout() (timeout time.Duration, enable bool) {
+	value, err := GetSysConfig("orderTimeout")
+	if err != nil {
+		return DefaultOrderTimeout, !errors.Is(err, ErrConfigDisabled)
+	}
+	minute, err := strconv.Atoi(value)
+	if err != nil || minute <= 0 {
+		global.SugarLog.Errorf("订单超时时间配置错误 orderTimeout: %s", value)
+		return DefaultOrderTimeout, true
+	}
+	return time.Duration(minute) * time.Minute, true
+}
+
+// DefaultPointDeductionMax 未配置 pointDeductionMax 参数时积分最多抵扣订单金额的百分比
+const DefaultPointDeductionMax = 20
+
+// GetPointDeduction 获取积分抵扣配置
+// pointDeduction 为多少积分抵扣 1 元，禁用或未配置时不允许积分抵扣
+// pointDeductionMax 为积分最多抵扣订单商品总金额的百分比
+func GetPointDeduction() (rate float64, maxPercent float64, enable bool) {
+	value, err := GetSysConfig("pointDeduction")
+	if err != nil {
+		return 0, 0, false
+	}
+	rate, err = strconv.ParseFloat(value, 64)
+	if err != nil || rate <= 0 {
+		global.SugarLog.Errorf("积分抵扣比例配置错误 pointDeduction: %s", value)
+		return 0, 0, false
+	}
+	maxPercent = DefaultPointDeductionMax
+	if value, err = GetSysConfig("pointDeductionMax"); err == nil {
+		if p, err := strconv.ParseFloat(value, 64); err == nil && p >= 0 && p <= 100 {
+			maxPercent = p
+		} else {
+			global.SugarLog.Errorf("积分最大抵扣比例配置错误 pointDeductionMax: %s", value)
+		}
+	}
+	return rate, maxPercent, true
+}
+
+// GetShopLocation 获取店铺位置 配置参数 shopLocation 格式为 "经度,纬度"
+// 未配置或格式错误时 ok 返回 false
+func GetShopLocation() (longitude, latitude float64, ok bool) {
+	value, err := GetSysConfig("shopLocation")
+	if err != nil {
+		return 0, 0, false
+	}
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		global.SugarLog.Errorf("店铺位置配置错误 shopLocation: %s", value)
+		return 0, 0, false
+	}
+	longitude, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	latitude, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err1 != nil || err2 != nil {
+		global.SugarLog.Errorf("店铺位置配置错误 shopLocation: %s", value)
+		return 0, 0, false
+	}
+	return longitude, latitude, true
+}
+
+// DefaultAutoReceiveDays 未配置 autoReceiveDays 参数时发货后自动确认收货的天数
+const DefaultAutoReceiveDays = 7
+
+// GetAutoReceiveDays 获取发货后自动确认收货的天数，配置参数 autoReceiveDays 单位为天
+// 配置不存在或格式错误时使用默认值，配置禁用时 enable 返回 false，不自动确认收货
+func GetAutoReceiveDays() (days int, enable bool) {
+	value, err := GetSysConfig("autoReceiveDays")
+	if err != nil {
+		return DefaultAutoReceiveDays, !errors.Is(err, ErrConfigDisabled)
+	}
+	days, err = strconv.Atoi(value)
+	if err != nil || days <= 0 {
+		global.SugarLog.Errorf("自动确认收货天数配置错误 autoReceiveDays: %s", value)
+		return DefaultAutoReceiveDays, true
+	}
+	return days, true
+}
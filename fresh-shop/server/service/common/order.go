@@ -0,0 +1,109 @@
+package common
+
+import (
+	"errors"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	sysModel "fresh-shop/server/model/system"
+	"fresh-shop/server/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strconv"
+	"time"
+)
+
+// DefaultPickUpNumberBase 未配置 pickUpNumberBase 参数时每天第一个取餐号码
+const DefaultPickUpNumberBase = 101
+
+// OrderPaidTx 订单支付成功后的公共处理，必须在将订单标记为已支付的同一个事务中调用
+// 扣除下单时冻结的抵扣积分，自提订单分配取餐号码
+func OrderPaidTx(tx *gorm.DB, order *shop.Order) error {
+	if err := DeductOrderPoints(tx, *order); err != nil {
+		return err
+	}
+	return AssignPickUpNumber(tx, order)
+}
+
+// OrderReceivedTx 订单确认收货，必须在事务中调用
+// 通过状态机以已发货状态为条件变更，用户确认、送货员送达和自动确认同时发生时只会处理一次，赠送积分只发放一次
+func OrderReceivedTx(tx *gorm.DB, order *shop.Order, receiveTime time.Time, actor OrderActor, remark string) error {
+	if err := TransitOrder(tx, order, OrderStateReceived, map[string]interface{}{"receive_time": receiveTime}, actor, remark); err != nil {
+		return err
+	}
+	order.ReceiveTime = utils.Pointer(receiveTime)
+	// 普通商品才能发放积分
+	if order.GoodsArea != nil && *order.GoodsArea != 0 || order.GiftPoints <= 0 {
+		return nil
+	}
+	var user sysModel.SysUser
+	if err := tx.Where("id = ?", *order.UserId).First(&user).Error; err != nil {
+		global.SugarLog.Errorf("获取用户信息失败 userId:%d, error: %v", *order.UserId, err)
+		return errors.New("用户不存在")
+	}
+	f := NewFinance(OptionTypeCASH, FinanceTypeGiftPoints, user.ID, user.Username, order.GiftPoints, order.OrderSn, user.ID, user.Username, remark)
+	if err := AccountUnifyDeductionTx(tx, POINT, f); err != nil {
+		global.SugarLog.Errorf("发放积分失败 UserFinance:%v, error: %v", f, err)
+		return err
+	}
+	return nil
+}
+
+// AssignPickUpNumber 为已支付的自提订单分配取餐号码，已分配过的订单不会重复分配
+// 号码按支付日期和自提点分别计数，从配置参数 pickUpNumberBase 开始
+func AssignPickUpNumber(tx *gorm.DB, order *shop.Order) error {
+	if order.ShipmentType == nil || *order.ShipmentType != 1 || order.PickUpNumber > 0 {
+		return nil
+	}
+	day := time.Now().Format("20060102")
+	if order.PayTime != nil {
+		day = order.PayTime.Format("20060102")
+	}
+	number, err := nextPickUpNumber(tx, day, order.PickUpPointId)
+	if err != nil {
+		global.SugarLog.Errorf("分配取餐号码失败 orderId:%d, err:%v \n", order.ID, err)
+		return errors.New("分配取餐号码失败")
+	}
+	result := tx.Model(&shop.Order{}).Where("id = ? and pick_up_number = 0", order.ID).Update("pick_up_number", number)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		order.PickUpNumber = number
+	}
+	return nil
+}
+
+// nextPickUpNumber 获取下一个取餐号码
+// 通过序列记录的条件自增分配，同一天同一个自提点的并发请求会在行锁上排队，不会拿到相同的号码
+func nextPickUpNumber(tx *gorm.DB, day string, pointId int) (int, error) {
+	base := DefaultPickUpNumberBase
+	if value, err := getSysConfig(tx, "pickUpNumberBase"); err == nil {
+		if b, err := strconv.Atoi(value); err == nil && b >= 0 {
+			base = b
+		} else {
+			global.SugarLog.Errorf("取餐号码起始值配置错误 pickUpNumberBase: %s", value)
+		}
+	}
+	result := tx.Model(&shop.PickUpSequence{}).Where("day = ? and pick_up_point_id = ?", day, pointId).
+		Update("current", gorm.Expr("current + 1"))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// 当天第一个号码 唯一索引冲突说明其他事务已经创建了记录，再自增一次
+		seq := shop.PickUpSequence{Day: day, PickUpPointId: pointId, Current: 1}
+		result = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "day"}, {Name: "pick_up_point_id"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"current": gorm.Expr("current + 1")}),
+		}).Create(&seq)
+		if result.Error != nil {
+			return 0, result.Error
+		}
+	}
+	var current int
+	err := tx.Model(&shop.PickUpSequence{}).Select("current").Where("day = ? and pick_up_point_id = ?", day, pointId).Scan(&current).Error
+	if err != nil {
+		return 0, err
+	}
+	return base + current - 1, nil
+}
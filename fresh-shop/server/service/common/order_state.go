@@ -0,0 +1,204 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"fresh-shop/server/model/shop"
+	"gorm.io/gorm"
+)
+
+// 订单状态机
+// 订单状态由 status、status_cancel、status_refund 三个字段共同决定，所有状态变更都通过 TransitOrder 完成
+// 按状态转换表校验后以变更前状态为条件更新，并记录到 shop_order_log
+
+// 订单状态
+const (
+	OrderStateUnpaid       = "unpaid"       // 待付款
+	OrderStatePaid         = "paid"         // 已付款待发货
+	OrderStateShipped      = "shipped"      // 已发货
+	OrderStateReceived     = "received"     // 已收货
+	OrderStateCancelled    = "cancelled"    // 已取消
+	OrderStateRefunding    = "refunding"    // 退款中
+	OrderStateRefunded     = "refunded"     // 已退款
+	OrderStateRefundFailed = "refundFailed" // 退款失败
+)
+
+// 状态变更操作人类型
+const (
+	ActorUser      = "user"      // 用户
+	ActorAdmin     = "admin"     // 管理员
+	ActorSystem    = "system"    // 系统 支付、退款回调等
+	ActorTimer     = "timer"     // 定时任务
+	ActorDeliverer = "deliverer" // 送货员
+)
+
+// OrderActor 订单状态变更操作人
+type OrderActor struct {
+	Type string
+	Id   uint
+	Name string
+}
+
+var orderStateNames = map[string]string{
+	OrderStateUnpaid:       "待付款",
+	OrderStatePaid:         "待发货",
+	OrderStateShipped:      "已发货",
+	OrderStateReceived:     "已收货",
+	OrderStateCancelled:    "已取消",
+	OrderStateRefunding:    "退款中",
+	OrderStateRefunded:     "已退款",
+	OrderStateRefundFailed: "退款失败",
+}
+
+// orderTransitions 订单状态转换表 key 为变更前状态，value 为允许变更到的状态
+var orderTransitions = map[string][]string{
+	OrderStateUnpaid:       {OrderStatePaid, OrderStateCancelled},
+	OrderStatePaid:         {OrderStateShipped, OrderStateCancelled},
+	OrderStateShipped:      {OrderStateReceived},
+	OrderStateReceived:     {OrderStateRefunding, OrderStateRefunded},
+	OrderStateCancelled:    {OrderStateRefunding, OrderStateRefunded},
+	OrderStateRefunding:    {OrderStateRefunded, OrderStateRefundFailed},
+	OrderStateRefundFailed: {OrderStateRefunding, OrderStateRefunded},
+}
+
+// OrderState 获取订单当前状态
+func OrderState(order shop.Order) string {
+	switch intValue(order.StatusRefund) {
+	case 1:
+		return OrderStateRefunding
+	case 2:
+		return OrderStateRefunded
+	case 3:
+		return OrderStateRefundFailed
+	}
+	if intValue(order.StatusCancel) > 0 {
+		return OrderStateCancelled
+	}
+	switch intValue(order.Status) {
+	case 1:
+		return OrderStatePaid
+	case 2:
+		return OrderStateShipped
+	case 3:
+		return OrderStateReceived
+	}
+	return OrderStateUnpaid
+}
+
+// OrderStateName 获取订单状态的中文名称
+func OrderStateName(state string) string {
+	if name, ok := orderStateNames[state]; ok {
+		return name
+	}
+	return state
+}
+
+// CanTransitOrder 判断订单是否允许从 from 状态变更到 to 状态
+func CanTransitOrder(from, to string) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitOrder 变更订单状态并记录变更日志，必须在事务中调用
+// values 为同时更新的其他字段，取消订单时需要传入 status_cancel
+// 以变更前状态作为更新条件，并发变更同一个订单时只有一个能成功，变更成功后同步更新 order 的状态字段
+func TransitOrder(tx *gorm.DB, order *shop.Order, to string, values map[string]interface{}, actor OrderActor, reason string) error {
+	from := OrderState(*order)
+	if !CanTransitOrder(from, to) {
+		return fmt.Errorf("订单%s，不能变更为%s", OrderStateName(from), OrderStateName(to))
+	}
+	updates := make(map[string]interface{}, len(values)+1)
+	for k, v := range values {
+		updates[k] = v
+	}
+	switch to {
+	case OrderStatePaid:
+		updates["status"] = 1
+	case OrderStateShipped:
+		updates["status"] = 2
+	case OrderStateReceived:
+		updates["status"] = 3
+	case OrderStateCancelled:
+		if _, ok := updates["status_cancel"]; !ok {
+			return errors.New("取消订单缺少取消类型")
+		}
+	case OrderStateRefunding:
+		updates["status_refund"] = 1
+	case OrderStateRefunded:
+		updates["status_refund"] = 2
+	case OrderStateRefundFailed:
+		updates["status_refund"] = 3
+	}
+	result := orderStateScope(tx.Model(&shop.Order{}).Where("id = ?", order.ID), from).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("订单状态已变更，请刷新后重试")
+	}
+	// 同步状态字段
+	var current shop.Order
+	if err := tx.Select("status", "status_cancel", "status_refund").Where("id = ?", order.ID).First(&current).Error; err != nil {
+		return err
+	}
+	order.Status, order.StatusCancel, order.StatusRefund = current.Status, current.StatusCancel, current.StatusRefund
+	// 同步使用订单退款单号的售后的退款状态，售后部分退款使用各自的退款单号，不受订单状态影响
+	if _, ok := updates["status_refund"]; ok && order.RefundSn != "" {
+		returnValues := map[string]interface{}{"refund_status": current.StatusRefund}
+		if refundTime, ok := updates["refund_time"]; ok {
+			returnValues["refund_time"] = refundTime
+		}
+		if err := tx.Model(&shop.OrderReturn{}).Where("order_id = ? and status = ? and refund_sn = ?", order.ID, shop.ReturnStatusApproved, order.RefundSn).
+			Updates(returnValues).Error; err != nil {
+			return err
+		}
+	}
+	return AddOrderLog(tx, order.ID, from, to, actor, reason)
+}
+
+// AddOrderLog 记录订单状态变更，创建订单时 from 为空
+func AddOrderLog(tx *gorm.DB, orderId uint, from, to string, actor OrderActor, reason string) error {
+	return tx.Create(&shop.OrderLog{
+		OrderId:    orderId,
+		FromState:  from,
+		ToState:    to,
+		Actor:      actor.Type,
+		OperatorId: actor.Id,
+		Operator:   actor.Name,
+		Reason:     reason,
+	}).Error
+}
+
+// orderStateScope 添加订单处于指定状态的查询条件
+func orderStateScope(db *gorm.DB, state string) *gorm.DB {
+	switch state {
+	case OrderStateUnpaid:
+		return db.Where("status = 0 and status_cancel = 0 and status_refund = 0")
+	case OrderStatePaid:
+		return db.Where("status = 1 and status_cancel = 0 and status_refund = 0")
+	case OrderStateShipped:
+		return db.Where("status = 2 and status_cancel = 0 and status_refund = 0")
+	case OrderStateReceived:
+		return db.Where("status = 3 and status_cancel = 0 and status_refund = 0")
+	case OrderStateCancelled:
+		return db.Where("status_cancel > 0 and status_refund = 0")
+	case OrderStateRefunding:
+		return db.Where("status_refund = 1")
+	case OrderStateRefunded:
+		return db.Where("status_refund = 2")
+	case OrderStateRefundFailed:
+		return db.Where("status_refund = 3")
+	}
+	return db.Where("1 = 0")
+}
+
+func intValue(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
@@ -0,0 +1,71 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"fresh-shop/server/model/shop"
+	sysModel "fresh-shop/server/model/system"
+	"gorm.io/gorm"
+	"math"
+)
+
+// 订单积分抵扣
+// 下单时将抵扣的积分从可用积分转入冻结积分，支付成功后扣除冻结积分，取消未支付订单时解冻退回可用积分
+
+// CalcPointDeduction 计算积分可抵扣的金额，超过配置的最大抵扣比例时返回错误
+func CalcPointDeduction(points float64, total float64) (amount float64, err error) {
+	if points <= 0 {
+		return 0, nil
+	}
+	rate, maxPercent, enable := GetPointDeduction()
+	if !enable {
+		return 0, errors.New("积分抵扣未开启")
+	}
+	amount = math.Floor(points/rate*100) / 100
+	if amount <= 0 {
+		return 0, fmt.Errorf("积分不足以抵扣，%.0f 积分抵扣 1 元", rate)
+	}
+	maxAmount := math.Floor(total*maxPercent) / 100
+	// 至少需要支付 0.01 元
+	if amount >= total {
+		maxAmount = math.Min(maxAmount, total-0.01)
+	}
+	if amount > maxAmount {
+		return 0, fmt.Errorf("积分最多抵扣订单金额的 %.0f%%，即 %.2f 元", maxPercent, maxAmount)
+	}
+	return amount, nil
+}
+
+// FreezeOrderPoints 冻结订单抵扣的积分，必须在事务中调用
+func FreezeOrderPoints(tx *gorm.DB, order shop.Order) error {
+	return moveOrderPoints(tx, order, -order.DeductPoints, order.DeductPoints, FinanceTypePointFreeze, "订单积分抵扣冻结")
+}
+
+// DeductOrderPoints 订单支付成功后扣除冻结的积分，必须在事务中调用
+func DeductOrderPoints(tx *gorm.DB, order shop.Order) error {
+	return moveOrderPoints(tx, order, 0, -order.DeductPoints, FinanceTypePointDeduct, "订单积分抵扣")
+}
+
+// UnfreezeOrderPoints 未支付订单取消后解冻积分，必须在事务中调用
+func UnfreezeOrderPoints(tx *gorm.DB, order shop.Order) error {
+	return moveOrderPoints(tx, order, order.DeductPoints, -order.DeductPoints, FinanceTypePointUnfreeze, "订单取消积分解冻")
+}
+
+// moveOrderPoints 变动订单用户的可用积分和冻结积分
+func moveOrderPoints(tx *gorm.DB, order shop.Order, amount, freezeAmount float64, typeId int, remark string) error {
+	if order.DeductPoints <= 0 {
+		return nil
+	}
+	var user sysModel.SysUser
+	if err := tx.Where("id = ?", *order.UserId).First(&user).Error; err != nil {
+		return errors.New("用户不存在")
+	}
+	if amount != 0 {
+		f := NewFinance(OptionTypeCASH, typeId, user.ID, user.Username, amount, order.OrderSn, user.ID, user.Username, remark)
+		if err := AccountUnifyDeductionTx(tx, POINT, f); err != nil {
+			return err
+		}
+	}
+	f := NewFinance(OptionTypeLock, typeId, user.ID, user.Username, freezeAmount, order.OrderSn, user.ID, user.Username, remark)
+	return AccountUnifyDeductionTx(tx, POINT, f)
+}
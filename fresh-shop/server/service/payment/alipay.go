@@ -0,0 +1,383 @@
+package payment
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// alipayGateway 支付宝正式环境网关
+const alipayGateway = "https://openapi.alipay.com/gateway.do"
+
+// 支付宝交易状态
+const (
+	alipayTradeSuccess  = "TRADE_SUCCESS"  // 支付成功 可退款
+	alipayTradeFinished = "TRADE_FINISHED" // 交易结束 不可退款
+	alipayTradeClosed   = "TRADE_CLOSED"   // 未付款交易超时关闭，或支付完成后全额退款
+)
+
+// alipayTimeLayout 支付宝接口和通知中的时间格式
+const alipayTimeLayout = "2006-01-02 15:04:05"
+
+// AlipayProvider 支付宝支付 小程序使用 alipay.trade.create 创建交易，H5 使用手机网站支付跳转收银台
+// 请求和通知都使用 RSA2 签名，退款结果同步返回
+type AlipayProvider struct {
+	Config     config.Alipay
+	Client     *http.Client
+	privateKey *rsa.PrivateKey
+	publicKey  *rsa.PublicKey
+}
+
+// alipayResult 支付宝接口返回的公共参数
+type alipayResult struct {
+	Code    string `json:"code"`
+	Msg     string `json:"msg"`
+	SubCode string `json:"sub_code"`
+	SubMsg  string `json:"sub_msg"`
+}
+
+// NewAlipayProvider 创建支付宝支付，解析应用私钥和支付宝公钥
+func NewAlipayProvider(cfg config.Alipay) (*AlipayProvider, error) {
+	privateKey, err := parseAlipayPrivateKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := parseAlipayPublicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &AlipayProvider{Config: cfg, Client: &http.Client{Timeout: 10 * time.Second}, privateKey: privateKey, publicKey: publicKey}, nil
+}
+
+// Prepay 发起支付，OpenId 为支付宝小程序用户的 buyer_id 时创建小程序交易并返回交易号，为空时返回 H5 收银台地址
+func (a *AlipayProvider) Prepay(req PrepayReq) (interface{}, error) {
+	biz := map[string]string{
+		"out_trade_no":    req.OrderSn,
+		"total_amount":    alipayAmount(a.PayAmount(req.Amount)),
+		"subject":         fmt.Sprintf("用户下单 金额:%.2f", req.Amount),
+		"time_expire":     req.TimeExpire.Format(alipayTimeLayout),
+		"passback_params": url.QueryEscape(strconv.Itoa(int(req.OrderId))), // 在支付通知中原样返回
+	}
+	if a.Config.Debug {
+		biz["subject"] = "测试支付"
+	}
+	if req.OpenId == "" {
+		biz["product_code"] = "QUICK_WAP_WAY"
+		params, err := a.params("alipay.trade.wap.pay", biz)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]string{"payUrl": a.gateway() + "?" + params.Encode()}, nil
+	}
+	biz["product_code"] = "JSAPI_PAY"
+	biz["buyer_id"] = req.OpenId
+	var rsp struct {
+		TradeNo string `json:"trade_no"`
+	}
+	if err := a.call("alipay.trade.create", biz, &rsp); err != nil {
+		global.SugarLog.Errorf("支付宝 - 创建交易发生错误 orderSn:%s, err:%s", req.OrderSn, err.Error())
+		return nil, err
+	}
+	return map[string]string{"tradeNo": rsp.TradeNo}, nil
+}
+
+// Query 查询支付宝交易
+func (a *AlipayProvider) Query(orderSn string) (trade Trade, err error) {
+	var rsp map[string]interface{}
+	if err = a.call("alipay.trade.query", map[string]string{"out_trade_no": orderSn}, &rsp); err != nil {
+		if !errors.Is(err, ErrTradeNotExist) {
+			global.SugarLog.Errorf("支付宝 - 查询交易发生错误 orderSn:%s, err:%s", orderSn, err.Error())
+		}
+		return
+	}
+	return alipayTrade(func(key string) string {
+		value, _ := rsp[key].(string)
+		return value
+	})
+}
+
+// Close 关闭未支付的支付宝交易 用户没有打开过收银台时交易不存在
+func (a *AlipayProvider) Close(orderSn string) error {
+	err := a.call("alipay.trade.close", map[string]string{"out_trade_no": orderSn}, &struct{}{})
+	if err != nil && !errors.Is(err, ErrTradeNotExist) {
+		global.SugarLog.Errorf("支付宝 - 关闭交易发生错误 orderSn:%s, err:%s", orderSn, err.Error())
+	}
+	return err
+}
+
+// Refund 申请支付宝退款 支付宝同步返回退款结果，没有退款通知，退款成功后直接更新订单的退款状态
+func (a *AlipayProvider) Refund(req RefundReq) error {
+	biz := map[string]string{
+		"out_trade_no":   req.OrderSn,
+		"out_request_no": req.RefundSn,
+		"refund_amount":  alipayAmount(a.PayAmount(req.Amount)),
+		"refund_reason":  req.Desc,
+	}
+	if err := a.call("alipay.trade.refund", biz, &struct{}{}); err != nil {
+		global.SugarLog.Errorf("支付宝 - 申请退款发生错误 orderSn:%s, refundSn:%s, err:%s", req.OrderSn, req.RefundSn, err.Error())
+		return err
+	}
+	// 退款已成功 更新状态失败时订单保持退款中，不能再标记为退款失败
+	result := RefundResult{OrderSn: req.OrderSn, RefundSn: req.RefundSn, Status: RefundSuccess, SuccessTime: time.Now()}
+	if err := RefundLogic(Alipay, result); err != nil {
+		global.SugarLog.Errorf("支付宝 - 退款成功更新订单失败 orderSn:%s, refundSn:%s, err:%s", req.OrderSn, req.RefundSn, err.Error())
+	}
+	return nil
+}
+
+// PayAmount 测试模式只支付 0.01 元
+func (a *AlipayProvider) PayAmount(amount float64) float64 {
+	if a.Config.Debug {
+		return 0.01
+	}
+	return amount
+}
+
+// ParsePayNotify 验证支付宝异步通知的应用和 RSA2 签名
+func (a *AlipayProvider) ParsePayNotify(header http.Header, body []byte) (trade Trade, err error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return trade, errors.New("参数格式校验错误")
+	}
+	if values.Get("app_id") != a.Config.AppId {
+		return trade, errors.New("支付通知应用不匹配")
+	}
+	if err = a.verify([]byte(alipaySignContent(values, "sign", "sign_type")), values.Get("sign")); err != nil {
+		return trade, errors.New("签名验证失败")
+	}
+	return alipayTrade(values.Get)
+}
+
+// ParseRefundNotify 支付宝退款结果同步返回，不接受退款通知
+func (a *AlipayProvider) ParseRefundNotify(header http.Header, body []byte) (RefundResult, error) {
+	return RefundResult{}, errors.New("支付宝退款没有退款通知")
+}
+
+// NotifyResponse 支付宝要求处理成功时返回 success，其他内容都会重新发送通知
+func (a *AlipayProvider) NotifyResponse(err error) (int, string, []byte) {
+	if err != nil {
+		return http.StatusOK, "text/plain; charset=utf-8", []byte("fail")
+	}
+	return http.StatusOK, "text/plain; charset=utf-8", []byte("success")
+}
+
+// call 调用支付宝接口，验证返回内容的签名后解析到 result，交易不存在时返回 ErrTradeNotExist
+func (a *AlipayProvider) call(method string, biz interface{}, result interface{}) error {
+	params, err := a.params(method, biz)
+	if err != nil {
+		return err
+	}
+	rsp, err := a.Client.PostForm(a.gateway(), params)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return err
+	}
+	var data map[string]json.RawMessage
+	if err = json.Unmarshal(body, &data); err != nil {
+		return fmt.Errorf("支付宝返回格式错误: %s", body)
+	}
+	content, ok := data[strings.ReplaceAll(method, ".", "_")+"_response"]
+	if !ok {
+		content = data["error_response"]
+	}
+	var res alipayResult
+	if err = json.Unmarshal(content, &res); err != nil {
+		return fmt.Errorf("支付宝返回格式错误: %s", body)
+	}
+	if res.Code != "10000" {
+		if res.SubCode == "ACQ.TRADE_NOT_EXIST" {
+			return ErrTradeNotExist
+		}
+		return fmt.Errorf("%s %s %s", res.Code, res.SubCode, res.SubMsg)
+	}
+	// 签名内容为返回的原始 json
+	var sign string
+	_ = json.Unmarshal(data["sign"], &sign)
+	if err = a.verify(content, sign); err != nil {
+		return errors.New("支付宝返回签名验证失败")
+	}
+	return json.Unmarshal(content, result)
+}
+
+// params 组织请求参数并签名
+func (a *AlipayProvider) params(method string, biz interface{}) (url.Values, error) {
+	content, err := json.Marshal(biz)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("app_id", a.Config.AppId)
+	params.Set("method", method)
+	params.Set("format", "JSON")
+	params.Set("charset", "utf-8")
+	params.Set("sign_type", "RSA2")
+	params.Set("timestamp", time.Now().Format(alipayTimeLayout))
+	params.Set("version", "1.0")
+	params.Set("biz_content", string(content))
+	if a.Config.NotifyURL != "" {
+		params.Set("notify_url", a.Config.NotifyURL)
+	}
+	if method == "alipay.trade.wap.pay" && a.Config.ReturnURL != "" {
+		params.Set("return_url", a.Config.ReturnURL)
+	}
+	hashed := sha256.Sum256([]byte(alipaySignContent(params, "sign")))
+	sign, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, err
+	}
+	params.Set("sign", base64.StdEncoding.EncodeToString(sign))
+	return params, nil
+}
+
+// verify 使用支付宝公钥验证 RSA2 签名
+func (a *AlipayProvider) verify(content []byte, sign string) error {
+	signBytes, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256(content)
+	return rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, hashed[:], signBytes)
+}
+
+func (a *AlipayProvider) gateway() string {
+	if a.Config.Gateway != "" {
+		return a.Config.Gateway
+	}
+	return alipayGateway
+}
+
+// alipayTrade 转换支付宝的交易信息 查询交易和支付通知的字段名相同，支付时间分别为 send_pay_date 和 gmt_payment
+func alipayTrade(get func(key string) string) (trade Trade, err error) {
+	trade.OrderSn = get("out_trade_no")
+	if trade.OrderSn == "" {
+		return trade, errors.New("支付结果参数错误")
+	}
+	trade.TransactionId = get("trade_no")
+	trade.OpenId = get("buyer_id")
+	trade.Attach, _ = url.QueryUnescape(get("passback_params"))
+	switch get("trade_status") {
+	case alipayTradeSuccess, alipayTradeFinished:
+		trade.State = TradeSuccess
+	case alipayTradeClosed:
+		trade.State = TradeClosed
+	default:
+		trade.State = TradeNotPay
+	}
+	if trade.State != TradeSuccess {
+		return trade, nil
+	}
+	if trade.Amount, err = strconv.ParseFloat(get("total_amount"), 64); err != nil {
+		return trade, fmt.Errorf("支付金额格式错误: %s", get("total_amount"))
+	}
+	payTime := get("gmt_payment")
+	if payTime == "" {
+		payTime = get("send_pay_date")
+	}
+	if payTime != "" {
+		if trade.PayTime, err = time.ParseInLocation(alipayTimeLayout, payTime, time.Local); err != nil {
+			return trade, fmt.Errorf("支付时间格式错误: %s", payTime)
+		}
+	}
+	return trade, nil
+}
+
+// alipaySignContent 待签名的内容 参数按名称排序后以 key=value 用 & 连接，跳过空值和 exclude 中的参数
+func alipaySignContent(values url.Values, exclude ...string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		v := values.Get(k)
+		if v == "" || contains(exclude, k) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(k + "=" + v)
+	}
+	return b.String()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// alipayAmount 金额转换为支付宝要求的两位小数
+func alipayAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// parseAlipayPrivateKey 解析应用私钥 支持 PKCS1 和 PKCS8
+func parseAlipayPrivateKey(key string) (*rsa.PrivateKey, error) {
+	der, err := alipayKeyBytes(key)
+	if err != nil {
+		return nil, errors.New("支付宝应用私钥格式错误")
+	}
+	if privateKey, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return privateKey, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("支付宝应用私钥格式错误")
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("支付宝应用私钥不是 RSA 密钥")
+	}
+	return privateKey, nil
+}
+
+// parseAlipayPublicKey 解析支付宝公钥
+func parseAlipayPublicKey(key string) (*rsa.PublicKey, error) {
+	der, err := alipayKeyBytes(key)
+	if err != nil {
+		return nil, errors.New("支付宝公钥格式错误")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, errors.New("支付宝公钥格式错误")
+	}
+	publicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("支付宝公钥不是 RSA 密钥")
+	}
+	return publicKey, nil
+}
+
+// alipayKeyBytes 密钥可以是 PEM 格式，也可以是支付宝密钥工具生成的 base64 内容
+func alipayKeyBytes(key string) ([]byte, error) {
+	key = strings.TrimSpace(key)
+	if block, _ := pem.Decode([]byte(key)); block != nil {
+		return block.Bytes, nil
+	}
+	return base64.StdEncoding.DecodeString(key)
+}
@@ -0,0 +1,241 @@
+package payment
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	"fresh-shop/server/service/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAlipayAppId = "2021000000000001"
+
+// alipayStub 本地的支付宝网关 验证应用的请求签名，使用支付宝私钥对返回内容签名
+type alipayStub struct {
+	t         *testing.T
+	appKey    *rsa.PublicKey
+	alipayKey *rsa.PrivateKey
+	mu        sync.Mutex
+	trades    map[string]map[string]string
+	methods   []string
+}
+
+// rsa2Sign 按支付宝规则排序参数后使用 RSA2 签名
+func rsa2Sign(t *testing.T, key *rsa.PrivateKey, content string) string {
+	hashed := sha256.Sum256([]byte(content))
+	sign, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	assert.Nil(t, err)
+	return base64.StdEncoding.EncodeToString(sign)
+}
+
+func sortedContent(values url.Values, exclude ...string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		skip := values.Get(k) == ""
+		for _, e := range exclude {
+			skip = skip || k == e
+		}
+		if !skip {
+			parts = append(parts, k+"="+values.Get(k))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func (s *alipayStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert.Nil(s.t, r.ParseForm())
+	method := r.PostForm.Get("method")
+	sign, _ := base64.StdEncoding.DecodeString(r.PostForm.Get("sign"))
+	hashed := sha256.Sum256([]byte(sortedContent(r.PostForm, "sign")))
+	assert.Nil(s.t, rsa.VerifyPKCS1v15(s.appKey, crypto.SHA256, hashed[:], sign), "请求签名错误")
+	var biz map[string]string
+	assert.Nil(s.t, json.Unmarshal([]byte(r.PostForm.Get("biz_content")), &biz))
+
+	s.mu.Lock()
+	s.methods = append(s.methods, method)
+	trade, ok := s.trades[biz["out_trade_no"]]
+	rsp := map[string]string{"code": "10000", "msg": "Success"}
+	switch {
+	case method == "alipay.trade.create":
+		trade = map[string]string{"out_trade_no": biz["out_trade_no"], "trade_no": "2024" + biz["out_trade_no"], "trade_status": "WAIT_BUYER_PAY", "total_amount": biz["total_amount"]}
+		s.trades[biz["out_trade_no"]] = trade
+		rsp["trade_no"] = trade["trade_no"]
+	case !ok:
+		rsp = map[string]string{"code": "40004", "msg": "Business Failed", "sub_code": "ACQ.TRADE_NOT_EXIST", "sub_msg": "交易不存在"}
+	case method == "alipay.trade.query":
+		for k, v := range trade {
+			rsp[k] = v
+		}
+	case method == "alipay.trade.close":
+		trade["trade_status"] = "TRADE_CLOSED"
+	case method == "alipay.trade.refund":
+		rsp["fund_change"] = "Y"
+		rsp["refund_fee"] = biz["refund_amount"]
+	}
+	s.mu.Unlock()
+
+	content, _ := json.Marshal(rsp)
+	key := strings.ReplaceAll(method, ".", "_") + "_response"
+	_, _ = fmt.Fprintf(w, `{"%s":%s,"sign":"%s"}`, key, content, rsa2Sign(s.t, s.alipayKey, string(content)))
+}
+
+// pay 模拟用户在支付宝完成付款
+func (s *alipayStub) pay(orderSn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trades[orderSn]["trade_status"] = "TRADE_SUCCESS"
+	s.trades[orderSn]["send_pay_date"] = "2024-01-01 12:00:00"
+	s.trades[orderSn]["buyer_id"] = "2088000000000001"
+}
+
+// notify 构造支付宝签名的异步通知
+func (s *alipayStub) notify(fields map[string]string) []byte {
+	values := url.Values{"app_id": {testAlipayAppId}, "notify_id": {"notify-1"}, "gmt_payment": {"2024-01-01 12:00:00"}, "buyer_id": {"2088000000000001"}}
+	for k, v := range fields {
+		values.Set(k, v)
+	}
+	values.Set("sign_type", "RSA2")
+	values.Set("sign", rsa2Sign(s.t, s.alipayKey, sortedContent(values, "sign", "sign_type")))
+	return []byte(values.Encode())
+}
+
+// setupAlipay 启动本地支付宝网关并注册支付宝支付
+func setupAlipay(t *testing.T) (*alipayStub, *AlipayProvider, func()) {
+	appKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	alipayKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	stub := &alipayStub{t: t, appKey: &appKey.PublicKey, alipayKey: alipayKey, trades: map[string]map[string]string{}}
+	server := httptest.NewServer(stub)
+
+	alipayPub, err := x509.MarshalPKIXPublicKey(&alipayKey.PublicKey)
+	assert.Nil(t, err)
+	provider, err := NewAlipayProvider(config.Alipay{
+		AppId:      testAlipayAppId,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(appKey)})),
+		PublicKey:  base64.StdEncoding.EncodeToString(alipayPub),
+		Gateway:    server.URL,
+		ReturnURL:  "https://shop.example.com/pay/result",
+	})
+	assert.Nil(t, err)
+	Register(Alipay, provider)
+	return stub, provider, func() {
+		Register(Alipay, nil)
+		server.Close()
+	}
+}
+
+func TestAlipayProvider(t *testing.T) {
+	setupPaymentTestDB(t)
+	stub, provider, teardown := setupAlipay(t)
+	defer teardown()
+
+	// 小程序支付创建交易，H5 支付返回收银台地址
+	pay, err := provider.Prepay(PrepayReq{OrderId: 1, OrderSn: "alipay-1", Amount: 35.5, OpenId: "2088000000000001", TimeExpire: time.Now().Add(15 * time.Minute)})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"tradeNo": "2024alipay-1"}, pay)
+	pay, err = provider.Prepay(PrepayReq{OrderId: 2, OrderSn: "alipay-2", Amount: 20, TimeExpire: time.Now().Add(15 * time.Minute)})
+	assert.Nil(t, err)
+	payUrl, err := url.Parse(pay.(map[string]string)["payUrl"])
+	assert.Nil(t, err)
+	assert.Equal(t, "alipay.trade.wap.pay", payUrl.Query().Get("method"))
+	assert.Equal(t, "https://shop.example.com/pay/result", payUrl.Query().Get("return_url"))
+	assert.Contains(t, payUrl.Query().Get("biz_content"), `"total_amount":"20.00"`)
+	_, err = provider.Query("alipay-2")
+	assert.ErrorIs(t, err, ErrTradeNotExist)
+
+	// 签名、应用不一致时拒绝处理
+	success := map[string]string{"out_trade_no": "alipay-1", "trade_no": "2024alipay-1", "trade_status": "TRADE_SUCCESS", "total_amount": "35.50"}
+	tampered := strings.Replace(string(stub.notify(success)), "total_amount=35.50", "total_amount=0.01", 1)
+	_, err = provider.ParsePayNotify(nil, []byte(tampered))
+	assert.NotNil(t, err)
+	_, err = provider.ParsePayNotify(nil, stub.notify(map[string]string{"app_id": "2021000000000002", "out_trade_no": "alipay-1", "trade_no": "2024alipay-1", "trade_status": "TRADE_SUCCESS", "total_amount": "35.50"}))
+	assert.NotNil(t, err)
+	trade, err := provider.ParsePayNotify(nil, stub.notify(map[string]string{"out_trade_no": "alipay-1", "trade_no": "2024alipay-1", "trade_status": "WAIT_BUYER_PAY"}))
+	assert.Nil(t, err)
+	assert.NotEqual(t, TradeSuccess, trade.State)
+	trade, err = provider.ParsePayNotify(nil, stub.notify(success))
+	assert.Nil(t, err)
+	assert.Equal(t, TradeSuccess, trade.State)
+	assert.Equal(t, "alipay-1", trade.OrderSn)
+	assert.Equal(t, "2024alipay-1", trade.TransactionId)
+	assert.Equal(t, 35.5, trade.Amount)
+	assert.Equal(t, "2088000000000001", trade.OpenId)
+
+	// 支付通知丢失时查询支付结果
+	stub.pay("alipay-1")
+	trade, err = provider.Query("alipay-1")
+	assert.Nil(t, err)
+	assert.Equal(t, TradeSuccess, trade.State)
+	assert.Equal(t, 35.5, trade.Amount)
+
+	// 退款同步返回结果 直接完成退款
+	order := createRefundingOrder(t, Alipay, "alipay-1", "alipay-1R", 35.5)
+	assert.Nil(t, provider.Refund(RefundReq{OrderSn: order.OrderSn, RefundSn: order.RefundSn, Total: 35.5, Amount: 35.5, Desc: "缺货退款"}))
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStateRefunded, common.OrderState(order))
+	assert.NotNil(t, order.RefundTime)
+	_, err = provider.ParseRefundNotify(nil, nil)
+	assert.NotNil(t, err)
+
+	// 关闭未支付的交易
+	_, err = provider.Prepay(PrepayReq{OrderId: 3, OrderSn: "alipay-3", Amount: 10, OpenId: "2088000000000001", TimeExpire: time.Now().Add(15 * time.Minute)})
+	assert.Nil(t, err)
+	assert.Nil(t, provider.Close("alipay-3"))
+	trade, err = provider.Query("alipay-3")
+	assert.Nil(t, err)
+	assert.Equal(t, TradeClosed, trade.State)
+	assert.Equal(t, []string{"alipay.trade.create", "alipay.trade.query", "alipay.trade.query", "alipay.trade.refund", "alipay.trade.create", "alipay.trade.close", "alipay.trade.query"}, stub.methods)
+
+	_, contentType, body := provider.NotifyResponse(nil)
+	assert.Equal(t, "success", string(body))
+	assert.Contains(t, contentType, "text/plain")
+	_, _, body = provider.NotifyResponse(errors.New("签名验证失败"))
+	assert.Equal(t, "fail", string(body))
+}
+
+func TestHandlePayNotify_Alipay(t *testing.T) {
+	setupPaymentTestDB(t)
+	stub, _, teardown := setupAlipay(t)
+	defer teardown()
+
+	// 验签失败和未支付的通知都保存原始报文和处理结果
+	_, err := HandlePayNotify(Alipay, nil, []byte("app_id="+testAlipayAppId+"&sign=WRONG"))
+	assert.NotNil(t, err)
+	_, err = HandlePayNotify(Alipay, nil, stub.notify(map[string]string{"out_trade_no": "alipay-1", "trade_no": "2024alipay-1", "trade_status": "WAIT_BUYER_PAY"}))
+	assert.Nil(t, err)
+
+	var records []shop.PayNotify
+	global.DB.Where("payment = ?", Alipay).Order("id").Find(&records)
+	assert.Len(t, records, 2)
+	assert.Equal(t, shop.PayNotifyTypePay, records[0].Type)
+	assert.Equal(t, shop.PayNotifyStatusFail, *records[0].Status)
+	assert.Equal(t, "签名验证失败", records[0].Message)
+	assert.Equal(t, shop.PayNotifyStatusSuccess, *records[1].Status)
+	assert.Equal(t, "alipay-1", records[1].OrderSn)
+	assert.Contains(t, records[1].Body, "out_trade_no")
+}
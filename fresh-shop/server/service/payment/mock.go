@@ -0,0 +1,159 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 模拟支付的支付结果
+const (
+	MockResultSuccess = "success" // 支付成功
+	MockResultFail    = "fail"    // 支付失败
+)
+
+// Mock 本地模拟支付，不请求第三方支付，用于测试和本地开发
+// 发起支付后按配置的结果模拟用户付款，延迟发送支付通知，通知和查询订单与真实支付走相同的处理逻辑
+type Mock struct {
+	Config  config.PayMock
+	payment int
+	mu      sync.Mutex
+	trades  map[string]Trade
+	refunds map[string]RefundResult
+}
+
+// NewMock 创建模拟支付，payment 为模拟的支付方式
+func NewMock(payment int, cfg config.PayMock) *Mock {
+	return &Mock{Config: cfg, payment: payment, trades: map[string]Trade{}, refunds: map[string]RefundResult{}}
+}
+
+// Prepay 模拟用户付款，支付成功时延迟发送支付通知
+func (m *Mock) Prepay(req PrepayReq) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if trade, ok := m.trades[req.OrderSn]; ok && trade.State == TradeSuccess {
+		return nil, errors.New("订单已支付")
+	}
+	trade := Trade{OrderSn: req.OrderSn, State: TradeFail, Amount: m.PayAmount(req.Amount), OpenId: req.OpenId, Attach: fmt.Sprint(req.OrderId)}
+	if m.Config.Result != MockResultFail {
+		trade.State = TradeSuccess
+		trade.TransactionId = fmt.Sprintf("MOCK%s%d", req.OrderSn, time.Now().UnixNano())
+		trade.PayTime = time.Now()
+		m.notify(func() error {
+			body, _ := json.Marshal(trade)
+			_, err := HandlePayNotify(m.payment, nil, body)
+			return err
+		})
+	}
+	m.trades[req.OrderSn] = trade
+	return map[string]interface{}{"mock": true, "orderSn": req.OrderSn, "result": trade.State}, nil
+}
+
+// Query 查询模拟的支付结果
+func (m *Mock) Query(orderSn string) (Trade, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	trade, ok := m.trades[orderSn]
+	if !ok {
+		return trade, ErrTradeNotExist
+	}
+	return trade, nil
+}
+
+// Close 关闭未支付的订单
+func (m *Mock) Close(orderSn string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	trade, ok := m.trades[orderSn]
+	if !ok {
+		return ErrTradeNotExist
+	}
+	if trade.State == TradeSuccess {
+		return errors.New("订单已支付")
+	}
+	trade.State = TradeClosed
+	m.trades[orderSn] = trade
+	return nil
+}
+
+// Refund 模拟退款，支付失败的配置下退款也会失败，退款结果延迟通过退款通知返回
+func (m *Mock) Refund(req RefundReq) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	trade, ok := m.trades[req.OrderSn]
+	if !ok || trade.State != TradeSuccess {
+		return errors.New("订单未支付")
+	}
+	if m.PayAmount(req.Amount) > trade.Amount {
+		return errors.New("退款金额大于支付金额")
+	}
+	result := RefundResult{OrderSn: req.OrderSn, RefundSn: req.RefundSn, Status: RefundSuccess, SuccessTime: time.Now()}
+	if m.Config.Result == MockResultFail {
+		result.Status = "CHANGE"
+	}
+	m.refunds[req.RefundSn] = result
+	m.notify(func() error {
+		body, _ := json.Marshal(result)
+		_, err := HandleRefundNotify(m.payment, nil, body)
+		return err
+	})
+	return nil
+}
+
+// PayAmount 模拟支付按订单金额付款
+func (m *Mock) PayAmount(amount float64) float64 {
+	return amount
+}
+
+// ParsePayNotify 解析模拟的支付通知，只接受本地发起过的交易
+func (m *Mock) ParsePayNotify(header http.Header, body []byte) (trade Trade, err error) {
+	if err = json.Unmarshal(body, &trade); err != nil {
+		return trade, errors.New("参数格式校验错误")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if saved, ok := m.trades[trade.OrderSn]; !ok || saved.TransactionId != trade.TransactionId {
+		return trade, errors.New("交易不存在")
+	}
+	return trade, nil
+}
+
+// ParseRefundNotify 解析模拟的退款通知，只接受本地发起过的退款
+func (m *Mock) ParseRefundNotify(header http.Header, body []byte) (result RefundResult, err error) {
+	if err = json.Unmarshal(body, &result); err != nil {
+		return result, errors.New("参数格式校验错误")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.refunds[result.RefundSn]; !ok {
+		return result, errors.New("退款不存在")
+	}
+	return result, nil
+}
+
+// NotifyResponse 返回 json 格式的处理结果
+func (m *Mock) NotifyResponse(err error) (int, string, []byte) {
+	rsp := map[string]string{"code": "SUCCESS", "message": "OK"}
+	if err != nil {
+		rsp = map[string]string{"code": "FAIL", "message": err.Error()}
+	}
+	body, _ := json.Marshal(rsp)
+	return http.StatusOK, "application/json; charset=utf-8", body
+}
+
+// notify 延迟发送通知，配置不发送通知时模拟通知丢失
+func (m *Mock) notify(send func() error) {
+	if m.Config.SkipNotify {
+		return
+	}
+	time.AfterFunc(time.Duration(m.Config.NotifyDelay)*time.Second, func() {
+		if err := send(); err != nil {
+			global.SugarLog.Errorf("模拟支付通知处理失败 err:%v \n", err)
+		}
+	})
+}
@@ -0,0 +1,354 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	sysModel "fresh-shop/server/model/system"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/utils"
+	"gorm.io/gorm"
+	"math"
+	"net/http"
+	"time"
+)
+
+// paymentNames 支付方式名称，用于订单日志和流水备注
+var paymentNames = map[int]string{Wechat: "微信", Alipay: "支付宝"}
+
+// financeTypes 第三方支付订单记录的流水类型
+var financeTypes = map[int]int{Wechat: common.FinanceTypeWechatPay, Alipay: common.FinanceTypeAlipayPay}
+
+// HandlePayNotify 处理支付通知，保存原始报文，验证通过后将订单变更为已支付，返回处理结果
+func HandlePayNotify(payment int, header http.Header, body []byte) (provider Provider, err error) {
+	record := savePayNotify(payment, shop.PayNotifyTypePay, body)
+	var trade Trade
+	defer func() {
+		finishPayNotify(record, trade.OrderSn, trade.TransactionId, err)
+	}()
+	if provider, err = Get(payment); err != nil {
+		return
+	}
+	if trade, err = provider.ParsePayNotify(header, body); err != nil {
+		global.SugarLog.Errorf("%s支付通知验证失败! err: %v \n", paymentNames[payment], err)
+		return
+	}
+	// 交易关闭等不是支付成功的通知不需要处理
+	if trade.State != TradeSuccess {
+		global.SugarLog.Infof("%s支付通知交易状态：%s, 订单号：%s \n", paymentNames[payment], trade.State, trade.OrderSn)
+		return
+	}
+	err = PaidLogic(payment, trade, common.OrderActor{Type: common.ActorSystem}, paymentNames[payment]+"支付成功")
+	return
+}
+
+// HandleRefundNotify 处理退款通知，保存原始报文，验证通过后更新订单的退款状态，返回处理结果
+func HandleRefundNotify(payment int, header http.Header, body []byte) (provider Provider, err error) {
+	record := savePayNotify(payment, shop.PayNotifyTypeRefund, body)
+	var result RefundResult
+	defer func() {
+		finishPayNotify(record, result.OrderSn, result.RefundSn, err)
+	}()
+	if provider, err = Get(payment); err != nil {
+		return
+	}
+	if result, err = provider.ParseRefundNotify(header, body); err != nil {
+		global.SugarLog.Errorf("%s退款通知验证失败! err: %v \n", paymentNames[payment], err)
+		return
+	}
+	err = RefundLogic(payment, result)
+	return
+}
+
+// PaidLogic 第三方支付成功后将订单变更为已支付，支付通知和主动查询订单共用
+// 金额与订单不一致时拒绝处理，订单以待支付状态为条件变更，重复通知不会重复处理
+// 支付成功后在同一事务中记录支付流水，订单已取消或已通过其他方式支付时原路退款
+func PaidLogic(payment int, trade Trade, actor common.OrderActor, reason string) error {
+	log := fmt.Sprintf("订单支付处理逻辑: 订单号：%s, %s, ", trade.OrderSn, reason)
+	if trade.OrderSn == "" || trade.TransactionId == "" || trade.State != TradeSuccess {
+		global.SugarLog.Errorf(log+"交易信息错误 trade:%#v \n", trade)
+		return errors.New("支付结果参数错误")
+	}
+	provider, err := Get(payment)
+	if err != nil {
+		return err
+	}
+	var order shop.Order
+	if errors.Is(global.DB.Where("order_sn = ?", trade.OrderSn).First(&order).Error, gorm.ErrRecordNotFound) {
+		global.SugarLog.Errorf(log + "订单不存在 \n")
+		return errors.New("订单不存在")
+	}
+	// 如果订单已经支付或已处理取消后的支付则直接结束
+	if order.TransationId == trade.TransactionId {
+		global.SugarLog.Infof(log + "订单已支付 \n")
+		return nil
+	}
+	state := common.OrderState(order)
+	if *order.Status != 0 || (state != common.OrderStateUnpaid && state != common.OrderStateCancelled) {
+		// 订单已通过余额或其他交易支付，这笔支付为重复支付
+		return duplicatePaidLogic(provider, payment, order, trade, log)
+	}
+	if expected := provider.PayAmount(order.PayAmount()); math.Abs(trade.Amount-expected) >= 0.005 {
+		global.SugarLog.Errorf(log+"支付金额不一致, 支付金额：%.2f, 订单金额：%.2f \n", trade.Amount, expected)
+		return errors.New("支付金额与订单金额不一致")
+	}
+	if trade.PayTime.IsZero() {
+		trade.PayTime = time.Now()
+	}
+	order.Payment = utils.Pointer(payment)
+	order.Finish = trade.Amount
+	order.PayTime = utils.Pointer(trade.PayTime)
+	order.PaymentOpenid = trade.OpenId
+	order.PaymentInfo = trade.Attach
+	order.TransationId = trade.TransactionId
+	values := map[string]interface{}{
+		"payment":        payment,
+		"finish":         order.Finish,
+		"pay_time":       order.PayTime,
+		"payment_openid": order.PaymentOpenid,
+		"payment_info":   order.PaymentInfo,
+		"transation_id":  order.TransationId,
+	}
+	// 超时或用户取消后才收到的支付，记录支付信息后原路退款
+	if state == common.OrderStateCancelled {
+		return cancelledPaidLogic(provider, payment, order, values, actor, log)
+	}
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		if err := common.TransitOrder(tx, &order, common.OrderStatePaid, values, actor, reason); err != nil {
+			global.SugarLog.Errorf(log+"保存订单信息失败, err:%s \n", err.Error())
+			return err
+		}
+		// 扣除抵扣积分 分配取餐号码
+		if err := common.OrderPaidTx(tx, &order); err != nil {
+			global.SugarLog.Errorf(log+"支付成功处理失败, err:%s \n", err.Error())
+			return err
+		}
+		// 生成流水记录
+		return addPayFinance(tx, payment, order)
+	})
+	if err != nil {
+		return err
+	}
+	global.SugarLog.Infof(log + "支付成功")
+	return nil
+}
+
+// cancelledPaidLogic 订单取消后才支付成功，保存支付信息并将订单变更为退款中，提交后向第三方支付申请退还全部支付金额
+// 抵扣积分在取消时已解冻，只退还支付金额；申请退款失败时订单变更为退款失败，通知仍按处理成功应答
+func cancelledPaidLogic(provider Provider, payment int, order shop.Order, values map[string]interface{}, actor common.OrderActor, log string) error {
+	order.RefundSn = utils.GenerateOrderNumber("RF")
+	values["refund_sn"] = order.RefundSn
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		if err := common.TransitOrder(tx, &order, common.OrderStateRefunding, values, actor, "订单已取消，退还支付金额"); err != nil {
+			global.SugarLog.Errorf(log+"保存订单信息失败, err:%s \n", err.Error())
+			return err
+		}
+		return addPayFinance(tx, payment, order)
+	})
+	if err != nil {
+		return err
+	}
+	err = provider.Refund(RefundReq{
+		OrderSn:  order.OrderSn,
+		RefundSn: order.RefundSn,
+		Total:    order.Finish,
+		Amount:   order.Finish,
+		Desc:     "订单已取消退款",
+	})
+	if err != nil {
+		global.SugarLog.Errorf(log+"订单已取消，申请退款失败, err:%s \n", err.Error())
+		txErr := global.DB.Transaction(func(tx *gorm.DB) error {
+			return common.TransitOrder(tx, &order, common.OrderStateRefundFailed, nil, common.OrderActor{Type: common.ActorSystem}, "退款申请失败："+err.Error())
+		})
+		if txErr != nil {
+			global.SugarLog.Errorf(log+"更新退款失败状态失败, err:%s \n", txErr.Error())
+		}
+		return nil
+	}
+	global.SugarLog.Infof(log + "订单已取消，已申请退款")
+	return nil
+}
+
+// duplicatePaidLogic 订单已支付后又收到其他交易的支付，保存重复支付记录后向第三方支付申请退还这笔支付的全部金额
+// 订单状态不变，交易号已记录时为重复通知；申请退款失败时记录退款失败，通知仍按处理成功应答
+func duplicatePaidLogic(provider Provider, payment int, order shop.Order, trade Trade, log string) error {
+	if trade.PayTime.IsZero() {
+		trade.PayTime = time.Now()
+	}
+	record := shop.PayDuplicate{
+		OrderId:       order.ID,
+		OrderSn:       order.OrderSn,
+		Payment:       payment,
+		TransactionId: trade.TransactionId,
+		Amount:        trade.Amount,
+		PayTime:       utils.Pointer(trade.PayTime),
+		RefundSn:      utils.GenerateOrderNumber("RF"),
+		RefundStatus:  utils.Pointer(1),
+	}
+	// 以交易号唯一索引保存，并发的重复通知只有一个能保存成功
+	result := global.DB.Where("transaction_id = ?", trade.TransactionId).Attrs(record).FirstOrCreate(&record)
+	if result.Error != nil {
+		global.SugarLog.Errorf(log+"保存重复支付记录失败, err:%s \n", result.Error.Error())
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		global.SugarLog.Infof(log+"重复支付已处理 transactionId:%s \n", trade.TransactionId)
+		return nil
+	}
+	global.SugarLog.Warnf(log+"订单已支付，收到重复支付 transactionId:%s, amount:%.2f \n", trade.TransactionId, trade.Amount)
+	err := provider.Refund(RefundReq{
+		OrderSn:  order.OrderSn,
+		RefundSn: record.RefundSn,
+		Total:    trade.Amount,
+		Amount:   trade.Amount,
+		Desc:     "订单重复支付退款",
+	})
+	if err != nil {
+		global.SugarLog.Errorf(log+"重复支付申请退款失败, err:%s \n", err.Error())
+		if txErr := global.DB.Model(&shop.PayDuplicate{}).Where("id = ? and refund_status = 1", record.ID).Update("refund_status", 3).Error; txErr != nil {
+			global.SugarLog.Errorf(log+"更新重复支付退款失败状态失败, err:%s \n", txErr.Error())
+		}
+	}
+	return nil
+}
+
+// RefundLogic 退款结果通知逻辑处理
+// 只处理退款中的订单和售后，重复通知不会重复修改
+func RefundLogic(payment int, result RefundResult) error {
+	if result.OrderSn == "" || result.RefundSn == "" || result.Status == "" {
+		return errors.New("退款通知参数错误")
+	}
+	log := fmt.Sprintf("订单退款回调逻辑: 订单号：%s, 退款单号：%s, ", result.OrderSn, result.RefundSn)
+	var order shop.Order
+	if errors.Is(global.DB.Where("order_sn = ? and refund_sn = ? and payment = ?", result.OrderSn, result.RefundSn, payment).First(&order).Error, gorm.ErrRecordNotFound) {
+		// 售后部分退款使用售后的退款单号
+		return returnRefundLogic(payment, result, log)
+	}
+	// 重复通知
+	if common.OrderState(order) != common.OrderStateRefunding {
+		global.SugarLog.Infof(log+"订单不是退款中状态, 已处理 statusRefund:%d \n", *order.StatusRefund)
+		return nil
+	}
+	state, values := common.OrderStateRefundFailed, map[string]interface{}{}
+	if result.Status == RefundSuccess {
+		state = common.OrderStateRefunded
+		refundTime := result.SuccessTime
+		if refundTime.IsZero() {
+			refundTime = time.Now()
+		}
+		values["refund_time"] = refundTime
+	}
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		return common.TransitOrder(tx, &order, state, values, common.OrderActor{Type: common.ActorSystem}, paymentNames[payment]+"退款结果通知："+result.Status)
+	})
+	if err != nil {
+		global.SugarLog.Errorf(log+"更新退款状态失败, err:%s \n", err.Error())
+		return err
+	}
+	global.SugarLog.Infof(log+"退款状态：%s", result.Status)
+	return nil
+}
+
+// returnRefundLogic 售后部分退款结果通知，只更新售后的退款状态，订单状态不变
+func returnRefundLogic(payment int, result RefundResult, log string) error {
+	var orderReturn shop.OrderReturn
+	err := global.DB.Joins("join shop_order on shop_order.id = shop_order_return.order_id").
+		Where("shop_order.order_sn = ? and shop_order.payment = ? and shop_order_return.refund_sn = ? and shop_order_return.status = ?", result.OrderSn, payment, result.RefundSn, shop.ReturnStatusApproved).
+		First(&orderReturn).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 重复支付的退款使用重复支付记录的退款单号
+		return duplicateRefundLogic(payment, result, log)
+	}
+	if err != nil {
+		return err
+	}
+	// 售后退款状态 1退款中 2已退款 3退款失败
+	values := map[string]interface{}{"refund_status": 3}
+	if result.Status == RefundSuccess {
+		refundTime := result.SuccessTime
+		if refundTime.IsZero() {
+			refundTime = time.Now()
+		}
+		values["refund_status"], values["refund_time"] = 2, refundTime
+	}
+	// 以退款中为条件更新，重复通知不会重复修改
+	res := global.DB.Model(&shop.OrderReturn{}).Where("id = ? and refund_status = ?", orderReturn.ID, 1).Updates(values)
+	if res.Error != nil {
+		global.SugarLog.Errorf(log+"更新售后退款状态失败, err:%s \n", res.Error.Error())
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		global.SugarLog.Infof(log+"售后不是退款中状态, 已处理 refundStatus:%d \n", *orderReturn.RefundStatus)
+		return nil
+	}
+	global.SugarLog.Infof(log+"售后退款状态：%s", result.Status)
+	return nil
+}
+
+// duplicateRefundLogic 重复支付退款结果通知，只更新重复支付记录的退款状态
+func duplicateRefundLogic(payment int, result RefundResult, log string) error {
+	values := map[string]interface{}{"refund_status": 3}
+	if result.Status == RefundSuccess {
+		refundTime := result.SuccessTime
+		if refundTime.IsZero() {
+			refundTime = time.Now()
+		}
+		values["refund_status"], values["refund_time"] = 2, refundTime
+	}
+	var record shop.PayDuplicate
+	if errors.Is(global.DB.Where("order_sn = ? and refund_sn = ? and payment = ?", result.OrderSn, result.RefundSn, payment).First(&record).Error, gorm.ErrRecordNotFound) {
+		global.SugarLog.Errorf(log + "订单不存在 \n")
+		return errors.New("订单不存在")
+	}
+	// 以退款中为条件更新，重复通知不会重复修改
+	if err := global.DB.Model(&shop.PayDuplicate{}).Where("id = ? and refund_status = 1", record.ID).Updates(values).Error; err != nil {
+		global.SugarLog.Errorf(log+"更新重复支付退款状态失败, err:%s \n", err.Error())
+		return err
+	}
+	global.SugarLog.Infof(log+"重复支付退款状态：%s", result.Status)
+	return nil
+}
+
+// addPayFinance 记录第三方支付订单的余额流水，第三方支付不变动账户余额
+func addPayFinance(tx *gorm.DB, payment int, order shop.Order) error {
+	typeId, ok := financeTypes[payment]
+	if !ok {
+		return nil
+	}
+	var user sysModel.SysUser
+	if err := tx.Where("id = ?", *order.UserId).First(&user).Error; err != nil {
+		global.SugarLog.Errorf("记录支付流水失败 用户不存在 userId:%d, err:%v \n", *order.UserId, err)
+		return errors.New("用户不存在")
+	}
+	f := common.NewFinance(common.OptionTypeCASH, typeId, user.ID, user.Username, -order.Finish, order.OrderSn, user.ID, user.Username, paymentNames[payment]+"支付订单")
+	return common.AddFinanceRecordTx(tx, common.CASH, f)
+}
+
+// savePayNotify 保存通知的原始报文，在解析和验签之前调用，保存失败不影响通知的处理
+func savePayNotify(payment int, notifyType string, body []byte) (record shop.PayNotify) {
+	record = shop.PayNotify{Payment: payment, Type: notifyType, Body: string(body), Status: utils.Pointer(shop.PayNotifyStatusPending)}
+	if err := global.DB.Create(&record).Error; err != nil {
+		global.SugarLog.Errorf("保存支付通知失败 type:%s, body:%s, err:%v \n", notifyType, body, err)
+	}
+	return
+}
+
+// finishPayNotify 记录通知对应的订单和处理结果，handleErr 为空时表示处理成功
+func finishPayNotify(record shop.PayNotify, orderSn, transactionId string, handleErr error) {
+	if record.ID == 0 {
+		return
+	}
+	values := map[string]interface{}{"status": shop.PayNotifyStatusSuccess, "message": "", "order_sn": orderSn, "transaction_id": transactionId}
+	if handleErr != nil {
+		message := []rune(handleErr.Error())
+		if len(message) > 255 {
+			message = message[:255]
+		}
+		values["status"] = shop.PayNotifyStatusFail
+		values["message"] = string(message)
+	}
+	if err := global.DB.Model(&shop.PayNotify{}).Where("id = ?", record.ID).Updates(values).Error; err != nil {
+		global.SugarLog.Errorf("更新支付通知处理结果失败 id:%d, err:%v \n", record.ID, err)
+	}
+}
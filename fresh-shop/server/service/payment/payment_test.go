@@ -0,0 +1,50 @@
+package payment
+
+import (
+	"path/filepath"
+	"testing"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	"fresh-shop/server/utils"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupPaymentTestDB 创建保存通知和订单退款状态需要的表
+func setupPaymentTestDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "payment.db")), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(shop.Order{}, shop.OrderLog{}, shop.OrderReturn{}, shop.PayNotify{}, shop.PayDuplicate{}); err != nil {
+		t.Fatal(err)
+	}
+	global.DB = db
+	global.Log = zap.NewNop()
+	global.SugarLog = global.Log.Sugar()
+}
+
+// createRefundingOrder 创建已申请退款的订单
+func createRefundingOrder(t *testing.T, payment int, orderSn, refundSn string, finish float64) shop.Order {
+	order := shop.Order{
+		OrderSn:      orderSn,
+		UserId:       utils.Pointer(1),
+		Status:       utils.Pointer(1),
+		StatusCancel: utils.Pointer(2),
+		StatusRefund: utils.Pointer(1),
+		Payment:      utils.Pointer(payment),
+		Finish:       finish,
+		RefundSn:     refundSn,
+	}
+	assert.Nil(t, global.DB.Create(&order).Error)
+	return order
+}
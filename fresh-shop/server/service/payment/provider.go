@@ -0,0 +1,138 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 支付方式 对应 shop_order.payment
+const (
+	Wechat = 2 // 微信
+	Alipay = 3 // 支付宝
+)
+
+// 支付实现 对应配置中的 provider
+const (
+	ProviderWechatV2 = "v2"   // 微信支付 API v2
+	ProviderWechatV3 = "v3"   // 微信支付 API v3
+	ProviderMock     = "mock" // 本地模拟支付
+)
+
+// 交易状态
+const (
+	TradeSuccess = "SUCCESS"  // 支付成功
+	TradeNotPay  = "NOTPAY"   // 未支付
+	TradeClosed  = "CLOSED"   // 已关闭
+	TradeFail    = "PAYERROR" // 支付失败
+)
+
+// RefundSuccess 退款通知中退款成功的状态，其他状态按退款失败处理
+const RefundSuccess = "SUCCESS"
+
+// ErrTradeNotExist 第三方支付订单不存在，用户没有发起过支付
+var ErrTradeNotExist = errors.New("支付订单不存在")
+
+// PrepayReq 发起支付的参数，金额单位为元
+type PrepayReq struct {
+	OrderId    uint
+	OrderSn    string
+	Amount     float64
+	OpenId     string    // 付款用户在第三方的标识 微信为小程序的 openid，支付宝为小程序的 user_id
+	ClientIP   string    // 用户的 ip
+	TimeExpire time.Time // 支付截止时间
+}
+
+// Trade 支付通知或查询订单返回的交易信息，金额单位为元
+type Trade struct {
+	OrderSn       string    `json:"orderSn"`
+	TransactionId string    `json:"transactionId"` // 第三方交易号
+	State         string    `json:"state"`         // 交易状态
+	Amount        float64   `json:"amount"`
+	PayTime       time.Time `json:"payTime"`
+	OpenId        string    `json:"openId"` // 付款用户在第三方的标识
+	Attach        string    `json:"attach"` // 发起支付时的附加数据
+}
+
+// RefundReq 退款参数，Total 为订单实付金额，Amount 为本次退款金额
+type RefundReq struct {
+	OrderSn  string
+	RefundSn string
+	Total    float64
+	Amount   float64
+	Desc     string
+}
+
+// RefundResult 退款通知中的退款结果
+type RefundResult struct {
+	OrderSn     string    `json:"orderSn"`
+	RefundSn    string    `json:"refundSn"`
+	Status      string    `json:"status"`      // 退款状态 SUCCESS 为成功
+	SuccessTime time.Time `json:"successTime"` // 退款成功时间 通知中没有时为零值
+}
+
+// Provider 第三方支付 发起支付、查询、关闭、退款以及解析支付和退款通知
+type Provider interface {
+	// Prepay 发起支付，返回客户端调起支付需要的参数
+	Prepay(req PrepayReq) (interface{}, error)
+	// Query 查询订单的支付结果，用户没有发起过支付时返回 ErrTradeNotExist
+	Query(orderSn string) (Trade, error)
+	// Close 关闭未支付的订单，关闭后用户无法再通过之前的支付参数付款
+	Close(orderSn string) error
+	// Refund 申请退款，申请成功只代表已受理，退款结果通过退款通知返回
+	Refund(req RefundReq) error
+	// PayAmount 实际向第三方发起支付的金额，测试模式下可以只支付 0.01 元
+	PayAmount(amount float64) float64
+	// ParsePayNotify 验证并解析支付通知
+	ParsePayNotify(header http.Header, body []byte) (Trade, error)
+	// ParseRefundNotify 验证并解析退款通知
+	ParseRefundNotify(header http.Header, body []byte) (RefundResult, error)
+	// NotifyResponse 通知处理完成后返回给第三方的状态码和内容，err 为空表示处理成功
+	NotifyResponse(err error) (status int, contentType string, body []byte)
+}
+
+// BillDownloader 支持下载交易对账单的第三方支付，billDate 格式为 20060102
+type BillDownloader interface {
+	DownloadBill(billDate string) ([]byte, error)
+}
+
+var (
+	lock      sync.RWMutex
+	providers = map[int]Provider{}
+)
+
+// Register 注册支付方式的实现，provider 为 nil 时取消注册
+func Register(payment int, provider Provider) {
+	lock.Lock()
+	defer lock.Unlock()
+	if provider == nil {
+		delete(providers, payment)
+		return
+	}
+	providers[payment] = provider
+}
+
+// Get 获取支付方式的实现，未配置时返回错误
+func Get(payment int) (Provider, error) {
+	lock.RLock()
+	defer lock.RUnlock()
+	provider, ok := providers[payment]
+	if !ok {
+		return nil, errors.New("暂不支持该支付方式")
+	}
+	return provider, nil
+}
+
+// Payments 已注册的支付方式
+func Payments() []int {
+	lock.RLock()
+	defer lock.RUnlock()
+	payments := make([]int, 0, len(providers))
+	for payment := range providers {
+		payments = append(payments, payment)
+	}
+	sort.Ints(payments)
+	return payments
+}
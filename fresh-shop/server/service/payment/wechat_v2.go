@@ -0,0 +1,192 @@
+package payment
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"fresh-shop/server/global"
+	"github.com/silenceper/wechat/v2/pay/notify"
+	orderPay "github.com/silenceper/wechat/v2/pay/order"
+	"github.com/silenceper/wechat/v2/pay/refund"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WechatV2 微信支付 API v2，使用 global.WxPay 请求微信支付
+type WechatV2 struct {
+}
+
+// Prepay 发起 JSAPI 支付，返回小程序调起支付需要的参数
+func (w *WechatV2) Prepay(req PrepayReq) (interface{}, error) {
+	param := &orderPay.Params{
+		OpenID:     req.OpenId,
+		Body:       fmt.Sprintf("用户下单 金额:%.2f", req.Amount),
+		OutTradeNo: req.OrderSn,
+		TotalFee:   strconv.Itoa(wechatFee(w.PayAmount(req.Amount))), // 订单总金额，单位为分，详见支付金额
+		CreateIP:   req.ClientIP,
+		TimeExpire: req.TimeExpire.Format("20060102150405"), // 订单支付截止时间
+		TradeType:  "JSAPI",                                 // 交易类型
+		Attach:     strconv.Itoa(int(req.OrderId)),          // 附加数据，在查询API和支付通知中原样返回，可作为自定义参数使用。
+	}
+	if global.Config.WechatPay.Debug {
+		param.Body = "测试支付"
+	}
+	preOrder, err := global.WxPay.GetOrder().BridgeConfig(param)
+	if err != nil {
+		global.SugarLog.Errorf("微信支付 - 发起 JSAPI 发生错误:%s", err.Error())
+		return nil, err
+	}
+	return preOrder, nil
+}
+
+// Query 查询微信支付订单
+func (w *WechatV2) Query(orderSn string) (trade Trade, err error) {
+	result, err := global.WxPay.GetOrder().QueryOrder(&orderPay.QueryParams{OutTradeNo: orderSn})
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "ORDERNOTEXIST") {
+			return trade, ErrTradeNotExist
+		}
+		global.SugarLog.Errorf("微信支付 - 查询订单发生错误 orderSn:%s, err:%s", orderSn, err.Error())
+		return
+	}
+	return wechatTrade(&result)
+}
+
+// Close 关闭微信支付订单
+func (w *WechatV2) Close(orderSn string) error {
+	_, err := global.WxPay.GetOrder().CloseOrder(&orderPay.CloseParams{OutTradeNo: orderSn})
+	if err != nil {
+		global.SugarLog.Errorf("微信支付 - 关闭订单发生错误 orderSn:%s, err:%s", orderSn, err.Error())
+	}
+	return err
+}
+
+// Refund 申请微信退款
+func (w *WechatV2) Refund(req RefundReq) error {
+	param := &refund.Params{
+		OutTradeNo:  req.OrderSn,
+		OutRefundNo: req.RefundSn,
+		TotalFee:    strconv.Itoa(wechatFee(w.PayAmount(req.Total))),
+		RefundFee:   strconv.Itoa(wechatFee(w.PayAmount(req.Amount))),
+		RefundDesc:  req.Desc,
+		RootCa:      global.Config.WechatPay.P12Path,
+		NotifyURL:   global.Config.WechatPay.RefundNotifyURL,
+	}
+	rsp, err := global.WxPay.GetRefund().Refund(param)
+	if err != nil {
+		global.SugarLog.Errorf("微信支付 - 申请退款发生错误 orderSn:%s, refundSn:%s, err:%s", req.OrderSn, req.RefundSn, err.Error())
+		return err
+	}
+	if rsp.ReturnCode != "SUCCESS" || rsp.ResultCode != "SUCCESS" {
+		global.SugarLog.Errorf("微信支付 - 申请退款失败 orderSn:%s, refundSn:%s, rsp:%#v", req.OrderSn, req.RefundSn, rsp)
+		return fmt.Errorf("微信退款申请失败: %s%s", rsp.ReturnMsg, rsp.ErrCodeDes)
+	}
+	return nil
+}
+
+// PayAmount 测试模式下只支付 0.01 元
+func (w *WechatV2) PayAmount(amount float64) float64 {
+	if global.Config.WechatPay.Debug {
+		return 0.01
+	}
+	return amount
+}
+
+// ParsePayNotify 验证支付通知的签名、返回结果以及小程序和商户号
+func (w *WechatV2) ParsePayNotify(header http.Header, body []byte) (trade Trade, err error) {
+	var req notify.PaidResult
+	if err = xml.Unmarshal(body, &req); err != nil {
+		return trade, errors.New("参数格式校验错误")
+	}
+	if req.Sign == nil || !global.WxPay.GetNotify().PaidVerifySign(req) {
+		return trade, errors.New("签名验证失败")
+	}
+	if req.ReturnCode == nil || *req.ReturnCode != "SUCCESS" || req.ResultCode == nil || *req.ResultCode != "SUCCESS" {
+		global.SugarLog.Errorf("微信支付通知返回失败! %#v \n", req)
+		return trade, errors.New("支付结果失败")
+	}
+	// 支付通知中没有交易状态 通知即表示支付成功
+	req.TradeState = req.ResultCode
+	return wechatTrade(&req)
+}
+
+// ParseRefundNotify 解密退款通知，解密失败说明不是微信发送的通知
+func (w *WechatV2) ParseRefundNotify(header http.Header, body []byte) (result RefundResult, err error) {
+	var req notify.RefundedResult
+	if err = xml.Unmarshal(body, &req); err != nil {
+		return result, errors.New("参数格式校验错误")
+	}
+	if req.ReturnCode == nil || *req.ReturnCode != "SUCCESS" {
+		global.SugarLog.Errorf("微信退款通知返回失败! %#v \n", req)
+		return result, errors.New("退款通知返回失败")
+	}
+	info, err := global.WxPay.GetNotify().DecryptReqInfo(&req)
+	if err != nil {
+		return result, errors.New("解密失败")
+	}
+	if info.OutTradeNO == nil || info.OutRefundNO == nil || info.RefundStatus == nil {
+		return result, errors.New("退款通知参数错误")
+	}
+	result = RefundResult{OrderSn: *info.OutTradeNO, RefundSn: *info.OutRefundNO, Status: *info.RefundStatus}
+	if info.SuccessTime != nil {
+		result.SuccessTime, _ = time.ParseInLocation("2006-01-02 15:04:05", *info.SuccessTime, time.Local)
+	}
+	return result, nil
+}
+
+// NotifyResponse 返回微信支付要求的 xml
+func (w *WechatV2) NotifyResponse(err error) (int, string, []byte) {
+	rsp := notify.PaidResp{ReturnCode: "SUCCESS", ReturnMsg: "OK"}
+	if err != nil {
+		rsp = notify.PaidResp{ReturnCode: "FAIL", ReturnMsg: err.Error()}
+	}
+	body, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"xml"`
+		notify.PaidResp
+	}{PaidResp: rsp})
+	return http.StatusOK, "application/xml; charset=utf-8", body
+}
+
+// wechatTrade 校验小程序和商户号与配置一致，转换为交易信息
+func wechatTrade(req *notify.PaidResult) (trade Trade, err error) {
+	if req.AppID == nil || *req.AppID != global.Config.Wechat.Appid {
+		return trade, errors.New("支付通知小程序不匹配")
+	}
+	if req.MchID == nil || *req.MchID != global.Config.WechatPay.MchId {
+		return trade, errors.New("支付通知商户号不匹配")
+	}
+	if req.OutTradeNo == nil {
+		return trade, errors.New("支付结果参数错误")
+	}
+	trade.OrderSn = *req.OutTradeNo
+	trade.State = TradeNotPay
+	if req.TradeState != nil {
+		trade.State = *req.TradeState
+	}
+	if trade.State != TradeSuccess {
+		return trade, nil
+	}
+	if req.TotalFee == nil || req.TimeEnd == nil || req.TransactionID == nil {
+		return trade, errors.New("支付结果参数错误")
+	}
+	trade.TransactionId = *req.TransactionID
+	trade.Amount = float64(*req.TotalFee) / 100
+	if trade.PayTime, err = time.ParseInLocation("20060102150405", *req.TimeEnd, time.Local); err != nil {
+		return trade, fmt.Errorf("支付时间格式错误: %s", *req.TimeEnd)
+	}
+	if req.OpenID != nil {
+		trade.OpenId = *req.OpenID
+	}
+	if req.Attach != nil {
+		trade.Attach = *req.Attach
+	}
+	return trade, nil
+}
+
+// wechatFee 金额转换为微信支付的分
+func wechatFee(amount float64) int {
+	return int(math.Round(amount * 100))
+}
@@ -0,0 +1,100 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"fresh-shop/server/global"
+	"github.com/silenceper/wechat/v2/pay"
+	payConfig "github.com/silenceper/wechat/v2/pay/config"
+	wxUtil "github.com/silenceper/wechat/v2/util"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPayKey = "0123456789abcdef0123456789abcdef"
+
+// paidXML 构造签名后的微信支付通知 金额单位为分，fields 覆盖默认字段，包含 sign 时使用传入的签名
+func paidXML(t *testing.T, orderSn, transactionId string, totalFee int, fields map[string]string) []byte {
+	params := map[string]string{
+		"appid":          "wx-test-app",
+		"mch_id":         "1000000001",
+		"nonce_str":      "nonce",
+		"out_trade_no":   orderSn,
+		"transaction_id": transactionId,
+		"total_fee":      fmt.Sprint(totalFee),
+		"time_end":       "20240101120000",
+		"openid":         "openid-1",
+		"attach":         "1",
+		"return_code":    "SUCCESS",
+		"result_code":    "SUCCESS",
+	}
+	for k, v := range fields {
+		params[k] = v
+	}
+	if _, ok := params["sign"]; !ok {
+		sign, err := wxUtil.ParamSign(params, testPayKey)
+		assert.Nil(t, err)
+		params["sign"] = sign
+	}
+	var b strings.Builder
+	b.WriteString("<xml>")
+	for k, v := range params {
+		if v != "" {
+			b.WriteString(fmt.Sprintf("<%s><![CDATA[%s]]></%s>", k, v, k))
+		}
+	}
+	b.WriteString("</xml>")
+	return []byte(b.String())
+}
+
+// setupWechatV2 使用测试商户配置注册微信支付 API v2，返回恢复配置的函数
+func setupWechatV2() func() {
+	appid, mchId, debug, wxPay := global.Config.Wechat.Appid, global.Config.WechatPay.MchId, global.Config.WechatPay.Debug, global.WxPay
+	global.Config.Wechat.Appid, global.Config.WechatPay.MchId, global.Config.WechatPay.Debug = "wx-test-app", "1000000001", false
+	global.WxPay = pay.NewPay(&payConfig.Config{AppID: "wx-test-app", MchID: "1000000001", Key: testPayKey})
+	Register(Wechat, &WechatV2{})
+	return func() {
+		global.Config.Wechat.Appid, global.Config.WechatPay.MchId, global.Config.WechatPay.Debug, global.WxPay = appid, mchId, debug, wxPay
+		Register(Wechat, nil)
+	}
+}
+
+func TestWechatV2Provider(t *testing.T) {
+	setupPaymentTestDB(t)
+	defer setupWechatV2()()
+	provider := &WechatV2{}
+
+	// 签名、小程序、商户号不一致或支付失败时拒绝处理
+	for _, fields := range []map[string]string{{"sign": "WRONG"}, {"appid": "wx-other"}, {"mch_id": ""}, {"result_code": "FAIL"}} {
+		_, err := provider.ParsePayNotify(nil, paidXML(t, "wxpay-1", "tx-1", 3550, fields))
+		assert.NotNil(t, err, fields)
+	}
+	_, err := provider.ParsePayNotify(nil, []byte("not xml"))
+	assert.NotNil(t, err)
+
+	// 金额单位转换为元
+	trade, err := provider.ParsePayNotify(nil, paidXML(t, "wxpay-1", "tx-1", 3550, nil))
+	assert.Nil(t, err)
+	assert.Equal(t, TradeSuccess, trade.State)
+	assert.Equal(t, "wxpay-1", trade.OrderSn)
+	assert.Equal(t, "tx-1", trade.TransactionId)
+	assert.Equal(t, 35.5, trade.Amount)
+	assert.Equal(t, "openid-1", trade.OpenId)
+	assert.Equal(t, "1", trade.Attach)
+	assert.Equal(t, "2024-01-01 12:00:00", trade.PayTime.Format("2006-01-02 15:04:05"))
+
+	// 测试模式只支付 0.01 元
+	assert.Equal(t, 35.5, provider.PayAmount(35.5))
+	global.Config.WechatPay.Debug = true
+	assert.Equal(t, 0.01, provider.PayAmount(35.5))
+
+	status, contentType, body := provider.NotifyResponse(nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, contentType, "xml")
+	assert.Contains(t, string(body), "<return_code>SUCCESS</return_code>")
+	_, _, body = provider.NotifyResponse(errors.New("签名验证失败"))
+	assert.Contains(t, string(body), "<return_code>FAIL</return_code>")
+}
@@ -0,0 +1,600 @@
+package payment
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	orderPay "github.com/silenceper/wechat/v2/pay/order"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wechatV3Base 微信支付 API v3 地址
+const wechatV3Base = "https://api.mch.weixin.qq.com"
+
+// wechatV3SignExpire 返回和通知的签名时间与当前时间相差超过这个时间时拒绝处理，防止重放
+const wechatV3SignExpire = 5 * time.Minute
+
+// wechatV3CertRefreshInterval 平台证书的最短下载间隔，防止伪造证书序列号的通知频繁触发下载
+const wechatV3CertRefreshInterval = 5 * time.Minute
+
+// WechatV3 微信支付 API v3
+// 请求使用商户私钥签名，返回和通知使用微信支付平台证书验签，通知和平台证书使用 APIv3 密钥 AES-GCM 解密
+type WechatV3 struct {
+	Config  config.WechatPay
+	AppId   string
+	BaseURL string // 为空时使用微信支付正式地址
+	Client  *http.Client
+
+	privateKey *rsa.PrivateKey
+	serialNo   string
+	mu         sync.RWMutex
+	certs      map[string]*x509.Certificate // 平台证书 key 为证书序列号
+	refreshMu  sync.Mutex                   // 同一时间只下载一次平台证书
+	refreshed  time.Time                    // 上次下载平台证书的时间
+}
+
+// wechatV3Resource 通知和平台证书中加密的内容
+type wechatV3Resource struct {
+	Algorithm      string `json:"algorithm"`
+	Ciphertext     string `json:"ciphertext"`
+	AssociatedData string `json:"associated_data"`
+	Nonce          string `json:"nonce"`
+}
+
+// wechatV3Notify 支付和退款通知
+type wechatV3Notify struct {
+	Id           string           `json:"id"`
+	EventType    string           `json:"event_type"`
+	ResourceType string           `json:"resource_type"`
+	Resource     wechatV3Resource `json:"resource"`
+}
+
+// wechatV3Transaction 查询订单和支付通知解密后的交易信息
+type wechatV3Transaction struct {
+	AppId         string `json:"appid"`
+	MchId         string `json:"mchid"`
+	OutTradeNo    string `json:"out_trade_no"`
+	TransactionId string `json:"transaction_id"`
+	TradeState    string `json:"trade_state"`
+	SuccessTime   string `json:"success_time"`
+	Attach        string `json:"attach"`
+	Payer         struct {
+		OpenId string `json:"openid"`
+	} `json:"payer"`
+	Amount struct {
+		Total int `json:"total"`
+	} `json:"amount"`
+}
+
+// wechatV3Refund 退款通知解密后的退款信息
+type wechatV3Refund struct {
+	MchId        string `json:"mchid"`
+	OutTradeNo   string `json:"out_trade_no"`
+	OutRefundNo  string `json:"out_refund_no"`
+	RefundStatus string `json:"refund_status"`
+	SuccessTime  string `json:"success_time"`
+}
+
+// errWechatV3NoBill 申请的日期没有对账单
+var errWechatV3NoBill = errors.New("对账单不存在")
+
+// wechatV3Error 微信支付返回的错误
+type wechatV3Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewWechatV3 创建微信支付 API v3，读取商户私钥和证书序列号，配置了平台证书时从文件加载，否则首次验签时下载
+func NewWechatV3(cfg config.WechatPay, appId string) (*WechatV3, error) {
+	if len(cfg.ApiV3Key) != 32 {
+		return nil, errors.New("微信支付 APIv3 密钥必须为 32 位")
+	}
+	keyPEM, err := os.ReadFile(cfg.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("读取微信支付商户私钥失败: %v", err)
+	}
+	privateKey, err := parseWechatPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	serialNo := cfg.SerialNo
+	if serialNo == "" {
+		certPEM, err := os.ReadFile(cfg.CertPath)
+		if err != nil {
+			return nil, fmt.Errorf("未配置商户证书序列号，读取商户证书失败: %v", err)
+		}
+		certs, err := parseWechatCerts(certPEM)
+		if err != nil || len(certs) == 0 {
+			return nil, errors.New("微信支付商户证书格式错误")
+		}
+		serialNo = fmt.Sprintf("%X", certs[0].SerialNumber)
+	}
+	w := &WechatV3{Config: cfg, AppId: appId, Client: &http.Client{Timeout: 10 * time.Second}, privateKey: privateKey, serialNo: serialNo, certs: map[string]*x509.Certificate{}}
+	if cfg.PlatformCertPath != "" {
+		certPEM, err := os.ReadFile(cfg.PlatformCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取微信支付平台证书失败: %v", err)
+		}
+		certs, err := parseWechatCerts(certPEM)
+		if err != nil || len(certs) == 0 {
+			return nil, errors.New("微信支付平台证书格式错误")
+		}
+		for _, cert := range certs {
+			w.certs[fmt.Sprintf("%X", cert.SerialNumber)] = cert
+		}
+	}
+	return w, nil
+}
+
+// Prepay 发起 JSAPI 支付，返回小程序调起支付需要的参数，格式与 API v2 相同
+func (w *WechatV3) Prepay(req PrepayReq) (interface{}, error) {
+	description := fmt.Sprintf("用户下单 金额:%.2f", req.Amount)
+	if w.Config.Debug {
+		description = "测试支付"
+	}
+	body := map[string]interface{}{
+		"appid":        w.AppId,
+		"mchid":        w.Config.MchId,
+		"description":  description,
+		"out_trade_no": req.OrderSn,
+		"time_expire":  req.TimeExpire.Format(time.RFC3339),
+		"attach":       strconv.Itoa(int(req.OrderId)),
+		"notify_url":   w.Config.NotifyURL,
+		"amount":       map[string]interface{}{"total": wechatFee(w.PayAmount(req.Amount)), "currency": "CNY"},
+		"payer":        map[string]string{"openid": req.OpenId},
+	}
+	var rsp struct {
+		PrepayId string `json:"prepay_id"`
+	}
+	if err := w.request(http.MethodPost, "/v3/pay/transactions/jsapi", body, &rsp); err != nil {
+		global.SugarLog.Errorf("微信支付 v3 - 发起 JSAPI 发生错误 orderSn:%s, err:%s", req.OrderSn, err.Error())
+		return nil, err
+	}
+	payConfig := orderPay.Config{
+		Timestamp: strconv.FormatInt(time.Now().Unix(), 10),
+		NonceStr:  wechatNonce(),
+		PrePayID:  rsp.PrepayId,
+		SignType:  "RSA",
+		Package:   "prepay_id=" + rsp.PrepayId,
+	}
+	paySign, err := w.sign(w.AppId + "\n" + payConfig.Timestamp + "\n" + payConfig.NonceStr + "\n" + payConfig.Package + "\n")
+	if err != nil {
+		return nil, err
+	}
+	payConfig.PaySign = paySign
+	return payConfig, nil
+}
+
+// Query 查询微信支付订单
+func (w *WechatV3) Query(orderSn string) (trade Trade, err error) {
+	var rsp wechatV3Transaction
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(orderSn) + "?mchid=" + url.QueryEscape(w.Config.MchId)
+	if err = w.request(http.MethodGet, path, nil, &rsp); err != nil {
+		if !errors.Is(err, ErrTradeNotExist) {
+			global.SugarLog.Errorf("微信支付 v3 - 查询订单发生错误 orderSn:%s, err:%s", orderSn, err.Error())
+		}
+		return
+	}
+	return w.trade(rsp)
+}
+
+// Close 关闭微信支付订单
+func (w *WechatV3) Close(orderSn string) error {
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(orderSn) + "/close"
+	err := w.request(http.MethodPost, path, map[string]string{"mchid": w.Config.MchId}, nil)
+	if err != nil {
+		global.SugarLog.Errorf("微信支付 v3 - 关闭订单发生错误 orderSn:%s, err:%s", orderSn, err.Error())
+	}
+	return err
+}
+
+// Refund 申请微信退款 退款结果通过退款通知返回
+func (w *WechatV3) Refund(req RefundReq) error {
+	body := map[string]interface{}{
+		"out_trade_no":  req.OrderSn,
+		"out_refund_no": req.RefundSn,
+		"reason":        req.Desc,
+		"notify_url":    w.Config.RefundNotifyURL,
+		"amount": map[string]interface{}{
+			"refund":   wechatFee(w.PayAmount(req.Amount)),
+			"total":    wechatFee(w.PayAmount(req.Total)),
+			"currency": "CNY",
+		},
+	}
+	var rsp struct {
+		Status string `json:"status"`
+	}
+	if err := w.request(http.MethodPost, "/v3/refund/domestic/refunds", body, &rsp); err != nil {
+		global.SugarLog.Errorf("微信支付 v3 - 申请退款发生错误 orderSn:%s, refundSn:%s, err:%s", req.OrderSn, req.RefundSn, err.Error())
+		return err
+	}
+	global.SugarLog.Infof("微信支付 v3 - 申请退款 orderSn:%s, refundSn:%s, status:%s", req.OrderSn, req.RefundSn, rsp.Status)
+	return nil
+}
+
+// DownloadBill 下载交易对账单，billDate 格式为 20060102，对账单格式与 API v2 下载的相同，当天没有交易时返回空的对账单
+// 先申请账单获取下载地址，下载的内容没有签名，使用申请账单返回的 SHA1 摘要校验
+func (w *WechatV3) DownloadBill(billDate string) ([]byte, error) {
+	day, err := time.Parse("20060102", billDate)
+	if err != nil {
+		return nil, errors.New("账单日期格式错误")
+	}
+	var rsp struct {
+		HashType    string `json:"hash_type"`
+		HashValue   string `json:"hash_value"`
+		DownloadURL string `json:"download_url"`
+	}
+	err = w.request(http.MethodGet, "/v3/bill/tradebill?bill_date="+day.Format("2006-01-02")+"&bill_type=ALL", nil, &rsp)
+	if errors.Is(err, errWechatV3NoBill) {
+		return nil, nil
+	}
+	if err != nil {
+		global.SugarLog.Errorf("微信支付 v3 - 申请对账单发生错误 billDate:%s, err:%s", billDate, err.Error())
+		return nil, err
+	}
+	downloadURL, err := url.Parse(rsp.DownloadURL)
+	if err != nil {
+		return nil, fmt.Errorf("对账单下载地址错误: %v", err)
+	}
+	status, _, data, err := w.do(http.MethodGet, downloadURL.RequestURI(), nil)
+	if err != nil {
+		global.SugarLog.Errorf("微信支付 v3 - 下载对账单发生错误 billDate:%s, err:%s", billDate, err.Error())
+		return nil, err
+	}
+	if status >= http.StatusMultipleChoices {
+		return nil, fmt.Errorf("下载对账单失败: %d %s", status, string(data))
+	}
+	if sum := sha1.Sum(data); !strings.EqualFold(rsp.HashType, "SHA1") || !strings.EqualFold(hex.EncodeToString(sum[:]), rsp.HashValue) {
+		return nil, errors.New("对账单摘要校验失败")
+	}
+	return data, nil
+}
+
+// PayAmount 测试模式只支付 0.01 元
+func (w *WechatV3) PayAmount(amount float64) float64 {
+	if w.Config.Debug {
+		return 0.01
+	}
+	return amount
+}
+
+// ParsePayNotify 验证支付通知的平台证书签名，解密交易信息并校验小程序和商户号
+func (w *WechatV3) ParsePayNotify(header http.Header, body []byte) (trade Trade, err error) {
+	var tx wechatV3Transaction
+	if err = w.parseNotify(header, body, &tx); err != nil {
+		return
+	}
+	return w.trade(tx)
+}
+
+// ParseRefundNotify 验证退款通知的平台证书签名并解密退款信息
+func (w *WechatV3) ParseRefundNotify(header http.Header, body []byte) (result RefundResult, err error) {
+	var refund wechatV3Refund
+	if err = w.parseNotify(header, body, &refund); err != nil {
+		return
+	}
+	if refund.MchId != w.Config.MchId {
+		return result, errors.New("退款通知商户号不匹配")
+	}
+	result = RefundResult{OrderSn: refund.OutTradeNo, RefundSn: refund.OutRefundNo, Status: refund.RefundStatus}
+	if refund.SuccessTime != "" {
+		if result.SuccessTime, err = time.Parse(time.RFC3339, refund.SuccessTime); err != nil {
+			return result, fmt.Errorf("退款时间格式错误: %s", refund.SuccessTime)
+		}
+	}
+	return result, nil
+}
+
+// NotifyResponse 处理失败时返回 500，微信支付会重新发送通知
+func (w *WechatV3) NotifyResponse(err error) (int, string, []byte) {
+	status, rsp := http.StatusOK, wechatV3Error{Code: "SUCCESS", Message: "成功"}
+	if err != nil {
+		status, rsp = http.StatusInternalServerError, wechatV3Error{Code: "FAIL", Message: err.Error()}
+	}
+	body, _ := json.Marshal(rsp)
+	return status, "application/json; charset=utf-8", body
+}
+
+// parseNotify 验证通知签名后解密通知内容到 result
+func (w *WechatV3) parseNotify(header http.Header, body []byte, result interface{}) error {
+	if err := w.verify(header, body); err != nil {
+		global.SugarLog.Errorf("微信支付 v3 - 通知验签失败 err:%v", err)
+		return errors.New("签名验证失败")
+	}
+	var notify wechatV3Notify
+	if err := json.Unmarshal(body, &notify); err != nil {
+		return errors.New("参数格式校验错误")
+	}
+	plaintext, err := w.decrypt(notify.Resource)
+	if err != nil {
+		return errors.New("解密失败")
+	}
+	if err = json.Unmarshal(plaintext, result); err != nil {
+		return errors.New("参数格式校验错误")
+	}
+	return nil
+}
+
+// trade 校验小程序和商户号与配置一致，转换为交易信息
+func (w *WechatV3) trade(tx wechatV3Transaction) (trade Trade, err error) {
+	if tx.AppId != w.AppId {
+		return trade, errors.New("支付通知小程序不匹配")
+	}
+	if tx.MchId != w.Config.MchId {
+		return trade, errors.New("支付通知商户号不匹配")
+	}
+	if tx.OutTradeNo == "" {
+		return trade, errors.New("支付结果参数错误")
+	}
+	trade = Trade{OrderSn: tx.OutTradeNo, TransactionId: tx.TransactionId, State: tx.TradeState, OpenId: tx.Payer.OpenId, Attach: tx.Attach}
+	if trade.State != TradeSuccess {
+		return trade, nil
+	}
+	trade.Amount = float64(tx.Amount.Total) / 100
+	if trade.PayTime, err = time.Parse(time.RFC3339, tx.SuccessTime); err != nil {
+		return trade, fmt.Errorf("支付时间格式错误: %s", tx.SuccessTime)
+	}
+	return trade, nil
+}
+
+// request 调用微信支付接口，验证返回的签名后解析到 result，订单不存在时返回 ErrTradeNotExist
+func (w *WechatV3) request(method, path string, body interface{}, result interface{}) error {
+	status, header, rspBody, err := w.do(method, path, body)
+	if err != nil {
+		return err
+	}
+	if status >= http.StatusMultipleChoices {
+		var rspErr wechatV3Error
+		_ = json.Unmarshal(rspBody, &rspErr)
+		if rspErr.Code == "ORDER_NOT_EXIST" || rspErr.Code == "RESOURCE_NOT_EXISTS" {
+			return ErrTradeNotExist
+		}
+		if rspErr.Code == "NO_STATEMENT_EXIST" {
+			return errWechatV3NoBill
+		}
+		return fmt.Errorf("%d %s %s", status, rspErr.Code, rspErr.Message)
+	}
+	if err = w.verify(header, rspBody); err != nil {
+		return fmt.Errorf("微信支付返回签名验证失败: %v", err)
+	}
+	if result == nil || len(rspBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(rspBody, result)
+}
+
+// do 发送使用商户私钥签名的请求
+func (w *WechatV3) do(method, path string, body interface{}) (status int, header http.Header, rspBody []byte, err error) {
+	var data []byte
+	if body != nil {
+		if data, err = json.Marshal(body); err != nil {
+			return
+		}
+	}
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), wechatNonce()
+	signature, err := w.sign(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n" + string(data) + "\n")
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(method, w.baseURL()+path, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(`WECHATPAY2-SHA256-RSA2048 mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		w.Config.MchId, nonce, signature, timestamp, w.serialNo))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := w.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer rsp.Body.Close()
+	rspBody, err = io.ReadAll(rsp.Body)
+	return rsp.StatusCode, rsp.Header, rspBody, err
+}
+
+// sign 使用商户私钥 SHA256-RSA 签名
+func (w *WechatV3) sign(message string) (string, error) {
+	hashed := sha256.Sum256([]byte(message))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, w.privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// verify 使用平台证书验证返回和通知的签名，签名内容为 时间戳\n随机串\n报文\n
+func (w *WechatV3) verify(header http.Header, body []byte) error {
+	timestamp := header.Get("Wechatpay-Timestamp")
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("签名时间格式错误")
+	}
+	if d := time.Since(time.Unix(unix, 0)); d > wechatV3SignExpire || d < -wechatV3SignExpire {
+		return errors.New("签名时间已过期")
+	}
+	cert, err := w.platformCert(header.Get("Wechatpay-Serial"))
+	if err != nil {
+		return err
+	}
+	return verifyWechatSign(cert, timestamp+"\n"+header.Get("Wechatpay-Nonce")+"\n"+string(body)+"\n", header.Get("Wechatpay-Signature"))
+}
+
+// platformCert 获取平台证书，证书不存在时重新下载，微信支付更换证书后可以自动获取新证书
+// 下载间隔小于 wechatV3CertRefreshInterval 时不再下载，下载后仍不存在的证书序列号直接拒绝
+func (w *WechatV3) platformCert(serialNo string) (*x509.Certificate, error) {
+	if cert, ok := w.cachedCert(serialNo); ok {
+		return cert, nil
+	}
+	if w.Config.PlatformCertPath == "" {
+		w.refreshMu.Lock()
+		defer w.refreshMu.Unlock()
+		// 等待期间其他请求可能已经下载了新证书
+		if cert, ok := w.cachedCert(serialNo); ok {
+			return cert, nil
+		}
+		if time.Since(w.refreshed) >= wechatV3CertRefreshInterval {
+			w.refreshed = time.Now()
+			if err := w.downloadCerts(); err != nil {
+				return nil, err
+			}
+			if cert, ok := w.cachedCert(serialNo); ok {
+				return cert, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("微信支付平台证书不存在 serialNo:%s", serialNo)
+}
+
+// cachedCert 获取已加载的平台证书
+func (w *WechatV3) cachedCert(serialNo string) (*x509.Certificate, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	cert, ok := w.certs[serialNo]
+	return cert, ok
+}
+
+// downloadCerts 下载并解密平台证书，使用下载的证书验证返回的签名
+func (w *WechatV3) downloadCerts() error {
+	status, header, body, err := w.do(http.MethodGet, "/v3/certificates", nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("下载微信支付平台证书失败: %d %s", status, body)
+	}
+	var rsp struct {
+		Data []struct {
+			SerialNo           string           `json:"serial_no"`
+			EncryptCertificate wechatV3Resource `json:"encrypt_certificate"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &rsp); err != nil {
+		return err
+	}
+	certs := map[string]*x509.Certificate{}
+	for _, item := range rsp.Data {
+		certPEM, err := w.decrypt(item.EncryptCertificate)
+		if err != nil {
+			return fmt.Errorf("解密微信支付平台证书失败: %v", err)
+		}
+		parsed, err := parseWechatCerts(certPEM)
+		if err != nil || len(parsed) == 0 {
+			return errors.New("微信支付平台证书格式错误")
+		}
+		certs[item.SerialNo] = parsed[0]
+	}
+	cert, ok := certs[header.Get("Wechatpay-Serial")]
+	if !ok {
+		return errors.New("微信支付平台证书不存在")
+	}
+	if err = verifyWechatSign(cert, header.Get("Wechatpay-Timestamp")+"\n"+header.Get("Wechatpay-Nonce")+"\n"+string(body)+"\n", header.Get("Wechatpay-Signature")); err != nil {
+		return fmt.Errorf("微信支付平台证书验签失败: %v", err)
+	}
+	w.mu.Lock()
+	w.certs = certs
+	w.mu.Unlock()
+	return nil
+}
+
+// decrypt 使用 APIv3 密钥 AES-256-GCM 解密
+func (w *WechatV3) decrypt(resource wechatV3Resource) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(resource.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher([]byte(w.Config.ApiV3Key))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, []byte(resource.Nonce), ciphertext, []byte(resource.AssociatedData))
+}
+
+func (w *WechatV3) baseURL() string {
+	if w.BaseURL != "" {
+		return w.BaseURL
+	}
+	return wechatV3Base
+}
+
+// verifyWechatSign 使用平台证书的公钥验证 SHA256-RSA 签名
+func verifyWechatSign(cert *x509.Certificate, message, signature string) error {
+	publicKey, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("微信支付平台证书不是 RSA 证书")
+	}
+	sign, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(message))
+	return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hashed[:], sign)
+}
+
+// parseWechatPrivateKey 解析商户私钥 apiclient_key.pem
+func parseWechatPrivateKey(keyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("微信支付商户私钥格式错误")
+	}
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("微信支付商户私钥格式错误")
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("微信支付商户私钥不是 RSA 密钥")
+	}
+	return privateKey, nil
+}
+
+// parseWechatCerts 解析 PEM 格式的证书，一个文件中可以包含多个证书
+func parseWechatCerts(certPEM []byte) (certs []*x509.Certificate, err error) {
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			return certs, nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+// wechatNonce 请求和调起支付使用的随机串
+func wechatNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
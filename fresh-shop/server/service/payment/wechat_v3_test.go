@@ -0,0 +1,396 @@
+package payment
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	"fresh-shop/server/service/common"
+	orderPay "github.com/silenceper/wechat/v2/pay/order"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testApiV3Key         = "abcdef0123456789abcdef0123456789"
+	testPlatformSerialNo = "5157F09EFDC096DE15EBE81A47057A72"
+)
+
+// wechatV3Stub 本地的微信支付 API v3 验证商户签名，使用平台私钥对返回内容签名
+type wechatV3Stub struct {
+	t           *testing.T
+	merchantKey *rsa.PublicKey
+	platformKey *rsa.PrivateKey
+	certPEM     []byte
+	mu          sync.Mutex
+	trades      map[string]map[string]interface{}
+	refunds     []map[string]interface{}
+	paths       []string
+	bill        []byte // 对账单内容
+	tampered    []byte // 不为空时下载的对账单内容与摘要不一致
+}
+
+var wechatV3AuthRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func (s *wechatV3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	auth := map[string]string{}
+	for _, m := range wechatV3AuthRe.FindAllStringSubmatch(strings.TrimPrefix(r.Header.Get("Authorization"), "WECHATPAY2-SHA256-RSA2048 "), -1) {
+		auth[m[1]] = m[2]
+	}
+	assert.Equal(s.t, "1000000001", auth["mchid"])
+	assert.Equal(s.t, "MERCHANT-SERIAL", auth["serial_no"])
+	message := r.Method + "\n" + r.URL.RequestURI() + "\n" + auth["timestamp"] + "\n" + auth["nonce_str"] + "\n" + string(body) + "\n"
+	sign, _ := base64.StdEncoding.DecodeString(auth["signature"])
+	hashed := sha256.Sum256([]byte(message))
+	assert.Nil(s.t, rsa.VerifyPKCS1v15(s.merchantKey, crypto.SHA256, hashed[:], sign), "请求签名错误")
+
+	s.mu.Lock()
+	s.paths = append(s.paths, r.Method+" "+r.URL.Path)
+	var req map[string]interface{}
+	_ = json.Unmarshal(body, &req)
+	status, rsp := http.StatusOK, interface{}(nil)
+	switch {
+	case r.URL.Path == "/v3/certificates":
+		rsp = map[string]interface{}{"data": []interface{}{map[string]interface{}{
+			"serial_no":           testPlatformSerialNo,
+			"encrypt_certificate": encryptV3Resource(s.t, s.certPEM, "certificate"),
+		}}}
+	case r.URL.Path == "/v3/pay/transactions/jsapi":
+		orderSn := req["out_trade_no"].(string)
+		s.trades[orderSn] = map[string]interface{}{
+			"appid": req["appid"], "mchid": req["mchid"], "out_trade_no": orderSn, "trade_state": TradeNotPay,
+			"attach": req["attach"], "amount": req["amount"], "payer": req["payer"],
+		}
+		rsp = map[string]string{"prepay_id": "wx-prepay-" + orderSn}
+	case r.URL.Path == "/v3/bill/tradebill":
+		assert.Equal(s.t, "ALL", r.URL.Query().Get("bill_type"))
+		if r.URL.Query().Get("bill_date") != "2024-01-01" {
+			status, rsp = http.StatusBadRequest, map[string]string{"code": "NO_STATEMENT_EXIST", "message": "账单文件不存在"}
+			break
+		}
+		sum := sha1.Sum(s.bill)
+		rsp = map[string]string{"hash_type": "SHA1", "hash_value": hex.EncodeToString(sum[:]), "download_url": "http://" + r.Host + "/v3/billdownload/file?token=bill-token"}
+	case r.URL.Path == "/v3/billdownload/file":
+		assert.Equal(s.t, "bill-token", r.URL.Query().Get("token"))
+		content := s.bill
+		if s.tampered != nil {
+			content = s.tampered
+		}
+		s.mu.Unlock()
+		_, _ = w.Write(content)
+		return
+	case r.URL.Path == "/v3/refund/domestic/refunds":
+		s.refunds = append(s.refunds, req)
+		rsp = map[string]string{"status": "PROCESSING"}
+	case strings.HasPrefix(r.URL.Path, "/v3/pay/transactions/out-trade-no/"):
+		orderSn := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v3/pay/transactions/out-trade-no/"), "/close")
+		trade, ok := s.trades[orderSn]
+		switch {
+		case !ok:
+			status, rsp = http.StatusNotFound, map[string]string{"code": "ORDER_NOT_EXIST", "message": "订单不存在"}
+		case strings.HasSuffix(r.URL.Path, "/close"):
+			trade["trade_state"] = TradeClosed
+			status = http.StatusNoContent
+		default:
+			assert.Equal(s.t, "1000000001", r.URL.Query().Get("mchid"))
+			rsp = trade
+		}
+	}
+	s.mu.Unlock()
+
+	var content []byte
+	if rsp != nil {
+		content, _ = json.Marshal(rsp)
+	}
+	for k, v := range s.sign(content) {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(content)
+}
+
+// sign 使用平台私钥签名，返回签名相关的请求头
+func (s *wechatV3Stub) sign(body []byte) http.Header {
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), "platform-nonce"
+	return http.Header{
+		"Wechatpay-Timestamp": {timestamp},
+		"Wechatpay-Nonce":     {nonce},
+		"Wechatpay-Serial":    {testPlatformSerialNo},
+		"Wechatpay-Signature": {rsa2Sign(s.t, s.platformKey, timestamp+"\n"+nonce+"\n"+string(body)+"\n")},
+	}
+}
+
+// pay 模拟用户在微信完成付款
+func (s *wechatV3Stub) pay(orderSn string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trade := s.trades[orderSn]
+	trade["trade_state"] = TradeSuccess
+	trade["transaction_id"] = "4200" + orderSn
+	trade["success_time"] = "2024-01-01T12:00:00+08:00"
+	copied := map[string]interface{}{}
+	for k, v := range trade {
+		copied[k] = v
+	}
+	return copied
+}
+
+// notify 构造加密并签名的通知，返回请求头和报文
+func (s *wechatV3Stub) notify(eventType string, resource interface{}) (http.Header, []byte) {
+	plaintext, _ := json.Marshal(resource)
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":            "notify-" + eventType,
+		"event_type":    eventType,
+		"resource_type": "encrypt-resource",
+		"resource":      encryptV3Resource(s.t, plaintext, "transaction"),
+	})
+	return s.sign(body), body
+}
+
+// encryptV3Resource 使用 APIv3 密钥 AES-256-GCM 加密
+func encryptV3Resource(t *testing.T, plaintext []byte, associatedData string) map[string]string {
+	block, err := aes.NewCipher([]byte(testApiV3Key))
+	assert.Nil(t, err)
+	gcm, err := cipher.NewGCM(block)
+	assert.Nil(t, err)
+	nonce := "0123456789ab"
+	return map[string]string{
+		"algorithm":       "AEAD_AES_256_GCM",
+		"ciphertext":      base64.StdEncoding.EncodeToString(gcm.Seal(nil, []byte(nonce), plaintext, []byte(associatedData))),
+		"associated_data": associatedData,
+		"nonce":           nonce,
+	}
+}
+
+// setupWechatV3 启动本地微信支付 API v3 并注册，平台证书在首次验签时下载
+func setupWechatV3(t *testing.T) (*wechatV3Stub, *WechatV3, func()) {
+	merchantKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	platformKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	serial, _ := new(big.Int).SetString(testPlatformSerialNo, 16)
+	template := &x509.Certificate{SerialNumber: serial, Subject: pkix.Name{CommonName: "Tenpay.com Root CA"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &platformKey.PublicKey, platformKey)
+	assert.Nil(t, err)
+	stub := &wechatV3Stub{
+		t:           t,
+		merchantKey: &merchantKey.PublicKey,
+		platformKey: platformKey,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		trades:      map[string]map[string]interface{}{},
+	}
+	server := httptest.NewServer(stub)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(merchantKey)
+	assert.Nil(t, err)
+	keyPath := filepath.Join(t.TempDir(), "apiclient_key.pem")
+	assert.Nil(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	provider, err := NewWechatV3(config.WechatPay{
+		Provider:        ProviderWechatV3,
+		MchId:           "1000000001",
+		ApiV3Key:        testApiV3Key,
+		SerialNo:        "MERCHANT-SERIAL",
+		KeyPath:         keyPath,
+		NotifyURL:       "https://shop.example.com/api/wechat/pay/notify",
+		RefundNotifyURL: "https://shop.example.com/api/wechat/refund/notify",
+	}, "wx-test-app")
+	assert.Nil(t, err)
+	provider.BaseURL = server.URL
+	Register(Wechat, provider)
+	return stub, provider, func() {
+		Register(Wechat, nil)
+		server.Close()
+	}
+}
+
+func TestWechatV3Provider(t *testing.T) {
+	setupPaymentTestDB(t)
+	stub, provider, teardown := setupWechatV3(t)
+	defer teardown()
+
+	// 调起支付的参数使用商户私钥签名
+	pay, err := provider.Prepay(PrepayReq{OrderId: 1, OrderSn: "wxv3-1", Amount: 35.5, OpenId: "openid-1", TimeExpire: time.Now().Add(15 * time.Minute)})
+	assert.Nil(t, err)
+	payConfig := pay.(orderPay.Config)
+	assert.Equal(t, "prepay_id=wx-prepay-wxv3-1", payConfig.Package)
+	assert.Equal(t, "RSA", payConfig.SignType)
+	sign, _ := base64.StdEncoding.DecodeString(payConfig.PaySign)
+	hashed := sha256.Sum256([]byte("wx-test-app\n" + payConfig.Timestamp + "\n" + payConfig.NonceStr + "\n" + payConfig.Package + "\n"))
+	assert.Nil(t, rsa.VerifyPKCS1v15(stub.merchantKey, crypto.SHA256, hashed[:], sign))
+	assert.Equal(t, float64(3550), stub.trades["wxv3-1"]["amount"].(map[string]interface{})["total"])
+
+	trade := stub.pay("wxv3-1")
+	// 签名错误、签名过期、商户号不一致时拒绝处理
+	header, body := stub.notify("TRANSACTION.SUCCESS", trade)
+	_, err = provider.ParsePayNotify(header, []byte(strings.Replace(string(body), "TRANSACTION.SUCCESS", "TRANSACTION.FAIL", 1)))
+	assert.NotNil(t, err)
+	header.Set("Wechatpay-Timestamp", strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10))
+	_, err = provider.ParsePayNotify(header, body)
+	assert.NotNil(t, err)
+	trade["mchid"] = "1000000002"
+	_, err = provider.ParsePayNotify(stub.notify("TRANSACTION.SUCCESS", trade))
+	assert.NotNil(t, err)
+	trade["mchid"] = "1000000001"
+
+	// 解密交易信息 金额单位转换为元
+	parsed, err := provider.ParsePayNotify(stub.notify("TRANSACTION.SUCCESS", trade))
+	assert.Nil(t, err)
+	assert.Equal(t, TradeSuccess, parsed.State)
+	assert.Equal(t, "wxv3-1", parsed.OrderSn)
+	assert.Equal(t, "4200wxv3-1", parsed.TransactionId)
+	assert.Equal(t, 35.5, parsed.Amount)
+	assert.Equal(t, "openid-1", parsed.OpenId)
+	assert.Equal(t, "1", parsed.Attach)
+	_, err = provider.ParsePayNotify(stub.notify("TRANSACTION.SUCCESS", trade))
+	assert.Nil(t, err)
+	// 只在首次验签时下载平台证书
+	assert.Equal(t, 1, strings.Count(strings.Join(stub.paths, ","), "/v3/certificates"))
+
+	// 支付通知丢失时查询支付结果
+	_, err = provider.Query("wxv3-2")
+	assert.ErrorIs(t, err, ErrTradeNotExist)
+	queried, err := provider.Query("wxv3-1")
+	assert.Nil(t, err)
+	assert.Equal(t, TradeSuccess, queried.State)
+	assert.Equal(t, 35.5, queried.Amount)
+
+	// 申请退款 退款结果通过通知返回
+	order := createRefundingOrder(t, Wechat, "wxv3-1", "wxv3-1R", 35.5)
+	assert.Nil(t, provider.Refund(RefundReq{OrderSn: order.OrderSn, RefundSn: order.RefundSn, Total: 35.5, Amount: 35.5, Desc: "缺货退款"}))
+	assert.Len(t, stub.refunds, 1)
+	assert.Equal(t, order.RefundSn, stub.refunds[0]["out_refund_no"])
+	assert.Equal(t, map[string]interface{}{"refund": float64(3550), "total": float64(3550), "currency": "CNY"}, stub.refunds[0]["amount"])
+
+	refund := map[string]interface{}{"mchid": "1000000001", "out_trade_no": order.OrderSn, "out_refund_no": order.RefundSn, "refund_status": "SUCCESS", "success_time": "2024-01-01T13:00:00+08:00"}
+	refund["mchid"] = "1000000002"
+	header, body = stub.notify("REFUND.SUCCESS", refund)
+	_, err = HandleRefundNotify(Wechat, header, body)
+	assert.NotNil(t, err)
+	refund["mchid"] = "1000000001"
+	header, body = stub.notify("REFUND.SUCCESS", refund)
+	_, err = HandleRefundNotify(Wechat, header, body)
+	assert.Nil(t, err)
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStateRefunded, common.OrderState(order))
+	assert.Equal(t, "2024-01-01 05:00:00", order.RefundTime.UTC().Format("2006-01-02 15:04:05"))
+
+	// 关闭未支付的订单
+	_, err = provider.Prepay(PrepayReq{OrderId: 3, OrderSn: "wxv3-3", Amount: 10, OpenId: "openid-1", TimeExpire: time.Now().Add(15 * time.Minute)})
+	assert.Nil(t, err)
+	assert.Nil(t, provider.Close("wxv3-3"))
+	closed, err := provider.Query("wxv3-3")
+	assert.Nil(t, err)
+	assert.Equal(t, TradeClosed, closed.State)
+
+	var records []shop.PayNotify
+	global.DB.Where("payment = ? and type = ?", Wechat, shop.PayNotifyTypeRefund).Order("id").Find(&records)
+	assert.Len(t, records, 2)
+	assert.Equal(t, shop.PayNotifyStatusFail, *records[0].Status)
+	assert.Equal(t, shop.PayNotifyStatusSuccess, *records[1].Status)
+	assert.Equal(t, "wxv3-1R", records[1].TransactionId)
+	status, contentType, rspBody := provider.NotifyResponse(fmt.Errorf("签名验证失败"))
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Contains(t, contentType, "json")
+	assert.Contains(t, string(rspBody), `"code":"FAIL"`)
+	status, _, rspBody = provider.NotifyResponse(nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, string(rspBody), `"code":"SUCCESS"`)
+}
+
+func TestWechatV3_PlatformCertRefresh(t *testing.T) {
+	setupPaymentTestDB(t)
+	stub, provider, teardown := setupWechatV3(t)
+	defer teardown()
+	downloads := func() int {
+		stub.mu.Lock()
+		defer stub.mu.Unlock()
+		return strings.Count(strings.Join(stub.paths, ","), "/v3/certificates")
+	}
+	forged := func() (http.Header, []byte) {
+		header, body := stub.notify("TRANSACTION.SUCCESS", map[string]interface{}{"out_trade_no": "wxv3-1"})
+		header.Set("Wechatpay-Serial", "FORGED-SERIAL")
+		return header, body
+	}
+
+	// 未知的证书序列号下载一次平台证书后拒绝，间隔内不会再次下载
+	_, err := provider.ParsePayNotify(forged())
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, downloads())
+	for i := 0; i < 5; i++ {
+		_, err = provider.ParsePayNotify(forged())
+		assert.NotNil(t, err)
+	}
+	assert.Equal(t, 1, downloads())
+	// 已下载的证书正常验签
+	_, err = provider.ParsePayNotify(stub.notify("TRANSACTION.NOTPAY", map[string]interface{}{"appid": "wx-test-app", "mchid": "1000000001", "out_trade_no": "wxv3-1", "trade_state": TradeNotPay}))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, downloads())
+
+	// 超过间隔后可以再次下载
+	provider.refreshed = time.Now().Add(-wechatV3CertRefreshInterval)
+	_, err = provider.ParsePayNotify(forged())
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, downloads())
+
+	// 并发的未知序列号只下载一次
+	provider.refreshed = time.Time{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = provider.ParsePayNotify(forged())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 3, downloads())
+}
+
+func TestWechatV3_DownloadBill(t *testing.T) {
+	setupPaymentTestDB(t)
+	stub, provider, teardown := setupWechatV3(t)
+	defer teardown()
+	stub.bill = []byte("交易时间,微信订单号,商户订单号\n`2024-01-01 10:00:00,`4200wxv3-1,`wxv3-1\n")
+
+	// 申请账单后按下载地址下载，校验摘要
+	data, err := provider.DownloadBill("20240101")
+	assert.Nil(t, err)
+	assert.Equal(t, stub.bill, data)
+	assert.Contains(t, stub.paths, "GET /v3/billdownload/file")
+
+	// 当天没有交易时返回空的对账单
+	data, err = provider.DownloadBill("20240102")
+	assert.Nil(t, err)
+	assert.Nil(t, data)
+
+	// 下载的内容与摘要不一致时拒绝
+	stub.tampered = []byte("交易时间,微信订单号,商户订单号\n")
+	_, err = provider.DownloadBill("20240101")
+	assert.EqualError(t, err, "对账单摘要校验失败")
+}
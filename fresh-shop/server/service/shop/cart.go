@@ -2,18 +2,22 @@ package shop
 
 import (
 	"errors"
+	"fmt"
 	"fresh-shop/server/global"
 	"fresh-shop/server/model/common/request"
 	"fresh-shop/server/model/shop"
 	shopReq "fresh-shop/server/model/shop/request"
+	shopResp "fresh-shop/server/model/shop/response"
 	"fresh-shop/server/utils"
 	"gorm.io/gorm"
+	"strings"
 )
 
 type CartService struct {
 }
 
 // CreateCart 创建Cart记录
+// 多规格商品按 (user_id, goods_id, spec_item_id) 区分购物车记录
 // Author [dalefeng](https://github.com/dalefeng)
 func (cartService *CartService) CreateCart(cart shop.Cart) (err error) {
 	var c shop.Cart
@@ -21,14 +25,30 @@ func (cartService *CartService) CreateCart(cart shop.Cart) (err error) {
 	if errors.Is(global.DB.Where("id = ?", cart.GoodsId).First(&goods).Error, gorm.ErrRecordNotFound) {
 		return errors.New("商品不存在")
 	}
+	// 库存 多规格商品取规格明细库存
+	sku := shop.Cart{Goods: goods}
+	if goods.SpecType != nil && *goods.SpecType == 1 {
+		if cart.SpecItemId == 0 {
+			return errors.New("请选择商品规格")
+		}
+		var specValue shop.GoodsSpecValue
+		if errors.Is(global.DB.Where("id = ? and goods_id = ?", cart.SpecItemId, goods.ID).First(&specValue).Error, gorm.ErrRecordNotFound) {
+			return errors.New("商品规格不存在")
+		}
+		sku.SpecType, sku.SpecValue = 1, specValue
+		cart.SpecType = 1
+	} else {
+		cart.SpecType = 0
+		cart.SpecItemId = 0
+	}
+	store := cartGoodsStore(sku)
 
 	// 记录不存在则创建
-	cart.SpecItemId = 0
 	if cart.Checked == nil {
 		cart.Checked = utils.Pointer(0)
 	}
-	if errors.Is(global.DB.Where("user_id = ? and goods_id = ?", cart.UserId, cart.GoodsId).First(&c).Error, gorm.ErrRecordNotFound) {
-		if *goods.Store < cart.Num {
+	if errors.Is(global.DB.Where("user_id = ? and goods_id = ? and spec_item_id = ?", cart.UserId, cart.GoodsId, cart.SpecItemId).First(&c).Error, gorm.ErrRecordNotFound) {
+		if store < cart.Num {
 			return errors.New("商品库存不足")
 		}
 		if cart.Num > 0 {
@@ -36,7 +56,7 @@ func (cartService *CartService) CreateCart(cart shop.Cart) (err error) {
 			err = global.DB.Create(&cart).Error
 		}
 	} else {
-		if cart.Num > c.Num && *goods.Store < cart.Num {
+		if cart.Num > c.Num && store < cart.Num {
 			return errors.New("商品库存不足")
 		}
 
@@ -70,12 +90,12 @@ func (cartService *CartService) DeleteCartByIds(ids request.IdsReq) (err error)
 // Author [dalefeng](https://github.com/dalefeng)
 func (cartService *CartService) UpdateCart(cart shop.Cart) (err error) {
 	var dbC shop.Cart
-	err = global.DB.Where("id = ?", cart.ID).Preload("Goods").First(&dbC).Error
+	err = global.DB.Where("id = ?", cart.ID).Preload("Goods").Preload("SpecValue").First(&dbC).Error
 	if err != nil {
 		return err
 	}
 	if *cart.Checked == 1 {
-		if *dbC.Goods.Store <= 0 || *dbC.Goods.Store < dbC.Num {
+		if store := cartGoodsStore(dbC); store <= 0 || store < dbC.Num {
 			return errors.New("商品库存不足")
 		}
 	}
@@ -89,7 +109,7 @@ func (cartService *CartService) UpdateCart(cart shop.Cart) (err error) {
 func (cartService *CartService) SelectAllChecked(userId uint) (err error) {
 	var ids []uint
 	var carts []shop.Cart
-	err = global.DB.Model(&shop.Cart{}).Where("user_id = ?", userId).Preload("Goods").Find(&carts).Error
+	err = global.DB.Model(&shop.Cart{}).Where("user_id = ?", userId).Preload("Goods").Preload("SpecValue").Find(&carts).Error
 	if err != nil {
 		return err
 	}
@@ -100,9 +120,7 @@ func (cartService *CartService) SelectAllChecked(userId uint) (err error) {
 		if c.Goods.ID == 0 {
 			continue
 		}
-		if *c.Goods.Store <= 0 {
-			continue
-		} else if *c.Goods.Store < c.Num {
+		if store := cartGoodsStore(c); store <= 0 || store < c.Num {
 			continue
 		}
 		ids = append(ids, c.ID)
@@ -134,7 +152,7 @@ func (cartService *CartService) GetCart(id uint) (cart shop.Cart, err error) {
 // Author [dalefeng](https://github.com/dalefeng)
 func (cartService *CartService) GetCartInfoList(info shopReq.CartSearch, userId uint) (list []shop.Cart, total int64, err error) {
 	// 创建db
-	db := global.DB.Debug().Model(&shop.Cart{}).Where("user_id = ?", userId).Preload("Goods.Images")
+	db := global.DB.Debug().Model(&shop.Cart{}).Where("user_id = ?", userId).Preload("Goods.Images").Preload("SpecValue")
 	var carts []shop.Cart
 	if info.Checked != nil {
 		db = db.Where("checked = ?", *info.Checked)
@@ -160,10 +178,7 @@ func (cartService *CartService) GetCartInfoList(info shopReq.CartSearch, userId
 		if c.Goods.ID == 0 {
 			continue
 		}
-		if *c.Goods.Store <= 0 {
-			carts[i].Checked = utils.Pointer(0)
-			cancelCheckIds = append(cancelCheckIds, c.ID)
-		} else if *c.Goods.Store < c.Num {
+		if store := cartGoodsStore(c); store <= 0 || store < c.Num {
 			carts[i].Checked = utils.Pointer(0)
 			cancelCheckIds = append(cancelCheckIds, c.ID)
 		}
@@ -175,3 +190,142 @@ func (cartService *CartService) GetCartInfoList(info shopReq.CartSearch, userId
 	total = int64(len(results))
 	return results, total, err
 }
+
+// BuyAgain 再次购买 将用户订单中的商品重新加入购物车并选中
+// 按商品当前的价格、规格、上架状态、最低购买数量和库存处理，返回每个商品加入、调整或跳过的结果
+func (cartService *CartService) BuyAgain(orderId uint, userId uint) (resp shopResp.BuyAgainResp, err error) {
+	var order shop.Order
+	if errors.Is(global.DB.Where("id = ? and user_id = ?", orderId, userId).Preload("OrderDetails").First(&order).Error, gorm.ErrRecordNotFound) {
+		return resp, errors.New("订单不存在")
+	}
+	if order.GoodsArea != nil && *order.GoodsArea == 1 {
+		return resp, errors.New("积分商品订单不支持再次购买")
+	}
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		for _, d := range order.OrderDetails {
+			line := shopResp.BuyAgainLine{GoodsId: d.GoodsId, SpecId: d.SpecId, GoodsName: d.GoodsName, Num: d.Num, OldPrice: d.Price}
+			reasons, txErr := buyAgainLine(tx, userId, d, &line)
+			if txErr != nil {
+				return txErr
+			}
+			line.Reason = strings.Join(reasons, "；")
+			switch {
+			case line.AddNum <= 0:
+				resp.Skipped = append(resp.Skipped, line)
+			case len(reasons) > 0:
+				resp.Adjusted = append(resp.Adjusted, line)
+			default:
+				resp.Added = append(resp.Added, line)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// buyAgainLine 将一个订单商品加入购物车，返回调整或跳过的原因
+func buyAgainLine(tx *gorm.DB, userId uint, d shop.OrderDetails, line *shopResp.BuyAgainLine) (reasons []string, err error) {
+	c := shop.Cart{GoodsId: utils.Pointer(int(d.GoodsId)), UserId: utils.Pointer(int(userId)), SpecItemId: d.SpecId}
+	if errors.Is(tx.Where("id = ?", d.GoodsId).First(&c.Goods).Error, gorm.ErrRecordNotFound) {
+		return []string{"商品已失效"}, nil
+	}
+	if c.Goods.Status != nil && *c.Goods.Status == 0 {
+		return []string{"商品已下架"}, nil
+	}
+	if c.Goods.GoodsArea != nil && *c.Goods.GoodsArea == 1 {
+		return []string{"积分商品不能加入购物车"}, nil
+	}
+	if c.Goods.SpecType != nil && *c.Goods.SpecType == 1 {
+		if d.SpecId == 0 || errors.Is(tx.Where("id = ? and goods_id = ?", d.SpecId, d.GoodsId).First(&c.SpecValue).Error, gorm.ErrRecordNotFound) {
+			return []string{"商品规格已失效，请重新选择规格"}, nil
+		}
+		c.SpecType = 1
+	} else if d.SpecId != 0 {
+		return []string{"商品规格已失效，请重新选择规格"}, nil
+	}
+	line.GoodsName = cartGoodsName(c)
+	line.Price = cartGoodsPrice(c)
+
+	// 购物车中已有的数量
+	var exist shop.Cart
+	existErr := tx.Where("user_id = ? and goods_id = ? and spec_item_id = ?", userId, d.GoodsId, c.SpecItemId).First(&exist).Error
+	if existErr != nil && !errors.Is(existErr, gorm.ErrRecordNotFound) {
+		return nil, existErr
+	}
+	num := exist.Num + d.Num
+	if minCount := c.Goods.MinCount; minCount != nil && num < *minCount {
+		num = *minCount
+		reasons = append(reasons, fmt.Sprintf("最低购买 %d%s，数量已调整", *minCount, c.Goods.Unit))
+	}
+	store := cartGoodsStore(c)
+	if num > store {
+		num = store
+		if num <= exist.Num || (c.Goods.MinCount != nil && num < *c.Goods.MinCount) {
+			return []string{"商品库存不足"}, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("库存不足，数量已调整为 %d", num))
+	}
+	if line.Price != d.Price {
+		reasons = append(reasons, fmt.Sprintf("价格由 %.2f 变为 %.2f", d.Price, line.Price))
+	}
+
+	line.AddNum = num - exist.Num
+	line.CartNum = num
+	if exist.ID > 0 {
+		return reasons, tx.Model(&exist).Updates(map[string]interface{}{"num": num, "checked": 1}).Error
+	}
+	c.Num = num
+	c.Checked = utils.Pointer(1)
+	return reasons, tx.Omit("Goods", "SpecValue").Create(&c).Error
+}
+
+// cartGoodsStore 获取购物车商品库存，多规格商品取规格明细的库存
+// 需要预加载 Goods 和 SpecValue
+func cartGoodsStore(c shop.Cart) int {
+	if c.SpecType == 1 {
+		if c.SpecValue.ID == 0 || c.SpecValue.Store == nil {
+			return 0
+		}
+		return *c.SpecValue.Store
+	}
+	if c.Goods.Store == nil {
+		return 0
+	}
+	return *c.Goods.Store
+}
+
+// cartGoodsName 获取购物车商品名称，多规格商品拼接规格名称
+func cartGoodsName(c shop.Cart) string {
+	if c.SpecType == 1 && c.SpecValue.KeyName != "" {
+		return c.Goods.Name + " " + c.SpecValue.KeyName
+	}
+	return c.Goods.Name
+}
+
+// cartGoodsCostPrice 获取购物车商品的原价，多规格商品取规格明细的原价
+func cartGoodsCostPrice(c shop.Cart) float64 {
+	costPrice := c.Goods.CostPrice
+	if c.SpecType == 1 {
+		costPrice = c.SpecValue.CostPrice
+	}
+	if costPrice == nil {
+		return 0
+	}
+	return *costPrice
+}
+
+// cartGoodsPrice 获取购物车商品的单价，多规格商品取规格明细的价格
+// 优惠价大于 0 且小于原价时使用优惠价，否则使用原价
+func cartGoodsPrice(c shop.Cart) float64 {
+	price, costPrice := c.Goods.Price, c.Goods.CostPrice
+	if c.SpecType == 1 {
+		price, costPrice = c.SpecValue.Price, c.SpecValue.CostPrice
+	}
+	if costPrice == nil {
+		costPrice = utils.Pointer(0.0)
+	}
+	if price != nil && *price > 0 && *price < *costPrice {
+		return *price
+	}
+	return *costPrice
+}
@@ -0,0 +1,123 @@
+package shop
+
+import (
+	"testing"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	systemReq "fresh-shop/server/model/system/request"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCartService_BuyAgain(t *testing.T) {
+	setupOrderTestDB(t)
+	newGoods := func(name string, price float64, store, minCount, status int) shop.Goods {
+		g := shop.Goods{
+			Name:      name,
+			SpecType:  utils.Pointer(0),
+			Unit:      "袋",
+			CostPrice: utils.Pointer(price),
+			Price:     utils.Pointer(0.0),
+			Store:     utils.Pointer(store),
+			MinCount:  utils.Pointer(minCount),
+			Status:    utils.Pointer(status),
+		}
+		assert.Nil(t, global.DB.Create(&g).Error)
+		return g
+	}
+	same := newGoods("冷冻带鱼", 20, 10, 1, 1)
+	lowStock := newGoods("冷冻虾仁", 30, 1, 1, 1)
+	offShelf := newGoods("冷冻鸡翅", 25, 10, 1, 0)
+	minCount := newGoods("速冻水饺", 15, 10, 3, 1)
+	changed := newGoods("冷冻牛排", 60, 10, 1, 1)
+
+	userId := createTestUser(t, "again-buyer")
+	order := shop.Order{OrderSn: "AGAIN001", UserId: utils.Pointer(int(userId)), GoodsArea: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&order).Error)
+	details := []shop.OrderDetails{
+		{OrderId: order.ID, GoodsId: same.ID, GoodsName: same.Name, Num: 2, Price: 20},
+		{OrderId: order.ID, GoodsId: lowStock.ID, GoodsName: lowStock.Name, Num: 2, Price: 30},
+		{OrderId: order.ID, GoodsId: offShelf.ID, GoodsName: offShelf.Name, Num: 1, Price: 25},
+		{OrderId: order.ID, GoodsId: minCount.ID, GoodsName: minCount.Name, Num: 1, Price: 15},
+		{OrderId: order.ID, GoodsId: changed.ID, GoodsName: changed.Name, Num: 1, Price: 50},
+		{OrderId: order.ID, GoodsId: 9999, GoodsName: "已删除商品", Num: 1, Price: 10},
+	}
+	assert.Nil(t, global.DB.Create(&details).Error)
+	// 购物车中已有 1 袋带鱼
+	assert.Nil(t, global.DB.Create(&shop.Cart{GoodsId: utils.Pointer(int(same.ID)), UserId: utils.Pointer(int(userId)), Num: 1, Checked: utils.Pointer(0)}).Error)
+
+	service := CartService{}
+	// 不能再次购买其他用户的订单
+	_, err := service.BuyAgain(order.ID, userId+1)
+	assert.NotNil(t, err)
+
+	resp, err := service.BuyAgain(order.ID, userId)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Added, 1)
+	assert.Equal(t, same.ID, resp.Added[0].GoodsId)
+	assert.Equal(t, 3, resp.Added[0].CartNum)
+
+	assert.Len(t, resp.Adjusted, 3)
+	adjusted := map[uint]int{}
+	for _, l := range resp.Adjusted {
+		adjusted[l.GoodsId] = l.CartNum
+		assert.NotEmpty(t, l.Reason)
+	}
+	assert.Equal(t, map[uint]int{lowStock.ID: 1, minCount.ID: 3, changed.ID: 1}, adjusted)
+
+	assert.Len(t, resp.Skipped, 2)
+	assert.Equal(t, "商品已下架", resp.Skipped[0].Reason)
+	assert.Equal(t, "商品已失效", resp.Skipped[1].Reason)
+
+	var carts []shop.Cart
+	global.DB.Where("user_id = ? and checked = 1", userId).Find(&carts)
+	assert.Len(t, carts, 4)
+}
+
+func TestCartService_SpecSku(t *testing.T) {
+	setupOrderTestDB(t)
+	goods := shop.Goods{Name: "冷冻鸡翅", SpecType: utils.Pointer(1), Unit: "袋", CostPrice: utils.Pointer(30.0), Price: utils.Pointer(0.0), Store: utils.Pointer(0), Sale: utils.Pointer(0)}
+	other := shop.Goods{Name: "冷冻带鱼", SpecType: utils.Pointer(0), Unit: "袋", CostPrice: utils.Pointer(20.0), Price: utils.Pointer(0.0), Store: utils.Pointer(10), Sale: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	assert.Nil(t, global.DB.Create(&other).Error)
+	small := shop.GoodsSpecValue{GoodsId: goods.ID, KeyName: "重量:500g", CostPrice: utils.Pointer(20.0), Price: utils.Pointer(18.0), Store: utils.Pointer(5), Sale: utils.Pointer(0)}
+	large := shop.GoodsSpecValue{GoodsId: goods.ID, KeyName: "重量:5kg", CostPrice: utils.Pointer(160.0), Price: utils.Pointer(0.0), Store: utils.Pointer(1), Sale: utils.Pointer(0)}
+	otherSpec := shop.GoodsSpecValue{GoodsId: other.ID, KeyName: "重量:1kg", CostPrice: utils.Pointer(1.0), Price: utils.Pointer(0.0), Store: utils.Pointer(100), Sale: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&[]*shop.GoodsSpecValue{&small, &large, &otherSpec}).Error)
+
+	userId := createTestUser(t, "sku-buyer")
+	service := CartService{}
+	cart := func(specItemId, num int) shop.Cart {
+		return shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), SpecItemId: specItemId, Num: num, Checked: utils.Pointer(1)}
+	}
+	// 多规格商品必须选择本商品的规格，库存按规格明细判断
+	assert.NotNil(t, service.CreateCart(cart(0, 1)))
+	assert.NotNil(t, service.CreateCart(cart(int(otherSpec.ID), 1)))
+	assert.NotNil(t, service.CreateCart(cart(int(large.ID), 2)))
+	// 同一商品的不同规格分别加入购物车，同一规格更新数量
+	assert.Nil(t, service.CreateCart(cart(int(small.ID), 1)))
+	assert.Nil(t, service.CreateCart(cart(int(small.ID), 2)))
+	assert.Nil(t, service.CreateCart(cart(int(large.ID), 1)))
+	list, total, err := service.GetCartInfoList(shopReq.CartSearch{}, userId)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), total)
+	nums := map[uint]int{}
+	for _, c := range list {
+		assert.Equal(t, 1, c.SpecType)
+		assert.Equal(t, uint(c.SpecItemId), c.SpecValue.ID)
+		nums[c.SpecValue.ID] = c.Num
+	}
+	assert.Equal(t, map[uint]int{small.ID: 2, large.ID: 1}, nums)
+
+	// 下单按规格明细的价格计算 明细记录规格名称
+	resp, err := (&OrderService{}).CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}, &systemReq.CustomClaims{}, "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2*18.0+160.0, resp.Order.Total)
+	var details []shop.OrderDetails
+	global.DB.Where("order_id = ?", resp.Order.ID).Order("spec_id").Find(&details)
+	assert.Len(t, details, 2)
+	assert.Equal(t, []string{"重量:500g", "重量:5kg"}, []string{details[0].SpecKeyName, details[1].SpecKeyName})
+	assert.Equal(t, []float64{18, 160}, []float64{details[0].Price, details[1].Price})
+}
@@ -0,0 +1,253 @@
+package shop
+
+import (
+	"errors"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/business"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	shopResp "fresh-shop/server/model/shop/response"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/utils/upload"
+	"gorm.io/gorm"
+	"mime/multipart"
+	"path"
+	"strings"
+	"time"
+)
+
+// newOss 上传送达凭证和售后图片使用的对象存储
+var newOss = upload.NewOss
+
+// uploadImageExts 送达凭证和售后图片允许上传的图片格式
+var uploadImageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+
+// DelivererService 送货员工作台 送货员只能查看和操作分配给自己的配送单
+type DelivererService struct {
+}
+
+// GetDeliverer 根据登录用户获取送货员信息
+func (delivererService *DelivererService) GetDeliverer(userId uint) (deliverer business.UserDelivery, err error) {
+	err = global.DB.Where("user_id = ? and status = 1", userId).First(&deliverer).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return deliverer, errors.New("当前账号不是送货员或已被禁用")
+	}
+	return
+}
+
+// GetDeliveryTaskList 分页获取送货员的配送任务
+func (delivererService *DelivererService) GetDeliveryTaskList(userId uint, info shopReq.DeliveryTaskSearch) (list []shopResp.DeliveryTask, total int64, err error) {
+	deliverer, err := delivererService.GetDeliverer(userId)
+	if err != nil {
+		return
+	}
+	limit := info.PageSize
+	offset := info.PageSize * (info.Page - 1)
+	db := global.DB.Model(&shop.OrderDelivery{}).Where("delivery_id = ?", deliverer.ID)
+	if info.Status != nil {
+		db = db.Where("status = ?", info.Status)
+	}
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	var deliveries []shop.OrderDelivery
+	if err = db.Order("status asc, scheduled_time asc, id asc").Limit(limit).Offset(offset).Find(&deliveries).Error; err != nil {
+		return
+	}
+	orderIds := make([]int, 0, len(deliveries))
+	for _, d := range deliveries {
+		if d.OrderId != nil {
+			orderIds = append(orderIds, *d.OrderId)
+		}
+	}
+	var orders []shop.Order
+	if err = global.DB.Where("id in ?", orderIds).Find(&orders).Error; err != nil {
+		return
+	}
+	orderMap := make(map[uint]shop.Order, len(orders))
+	for _, o := range orders {
+		orderMap[o.ID] = o
+	}
+	list = make([]shopResp.DeliveryTask, 0, len(deliveries))
+	for _, d := range deliveries {
+		var order shop.Order
+		if d.OrderId != nil {
+			order = orderMap[uint(*d.OrderId)]
+		}
+		list = append(list, deliveryTask(d, order))
+	}
+	return list, total, nil
+}
+
+// GetDeliveryTask 获取送货员的单个配送任务 包含订单详情
+func (delivererService *DelivererService) GetDeliveryTask(userId uint, id uint) (task shopResp.DeliveryTask, err error) {
+	deliverer, err := delivererService.GetDeliverer(userId)
+	if err != nil {
+		return
+	}
+	delivery, err := ownDelivery(global.DB, deliverer, id)
+	if err != nil {
+		return
+	}
+	var order shop.Order
+	if err = global.DB.Where("id = ?", delivery.OrderId).Preload("OrderDetails").First(&order).Error; err != nil {
+		global.SugarLog.Errorf("获取配送订单失败 deliveryId:%d, err:%v \n", delivery.ID, err)
+		return task, errors.New("配送订单不存在")
+	}
+	task = deliveryTask(delivery, order)
+	task.Details = order.OrderDetails
+	return
+}
+
+// PickUpDelivery 送货员确认取货
+func (delivererService *DelivererService) PickUpDelivery(userId uint, id uint) error {
+	return delivererService.updateDeliveryStatus(userId, id, shop.DeliveryStatusPickedUp, "pick_up_time")
+}
+
+// ArriveDelivery 送货员确认到达收货地址
+func (delivererService *DelivererService) ArriveDelivery(userId uint, id uint) error {
+	return delivererService.updateDeliveryStatus(userId, id, shop.DeliveryStatusArrived, "arrive_time")
+}
+
+// DeliverDelivery 送货员确认送达 订单确认收货并发放赠送积分，送货员送货单数加一
+func (delivererService *DelivererService) DeliverDelivery(userId uint, id uint) error {
+	return delivererService.updateDeliveryStatus(userId, id, shop.DeliveryStatusDelivered, "receipt_time")
+}
+
+// UploadDeliveryProof 送货员上传送达凭证 包括包裹照片、可选的收货人签名和上传时的定位
+// 取货后才能上传，重复上传会替换之前的凭证并删除旧文件
+func (delivererService *DelivererService) UploadDeliveryProof(userId uint, req shopReq.DeliveryProofReq, photo, sign *multipart.FileHeader) (delivery shop.OrderDelivery, err error) {
+	deliverer, err := delivererService.GetDeliverer(userId)
+	if err != nil {
+		return
+	}
+	if delivery, err = ownDelivery(global.DB, deliverer, req.ID); err != nil {
+		return
+	}
+	if delivery.Status == nil || *delivery.Status < shop.DeliveryStatusPickedUp {
+		return delivery, errors.New("请先确认取货")
+	}
+	if req.Longitude == nil || req.Latitude == nil || *req.Longitude < -180 || *req.Longitude > 180 || *req.Latitude < -90 || *req.Latitude > 90 {
+		return delivery, errors.New("定位信息错误，请开启定位后重试")
+	}
+	if photo == nil {
+		return delivery, errors.New("请上传包裹照片")
+	}
+	for _, f := range []*multipart.FileHeader{photo, sign} {
+		if f != nil && !uploadImageExts[strings.ToLower(path.Ext(f.Filename))] {
+			return delivery, errors.New("送达凭证只能上传 jpg、png、webp 格式的图片")
+		}
+	}
+
+	oss := newOss()
+	var keys []string
+	// 保存失败时删除已上传的文件
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, key := range keys {
+			if delErr := oss.DeleteFile(key); delErr != nil {
+				global.SugarLog.Errorf("删除送达凭证文件失败 key:%s, err:%v \n", key, delErr)
+			}
+		}
+	}()
+	photoUrl, photoKey, err := oss.UploadFile(photo)
+	if err != nil {
+		global.SugarLog.Errorf("上传送达凭证照片失败 deliveryId:%d, err:%v \n", delivery.ID, err)
+		return delivery, errors.New("上传包裹照片失败")
+	}
+	keys = append(keys, photoKey)
+	signUrl, signKey := "", ""
+	if sign != nil {
+		if signUrl, signKey, err = oss.UploadFile(sign); err != nil {
+			global.SugarLog.Errorf("上传收货人签名失败 deliveryId:%d, err:%v \n", delivery.ID, err)
+			return delivery, errors.New("上传签名图片失败")
+		}
+		keys = append(keys, signKey)
+	}
+
+	oldKeys := []string{delivery.ProofImageKey, delivery.SignImageKey}
+	values := map[string]interface{}{
+		"proof_image":     photoUrl,
+		"proof_image_key": photoKey,
+		"sign_image":      signUrl,
+		"sign_image_key":  signKey,
+		"proof_longitude": *req.Longitude,
+		"proof_latitude":  *req.Latitude,
+		"proof_time":      time.Now(),
+	}
+	if err = global.DB.Model(&shop.OrderDelivery{}).Where("id = ? and delivery_id = ?", delivery.ID, deliverer.ID).Updates(values).Error; err != nil {
+		return
+	}
+	keys = nil
+	for _, key := range oldKeys {
+		if key == "" {
+			continue
+		}
+		if delErr := oss.DeleteFile(key); delErr != nil {
+			global.SugarLog.Errorf("删除旧的送达凭证文件失败 key:%s, err:%v \n", key, delErr)
+		}
+	}
+	err = global.DB.Where("id = ?", delivery.ID).First(&delivery).Error
+	return
+}
+
+// updateDeliveryStatus 更新配送状态 以当前状态小于目标状态为条件更新，重复提交只会处理一次
+func (delivererService *DelivererService) updateDeliveryStatus(userId uint, id uint, status int, timeColumn string) error {
+	deliverer, err := delivererService.GetDeliverer(userId)
+	if err != nil {
+		return err
+	}
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		delivery, err := ownDelivery(tx, deliverer, id)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		result := tx.Model(&shop.OrderDelivery{}).Where("id = ? and delivery_id = ? and status < ?", delivery.ID, deliverer.ID, status).
+			Updates(map[string]interface{}{"status": status, timeColumn: now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("配送状态已变更，请刷新后重试")
+		}
+		if status != shop.DeliveryStatusDelivered {
+			return nil
+		}
+		if err = tx.Model(&business.UserDelivery{}).Where("id = ?", deliverer.ID).
+			Update("deliver_count", gorm.Expr("coalesce(deliver_count, 0) + 1")).Error; err != nil {
+			return err
+		}
+		var order shop.Order
+		if err = tx.Where("id = ?", delivery.OrderId).First(&order).Error; err != nil {
+			global.SugarLog.Errorf("获取配送订单失败 deliveryId:%d, err:%v \n", delivery.ID, err)
+			return errors.New("配送订单不存在")
+		}
+		actor := common.OrderActor{Type: common.ActorDeliverer, Id: userId, Name: deliverer.Name}
+		return common.OrderReceivedTx(tx, &order, now, actor, "送货员确认送达发放积分")
+	})
+}
+
+// ownDelivery 获取分配给送货员的配送单，不是自己的配送单按不存在处理
+func ownDelivery(db *gorm.DB, deliverer business.UserDelivery, id uint) (delivery shop.OrderDelivery, err error) {
+	err = db.Where("id = ? and delivery_id = ?", id, deliverer.ID).First(&delivery).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return delivery, errors.New("配送单不存在")
+	}
+	return
+}
+
+// deliveryTask 组织配送任务 只返回配送需要的收货信息
+func deliveryTask(delivery shop.OrderDelivery, order shop.Order) shopResp.DeliveryTask {
+	return shopResp.DeliveryTask{
+		OrderDelivery:   delivery,
+		OrderSn:         order.OrderSn,
+		ShipmentName:    order.ShipmentName,
+		ShipmentMobile:  order.ShipmentMobile,
+		ShipmentAddress: order.ShipmentAddress,
+		Remarks:         order.Remarks,
+		Num:             order.Num,
+	}
+}
@@ -0,0 +1,188 @@
+package shop
+
+import (
+	"errors"
+	"mime/multipart"
+	"testing"
+	"time"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/business"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/utils"
+	"fresh-shop/server/utils/upload"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelivererService_Deliver(t *testing.T) {
+	setupOrderTestDB(t)
+	buyerId := createTestUser(t, "deliver-buyer")
+	createTestAccount(t, buyerId, common.POINT, 0)
+	courierId := createTestUser(t, "courier")
+	otherId := createTestUser(t, "other-courier")
+
+	courier := business.UserDelivery{Name: "张师傅", Mobile: "13800000000", DeliverCount: utils.Pointer(0), Status: utils.Pointer(1), UserId: utils.Pointer(int(courierId))}
+	other := business.UserDelivery{Name: "李师傅", Mobile: "13900000000", DeliverCount: utils.Pointer(0), Status: utils.Pointer(1), UserId: utils.Pointer(int(otherId))}
+	assert.Nil(t, global.DB.Create(&courier).Error)
+	assert.Nil(t, global.DB.Create(&other).Error)
+
+	order := shop.Order{
+		OrderSn:         "deliver-1",
+		UserId:          utils.Pointer(int(buyerId)),
+		GoodsArea:       utils.Pointer(0),
+		ShipmentName:    "王女士",
+		ShipmentMobile:  "13700000000",
+		ShipmentAddress: "幸福路 1 号",
+		Status:          utils.Pointer(2),
+		StatusCancel:    utils.Pointer(0),
+		StatusRefund:    utils.Pointer(0),
+		ShipmentType:    utils.Pointer(0),
+		ShipmentTime:    utils.Pointer(time.Now()),
+		Total:           50,
+		GiftPoints:      5,
+	}
+	assert.Nil(t, global.DB.Create(&order).Error)
+	delivery := shop.OrderDelivery{OrderId: utils.Pointer(int(order.ID)), DeliveryId: utils.Pointer(int(courier.ID)), DeliverName: courier.Name}
+	assert.Nil(t, global.DB.Create(&delivery).Error)
+
+	service := DelivererService{}
+	// 不是送货员的账号不能使用工作台
+	_, _, err := service.GetDeliveryTaskList(buyerId, shopReq.DeliveryTaskSearch{})
+	assert.NotNil(t, err)
+
+	list, total, err := service.GetDeliveryTaskList(courierId, shopReq.DeliveryTaskSearch{Status: utils.Pointer(shop.DeliveryStatusWaiting)})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "王女士", list[0].ShipmentName)
+	assert.Equal(t, "幸福路 1 号", list[0].ShipmentAddress)
+
+	// 其他送货员不能查看和操作不属于自己的配送单
+	_, total, err = service.GetDeliveryTaskList(otherId, shopReq.DeliveryTaskSearch{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), total)
+	_, err = service.GetDeliveryTask(otherId, delivery.ID)
+	assert.NotNil(t, err)
+	assert.NotNil(t, service.DeliverDelivery(otherId, delivery.ID))
+
+	task, err := service.GetDeliveryTask(courierId, delivery.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "13700000000", task.ShipmentMobile)
+
+	assert.Nil(t, service.PickUpDelivery(courierId, delivery.ID))
+	assert.NotNil(t, service.PickUpDelivery(courierId, delivery.ID))
+	assert.Nil(t, service.ArriveDelivery(courierId, delivery.ID))
+	assert.Nil(t, service.DeliverDelivery(courierId, delivery.ID))
+	// 重复确认送达不会重复发放积分
+	assert.NotNil(t, service.DeliverDelivery(courierId, delivery.ID))
+
+	global.DB.First(&delivery, delivery.ID)
+	assert.Equal(t, shop.DeliveryStatusDelivered, *delivery.Status)
+	assert.NotNil(t, delivery.PickUpTime)
+	assert.NotNil(t, delivery.ArriveTime)
+	assert.NotNil(t, delivery.ReceiptTime)
+
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, 3, *order.Status)
+	assert.NotNil(t, order.ReceiveTime)
+	assert.Equal(t, 5.0, getTestAccountAmount(buyerId, common.POINT))
+
+	global.DB.First(&courier, courier.ID)
+	assert.Equal(t, 1, *courier.DeliverCount)
+
+	var log shop.OrderLog
+	global.DB.Where("order_id = ? and to_state = ?", order.ID, common.OrderStateReceived).First(&log)
+	assert.Equal(t, common.ActorDeliverer, log.Actor)
+	assert.Equal(t, courierId, log.OperatorId)
+}
+
+// fakeOss 测试用的对象存储 只记录上传和删除的文件
+type fakeOss struct {
+	uploaded []string
+	deleted  []string
+	failName string
+}
+
+func (o *fakeOss) UploadFile(file *multipart.FileHeader) (string, string, error) {
+	if file.Filename == o.failName {
+		return "", "", errors.New("upload failed")
+	}
+	o.uploaded = append(o.uploaded, file.Filename)
+	return "https://oss.example.com/" + file.Filename, file.Filename, nil
+}
+
+func (o *fakeOss) DeleteFile(key string) error {
+	o.deleted = append(o.deleted, key)
+	return nil
+}
+
+func TestDelivererService_UploadDeliveryProof(t *testing.T) {
+	setupOrderTestDB(t)
+	oss := &fakeOss{}
+	newOss = func() upload.OSS { return oss }
+	defer func() { newOss = upload.NewOss }()
+
+	buyerId := createTestUser(t, "proof-buyer")
+	courierId := createTestUser(t, "proof-courier")
+	courier := business.UserDelivery{Name: "张师傅", Mobile: "13800000000", DeliverCount: utils.Pointer(0), Status: utils.Pointer(1), UserId: utils.Pointer(int(courierId))}
+	assert.Nil(t, global.DB.Create(&courier).Error)
+	order := shop.Order{OrderSn: "proof-1", UserId: utils.Pointer(int(buyerId)), Status: utils.Pointer(2), StatusCancel: utils.Pointer(0), StatusRefund: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&order).Error)
+	delivery := shop.OrderDelivery{OrderId: utils.Pointer(int(order.ID)), DeliveryId: utils.Pointer(int(courier.ID))}
+	assert.Nil(t, global.DB.Create(&delivery).Error)
+	orderReturn := shop.OrderReturn{OrderId: utils.Pointer(int(order.ID)), UserId: utils.Pointer(int(buyerId)), Reason: "未收到货"}
+	assert.Nil(t, global.DB.Create(&orderReturn).Error)
+
+	service := DelivererService{}
+	req := shopReq.DeliveryProofReq{ID: delivery.ID, Longitude: utils.Pointer(120.1), Latitude: utils.Pointer(30.2)}
+	photo := &multipart.FileHeader{Filename: "door.jpg"}
+	sign := &multipart.FileHeader{Filename: "sign.png"}
+
+	// 取货前不能上传
+	_, err := service.UploadDeliveryProof(courierId, req, photo, nil)
+	assert.NotNil(t, err)
+	assert.Nil(t, service.PickUpDelivery(courierId, delivery.ID))
+
+	_, err = service.UploadDeliveryProof(courierId, shopReq.DeliveryProofReq{ID: delivery.ID}, photo, nil)
+	assert.NotNil(t, err)
+	_, err = service.UploadDeliveryProof(courierId, req, &multipart.FileHeader{Filename: "door.exe"}, nil)
+	assert.NotNil(t, err)
+
+	// 签名上传失败时删除已上传的照片
+	oss.failName = "bad.png"
+	_, err = service.UploadDeliveryProof(courierId, req, photo, &multipart.FileHeader{Filename: "bad.png"})
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"door.jpg"}, oss.deleted)
+	oss.deleted = nil
+
+	saved, err := service.UploadDeliveryProof(courierId, req, photo, sign)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://oss.example.com/door.jpg", saved.ProofImage)
+	assert.Equal(t, "https://oss.example.com/sign.png", saved.SignImage)
+	assert.Equal(t, 120.1, *saved.ProofLongitude)
+	assert.NotNil(t, saved.ProofTime)
+
+	// 重新上传替换旧的凭证
+	saved, err = service.UploadDeliveryProof(courierId, req, &multipart.FileHeader{Filename: "door2.jpg"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://oss.example.com/door2.jpg", saved.ProofImage)
+	assert.Equal(t, "", saved.SignImage)
+	assert.Equal(t, []string{"door.jpg", "sign.png"}, oss.deleted)
+
+	// 后台修改配送信息不会覆盖送达凭证
+	update := saved
+	update.ProofImage = ""
+	update.DeliverName = "张师傅"
+	assert.Nil(t, (&OrderDeliveryService{}).UpdateOrderDelivery(update, common.OrderActor{Type: common.ActorAdmin}))
+
+	found, err := (&OrderService{}).GetOrder(order.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://oss.example.com/door2.jpg", found.OrderDelivery.ProofImage)
+	assert.Equal(t, "张师傅", found.OrderDelivery.DeliverName)
+
+	returned, err := (&OrderReturnService{}).GetOrderReturn(orderReturn.ID)
+	assert.Nil(t, err)
+	assert.NotNil(t, returned.Delivery)
+	assert.Equal(t, "https://oss.example.com/door2.jpg", returned.Delivery.ProofImage)
+}
@@ -0,0 +1,168 @@
+package shop
+
+import (
+	"errors"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"gorm.io/gorm"
+	"time"
+)
+
+type DeliverySlotService struct {
+}
+
+// CreateDeliverySlot 创建DeliverySlot记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliverySlotService *DeliverySlotService) CreateDeliverySlot(deliverySlot shop.DeliverySlot) (err error) {
+	if err = checkDeliverySlot(deliverySlot); err != nil {
+		return err
+	}
+	deliverySlot.Reserved = 0
+	err = global.DB.Create(&deliverySlot).Error
+	return err
+}
+
+// DeleteDeliverySlot 删除DeliverySlot记录 已有预约订单的时段不能删除
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliverySlotService *DeliverySlotService) DeleteDeliverySlot(deliverySlot shop.DeliverySlot) (err error) {
+	return deliverySlotService.DeleteDeliverySlotByIds(request.IdsReq{Ids: []int{int(deliverySlot.ID)}})
+}
+
+// DeleteDeliverySlotByIds 批量删除DeliverySlot记录 已有预约订单的时段不能删除
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliverySlotService *DeliverySlotService) DeleteDeliverySlotByIds(ids request.IdsReq) (err error) {
+	var reserved int64
+	if err = global.DB.Model(&shop.DeliverySlot{}).Where("id in ? and reserved > 0", ids.Ids).Count(&reserved).Error; err != nil {
+		return err
+	}
+	if reserved > 0 {
+		return errors.New("配送时段已有预约订单，不能删除，可以禁用该时段")
+	}
+	err = global.DB.Delete(&[]shop.DeliverySlot{}, "id in ?", ids.Ids).Error
+	return err
+}
+
+// UpdateDeliverySlot 更新DeliverySlot记录 已预约订单数只能通过下单和取消订单修改
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliverySlotService *DeliverySlotService) UpdateDeliverySlot(deliverySlot shop.DeliverySlot) (err error) {
+	if err = checkDeliverySlot(deliverySlot); err != nil {
+		return err
+	}
+	err = global.DB.Omit("reserved").Save(&deliverySlot).Error
+	return err
+}
+
+// GetDeliverySlot 根据id获取DeliverySlot记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliverySlotService *DeliverySlotService) GetDeliverySlot(id uint) (deliverySlot shop.DeliverySlot, err error) {
+	err = global.DB.Where("id = ?", id).First(&deliverySlot).Error
+	return
+}
+
+// GetDeliverySlotInfoList 分页获取DeliverySlot记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliverySlotService *DeliverySlotService) GetDeliverySlotInfoList(info shopReq.DeliverySlotSearch) (list []shop.DeliverySlot, total int64, err error) {
+	limit := info.PageSize
+	offset := info.PageSize * (info.Page - 1)
+	// 创建db
+	db := global.DB.Model(&shop.DeliverySlot{})
+	var deliverySlots []shop.DeliverySlot
+	// 如果有条件搜索 下方会自动创建搜索语句
+	if info.StartCreatedAt != nil && info.EndCreatedAt != nil {
+		db = db.Where("created_at BETWEEN ? AND ?", info.StartCreatedAt, info.EndCreatedAt)
+	}
+	if info.StartDay != "" && info.EndDay != "" {
+		db = db.Where("day BETWEEN ? AND ?", info.StartDay, info.EndDay)
+	}
+	if info.Day != "" {
+		db = db.Where("day = ?", info.Day)
+	}
+	if info.Status != nil {
+		db = db.Where("status = ?", info.Status)
+	}
+	err = db.Count(&total).Error
+	if err != nil {
+		return
+	}
+
+	err = db.Order("day asc, start_time asc").Limit(limit).Offset(offset).Find(&deliverySlots).Error
+	return deliverySlots, total, err
+}
+
+// GetAvailableDeliverySlots 获取用户下单时可以选择的配送时段 未截止下单且未约满
+// day 为空时返回今天及以后的所有时段
+func (deliverySlotService *DeliverySlotService) GetAvailableDeliverySlots(day string) (list []shop.DeliverySlot, err error) {
+	now := time.Now()
+	db := global.DB.Where("status = 1 and reserved < capacity")
+	if day != "" {
+		db = db.Where("day = ?", day)
+	} else {
+		db = db.Where("day >= ?", now.Format("2006-01-02"))
+	}
+	var slots []shop.DeliverySlot
+	if err = db.Order("day asc, start_time asc").Find(&slots).Error; err != nil {
+		return
+	}
+	list = make([]shop.DeliverySlot, 0, len(slots))
+	for _, s := range slots {
+		if cutoff, err := s.Cutoff(); err == nil && now.Before(cutoff) {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+// checkDeliverySlot 校验配送时段的日期、时间和最大订单数
+func checkDeliverySlot(slot shop.DeliverySlot) error {
+	start, end, err := slot.Window()
+	if err != nil {
+		return errors.New("配送日期或时间格式错误")
+	}
+	if !end.After(start) {
+		return errors.New("结束时间必须晚于开始时间")
+	}
+	if slot.Capacity == nil || *slot.Capacity <= 0 {
+		return errors.New("最大订单数必须大于 0")
+	}
+	if slot.CutoffTime != nil && slot.CutoffTime.After(end) {
+		return errors.New("截止下单时间不能晚于结束时间")
+	}
+	return nil
+}
+
+// reserveDeliverySlot 下单时预约配送时段，必须在事务中调用
+// 以已预约订单数小于最大订单数为条件自增，并发下单时不会超出最大订单数
+func reserveDeliverySlot(tx *gorm.DB, slotId uint) error {
+	var slot shop.DeliverySlot
+	if errors.Is(tx.Where("id = ? and status = 1", slotId).First(&slot).Error, gorm.ErrRecordNotFound) {
+		return errors.New("配送时段不存在")
+	}
+	cutoff, err := slot.Cutoff()
+	if err != nil {
+		global.SugarLog.Errorf("配送时段配置错误 slotId:%d, err:%v \n", slotId, err)
+		return errors.New("配送时段不可用")
+	}
+	if !time.Now().Before(cutoff) {
+		return errors.New("该配送时段已截止下单，请选择其他时段")
+	}
+	result := tx.Model(&shop.DeliverySlot{}).Where("id = ? and reserved < capacity", slotId).
+		Update("reserved", gorm.Expr("reserved + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("该配送时段已约满，请选择其他时段")
+	}
+	return nil
+}
+
+// releaseDeliverySlot 取消订单时释放预约的配送时段，必须在事务中调用
+func releaseDeliverySlot(tx *gorm.DB, slotId uint) error {
+	if slotId == 0 {
+		return nil
+	}
+	return tx.Model(&shop.DeliverySlot{}).Where("id = ? and reserved > 0", slotId).
+		Update("reserved", gorm.Expr("reserved - 1")).Error
+}
@@ -0,0 +1,84 @@
+package shop
+
+import (
+	"testing"
+	"time"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	systemReq "fresh-shop/server/model/system/request"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderService_CreateOrder_DeliverySlot(t *testing.T) {
+	setupOrderTestDB(t)
+	goods := shop.Goods{
+		Name:      "冷冻带鱼",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(20.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(500),
+		Store:     utils.Pointer(10),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	slotService := DeliverySlotService{}
+	assert.NotNil(t, slotService.CreateDeliverySlot(shop.DeliverySlot{Day: tomorrow, StartTime: "11:00", EndTime: "09:00", Capacity: utils.Pointer(1)}))
+	assert.Nil(t, slotService.CreateDeliverySlot(shop.DeliverySlot{Day: tomorrow, StartTime: "09:00", EndTime: "11:00", Capacity: utils.Pointer(1)}))
+	// 已截止下单的时段
+	assert.Nil(t, slotService.CreateDeliverySlot(shop.DeliverySlot{Day: tomorrow, StartTime: "14:00", EndTime: "16:00", Capacity: utils.Pointer(5), CutoffTime: utils.Pointer(time.Now().Add(-time.Minute))}))
+	var slots []shop.DeliverySlot
+	global.DB.Order("id").Find(&slots)
+
+	available, err := slotService.GetAvailableDeliverySlots(tomorrow)
+	assert.Nil(t, err)
+	assert.Len(t, available, 1)
+
+	service := OrderService{}
+	createOrder := func(name string, slotId uint) (*shop.Order, error) {
+		userId := createTestUser(t, name)
+		cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 1, Checked: utils.Pointer(1)}
+		assert.Nil(t, global.DB.Create(&cart).Error)
+		claims := &systemReq.CustomClaims{BaseClaims: systemReq.BaseClaims{ID: userId, Username: name}}
+		resp, err := service.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(0), DeliverySlotId: slotId}, claims, "127.0.0.1")
+		if err != nil {
+			return nil, err
+		}
+		return &resp.Order, nil
+	}
+
+	_, err = createOrder("slot-late", slots[1].ID)
+	assert.EqualError(t, err, "该配送时段已截止下单，请选择其他时段")
+
+	first, err := createOrder("slot-a", slots[0].ID)
+	assert.Nil(t, err)
+	_, err = createOrder("slot-b", slots[0].ID)
+	assert.EqualError(t, err, "该配送时段已约满，请选择其他时段")
+	// 预约失败不会扣减库存
+	var dbGoods shop.Goods
+	global.DB.First(&dbGoods, goods.ID)
+	assert.Equal(t, 9, *dbGoods.Store)
+
+	// 取消订单释放时段
+	assert.Nil(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: first.ID}}, uint(*first.UserId), "slot-a"))
+	var slot shop.DeliverySlot
+	global.DB.First(&slot, slots[0].ID)
+	assert.Equal(t, 0, slot.Reserved)
+
+	// 发货时复制预约的配送时段
+	second, err := createOrder("slot-c", slots[0].ID)
+	assert.Nil(t, err)
+	global.DB.Model(&shop.Order{}).Where("id = ?", second.ID).Update("status", 1)
+	err = (&OrderDeliveryService{}).CreateOrderDelivery(shop.OrderDelivery{OrderId: utils.Pointer(int(second.ID)), DeliverName: "张三"}, common.OrderActor{Type: common.ActorAdmin})
+	assert.Nil(t, err)
+	var delivery shop.OrderDelivery
+	global.DB.Where("order_id = ?", second.ID).First(&delivery)
+	start, end, _ := slot.Window()
+	assert.True(t, delivery.SlotStartTime.Equal(start))
+	assert.True(t, delivery.SlotEndTime.Equal(end))
+	assert.True(t, delivery.ScheduledTime.Equal(end))
+}
@@ -0,0 +1,184 @@
+package shop
+
+import (
+	"errors"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	shopResp "fresh-shop/server/model/shop/response"
+	"fresh-shop/server/service/common"
+	"strconv"
+	"strings"
+)
+
+type DeliveryZoneService struct {
+}
+
+// CreateDeliveryZone 创建DeliveryZone记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliveryZoneService *DeliveryZoneService) CreateDeliveryZone(deliveryZone shop.DeliveryZone) (err error) {
+	if err = checkDeliveryZone(deliveryZone); err != nil {
+		return err
+	}
+	err = global.DB.Create(&deliveryZone).Error
+	return err
+}
+
+// DeleteDeliveryZone 删除DeliveryZone记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliveryZoneService *DeliveryZoneService) DeleteDeliveryZone(deliveryZone shop.DeliveryZone) (err error) {
+	err = global.DB.Delete(&deliveryZone).Error
+	return err
+}
+
+// DeleteDeliveryZoneByIds 批量删除DeliveryZone记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliveryZoneService *DeliveryZoneService) DeleteDeliveryZoneByIds(ids request.IdsReq) (err error) {
+	err = global.DB.Delete(&[]shop.DeliveryZone{}, "id in ?", ids.Ids).Error
+	return err
+}
+
+// UpdateDeliveryZone 更新DeliveryZone记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliveryZoneService *DeliveryZoneService) UpdateDeliveryZone(deliveryZone shop.DeliveryZone) (err error) {
+	if err = checkDeliveryZone(deliveryZone); err != nil {
+		return err
+	}
+	err = global.DB.Save(&deliveryZone).Error
+	return err
+}
+
+// GetDeliveryZone 根据id获取DeliveryZone记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliveryZoneService *DeliveryZoneService) GetDeliveryZone(id uint) (deliveryZone shop.DeliveryZone, err error) {
+	err = global.DB.Where("id = ?", id).First(&deliveryZone).Error
+	return
+}
+
+// GetDeliveryZoneInfoList 分页获取DeliveryZone记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (deliveryZoneService *DeliveryZoneService) GetDeliveryZoneInfoList(info shopReq.DeliveryZoneSearch) (list []shop.DeliveryZone, total int64, err error) {
+	limit := info.PageSize
+	offset := info.PageSize * (info.Page - 1)
+	// 创建db
+	db := global.DB.Model(&shop.DeliveryZone{})
+	var deliveryZones []shop.DeliveryZone
+	// 如果有条件搜索 下方会自动创建搜索语句
+	if info.StartCreatedAt != nil && info.EndCreatedAt != nil {
+		db = db.Where("created_at BETWEEN ? AND ?", info.StartCreatedAt, info.EndCreatedAt)
+	}
+	if info.Name != "" {
+		db = db.Where("name LIKE ?", "%"+info.Name+"%")
+	}
+	if info.Type != nil {
+		db = db.Where("type = ?", info.Type)
+	}
+	if info.Status != nil {
+		db = db.Where("status = ?", info.Status)
+	}
+	err = db.Count(&total).Error
+	if err != nil {
+		return
+	}
+
+	err = db.Order("sort asc, id asc").Limit(limit).Offset(offset).Find(&deliveryZones).Error
+	return deliveryZones, total, err
+}
+
+// CheckAddress 校验收货地址是否在配送范围内，地址表单保存前和配送订单下单时调用
+// 没有启用的配送区域时不限制配送范围
+func (deliveryZoneService *DeliveryZoneService) CheckAddress(address shop.UserAddress) (resp shopResp.DeliveryZoneCheckResp, err error) {
+	var zones []shop.DeliveryZone
+	if err = global.DB.Where("status = 1").Order("sort asc, id asc").Find(&zones).Error; err != nil {
+		global.SugarLog.Errorf("查询配送区域失败, err:%v \n", err)
+		return
+	}
+	if len(zones) == 0 {
+		resp.Deliverable = true
+		return
+	}
+	if address.Longitude == nil || address.Latitude == nil || (*address.Longitude == 0 && *address.Latitude == 0) {
+		resp.Message = "收货地址缺少定位信息，请重新选择地址"
+		return
+	}
+	for _, z := range zones {
+		if inDeliveryZone(z, *address.Longitude, *address.Latitude) {
+			resp.Deliverable = true
+			resp.ZoneId = z.ID
+			resp.ZoneName = z.Name
+			return
+		}
+	}
+	resp.Message = "收货地址不在配送范围内"
+	return
+}
+
+// checkDeliveryZone 校验配送区域的半径或多边形顶点
+func checkDeliveryZone(zone shop.DeliveryZone) error {
+	if zone.Type != nil && *zone.Type == shop.DeliveryZonePolygon {
+		if len(parsePolygon(zone.Polygon)) < 3 {
+			return errors.New("多边形至少需要 3 个有效的顶点，格式为 经度,纬度;经度,纬度")
+		}
+		return nil
+	}
+	if zone.Radius == nil || *zone.Radius <= 0 {
+		return errors.New("配送半径必须大于 0")
+	}
+	return nil
+}
+
+// inDeliveryZone 判断经纬度是否在配送区域内
+func inDeliveryZone(zone shop.DeliveryZone, lng, lat float64) bool {
+	if zone.Type != nil && *zone.Type == shop.DeliveryZonePolygon {
+		return pointInPolygon(parsePolygon(zone.Polygon), lng, lat)
+	}
+	if zone.Radius == nil {
+		return false
+	}
+	centerLng, centerLat := 0.0, 0.0
+	if zone.CenterLongitude != nil && zone.CenterLatitude != nil {
+		centerLng, centerLat = *zone.CenterLongitude, *zone.CenterLatitude
+	}
+	if centerLng == 0 && centerLat == 0 {
+		var ok bool
+		if centerLng, centerLat, ok = common.GetShopLocation(); !ok {
+			global.SugarLog.Errorf("配送区域未设置中心点且未配置店铺位置 zoneId:%d \n", zone.ID)
+			return false
+		}
+	}
+	return sphereDistance(centerLat, centerLng, lat, lng) <= *zone.Radius
+}
+
+// parsePolygon 解析多边形顶点 格式为 经度,纬度;经度,纬度 无效的顶点会被忽略
+func parsePolygon(polygon string) (points [][2]float64) {
+	for _, p := range strings.Split(polygon, ";") {
+		parts := strings.Split(strings.TrimSpace(p), ",")
+		if len(parts) != 2 {
+			continue
+		}
+		lng, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lat, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		points = append(points, [2]float64{lng, lat})
+	}
+	return
+}
+
+// pointInPolygon 射线法判断点是否在多边形内 配送范围较小，直接按平面坐标计算
+func pointInPolygon(points [][2]float64, lng, lat float64) bool {
+	if len(points) < 3 {
+		return false
+	}
+	inside := false
+	for i, j := 0, len(points)-1; i < len(points); j, i = i, i+1 {
+		xi, yi := points[i][0], points[i][1]
+		xj, yj := points[j][0], points[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,84 @@
+package shop
+
+import (
+	"testing"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	"fresh-shop/server/model/system"
+	systemReq "fresh-shop/server/model/system/request"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryZoneService_CheckAddress(t *testing.T) {
+	setupOrderTestDB(t)
+	service := DeliveryZoneService{}
+	address := func(lng, lat float64) shop.UserAddress {
+		return shop.UserAddress{Longitude: utils.Pointer(lng), Latitude: utils.Pointer(lat)}
+	}
+
+	// 没有配送区域时不限制
+	resp, err := service.CheckAddress(shop.UserAddress{})
+	assert.Nil(t, err)
+	assert.True(t, resp.Deliverable)
+
+	global.DB.Create(&system.SysConfig{Name: "shopLocation", Value: "116.397428,39.90923", Status: utils.Pointer(1)})
+	assert.NotNil(t, service.CreateDeliveryZone(shop.DeliveryZone{Name: "无半径", Type: utils.Pointer(shop.DeliveryZoneRadius)}))
+	assert.Nil(t, service.CreateDeliveryZone(shop.DeliveryZone{Name: "门店 5 公里", Type: utils.Pointer(shop.DeliveryZoneRadius), Radius: utils.Pointer(5.0)}))
+	assert.NotNil(t, service.CreateDeliveryZone(shop.DeliveryZone{Name: "两点", Type: utils.Pointer(shop.DeliveryZonePolygon), Polygon: "116.5,39.9;116.6,39.9"}))
+	// 东边的一块矩形区域
+	assert.Nil(t, service.CreateDeliveryZone(shop.DeliveryZone{Name: "通州", Type: utils.Pointer(shop.DeliveryZonePolygon), Polygon: "116.6,39.8; 116.8,39.8; 116.8,40.0; 116.6,40.0"}))
+
+	resp, _ = service.CheckAddress(address(116.41, 39.92))
+	assert.True(t, resp.Deliverable)
+	assert.Equal(t, "门店 5 公里", resp.ZoneName)
+
+	resp, _ = service.CheckAddress(address(116.7, 39.9))
+	assert.True(t, resp.Deliverable)
+	assert.Equal(t, "通州", resp.ZoneName)
+
+	resp, _ = service.CheckAddress(address(116.5, 39.9))
+	assert.False(t, resp.Deliverable)
+	assert.Equal(t, "收货地址不在配送范围内", resp.Message)
+
+	resp, _ = service.CheckAddress(shop.UserAddress{})
+	assert.False(t, resp.Deliverable)
+}
+
+func TestOrderService_CreateOrder_DeliveryZone(t *testing.T) {
+	setupOrderTestDB(t)
+	assert.Nil(t, (&DeliveryZoneService{}).CreateDeliveryZone(shop.DeliveryZone{
+		Name: "配送区域", Type: utils.Pointer(shop.DeliveryZonePolygon), Polygon: "116.6,39.8;116.8,39.8;116.8,40.0;116.6,40.0",
+	}))
+	goods := shop.Goods{
+		Name:      "冷冻带鱼",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(20.0),
+		Price:     utils.Pointer(0.0),
+		Store:     utils.Pointer(10),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	userId := createTestUser(t, "zone-buyer")
+	cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 1, Checked: utils.Pointer(1)}
+	assert.Nil(t, global.DB.Create(&cart).Error)
+	far := shop.UserAddress{UserId: utils.Pointer(int(userId)), Longitude: utils.Pointer(116.3), Latitude: utils.Pointer(39.9)}
+	assert.Nil(t, global.DB.Create(&far).Error)
+
+	service := OrderService{}
+	order := shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(0), AddressId: int(far.ID)}
+	preview, err := service.PreviewOrder(order)
+	assert.Nil(t, err)
+	assert.False(t, preview.Available)
+	assert.Equal(t, "收货地址不在配送范围内", preview.Message)
+
+	claims := &systemReq.CustomClaims{BaseClaims: systemReq.BaseClaims{ID: userId, Username: "zone-buyer"}}
+	_, err = service.CreateOrder(order, claims, "127.0.0.1")
+	assert.EqualError(t, err, "收货地址不在配送范围内")
+
+	// 自提订单不校验配送范围
+	order.ShipmentType = utils.Pointer(1)
+	_, err = service.CreateOrder(order, claims, "127.0.0.1")
+	assert.Nil(t, err)
+}
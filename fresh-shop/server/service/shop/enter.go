@@ -13,4 +13,10 @@ type ServiceGroup struct {
 	FavoritesService
 	CartService
 	UserAddressService
+	PostageRuleService
+	DeliverySlotService
+	DeliveryZoneService
+	DelivererService
+	PayBillService
+	StockLogService
 }
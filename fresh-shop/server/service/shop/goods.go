@@ -8,6 +8,7 @@ import (
 	"fresh-shop/server/model/common/request"
 	"fresh-shop/server/model/shop"
 	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/common"
 	"fresh-shop/server/utils"
 	"fresh-shop/server/utils/upload"
 	"github.com/xuri/excelize/v2"
@@ -205,9 +206,9 @@ func joinCellIndex(rowName string, colIndex int) string {
 	return fmt.Sprintf("%s%d", excelGoods[rowName], colIndex)
 }
 
-// BatchCreateGoodsByExcel 批量导入商品信息
+// BatchCreateGoodsByExcel 批量导入商品信息 导入的库存与原库存不同时记录库存流水
 // Author [dalefeng](https://github.com/dalefeng)
-func (goodsService *GoodsService) BatchCreateGoodsByExcel(header *multipart.FileHeader) (err error) {
+func (goodsService *GoodsService) BatchCreateGoodsByExcel(header *multipart.FileHeader, actor common.OrderActor) (err error) {
 	oss := upload.NewOss()
 	filePath, _, err := oss.UploadFile(header)
 	if err != nil {
@@ -235,6 +236,7 @@ func (goodsService *GoodsService) BatchCreateGoodsByExcel(header *multipart.File
 		global.SugarLog.Errorf("未在 Excel 表中查询到记录, len(rows) = %d", len(rows))
 		return errors.New("未在 Excel 表中查询到记录")
 	}
+	stock := stockRef{Reason: shop.StockReasonImport, RefId: header.Filename, Actor: actor}
 	txDB := global.DB.Begin()
 	for key, row := range rows {
 		rowIndex := key + 1
@@ -404,14 +406,17 @@ func (goodsService *GoodsService) BatchCreateGoodsByExcel(header *multipart.File
 			IsNew:      utils.Pointer(isNewInt),
 		}
 
+		beforeStock := stockItem{}
 		if isChange == "1" {
+			// 在事务中锁定读取覆盖前的库存
 			var goodsInfo shop.Goods
-			err = global.DB.Where("id = ?", goodsIdInt).First(&goodsInfo).Error
+			err = lockStock(txDB).Where("id = ?", goodsIdInt).First(&goodsInfo).Error
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				txDB.Callback()
+				txDB.Rollback()
 				global.SugarLog.Errorf(log+"商品不存在 goodsId: %v, err:%v", goodsId, err)
 				return errors.New(log + "商品不存在，goodsId" + goodsId)
 			} else if err != nil {
+				txDB.Rollback()
 				global.SugarLog.Errorf(log+"查找商品失败 goodsId: %v, err:%v", goodsId, err)
 				return errors.New(log + "查找商品失败，goodsId" + goodsId)
 			}
@@ -422,6 +427,9 @@ func (goodsService *GoodsService) BatchCreateGoodsByExcel(header *multipart.File
 			goods.ID = uint(goodsIdInt)
 			goods.CreatedAt = goodsInfo.CreatedAt
 			goods.DeletedAt = goodsInfo.DeletedAt
+			if goodsInfo.Store != nil {
+				beforeStock.Store = *goodsInfo.Store
+			}
 			if err := txDB.Save(&goods).Error; err != nil {
 				txDB.Callback()
 				global.SugarLog.Errorf(log+"更新商品信息失败 goods: %v, err:%v", goods, err)
@@ -434,6 +442,12 @@ func (goodsService *GoodsService) BatchCreateGoodsByExcel(header *multipart.File
 				return errors.New(log + "创建商品信息失败")
 			}
 		}
+		beforeStock.GoodsId = goods.ID
+		if err := recordStockUpdate(txDB, beforeStock, stock); err != nil {
+			txDB.Rollback()
+			global.SugarLog.Errorf(log+"记录库存流水失败 goodsId: %d, err:%v", goods.ID, err)
+			return errors.New(log + "记录库存流水失败")
+		}
 
 		// 商品详情
 		goodsDetails := shop.GoodsDescription{
@@ -549,9 +563,9 @@ func paramCheckEmpty(rowIndex int, name, categoryName, constPrice, uint, weight,
 	return nil
 }
 
-// CreateGoods 创建Goods记录
+// CreateGoods 创建Goods记录 商品和规格明细的初始库存记录库存流水
 // Author [dalefeng](https://github.com/dalefeng)
-func (goodsService *GoodsService) CreateGoods(form shopReq.GoodsSubmitFrom) (err error) {
+func (goodsService *GoodsService) CreateGoods(form shopReq.GoodsSubmitFrom, actor common.OrderActor) (err error) {
 	log := "创建商品 --- "
 
 	goods := form.GoodsInfo
@@ -572,6 +586,12 @@ func (goodsService *GoodsService) CreateGoods(form shopReq.GoodsSubmitFrom) (err
 		global.SugarLog.Errorf(log+" 创建商品信息失败 goodsInfo: %#v, err: %s", goods, err.Error())
 		return errors.New("创建商品信息失败")
 	}
+	stock := stockRef{Reason: shop.StockReasonManual, Actor: actor, Remark: "创建商品"}
+	if err := recordStockUpdate(tx, stockItem{GoodsId: goods.ID}, stock); err != nil {
+		tx.Rollback()
+		global.SugarLog.Errorf(log+" 记录库存流水失败 goodsId: %d, err: %s", goods.ID, err.Error())
+		return errors.New("记录库存流水失败")
+	}
 	goodsIdPointr := utils.Pointer(int(goods.ID))
 	// 创建商品详情信息
 	goodsDesc.GoodsId = goodsIdPointr
@@ -683,6 +703,13 @@ func (goodsService *GoodsService) CreateGoods(form shopReq.GoodsSubmitFrom) (err
 			global.SugarLog.Errorf(log+"specValue: %#v, err: %s", specValue, err.Error())
 			return errors.New("创建商品性规格明细失败")
 		}
+		for _, v := range specValue {
+			if err := recordStockUpdate(tx, stockItem{GoodsId: goods.ID, SpecValueId: int(v.ID)}, stock); err != nil {
+				tx.Rollback()
+				global.SugarLog.Errorf(log+"记录规格明细库存流水失败 specValueId: %d, err: %s", v.ID, err.Error())
+				return errors.New("记录库存流水失败")
+			}
+		}
 	}
 
 	// 提交事务
@@ -690,9 +717,9 @@ func (goodsService *GoodsService) CreateGoods(form shopReq.GoodsSubmitFrom) (err
 	return nil
 }
 
-// UpdateGoods 更新Goods记录
+// UpdateGoods 更新Goods记录 商品和规格明细的库存有变化时记录库存流水
 // Author [dalefeng](https://github.com/dalefeng)
-func (goodsService *GoodsService) UpdateGoods(form shopReq.GoodsSubmitFrom) (err error) {
+func (goodsService *GoodsService) UpdateGoods(form shopReq.GoodsSubmitFrom, actor common.OrderActor) (err error) {
 	log := "更新商品 --- "
 
 	goods := form.GoodsInfo
@@ -816,12 +843,24 @@ func (goodsService *GoodsService) UpdateGoods(form shopReq.GoodsSubmitFrom) (err
 	// 开始事务
 	tx := global.DB.Begin()
 
-	// 更新商品基本信息
+	// 更新商品基本信息 保存前锁定读取库存用于记录库存流水
+	stock := stockRef{Reason: shop.StockReasonManual, Actor: actor, Remark: "编辑商品"}
+	beforeStock, err := getStockItem(lockStock(tx), goods.ID, 0)
+	if err != nil {
+		tx.Rollback()
+		global.SugarLog.Errorf(log+" 查询商品库存失败 goodsId: %d, err: %s", goods.ID, err.Error())
+		return errors.New("更新商品信息失败")
+	}
 	if err := tx.Save(&goods).Error; err != nil {
 		tx.Rollback()
 		global.SugarLog.Errorf(log+" 更新商品信息失败 goodsInfo: %#v, err: %s", goods, err.Error())
 		return errors.New("更新商品信息失败")
 	}
+	if err := recordStockUpdate(tx, beforeStock, stock); err != nil {
+		tx.Rollback()
+		global.SugarLog.Errorf(log+" 记录库存流水失败 goodsId: %d, err: %s", goods.ID, err.Error())
+		return errors.New("记录库存流水失败")
+	}
 	goodsIdPointr := utils.Pointer(int(goods.ID))
 
 	// 更新商品详情信息
@@ -999,12 +1038,33 @@ func (goodsService *GoodsService) UpdateGoods(form shopReq.GoodsSubmitFrom) (err
 
 		// region 处理规格项明细
 		if len(deleteValueId) > 0 { // 删除
+			var deleteStock []stockItem
+			for _, id := range deleteValueId {
+				item, err := getSpecValueStock(lockStock(tx), goods.ID, id)
+				if err != nil {
+					tx.Rollback()
+					global.SugarLog.Errorf(log+"查询规格明细库存失败  itemIds: %s, err: %s", id, err.Error())
+					return errors.New("处理规格明细失败")
+				}
+				item.GoodsName = goods.Name
+				deleteStock = append(deleteStock, item)
+			}
 			err := tx.Where("item_ids in ?", deleteValueId).Delete(&shop.GoodsSpecValue{}).Error
 			if err != nil {
 				tx.Rollback()
 				global.SugarLog.Errorf(log+"删除规格明细失败  deleteValueId: %#v, err: %s", deleteValueId, err.Error())
 				return errors.New("处理规格明细失败")
 			}
+			// 删除的规格明细库存清零
+			deleteRef := stock
+			deleteRef.Remark = "删除规格"
+			for _, item := range deleteStock {
+				if err := addStockLog(tx, item, item.Store, 0, deleteRef); err != nil {
+					tx.Rollback()
+					global.SugarLog.Errorf(log+"记录规格明细库存流水失败  specValueId: %d, err: %s", item.SpecValueId, err.Error())
+					return errors.New("记录库存流水失败")
+				}
+			}
 		}
 		if len(createValueId) > 0 {
 			for _, id := range createValueId {
@@ -1058,6 +1118,13 @@ func (goodsService *GoodsService) UpdateGoods(form shopReq.GoodsSubmitFrom) (err
 				global.SugarLog.Errorf(log+"创建 -- createValue: %#v, err: %s", createValue, err.Error())
 				return errors.New("更新商品性规格明细失败")
 			}
+			for _, v := range createValue {
+				if err := recordStockUpdate(tx, stockItem{GoodsId: goods.ID, SpecValueId: int(v.ID)}, stock); err != nil {
+					tx.Rollback()
+					global.SugarLog.Errorf(log+"创建 -- 记录规格明细库存流水失败 specValueId: %d, err: %s", v.ID, err.Error())
+					return errors.New("记录库存流水失败")
+				}
+			}
 		}
 
 		if len(unionValueId) > 0 {
@@ -1108,12 +1175,23 @@ func (goodsService *GoodsService) UpdateGoods(form shopReq.GoodsSubmitFrom) (err
 			}
 
 			for _, u := range unionValue {
-				err := tx.Model(&shop.GoodsSpecValue{}).Where("item_ids = ?", u.ItemIds).Updates(u).Error
+				before, err := getSpecValueStock(lockStock(tx), goods.ID, u.ItemIds)
+				if err != nil {
+					tx.Rollback()
+					global.SugarLog.Errorf(log+"更新 -- 查询规格明细库存失败 itemIds: %s, err: %s", u.ItemIds, err.Error())
+					return errors.New("更新商品性规格明细失败")
+				}
+				err = tx.Model(&shop.GoodsSpecValue{}).Where("item_ids = ?", u.ItemIds).Updates(u).Error
 				if err != nil {
 					tx.Rollback()
 					global.SugarLog.Errorf(log+"更新 -- unionValue: %#v, err: %s", unionValue, err.Error())
 					return errors.New("更新商品性规格明细失败")
 				}
+				if err = recordStockUpdate(tx, before, stock); err != nil {
+					tx.Rollback()
+					global.SugarLog.Errorf(log+"更新 -- 记录规格明细库存流水失败 specValueId: %d, err: %s", before.SpecValueId, err.Error())
+					return errors.New("记录库存流水失败")
+				}
 			}
 
 		}
@@ -1213,16 +1291,12 @@ func (goodsService *GoodsService) GetGoods(id, userId uint) (goods shop.Goods, e
 	} else {
 		goods.IsFavorite = true
 	}
-	var cart shop.Cart   // 当前商品购物车中存在的数量
+	var cartNum int      // 当前商品购物车中存在的数量 多规格商品为所有规格数量之和
 	var cartTotalNum int // 当前用户购物车总存在的数量
 	// 查询当前用户和当前商品购物车数量 直接查询 num 字段
 	global.DB.Model(shop.Cart{}).Where("user_id = ?", userId).Pluck("SUM(num) as cartNum", &cartTotalNum)
-	cartErr := global.DB.Model(shop.Cart{}).Where("user_id = ? and goods_id = ?", userId, id).First(&cart).Error
-	if cartErr != nil {
-		goods.CartNum = utils.Pointer(0)
-	} else {
-		goods.CartNum = &cart.Num
-	}
+	global.DB.Model(shop.Cart{}).Where("user_id = ? and goods_id = ?", userId, id).Pluck("COALESCE(SUM(num), 0) as cartNum", &cartNum)
+	goods.CartNum = &cartNum
 	goods.CartTotalNum = &cartTotalNum
 	return
 }
@@ -1,6 +1,7 @@
 package shop
 
 import (
+	"fresh-shop/server/service/common"
 	"mime/multipart"
 	"testing"
 )
@@ -8,7 +9,7 @@ import (
 func TestGoodsService_BatchCreateGoodsByExcel(t *testing.T) {
 	g := GoodsService{}
 	h := multipart.FileHeader{}
-	err := g.BatchCreateGoodsByExcel(&h)
+	err := g.BatchCreateGoodsByExcel(&h, common.OrderActor{Type: common.ActorAdmin})
 	if err != nil {
 		panic(err)
 	}
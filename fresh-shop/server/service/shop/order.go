@@ -12,18 +12,22 @@ import (
 	systemReq "fresh-shop/server/model/system/request"
 	"fresh-shop/server/model/wechat/response"
 	"fresh-shop/server/service/common"
-	"fresh-shop/server/service/wechat"
+	"fresh-shop/server/service/payment"
 	"fresh-shop/server/utils"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
-	"strconv"
-	"strings"
 	"time"
 )
 
 type OrderService struct {
 }
 
+// orderStateColumns 订单状态及状态变更时写入的字段，只能由状态机修改
+var orderStateColumns = []string{
+	"status", "status_cancel", "status_refund", "pay_time", "shipment_time", "receive_time",
+	"cancel_time", "cancel_by", "cancel_reason", "refund_sn", "refund_time", "finish", "payment",
+}
+
 // CreateOrder 创建Order记录
 // Author [dalefeng](https://github.com/dalefeng)
 func (orderService *OrderService) CreateOrder(order shop.Order, userClaims *systemReq.CustomClaims, clientIP string) (resp *response.CreateOrderResp, err error) {
@@ -34,134 +38,34 @@ func (orderService *OrderService) CreateOrder(order shop.Order, userClaims *syst
 	}
 
 	// 获取收货地址信息
-	address := shop.UserAddress{}
+	address, err := loadOrderAddress(order)
+	if err != nil {
+		return nil, err
+	}
 	addressName := ""
 	if order.AddressId > 0 {
-		if errors.Is(global.DB.Where("id = ?", order.AddressId).First(&address).Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("收货地址不存在")
-		}
-		if *address.Sex == 1 {
+		if address.Sex != nil && *address.Sex == 1 {
 			addressName = addressName + "先生"
 		} else {
 			addressName = addressName + "女士"
 		}
 	}
-	var cartList []shop.Cart
-	var orderDetailList []shop.OrderDetails
-
-	if order.PointGoodsId != 0 { // 积分商品
-		var goodsInfo shop.Goods
-		if err := global.DB.Where("id = ? and goods_area = 1", order.PointGoodsId).Preload("Images").First(&goodsInfo).Error; err != nil {
-			global.SugarLog.Errorf("创建订单时查询积分商品信息异常, err:%v \n", err)
-			return nil, errors.New("商品查询失败")
-		}
-		c := shop.Cart{
-			GoodsId:    utils.Pointer(order.PointGoodsId),
-			UserId:     order.UserId,
-			SpecType:   0,
-			SpecItemId: 0,
-			Num:        1,
-			Checked:    utils.Pointer(1),
-			Goods:      goodsInfo,
-		}
-		cartList = append(cartList, c)
-
-	} else { // 普通商品
-		// 获取购物车已选中的商品数据
-		global.DB.Where("user_id = ? and checked = 1", order.UserId).Preload("Goods.Images").Find(&cartList)
-		if len(cartList) <= 0 {
-			global.SugarLog.Errorf("创建订单时查询商品信息异常, err:%v \n", err)
-			return nil, errors.New("商品查询失败")
-		}
+	cartList, err := loadOrderCarts(order)
+	if err != nil {
+		return nil, err
 	}
-	pointCfg, err := common.GetSysConfig("point")
-	pointSwitch := true
+	// 计算订单金额 预先判断库存是否充足，最终以事务中的条件扣减为准
+	pricing, err := priceOrder(&order, cartList, address)
 	if err != nil {
-		if errors.Is(err, common.ErrConfigDisabled) {
-			pointSwitch = false
-		} else {
-			global.SugarLog.Errorf("创建订单时查询积分配置参数异常, err:%v \n", err)
-			return
-		}
+		return nil, err
 	}
-	// 获取取餐号码
-	if *order.ShipmentType == 1 {
-		// 获取到最后一条有取餐号码的订单
-		var pickOrder shop.Order
-		if pickErr := global.DB.Select("pick_up_number").Where("pick_up_number is not null and pick_up_number <> 0").Order("id desc").First(&pickOrder).Error; pickErr == nil {
-			order.PickUpNumber = pickOrder.PickUpNumber + 1
-		} else {
-			order.PickUpNumber = 101
-		}
+	if len(pricing.Problems) > 0 {
+		return nil, pricing.Problems[0]
 	}
-
-	// 判断库存是否充足  以后可以上锁，解决高并发
-	for _, c := range cartList {
-		// 购物车数量大于库存
-		if c.Num > *c.Goods.Store {
-			global.SugarLog.Errorf("创建订单使库存不足 goodsId:%d, 购买数量:%d, 库存数量:%d \n", c.Goods.ID, c.Num, *c.Goods.Store)
-			return nil, errors.New("商品库存不足")
-		}
-		// 计算总数量
-		order.Num = order.Num + c.Num
-		if order.PointGoodsId != 0 { // 积分商品
-			order.Total = *c.Goods.CostPrice
-		} else {
-			// 计算总金额 如果优惠价小于成本价
-			if *c.Goods.Price > 0 && *c.Goods.Price < *c.Goods.CostPrice {
-				order.Total += float64(c.Num) * *c.Goods.Price
-			} else {
-				order.Total += float64(c.Num) * *c.Goods.CostPrice
-			}
-		}
-
-		// 组织订单详情数据
-		imgUrl := ""
-		if len(c.Goods.Images) > 0 {
-			imgUrl = c.Goods.Images[0].Url
-		}
-		orderDetail := shop.OrderDetails{}
-		orderDetail.GoodsId = c.Goods.ID
-		orderDetail.GoodsName = c.Goods.Name
-		orderDetail.GoodsImage = imgUrl
-		orderDetail.Unit = c.Goods.Unit
-		orderDetail.Num = c.Num
-		orderDetail.Price = *c.Goods.Price
-		orderDetail.Total = 0
-		if order.PointGoodsId != 0 { // 积分商品
-			orderDetail.Total = *c.Goods.CostPrice
-		} else {
-			// 计算单个商品多个数量的总金额
-			if *c.Goods.Price > 0 && *c.Goods.Price < *c.Goods.CostPrice {
-				orderDetail.Total = float64(c.Num) * *c.Goods.Price
-			} else {
-				orderDetail.Total = float64(c.Num) * *c.Goods.CostPrice
-			}
-		}
-
-		// 规格id 现在只开发了单规格订单，多规格以后在支持
-		orderDetail.SpecId = 0
-		spec := ""
-		if *c.Goods.Weight > 0 {
-			spec = fmt.Sprintf("%dg", *c.Goods.Weight)
-		}
-		if strings.TrimSpace(spec) == "" {
-			spec = c.Goods.Unit
-		} else {
-			spec = spec + "/" + c.Goods.Unit
-		}
-		orderDetail.SpecKeyName = spec
-		// 计算赠送积分
-		if pointSwitch && order.PointGoodsId == 0 {
-			point, err := strconv.Atoi(pointCfg)
-			if err != nil {
-				global.SugarLog.Errorf("创建订单详情信息时转换积分配置参数异常, err:%v \n", err)
-				return nil, err
-			}
-			orderDetail.GiftPoints = orderDetail.Total * (float64(point) / 100)
-		}
-		orderDetailList = append(orderDetailList, orderDetail)
+	if pricing.DeductErr != nil {
+		return nil, pricing.DeductErr
 	}
+	orderDetailList := pricing.Details
 
 	// 设置订单基本信息
 	order.OrderSn = utils.GenerateOrderNumber("SN")
@@ -181,71 +85,88 @@ func (orderService *OrderService) CreateOrder(order shop.Order, userClaims *syst
 	order.ShipmentAddress = address.Address + address.Title + address.Detail
 	order.StatusCancel = utils.Pointer(0)
 	order.StatusRefund = utils.Pointer(0)
-	// 计算总赠送积分
-	if pointSwitch && order.PointGoodsId == 0 {
-		point, err := strconv.Atoi(pointCfg)
-		if err != nil {
-			global.SugarLog.Errorf("创建订单时转换积分配置参数异常, err:%v \n", err)
-			return nil, err
-		}
-		// 公式 总金额 * n%
-		order.GiftPoints = order.Total * (float64(point) / 100)
+	// 取餐号码在支付成功后分配
+	order.PickUpNumber = 0
+	// 自提订单不需要预约配送时段
+	if *order.ShipmentType == 1 {
+		order.DeliverySlotId = 0
 	}
 
 	log := fmt.Sprintf("[OrderService] CreateOrder submit data:%+v; \n", order)
-	// 启动事务
-	txDB := global.DB.Begin()
-	// 创建订单
-	if err = global.DB.Create(&order).Error; err != nil {
-		txDB.Rollback()
-		global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
-		return nil, errors.New("订单创建失败")
-	}
-	if order.ID == 0 {
-		txDB.Rollback()
-		global.SugarLog.Errorf("log:%s, err: 创建订单后订单ID获取失败 \n", log)
-		return nil, errors.New("订单创建失败")
-	}
-	// 创建订单详情
-	// 设置订单详情 orderId
-	for k, _ := range orderDetailList {
-		orderDetailList[k].OrderId = order.ID
-	}
-	if err = global.DB.Create(&orderDetailList).Error; err != nil {
-		txDB.Rollback()
-		global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
-		return nil, errors.New("订单详情创建失败")
-	}
-	// 扣减库存
-	for _, v := range cartList {
-		if err = global.DB.Model(&shop.Goods{}).Where("id = ?", v.GoodsId).Update("store", gorm.Expr("store - ?", v.Num)).Error; err != nil {
-			txDB.Rollback()
-			global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
-			return nil, errors.New("库存扣减失败")
+	// 订单、订单详情、库存、购物车、积分在同一个事务中处理，任意一步失败全部回滚
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		// 创建订单
+		if txErr := tx.Create(&order).Error; txErr != nil {
+			global.SugarLog.Errorf("log:%s,err:%v \n", log, txErr)
+			return errors.New("订单创建失败")
 		}
-	}
-	if order.PointGoodsId == 0 {
-		// 删除购物车列表
-		if err = global.DB.Delete(&cartList).Error; err != nil {
-			txDB.Rollback()
-			global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
-			return nil, errors.New("购物车删除失败")
+		if order.ID == 0 {
+			global.SugarLog.Errorf("log:%s, err: 创建订单后订单ID获取失败 \n", log)
+			return errors.New("订单创建失败")
+		}
+		actor := common.OrderActor{Type: common.ActorUser, Id: user.ID, Name: user.Username}
+		if txErr := common.AddOrderLog(tx, order.ID, "", common.OrderState(order), actor, "创建订单"); txErr != nil {
+			global.SugarLog.Errorf("log:%s,err:%v \n", log, txErr)
+			return errors.New("订单创建失败")
+		}
+		// 创建订单详情
+		// 设置订单详情 orderId
+		for k := range orderDetailList {
+			orderDetailList[k].OrderId = order.ID
+		}
+		if txErr := tx.Create(&orderDetailList).Error; txErr != nil {
+			global.SugarLog.Errorf("log:%s,err:%v \n", log, txErr)
+			return errors.New("订单详情创建失败")
+		}
+		// 预约配送时段
+		if order.DeliverySlotId > 0 {
+			if txErr := reserveDeliverySlot(tx, order.DeliverySlotId); txErr != nil {
+				return txErr
+			}
+		}
+		// 扣减库存 增加销量 库存不足时返回具体商品
+		stock := stockRef{Reason: shop.StockReasonOrder, RefId: order.OrderSn, Actor: actor}
+		for _, v := range cartList {
+			if txErr := deductGoodsStock(tx, v.Goods.ID, v.SpecItemId, v.Num, cartGoodsName(v), stock); txErr != nil {
+				var stockErr *StockNotEnoughError
+				if errors.As(txErr, &stockErr) {
+					return stockErr
+				}
+				global.SugarLog.Errorf("log:%s,err:%v \n", log, txErr)
+				return errors.New("库存扣减失败")
+			}
+		}
+		if order.PointGoodsId == 0 {
+			// 删除购物车列表
+			if txErr := tx.Delete(&cartList).Error; txErr != nil {
+				global.SugarLog.Errorf("log:%s,err:%v \n", log, txErr)
+				return errors.New("购物车删除失败")
+			}
 		}
-	}
 
-	if order.PointGoodsId > 0 {
-		// 扣减积分
-		f := common.NewFinance(common.OptionTypeCASH, 1, user.ID, user.Username, -order.Total, order.OrderSn, user.ID, user.Username, "购买积分商品")
-		err := common.AccountUnifyDeduction(common.POINT, f)
-		if err != nil {
-			txDB.Rollback()
-			global.SugarLog.Errorf("log:%s, 积分扣减失败 err:%v \n", log, err)
-			return nil, err
+		// 冻结抵扣的积分 支付成功后扣除
+		if txErr := common.FreezeOrderPoints(tx, order); txErr != nil {
+			global.SugarLog.Errorf("log:%s, 积分冻结失败 err:%v \n", log, txErr)
+			return txErr
 		}
-	}
 
-	// 提交事务
-	txDB.Commit()
+		if order.PointGoodsId > 0 {
+			// 积分商品下单即支付 分配取餐号码
+			if txErr := common.AssignPickUpNumber(tx, &order); txErr != nil {
+				return txErr
+			}
+			// 扣减积分
+			f := common.NewFinance(common.OptionTypeCASH, common.FinanceTypeBuyPointGoods, user.ID, user.Username, -order.Total, order.OrderSn, user.ID, user.Username, "购买积分商品")
+			if txErr := common.AccountUnifyDeductionTx(tx, common.POINT, f); txErr != nil {
+				global.SugarLog.Errorf("log:%s, 积分扣减失败 err:%v \n", log, txErr)
+				return txErr
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	//jsApiData := &orderPay.Config{}
 	//if order.PointGoodsId == 0 {
 	//	// 发起 JSAIP 支付返回参数
@@ -262,12 +183,13 @@ func (orderService *OrderService) CreateOrder(order shop.Order, userClaims *syst
 	return
 }
 
-// OrderPay 支付 Order, 返回微信支付所需要的参数
+// OrderPay 支付 Order, 微信、支付宝支付返回调起支付所需要的参数，余额支付直接完成支付
 // Author [dalefeng](https://github.com/dalefeng)
-func (orderService *OrderService) OrderPay(order shop.Order, userClaims *systemReq.CustomClaims, clientIP string) (resp *response.CreateOrderResp, err error) {
-	log := fmt.Sprintf("[OrderService] OrderPay orderId:%d; \n", order.ID)
+func (orderService *OrderService) OrderPay(req shopReq.OrderPayReq, userClaims *systemReq.CustomClaims, clientIP string) (resp *response.CreateOrderResp, err error) {
+	log := fmt.Sprintf("[OrderService] OrderPay orderId:%d, payment:%d; \n", req.ID, req.Payment)
 	// 查询订单信息
-	if errors.Is(global.DB.Where("id = ?", order.ID).First(&order).Error, gorm.ErrRecordNotFound) {
+	var order shop.Order
+	if errors.Is(global.DB.Where("id = ? and user_id = ?", req.ID, userClaims.BaseClaims.ID).First(&order).Error, gorm.ErrRecordNotFound) {
 		global.SugarLog.Errorf("log:%s,err:订单不存在 \n", log)
 		return nil, errors.New("订单不存在")
 	}
@@ -278,15 +200,160 @@ func (orderService *OrderService) OrderPay(order shop.Order, userClaims *systemR
 		global.SugarLog.Errorf("log:%s,err:订单状态不正确 \n", log)
 		return nil, errors.New("订单状态不正确")
 	}
-	// 发起 JSAIP 支付返回参数
-	err, jsApiData := wechat.JSAPIPay(userClaims.OpenId, order.OrderSn, order.ID, order.Total, clientIP)
+	if *order.StatusCancel > 0 {
+		global.SugarLog.Errorf("log:%s,err:订单已取消 \n", log)
+		return nil, errors.New("订单已取消")
+	}
+	// 超过支付时间的订单等待定时任务取消，不允许再发起支付
+	timeout, _ := common.GetOrderTimeout()
+	expire := order.CreatedAt.Add(timeout)
+	if time.Now().After(expire) {
+		global.SugarLog.Errorf("log:%s,err:订单已超时 \n", log)
+		return nil, errors.New("订单已超过支付时间")
+	}
+	if req.DeductPoints > 0 {
+		if err = orderService.deductPointsOnPay(&order, req.DeductPoints); err != nil {
+			global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
+			return nil, err
+		}
+	}
+	if req.Payment == PaymentBalance {
+		return orderService.balancePay(order, req.SafePassword)
+	}
+	if req.Payment == 0 {
+		req.Payment = PaymentWechat
+	}
+	// 发起第三方支付 返回客户端调起支付的参数
+	provider, err := payment.Get(req.Payment)
 	if err != nil {
-		global.SugarLog.Errorf("log:%s, 微信 JsApi 发起调用异常, err: %v \n", log, err)
+		global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
+		return nil, err
+	}
+	if err = orderService.switchPayment(&order, req.Payment); err != nil {
+		global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
+		return nil, err
+	}
+	openId := userClaims.OpenId
+	if req.Payment == PaymentAlipay {
+		openId = req.BuyerId
+	}
+	payData, err := provider.Prepay(payment.PrepayReq{
+		OrderId:    order.ID,
+		OrderSn:    order.OrderSn,
+		Amount:     order.PayAmount(),
+		OpenId:     openId,
+		ClientIP:   clientIP,
+		TimeExpire: expire,
+	})
+	if err != nil {
+		global.SugarLog.Errorf("log:%s, 发起支付异常, err: %v \n", log, err)
 		return
 	}
 	resp = &response.CreateOrderResp{
 		Order: order,
-		Pay:   *jsApiData,
+		Pay:   payData,
+	}
+	return
+}
+
+// switchPayment 更换未支付订单的支付方式，关闭原支付方式已发起的支付防止重复付款
+// 查询支付结果和超时取消按订单的支付方式处理
+func (orderService *OrderService) switchPayment(order *shop.Order, payType int) error {
+	if order.Payment != nil && *order.Payment == payType {
+		return nil
+	}
+	result := global.DB.Model(&shop.Order{}).Where("id = ? and status = 0 and status_cancel = 0", order.ID).Update("payment", payType)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("订单状态已变更，请刷新后重试")
+	}
+	if provider, ok := orderProvider(*order); ok {
+		_ = provider.Close(order.OrderSn)
+	}
+	order.Payment = utils.Pointer(payType)
+	return nil
+}
+
+// deductPointsOnPay 支付时使用积分抵扣 冻结积分并更新订单抵扣金额
+func (orderService *OrderService) deductPointsOnPay(order *shop.Order, points float64) error {
+	if order.DeductPoints > 0 {
+		return errors.New("订单已使用积分抵扣")
+	}
+	if order.GoodsArea != nil && *order.GoodsArea == 1 {
+		return errors.New("积分商品不能使用积分抵扣")
+	}
+	amount, err := common.CalcPointDeduction(points, order.Total)
+	if err != nil {
+		return err
+	}
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&shop.Order{}).Where("id = ? and status = 0 and status_cancel = 0 and deduct_points = 0", order.ID).
+			Updates(map[string]interface{}{"deduct_points": points, "deduct_amount": amount})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("订单状态已变更，请刷新后重试")
+		}
+		order.DeductPoints = points
+		order.DeductAmount = amount
+		return common.FreezeOrderPoints(tx, *order)
+	})
+}
+
+// balancePay 余额支付 验证安全密码后扣减余额账户，扣款和订单状态在同一个事务中完成
+func (orderService *OrderService) balancePay(order shop.Order, safePassword string) (resp *response.CreateOrderResp, err error) {
+	log := fmt.Sprintf("[OrderService] balancePay orderId:%d; \n", order.ID)
+	var user sysModel.SysUser
+	if err = global.DB.Where("id = ?", *order.UserId).First(&user).Error; err != nil {
+		global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
+		return nil, errors.New("用户不存在")
+	}
+	if user.SafePassword == "" {
+		return nil, errors.New("请先设置安全密码")
+	}
+	if safePassword == "" || !utils.BcryptCheck(safePassword, user.SafePassword) {
+		global.SugarLog.Errorf("log:%s,err:安全密码错误 \n", log)
+		return nil, errors.New("安全密码错误")
+	}
+	finish := order.PayAmount()
+	payTime := time.Now()
+	provider, hasProvider := orderProvider(order)
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		// 只有未支付且未取消的订单才能支付，防止与超时取消、微信支付回调同时发生时重复扣款
+		values := map[string]interface{}{
+			"payment":  PaymentBalance,
+			"finish":   finish,
+			"pay_time": payTime,
+		}
+		actor := common.OrderActor{Type: common.ActorUser, Id: user.ID, Name: user.Username}
+		if err := common.TransitOrder(tx, &order, common.OrderStatePaid, values, actor, "余额支付"); err != nil {
+			return err
+		}
+		order.PayTime = &payTime
+		if err := common.OrderPaidTx(tx, &order); err != nil {
+			return err
+		}
+		if finish <= 0 {
+			return nil
+		}
+		f := common.NewFinance(common.OptionTypeCASH, common.FinanceTypeOrderPay, user.ID, user.Username, -finish, order.OrderSn, user.ID, user.Username, "余额支付订单")
+		return common.AccountUnifyDeductionTx(tx, common.CASH, f)
+	})
+	if err != nil {
+		global.SugarLog.Errorf("log:%s,err:%v \n", log, err)
+		return nil, err
+	}
+	// 用户可能已经发起过第三方支付，关闭支付订单防止重复支付
+	if hasProvider {
+		_ = provider.Close(order.OrderSn)
+	}
+	order.Payment = utils.Pointer(PaymentBalance)
+	order.Finish = finish
+	resp = &response.CreateOrderResp{
+		Order: order,
 	}
 	return
 }
@@ -316,25 +383,163 @@ func (orderService *OrderService) DeleteOrder(order shop.Order) (err error) {
 	return err
 }
 
-// CancelOrder 取消订单
+// CancelOrder 用户取消订单，只能取消自己的订单
 // Author [dalefeng](https://github.com/dalefeng)
-func (orderService *OrderService) CancelOrder(order shop.Order) (err error) {
-	cancelType := 1 // 默认用户取消
-	if order.StatusCancel != nil && *order.StatusCancel > 1 {
-		cancelType = *order.StatusCancel
+func (orderService *OrderService) CancelOrder(order shop.Order, userId uint, operator string) (err error) {
+	reason := order.CancelReason
+	if errors.Is(global.DB.Where("id = ? and user_id = ?", order.ID, userId).First(&order).Error, gorm.ErrRecordNotFound) {
+		return errors.New("订单不存在")
 	}
+	return orderService.checkAndCancelOrder(order, common.OrderActor{Type: common.ActorUser, Id: userId, Name: operator}, reason)
+}
+
+// AdminCancelOrder 后台取消订单
+func (orderService *OrderService) AdminCancelOrder(order shop.Order, actor common.OrderActor) (err error) {
+	reason := order.CancelReason
 	if errors.Is(global.DB.Where("id = ?", order.ID).First(&order).Error, gorm.ErrRecordNotFound) {
 		return errors.New("订单不存在")
 	}
-	// 发货 收货状态不允许取消
+	return orderService.checkAndCancelOrder(order, actor, reason)
+}
+
+// checkAndCancelOrder 校验订单状态后取消订单，发货、收货状态不允许取消
+func (orderService *OrderService) checkAndCancelOrder(order shop.Order, actor common.OrderActor, reason string) error {
+	if *order.StatusCancel > 0 {
+		return errors.New("订单已取消")
+	}
 	if *order.Status >= 2 {
 		return errors.New("订单不允许取消")
 	}
-	// 如果订单已支付需要进行退款
-	order.StatusCancel = &cancelType
-	order.CancelTime = utils.Pointer(time.Now())
-	err = global.DB.Where("id = ?", order.ID).Updates(&order).Error
-	return err
+	return orderService.cancelOrder(order, actor, reason)
+}
+
+// CancelTimeoutOrders 取消超时未支付的订单，由定时任务调用
+func (orderService *OrderService) CancelTimeoutOrders() {
+	if global.DB == nil {
+		return
+	}
+	timeout, enable := common.GetOrderTimeout()
+	if !enable {
+		return
+	}
+	var orders []shop.Order
+	err := global.DB.Where("status = 0 and status_cancel = 0 and created_at < ?", time.Now().Add(-timeout)).
+		Order("id").Limit(100).Find(&orders).Error
+	if err != nil {
+		global.SugarLog.Errorf("查询超时未支付订单失败, err:%v \n", err)
+		return
+	}
+	for _, order := range orders {
+		// 第三方支付的订单取消前查询支付结果，防止支付回调丢失时取消已付款的订单，查询失败下次再处理
+		if provider, ok := orderProvider(order); ok {
+			paid, err := syncPay(provider, order)
+			if err != nil {
+				global.SugarLog.Errorf("超时订单查询支付结果失败 orderId:%d, err:%v \n", order.ID, err)
+				continue
+			}
+			if paid {
+				continue
+			}
+		}
+		err = orderService.cancelOrder(order, common.OrderActor{Type: common.ActorTimer, Name: "system"}, "超时未支付自动取消")
+		if err != nil {
+			global.SugarLog.Errorf("超时订单自动取消失败 orderId:%d, err:%v \n", order.ID, err)
+		}
+	}
+}
+
+// AutoReceiveOrders 发货超过配置天数的订单自动确认收货并发放赠送积分，由定时任务调用
+func (orderService *OrderService) AutoReceiveOrders() {
+	if global.DB == nil {
+		return
+	}
+	days, enable := common.GetAutoReceiveDays()
+	if !enable {
+		return
+	}
+	var orders []shop.Order
+	err := global.DB.Where("status = 2 and status_cancel = 0 and shipment_time < ?", time.Now().AddDate(0, 0, -days)).
+		Order("id").Limit(100).Find(&orders).Error
+	if err != nil {
+		global.SugarLog.Errorf("查询待自动收货订单失败, err:%v \n", err)
+		return
+	}
+	for _, order := range orders {
+		now := time.Now()
+		err = global.DB.Transaction(func(tx *gorm.DB) error {
+			if err := common.OrderReceivedTx(tx, &order, now, common.OrderActor{Type: common.ActorTimer}, "自动确认收货发放积分"); err != nil {
+				return err
+			}
+			// 同步配送记录的收货时间和配送状态
+			return tx.Model(&shop.OrderDelivery{}).Where("order_id = ? and receipt_time is null", order.ID).
+				Updates(map[string]interface{}{"receipt_time": now, "status": shop.DeliveryStatusDelivered}).Error
+		})
+		if err != nil {
+			global.SugarLog.Errorf("订单自动确认收货失败 orderId:%d, err:%v \n", order.ID, err)
+			continue
+		}
+		global.SugarLog.Infof("订单自动确认收货 orderId:%d, orderSn:%s, shipmentTime:%v, giftPoints:%.2f \n", order.ID, order.OrderSn, order.ShipmentTime, order.GiftPoints)
+	}
+}
+
+// cancelOrder 取消订单并归还库存，未支付的第三方支付订单同时关闭支付订单，已支付的订单按原支付方式退款
+// 取消类型由操作人类型决定，通过状态机以待付款、待发货状态为条件变更，用户取消和超时取消同时发生时只会处理一次
+func (orderService *OrderService) cancelOrder(order shop.Order, actor common.OrderActor, reason string) error {
+	paid := *order.Status == 1
+	cancelType := 1 // 用户取消
+	switch actor.Type {
+	case common.ActorAdmin:
+		cancelType = 2 // 后台取消
+	case common.ActorTimer:
+		cancelType = 3 // 超时取消
+	}
+	var refund payment.Provider
+	if paid {
+		var err error
+		if refund, err = refundProvider(order); err != nil {
+			return err
+		}
+	}
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		values := map[string]interface{}{
+			"status_cancel": cancelType,
+			"cancel_time":   time.Now(),
+			"cancel_by":     actor.Name,
+			"cancel_reason": reason,
+		}
+		if err := common.TransitOrder(tx, &order, common.OrderStateCancelled, values, actor, reason); err != nil {
+			return err
+		}
+		if err := restoreOrderStock(tx, order, actor); err != nil {
+			return err
+		}
+		if err := releaseDeliverySlot(tx, order.DeliverySlotId); err != nil {
+			return err
+		}
+		// 已支付的订单需要进行退款，未支付的订单解冻抵扣的积分
+		if paid {
+			return refundOrderTx(tx, &order, actor, "订单取消退款")
+		}
+		return common.UnfreezeOrderPoints(tx, order)
+	})
+	if err != nil {
+		global.SugarLog.Errorf("取消订单失败 orderId:%d, err:%v \n", order.ID, err)
+		return err
+	}
+	if paid {
+		if refund == nil {
+			return nil
+		}
+		if err = applyPayRefund(refund, order, order.Finish, "订单取消退款"); err != nil {
+			return errors.New("订单已取消，" + err.Error())
+		}
+		return nil
+	}
+	// 关闭未支付的第三方支付订单，关闭失败不影响取消结果
+	if provider, ok := orderProvider(order); ok {
+		_ = provider.Close(order.OrderSn)
+	}
+	return nil
 }
 
 // DeleteOrderByIds 批量删除Order记录
@@ -344,10 +549,10 @@ func (orderService *OrderService) DeleteOrderByIds(ids request.IdsReq) (err erro
 	return err
 }
 
-// UpdateOrder 更新Order记录
+// UpdateOrder 更新Order记录 订单状态只能通过支付、发货、收货、取消、退款流程变更，这里不会修改
 // Author [dalefeng](https://github.com/dalefeng)
 func (orderService *OrderService) UpdateOrder(order shop.Order) (err error) {
-	err = global.DB.Save(&order).Error
+	err = global.DB.Omit(orderStateColumns...).Save(&order).Error
 	return err
 }
 
@@ -356,15 +561,32 @@ func (orderService *OrderService) UpdateOrder(order shop.Order) (err error) {
 func (orderService *OrderService) GetOrder(id uint) (order shop.Order, err error) {
 	err = global.DB.Where("id = ?", id).
 		Preload("OrderDetails.Goods").
-		Preload("OrderReturn.Details").
+		Preload("OrderReturns", orderReturnsOrder).
+		Preload("OrderReturns.Details").
 		Preload("OrderDelivery.UserDelivery").
+		Preload("Logs", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id asc")
+		}).
 		First(&order).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return order, errors.New("订单不存在")
 	}
+	fillLatestReturn(&order)
 	return
 }
 
+// orderReturnsOrder 订单的售后按申请时间倒序加载
+func orderReturnsOrder(db *gorm.DB) *gorm.DB {
+	return db.Order("id desc")
+}
+
+// fillLatestReturn 使用最近一次售后填充 OrderReturn，订单列表和详情按最近一次售后展示售后状态
+func fillLatestReturn(order *shop.Order) {
+	if len(order.OrderReturns) > 0 {
+		order.OrderReturn = order.OrderReturns[0]
+	}
+}
+
 // FindUserOrderStatus 获取用户订单中数量
 // Author [dalefeng](https://github.com/dalefeng)
 func (orderService *OrderService) FindUserOrderStatus(userId uint) (resp shopResp.OrderStatusCountResponse, err error) {
@@ -395,51 +617,8 @@ func (orderService *OrderService) GetOrderInfoList(info shopReq.OrderSearch) (li
 	limit := info.PageSize
 	offset := info.PageSize * (info.Page - 1)
 	// 创建db
-	db := global.DB.Debug().Model(&shop.Order{}).Preload("OrderDetails").Preload("OrderDelivery").Joins("OrderReturn")
+	db := global.DB.Debug().Model(&shop.Order{}).Preload("OrderDetails").Preload("OrderDelivery").Preload("OrderReturns", orderReturnsOrder).Scopes(orderSearchScope(info))
 	var orders []shop.Order
-	// 如果有条件搜索 下方会自动创建搜索语句
-
-	if info.Status != nil {
-		if *info.Status == 0 || *info.Status == 1 || *info.Status == 2 || *info.Status == 3 { // 未付款
-			db = db.Where("shop_order.status = ? and shop_order.status_cancel = 0 and shop_order.status_refund = 0", info.Status)
-		} else if *info.Status == 10 { // 售后订单
-			db = db.Where("OrderReturn.order_id = shop_order.id and shop_order.status_cancel = 0")
-		}
-	}
-
-	if info.UserId != nil {
-		db = db.Where("shop_order.user_id = ?", info.UserId)
-	}
-	if info.GoodsArea != nil {
-		db = db.Where("shop_order.goods_area = ?", info.GoodsArea)
-	}
-	if info.StartCreatedAt != nil && info.EndCreatedAt != nil {
-		db = db.Where("shop_order.created_at BETWEEN ? AND ?", info.StartCreatedAt, info.EndCreatedAt)
-	}
-	if info.OrderSn != "" {
-		db = db.Where("shop_order.order_sn LIKE ?", "%"+info.OrderSn+"%")
-	}
-	if info.ShipmentName != "" {
-		db = db.Where("shop_order.shipment_name LIKE ?", "%"+info.ShipmentName+"%")
-	}
-	if info.ShipmentMobile != "" {
-		db = db.Where("shop_order.shipment_mobile LIKE ?", "%"+info.ShipmentMobile+"%")
-	}
-	if info.ShipmentAddress != "" {
-		db = db.Where("shop_order.shipment_address LIKE ?", "%"+info.ShipmentAddress+"%")
-	}
-	if info.Payment != nil {
-		db = db.Where("shop_order.payment = ?", info.Payment)
-	}
-	if info.StartShipmentTime != nil && info.EndShipmentTime != nil {
-		db = db.Where("shop_order.shipment_time BETWEEN ? AND ? ", info.StartShipmentTime, info.EndShipmentTime)
-	}
-	if info.StartReceiveTime != nil && info.EndReceiveTime != nil {
-		db = db.Where("shop_order.receive_time BETWEEN ? AND ? ", info.StartReceiveTime, info.EndReceiveTime)
-	}
-	if info.StartCancelTime != nil && info.EndCancelTime != nil {
-		db = db.Where("shop_order.cancel_time BETWEEN ? AND ? ", info.StartCancelTime, info.EndCancelTime)
-	}
 	err = db.Count(&total).Error
 	if err != nil {
 		return
@@ -448,5 +627,57 @@ func (orderService *OrderService) GetOrderInfoList(info shopReq.OrderSearch) (li
 	db = db.Order("shop_order.created_at desc")
 
 	err = db.Limit(limit).Offset(offset).Find(&orders).Error
+	for i := range orders {
+		fillLatestReturn(&orders[i])
+	}
 	return orders, total, err
 }
+
+// orderSearchScope 订单列表和订单导出共用的搜索条件
+func orderSearchScope(info shopReq.OrderSearch) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		// 如果有条件搜索 下方会自动创建搜索语句
+		if info.Status != nil {
+			if *info.Status == 0 || *info.Status == 1 || *info.Status == 2 || *info.Status == 3 { // 未付款
+				db = db.Where("shop_order.status = ? and shop_order.status_cancel = 0 and shop_order.status_refund = 0", info.Status)
+			} else if *info.Status == 10 { // 售后订单 一个订单可以有多次售后，使用 exists 避免订单重复
+				db = db.Where("exists (select 1 from shop_order_return where shop_order_return.order_id = shop_order.id and shop_order_return.deleted_at is null) and shop_order.status_cancel = 0")
+			}
+		}
+
+		if info.UserId != nil {
+			db = db.Where("shop_order.user_id = ?", info.UserId)
+		}
+		if info.GoodsArea != nil {
+			db = db.Where("shop_order.goods_area = ?", info.GoodsArea)
+		}
+		if info.StartCreatedAt != nil && info.EndCreatedAt != nil {
+			db = db.Where("shop_order.created_at BETWEEN ? AND ?", info.StartCreatedAt, info.EndCreatedAt)
+		}
+		if info.OrderSn != "" {
+			db = db.Where("shop_order.order_sn LIKE ?", "%"+info.OrderSn+"%")
+		}
+		if info.ShipmentName != "" {
+			db = db.Where("shop_order.shipment_name LIKE ?", "%"+info.ShipmentName+"%")
+		}
+		if info.ShipmentMobile != "" {
+			db = db.Where("shop_order.shipment_mobile LIKE ?", "%"+info.ShipmentMobile+"%")
+		}
+		if info.ShipmentAddress != "" {
+			db = db.Where("shop_order.shipment_address LIKE ?", "%"+info.ShipmentAddress+"%")
+		}
+		if info.Payment != nil {
+			db = db.Where("shop_order.payment = ?", info.Payment)
+		}
+		if info.StartShipmentTime != nil && info.EndShipmentTime != nil {
+			db = db.Where("shop_order.shipment_time BETWEEN ? AND ? ", info.StartShipmentTime, info.EndShipmentTime)
+		}
+		if info.StartReceiveTime != nil && info.EndReceiveTime != nil {
+			db = db.Where("shop_order.receive_time BETWEEN ? AND ? ", info.StartReceiveTime, info.EndReceiveTime)
+		}
+		if info.StartCancelTime != nil && info.EndCancelTime != nil {
+			db = db.Where("shop_order.cancel_time BETWEEN ? AND ? ", info.StartCancelTime, info.EndCancelTime)
+		}
+		return db
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"fresh-shop/server/model/common/request"
 	"fresh-shop/server/model/shop"
 	shopReq "fresh-shop/server/model/shop/request"
-	sysModel "fresh-shop/server/model/system"
 	"fresh-shop/server/service/common"
 	"fresh-shop/server/utils"
 	"gorm.io/gorm"
@@ -17,19 +16,37 @@ import (
 type OrderDeliveryService struct {
 }
 
-// CreateOrderDelivery 创建OrderDelivery记录
+// deliveryProgressColumns 送货员更新的配送进度和送达凭证字段
+var deliveryProgressColumns = []string{"status", "pick_up_time", "arrive_time", "proof_image", "proof_image_key",
+	"sign_image", "sign_image_key", "proof_longitude", "proof_latitude", "proof_time"}
+
+// CreateOrderDelivery 订单发货 创建OrderDelivery记录
 // Author [dalefeng](https://github.com/dalefeng)
-func (orderDeliveryService *OrderDeliveryService) CreateOrderDelivery(orderDelivery shop.OrderDelivery) (err error) {
+func (orderDeliveryService *OrderDeliveryService) CreateOrderDelivery(orderDelivery shop.OrderDelivery, actor common.OrderActor) (err error) {
 	var order shop.Order
 	err = global.DB.Where("id = ? and status = 1 and status_cancel = 0", orderDelivery.OrderId).First(&order).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		global.SugarLog.Errorf("获取订单信息失败 orderId:%d, error: %v", order.ID, err)
 		return err
 	}
-	order.Status = utils.Pointer(2)
-	order.ShipmentTime = utils.Pointer(time.Now()) //发货时间
+	// 复制用户预约的配送时段 未填写预计到达时间时使用时段结束时间
+	if order.DeliverySlotId > 0 {
+		var slot shop.DeliverySlot
+		if slotErr := global.DB.Where("id = ?", order.DeliverySlotId).First(&slot).Error; slotErr == nil {
+			if start, end, windowErr := slot.Window(); windowErr == nil {
+				orderDelivery.SlotStartTime = utils.Pointer(start)
+				orderDelivery.SlotEndTime = utils.Pointer(end)
+				if orderDelivery.ScheduledTime.IsZero() {
+					orderDelivery.ScheduledTime = end
+				}
+			}
+		} else {
+			global.SugarLog.Errorf("获取配送时段失败 slotId:%d, error: %v", order.DeliverySlotId, slotErr)
+		}
+	}
 	err = global.DB.Transaction(func(tx *gorm.DB) error {
-		if txErr := tx.Save(&order).Error; txErr != nil {
+		values := map[string]interface{}{"shipment_time": time.Now()} //发货时间
+		if txErr := common.TransitOrder(tx, &order, common.OrderStateShipped, values, actor, "订单发货"); txErr != nil {
 			return txErr
 		}
 		err = tx.Create(&orderDelivery).Error
@@ -55,19 +72,17 @@ func (orderDeliveryService *OrderDeliveryService) DeleteOrderDeliveryByIds(ids r
 	return err
 }
 
-// UpdateOrderDelivery 订单收货
+// UpdateOrderDelivery 订单收货 操作人是下单用户时记录为用户确认收货
 // Author [dalefeng](https://github.com/dalefeng)
-func (orderDeliveryService *OrderDeliveryService) UpdateOrderDelivery(orderDelivery shop.OrderDelivery) (err error) {
+func (orderDeliveryService *OrderDeliveryService) UpdateOrderDelivery(orderDelivery shop.OrderDelivery, actor common.OrderActor) (err error) {
 	var order shop.Order
 	err = global.DB.Where("id = ? and status = 2 and status_cancel = 0", orderDelivery.OrderId).First(&order).Error
 	if err != nil {
 		global.SugarLog.Errorf("获取订单信息失败 orderId:%d, error: %v", orderDelivery.OrderId, err)
 		return err
 	}
-	var user sysModel.SysUser
-	if err = global.DB.Where("id = ?", order.UserId).First(&user).Error; err != nil {
-		global.SugarLog.Errorf("获取用户信息失败 userId:%d, error: %v", order.UserId, err)
-		return err
+	if actor.Id > 0 && order.UserId != nil && actor.Id == uint(*order.UserId) {
+		actor.Type = common.ActorUser
 	}
 	var deliver business.UserDelivery
 	if orderDelivery.DeliveryId != nil && *orderDelivery.DeliveryId > 0 {
@@ -78,32 +93,25 @@ func (orderDeliveryService *OrderDeliveryService) UpdateOrderDelivery(orderDeliv
 		}
 		deliver.DeliverCount = utils.Pointer(*deliver.DeliverCount + 1)
 	}
-	if orderDelivery.ReceiptTime != nil {
-		order.Status = utils.Pointer(3)
-		order.ReceiveTime = orderDelivery.ReceiptTime
-	}
 	err = global.DB.Transaction(func(tx *gorm.DB) error {
-		if txErr := tx.Save(&order).Error; txErr != nil {
-			return txErr
-		}
 		// 保存收货人信息
 		if orderDelivery.DeliveryId != nil && *orderDelivery.DeliveryId > 0 {
 			if txErr := tx.Save(&deliver).Error; txErr != nil {
 				return txErr
 			}
 		}
-		err = tx.Save(&orderDelivery).Error
+		// 配送进度由送货员更新，后台修改配送信息时不覆盖
+		err = tx.Omit(deliveryProgressColumns...).Save(&orderDelivery).Error
 		if err != nil {
 			return err
 		}
-		if *order.GoodsArea == 0 { // 普通商品才能发放积分
-			// 发放积分
-			f := common.NewFinance(0, 6, user.ID, user.Username, order.GiftPoints, order.OrderSn, user.ID, user.Username, "确认收货发放积分")
-			err = common.AccountUnifyDeduction(common.POINT, f)
-			if err != nil {
-				global.SugarLog.Errorf("发放积分失败 UserFinance:%v, error: %v", f, err)
-				return err
+		if orderDelivery.ReceiptTime != nil {
+			if txErr := tx.Model(&shop.OrderDelivery{}).Where("id = ?", orderDelivery.ID).
+				Update("status", shop.DeliveryStatusDelivered).Error; txErr != nil {
+				return txErr
 			}
+			// 确认收货并发放积分
+			return common.OrderReceivedTx(tx, &order, *orderDelivery.ReceiptTime, actor, "确认收货发放积分")
 		}
 		return nil
 	})
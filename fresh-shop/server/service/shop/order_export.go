@@ -0,0 +1,137 @@
+package shop
+
+import (
+	"errors"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/common"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"io"
+	"time"
+)
+
+const (
+	orderExportSheet       = "订单"
+	orderDetailExportSheet = "订单明细"
+	orderExportBatchSize   = 500
+)
+
+var excelOrderHeader = []interface{}{
+	"订单编号", "用户id", "商品区域", "收货人", "手机号", "收货地址", "收货方式", "商品数量", "商品金额", "邮费",
+	"抵扣积分", "抵扣金额", "实付金额", "支付方式", "支付流水号", "订单状态", "取餐号码", "留言",
+	"下单时间", "支付时间", "发货时间", "收货时间", "取消时间", "取消原因", "退款时间",
+}
+
+var excelOrderDetailHeader = []interface{}{
+	"订单编号", "商品id", "商品名称", "规格", "单位", "单价", "数量", "小计", "赠送积分",
+}
+
+var orderPaymentNames = map[int]string{
+	PaymentBalance: "余额",
+	PaymentWechat:  "微信",
+	PaymentAlipay:  "支付宝",
+	PaymentPoint:   "积分",
+}
+
+// ExportOrders 按订单列表的搜索条件导出订单 订单和订单明细分别写入两个工作表
+// 分批查询订单并使用 StreamWriter 写入，数据量大时不会一次性加载到内存中
+func (orderService *OrderService) ExportOrders(info shopReq.OrderSearch, w io.Writer) (err error) {
+	ex := excelize.NewFile()
+	defer func() {
+		if err := ex.Close(); err != nil {
+			global.SugarLog.Errorf("excelize.close %v", err)
+		}
+	}()
+	if err = ex.SetSheetName(Sheet1, orderExportSheet); err != nil {
+		return err
+	}
+	if _, err = ex.NewSheet(orderDetailExportSheet); err != nil {
+		return err
+	}
+	orderWriter, err := ex.NewStreamWriter(orderExportSheet)
+	if err != nil {
+		return err
+	}
+	detailWriter, err := ex.NewStreamWriter(orderDetailExportSheet)
+	if err != nil {
+		return err
+	}
+	if err = orderWriter.SetRow("A1", excelOrderHeader); err != nil {
+		return err
+	}
+	if err = detailWriter.SetRow("A1", excelOrderDetailHeader); err != nil {
+		return err
+	}
+
+	orderRow, detailRow := 2, 2
+	var orders []shop.Order
+	result := global.DB.Model(&shop.Order{}).Preload("OrderDetails").Scopes(orderSearchScope(info)).
+		FindInBatches(&orders, orderExportBatchSize, func(tx *gorm.DB, batch int) error {
+			for _, o := range orders {
+				cell, _ := excelize.CoordinatesToCellName(1, orderRow)
+				if err := orderWriter.SetRow(cell, excelOrderRow(o)); err != nil {
+					return err
+				}
+				orderRow++
+				for _, d := range o.OrderDetails {
+					cell, _ = excelize.CoordinatesToCellName(1, detailRow)
+					row := []interface{}{o.OrderSn, d.GoodsId, d.GoodsName, d.SpecKeyName, d.Unit, d.Price, d.Num, d.Total, d.GiftPoints}
+					if err := detailWriter.SetRow(cell, row); err != nil {
+						return err
+					}
+					detailRow++
+				}
+			}
+			return nil
+		})
+	if result.Error != nil {
+		global.SugarLog.Errorf("导出订单失败 %v", result.Error)
+		return errors.New("导出订单失败")
+	}
+	if err = orderWriter.Flush(); err != nil {
+		return err
+	}
+	if err = detailWriter.Flush(); err != nil {
+		return err
+	}
+	return ex.Write(w)
+}
+
+// excelOrderRow 订单导出的一行数据 与 excelOrderHeader 对应
+func excelOrderRow(o shop.Order) []interface{} {
+	goodsArea := "普通商品"
+	if o.GoodsArea != nil && *o.GoodsArea == 1 {
+		goodsArea = "积分商品"
+	}
+	shipmentType := "配送"
+	if o.ShipmentType != nil && *o.ShipmentType == 1 {
+		shipmentType = "自提"
+	}
+	payment := ""
+	if o.Payment != nil {
+		payment = orderPaymentNames[*o.Payment]
+	}
+	return []interface{}{
+		o.OrderSn, intCell(o.UserId), goodsArea, o.ShipmentName, o.ShipmentMobile, o.ShipmentAddress, shipmentType,
+		o.Num, o.Total, o.Postage, o.DeductPoints, o.DeductAmount, o.Finish, payment, o.TransationId,
+		common.OrderStateName(common.OrderState(o)), o.PickUpNumber, o.Remarks,
+		timeCell(&o.CreatedAt), timeCell(o.PayTime), timeCell(o.ShipmentTime), timeCell(o.ReceiveTime),
+		timeCell(o.CancelTime), o.CancelReason, timeCell(o.RefundTime),
+	}
+}
+
+func intCell(v *int) interface{} {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+func timeCell(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,59 @@
+package shop
+
+import (
+	"bytes"
+	"testing"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestOrderService_ExportOrders(t *testing.T) {
+	setupOrderTestDB(t)
+	for i, sn := range []string{"SN001", "SN002", "SN003"} {
+		order := shop.Order{
+			OrderSn:      sn,
+			UserId:       utils.Pointer(1),
+			GoodsArea:    utils.Pointer(0),
+			ShipmentType: utils.Pointer(i % 2),
+			Payment:      utils.Pointer(PaymentWechat),
+			Status:       utils.Pointer(i),
+			StatusCancel: utils.Pointer(0),
+			StatusRefund: utils.Pointer(0),
+			Num:          2,
+			Total:        30,
+		}
+		assert.Nil(t, global.DB.Create(&order).Error)
+		details := []shop.OrderDetails{
+			{OrderId: order.ID, GoodsId: 1, GoodsName: "带鱼", Num: 1, Price: 10, Total: 10},
+			{OrderId: order.ID, GoodsId: 2, GoodsName: "虾仁", Num: 1, Price: 20, Total: 20},
+		}
+		assert.Nil(t, global.DB.Create(&details).Error)
+	}
+
+	service := OrderService{}
+	var buf bytes.Buffer
+	// 只导出待发货的微信支付订单
+	err := service.ExportOrders(shopReq.OrderSearch{Order: shop.Order{Payment: utils.Pointer(PaymentWechat), Status: utils.Pointer(1)}}, &buf)
+	assert.Nil(t, err)
+
+	f, err := excelize.OpenReader(&buf)
+	assert.Nil(t, err)
+	defer f.Close()
+	orders, err := f.GetRows(orderExportSheet)
+	assert.Nil(t, err)
+	assert.Len(t, orders, 2)
+	assert.Equal(t, "订单编号", orders[0][0])
+	assert.Equal(t, "SN002", orders[1][0])
+	assert.Equal(t, "自提", orders[1][6])
+	assert.Equal(t, "待发货", orders[1][15])
+
+	details, err := f.GetRows(orderDetailExportSheet)
+	assert.Nil(t, err)
+	assert.Len(t, details, 3)
+	assert.Equal(t, []string{"SN002", "2", "虾仁"}, details[2][:3])
+}
@@ -0,0 +1,61 @@
+package shop
+
+import (
+	"errors"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/payment"
+	"time"
+)
+
+// payQueryDelay 下单后超过这个时间才主动查询支付结果，优先等待支付回调
+const payQueryDelay = time.Minute
+
+// SyncPayOrders 主动查询支付时间内未支付的第三方支付订单，支付回调丢失时根据查询结果完成支付，由定时任务调用
+// 超过支付时间的订单由 CancelTimeoutOrders 在取消前查询，未支付的取消订单并关闭支付订单
+func (orderService *OrderService) SyncPayOrders() {
+	if global.DB == nil {
+		return
+	}
+	timeout, _ := common.GetOrderTimeout()
+	now := time.Now()
+	for _, payType := range payment.Payments() {
+		provider, err := payment.Get(payType)
+		if err != nil {
+			continue
+		}
+		var orders []shop.Order
+		err = global.DB.Where("status = 0 and status_cancel = 0 and payment = ? and created_at between ? and ?", payType, now.Add(-timeout), now.Add(-payQueryDelay)).
+			Order("id").Limit(100).Find(&orders).Error
+		if err != nil {
+			global.SugarLog.Errorf("查询待支付的订单失败 payment:%d, err:%v \n", payType, err)
+			continue
+		}
+		for _, order := range orders {
+			if _, err = syncPay(provider, order); err != nil {
+				global.SugarLog.Errorf("同步支付结果失败 orderId:%d, err:%v \n", order.ID, err)
+			}
+		}
+	}
+}
+
+// syncPay 查询订单的支付结果，已支付时按支付回调相同的逻辑将订单变更为已支付
+// 用户没有发起过支付或尚未支付时 paid 返回 false
+func syncPay(provider payment.Provider, order shop.Order) (paid bool, err error) {
+	trade, err := provider.Query(order.OrderSn)
+	if errors.Is(err, payment.ErrTradeNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if trade.State != payment.TradeSuccess {
+		return false, nil
+	}
+	if err = payment.PaidLogic(*order.Payment, trade, common.OrderActor{Type: common.ActorTimer}, "查询支付结果确认支付"); err != nil {
+		return false, err
+	}
+	global.SugarLog.Infof("查询支付结果确认支付 orderId:%d, orderSn:%s \n", order.ID, order.OrderSn)
+	return true, nil
+}
@@ -0,0 +1,193 @@
+package shop
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	"fresh-shop/server/model/system"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/payment"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// queryRecorder 记录查询过的订单的模拟支付
+type queryRecorder struct {
+	*payment.Mock
+	queried []string
+}
+
+func (r *queryRecorder) Query(orderSn string) (payment.Trade, error) {
+	r.queried = append(r.queried, orderSn)
+	return r.Mock.Query(orderSn)
+}
+
+func TestOrderService_SyncPayOrders(t *testing.T) {
+	setupOrderTestDB(t)
+	global.DB.Create(&system.SysConfig{Name: "orderTimeout", Value: "15", Status: utils.Pointer(1)})
+	// 支付成功但不发送通知 模拟支付回调丢失
+	mock := payment.NewMock(payment.Wechat, config.PayMock{Result: payment.MockResultSuccess, SkipNotify: true})
+	provider := &queryRecorder{Mock: mock}
+	payment.Register(payment.Wechat, provider)
+	defer payment.Register(payment.Wechat, nil)
+
+	userId := createTestUser(t, "sync-buyer")
+	createTestAccount(t, userId, common.CASH, 0)
+	createOrder := func(orderSn string, age time.Duration) shop.Order {
+		order := createPayTestOrder(t, orderSn, userId, 20)
+		global.DB.Model(&order).Update("created_at", time.Now().Add(-age))
+		return order
+	}
+	prepay := func(order shop.Order) {
+		_, err := mock.Prepay(payment.PrepayReq{OrderId: order.ID, OrderSn: order.OrderSn, Amount: order.PayAmount()})
+		assert.Nil(t, err)
+	}
+	paid := createOrder("sync-paid", 5*time.Minute)
+	unpaid := createOrder("sync-unpaid", 5*time.Minute)
+	recent := createOrder("sync-recent", 0)
+	expired := createOrder("sync-expired", 20*time.Minute)
+	expiredPaid := createOrder("sync-expired-paid", 20*time.Minute)
+	prepay(paid)
+	prepay(expiredPaid)
+	mock.Config.Result = payment.MockResultFail
+	prepay(expired)
+
+	service := OrderService{}
+	service.SyncPayOrders()
+	// 刚创建的订单等待支付回调，超时的订单由超时取消处理
+	assert.Equal(t, []string{"sync-paid", "sync-unpaid"}, provider.queried)
+	service.CancelTimeoutOrders()
+
+	status := func(order shop.Order) (int, int) {
+		global.DB.First(&order, order.ID)
+		return *order.Status, *order.StatusCancel
+	}
+	s, c := status(paid)
+	assert.Equal(t, []int{1, 0}, []int{s, c})
+	s, c = status(unpaid)
+	assert.Equal(t, []int{0, 0}, []int{s, c})
+	s, c = status(recent)
+	assert.Equal(t, []int{0, 0}, []int{s, c})
+	// 超时未支付的订单取消并关闭支付订单
+	s, c = status(expired)
+	assert.Equal(t, []int{0, 3}, []int{s, c})
+	trade, _ := mock.Query(expired.OrderSn)
+	assert.Equal(t, payment.TradeClosed, trade.State)
+	// 超时但已付款的订单完成支付 不会被取消
+	s, c = status(expiredPaid)
+	assert.Equal(t, []int{1, 0}, []int{s, c})
+
+	var log shop.OrderLog
+	global.DB.Where("order_id = ? and to_state = ?", paid.ID, common.OrderStatePaid).First(&log)
+	assert.Equal(t, common.ActorTimer, log.Actor)
+
+	// 已支付的订单不会再查询
+	provider.queried = nil
+	service.SyncPayOrders()
+	assert.Equal(t, []string{"sync-unpaid"}, provider.queried)
+}
+
+func TestPaidLogic_PaidAfterCancel(t *testing.T) {
+	setupOrderTestDB(t)
+	mock := payment.NewMock(payment.Wechat, config.PayMock{Result: payment.MockResultSuccess, SkipNotify: true})
+	payment.Register(payment.Wechat, mock)
+	defer payment.Register(payment.Wechat, nil)
+
+	userId := createTestUser(t, "late-buyer")
+	createTestAccount(t, userId, common.CASH, 0)
+	service := OrderService{}
+	// 超时取消后用户才完成支付
+	order := createPayTestOrder(t, "late-1", userId, 20)
+	assert.Nil(t, service.cancelOrder(order, common.OrderActor{Type: common.ActorTimer, Name: "system"}, "超时未支付自动取消"))
+	_, err := mock.Prepay(payment.PrepayReq{OrderId: order.ID, OrderSn: order.OrderSn, Amount: order.PayAmount()})
+	assert.Nil(t, err)
+	trade, _ := mock.Query(order.OrderSn)
+	body, _ := json.Marshal(trade)
+
+	// 保存支付信息并原路退款 通知按处理成功应答
+	_, err = payment.HandlePayNotify(payment.Wechat, nil, body)
+	assert.Nil(t, err)
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStateRefunding, common.OrderState(order))
+	assert.Equal(t, 0, *order.Status)
+	assert.Equal(t, trade.TransactionId, order.TransationId)
+	assert.Equal(t, 20.0, order.Finish)
+	assert.NotEqual(t, "", order.RefundSn)
+	var log shop.OrderLog
+	global.DB.Where("order_id = ? and to_state = ?", order.ID, common.OrderStateRefunding).First(&log)
+	assert.Equal(t, common.OrderStateCancelled, log.FromState)
+	assert.Equal(t, common.ActorSystem, log.Actor)
+
+	// 重复通知不会重复退款
+	_, err = payment.HandlePayNotify(payment.Wechat, nil, body)
+	assert.Nil(t, err)
+	var count int64
+	global.DB.Model(&shop.OrderLog{}).Where("order_id = ? and to_state = ?", order.ID, common.OrderStateRefunding).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	assert.Nil(t, payment.RefundLogic(payment.Wechat, payment.RefundResult{OrderSn: order.OrderSn, RefundSn: order.RefundSn, Status: payment.RefundSuccess}))
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStateRefunded, common.OrderState(order))
+
+	// 查询支付结果时订单已被用户取消
+	synced := createPayTestOrder(t, "late-2", userId, 20)
+	assert.Nil(t, service.CancelOrder(synced, userId, "late-buyer"))
+	_, err = mock.Prepay(payment.PrepayReq{OrderId: synced.ID, OrderSn: synced.OrderSn, Amount: synced.PayAmount()})
+	assert.Nil(t, err)
+	paid, err := syncPay(mock, synced)
+	assert.Nil(t, err)
+	assert.True(t, paid)
+	global.DB.First(&synced, synced.ID)
+	assert.Equal(t, common.OrderStateRefunding, common.OrderState(synced))
+	var syncLog shop.OrderLog
+	global.DB.Where("order_id = ? and to_state = ?", synced.ID, common.OrderStateRefunding).First(&syncLog)
+	assert.Equal(t, common.ActorTimer, syncLog.Actor)
+}
+
+func TestPaidLogic_PaidAfterBalancePay(t *testing.T) {
+	setupOrderTestDB(t)
+	mock := payment.NewMock(payment.Wechat, config.PayMock{Result: payment.MockResultSuccess, SkipNotify: true})
+	payment.Register(payment.Wechat, mock)
+	defer payment.Register(payment.Wechat, nil)
+
+	userId := createTestUser(t, "double-buyer")
+	order := createPayTestOrder(t, "double-1", userId, 20)
+	_, err := mock.Prepay(payment.PrepayReq{OrderId: order.ID, OrderSn: order.OrderSn, Amount: order.PayAmount()})
+	assert.Nil(t, err)
+	trade, _ := mock.Query(order.OrderSn)
+	body, _ := json.Marshal(trade)
+	// 关闭第三方订单失败，订单已通过余额支付
+	assert.Nil(t, global.DB.Model(&order).Updates(map[string]interface{}{"status": 1, "payment": PaymentBalance, "finish": 20}).Error)
+
+	// 保存重复支付记录并原路退款，订单不变，通知按处理成功应答
+	_, err = payment.HandlePayNotify(payment.Wechat, nil, body)
+	assert.Nil(t, err)
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStatePaid, common.OrderState(order))
+	assert.Equal(t, PaymentBalance, *order.Payment)
+	assert.Equal(t, "", order.TransationId)
+	var record shop.PayDuplicate
+	assert.Nil(t, global.DB.Where("transaction_id = ?", trade.TransactionId).First(&record).Error)
+	assert.Equal(t, order.ID, record.OrderId)
+	assert.Equal(t, 20.0, record.Amount)
+	assert.Equal(t, 1, *record.RefundStatus)
+	assert.NotEqual(t, "", record.RefundSn)
+
+	// 重复通知不会重复退款
+	_, err = payment.HandlePayNotify(payment.Wechat, nil, body)
+	assert.Nil(t, err)
+	var count int64
+	global.DB.Model(&shop.PayDuplicate{}).Where("order_id = ?", order.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	assert.Nil(t, payment.RefundLogic(payment.Wechat, payment.RefundResult{OrderSn: order.OrderSn, RefundSn: record.RefundSn, Status: payment.RefundSuccess}))
+	global.DB.First(&record, record.ID)
+	assert.Equal(t, 2, *record.RefundStatus)
+	assert.NotNil(t, record.RefundTime)
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStatePaid, common.OrderState(order))
+}
@@ -0,0 +1,271 @@
+package shop
+
+import (
+	"errors"
+	"fmt"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	shopResp "fresh-shop/server/model/shop/response"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/utils"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+)
+
+var (
+	postageRuleService  = PostageRuleService{}
+	deliveryZoneService = DeliveryZoneService{}
+)
+
+// orderPricing 订单计价结果
+type orderPricing struct {
+	Carts     []shop.Cart                 // 下单的商品
+	Details   []shop.OrderDetails         // 订单详情 不包含已失效的商品
+	Lines     []shopResp.OrderPreviewLine // 预览明细 包含所有商品
+	Problems  []error                     // 商品失效、库存不足等导致不能下单的问题
+	DeductErr error                       // 积分抵扣不可用的原因
+}
+
+// loadOrderCarts 获取下单的商品，积分商品直接购买，普通商品为购物车中已选中的商品
+func loadOrderCarts(order shop.Order) ([]shop.Cart, error) {
+	var cartList []shop.Cart
+	if order.PointGoodsId != 0 { // 积分商品
+		var goodsInfo shop.Goods
+		if err := global.DB.Where("id = ? and goods_area = 1", order.PointGoodsId).Preload("Images").First(&goodsInfo).Error; err != nil {
+			global.SugarLog.Errorf("查询积分商品信息异常, err:%v \n", err)
+			return nil, errors.New("商品查询失败")
+		}
+		c := shop.Cart{
+			GoodsId:    utils.Pointer(order.PointGoodsId),
+			UserId:     order.UserId,
+			SpecType:   0,
+			SpecItemId: 0,
+			Num:        1,
+			Checked:    utils.Pointer(1),
+			Goods:      goodsInfo,
+		}
+		return append(cartList, c), nil
+	}
+	// 获取购物车已选中的商品数据
+	err := global.DB.Where("user_id = ? and checked = 1", order.UserId).Preload("Goods.Images").Preload("SpecValue").Find(&cartList).Error
+	if err != nil || len(cartList) <= 0 {
+		global.SugarLog.Errorf("查询购物车商品信息异常, userId:%d, err:%v \n", *order.UserId, err)
+		return nil, errors.New("商品查询失败")
+	}
+	return cartList, nil
+}
+
+// loadOrderAddress 获取用户的收货地址，未选择收货地址时返回空地址
+func loadOrderAddress(order shop.Order) (address shop.UserAddress, err error) {
+	if order.AddressId <= 0 {
+		return
+	}
+	if errors.Is(global.DB.Where("id = ? and user_id = ?", order.AddressId, order.UserId).First(&address).Error, gorm.ErrRecordNotFound) {
+		return address, errors.New("收货地址不存在")
+	}
+	return
+}
+
+// priceOrder 订单计价，创建订单和订单预览共用，保证预览金额与下单金额一致
+// 计算订单详情、商品总数量、总金额、邮费、赠送积分和积分抵扣金额，结果写入 order
+// 商品失效、库存不足、超出配送范围不会返回错误，记录在 Problems 中由调用方决定如何处理
+func priceOrder(order *shop.Order, cartList []shop.Cart, address shop.UserAddress) (*orderPricing, error) {
+	pointCfg, err := common.GetSysConfig("point")
+	pointSwitch := true
+	if err != nil {
+		if errors.Is(err, common.ErrConfigDisabled) {
+			pointSwitch = false
+		} else {
+			global.SugarLog.Errorf("订单计价时查询积分配置参数异常, err:%v \n", err)
+			return nil, err
+		}
+	}
+	pointPercent := 0
+	if pointSwitch && order.PointGoodsId == 0 {
+		if pointPercent, err = strconv.Atoi(pointCfg); err != nil {
+			global.SugarLog.Errorf("订单计价时转换积分配置参数异常, err:%v \n", err)
+			return nil, err
+		}
+	}
+
+	pricing := &orderPricing{Carts: cartList}
+	order.Num = 0
+	order.Total = 0
+	weight := 0
+	for _, c := range cartList {
+		line := shopResp.OrderPreviewLine{Available: true}
+		line.GoodsId = c.Goods.ID
+		line.SpecId = c.SpecItemId
+		line.Num = c.Num
+		line.GoodsName = c.Goods.Name
+		// 商品已删除 或者多规格商品的规格明细已被删除
+		var problem error
+		if c.Goods.ID == 0 {
+			problem = errors.New("购物车中存在已失效的商品，请重新选择")
+		} else if c.SpecType == 1 && c.SpecValue.ID == 0 {
+			problem = fmt.Errorf("商品【%s】规格已失效，请重新选择", c.Goods.Name)
+		}
+		if problem != nil {
+			global.SugarLog.Errorf("订单计价时商品已失效 cartId:%d, goodsId:%d, specItemId:%d \n", c.ID, c.Goods.ID, c.SpecItemId)
+			line.Available = false
+			line.Warning = problem.Error()
+			pricing.Lines = append(pricing.Lines, line)
+			pricing.Problems = append(pricing.Problems, problem)
+			continue
+		}
+		if c.Goods.Status != nil && *c.Goods.Status == 0 {
+			problem = fmt.Errorf("商品【%s】已下架", c.Goods.Name)
+		} else if store := cartGoodsStore(c); c.Num > store {
+			// 购物车数量大于库存 最终以事务中的条件扣减为准
+			problem = &StockNotEnoughError{GoodsId: c.Goods.ID, SpecValueId: c.SpecItemId, Name: cartGoodsName(c), Num: c.Num, Store: store}
+		}
+		line.Store = cartGoodsStore(c)
+		if problem != nil {
+			line.Available = false
+			line.Warning = problem.Error()
+			pricing.Problems = append(pricing.Problems, problem)
+		}
+
+		// 商品单价 积分商品为所需积分
+		price := cartGoodsPrice(c)
+		originalPrice := cartGoodsCostPrice(c)
+		if order.PointGoodsId != 0 {
+			price = *c.Goods.CostPrice
+			originalPrice = price
+		}
+		// 计算总数量和总重量
+		order.Num = order.Num + c.Num
+		if c.Goods.Weight != nil {
+			weight += *c.Goods.Weight * c.Num
+		}
+		if order.PointGoodsId != 0 { // 积分商品
+			order.Total = price
+		} else {
+			order.Total += float64(c.Num) * price
+		}
+
+		// 组织订单详情数据
+		imgUrl := ""
+		if len(c.Goods.Images) > 0 {
+			imgUrl = c.Goods.Images[0].Url
+		}
+		orderDetail := shop.OrderDetails{}
+		orderDetail.GoodsId = c.Goods.ID
+		orderDetail.GoodsName = c.Goods.Name
+		orderDetail.GoodsImage = imgUrl
+		orderDetail.Unit = c.Goods.Unit
+		orderDetail.Num = c.Num
+		orderDetail.Price = price
+		if order.PointGoodsId != 0 { // 积分商品
+			orderDetail.Total = price
+		} else {
+			// 计算单个商品多个数量的总金额
+			orderDetail.Total = float64(c.Num) * price
+		}
+
+		// 规格 多规格商品记录规格明细id和规格中文名
+		orderDetail.SpecId = c.SpecItemId
+		spec := ""
+		if c.SpecType == 1 {
+			spec = c.SpecValue.KeyName
+		} else {
+			if c.Goods.Weight != nil && *c.Goods.Weight > 0 {
+				spec = fmt.Sprintf("%dg", *c.Goods.Weight)
+			}
+			if strings.TrimSpace(spec) == "" {
+				spec = c.Goods.Unit
+			} else {
+				spec = spec + "/" + c.Goods.Unit
+			}
+		}
+		orderDetail.SpecKeyName = spec
+		// 计算赠送积分
+		if pointPercent > 0 {
+			orderDetail.GiftPoints = orderDetail.Total * (float64(pointPercent) / 100)
+		}
+		pricing.Details = append(pricing.Details, orderDetail)
+
+		line.OrderDetails = orderDetail
+		line.OriginalPrice = originalPrice
+		if order.PointGoodsId == 0 && originalPrice > price {
+			line.Discount = (originalPrice - price) * float64(c.Num)
+		}
+		pricing.Lines = append(pricing.Lines, line)
+	}
+
+	// 配送订单的收货地址必须在配送范围内
+	if *order.ShipmentType == 0 {
+		check, err := deliveryZoneService.CheckAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		if !check.Deliverable {
+			pricing.Problems = append(pricing.Problems, errors.New(check.Message))
+		}
+	}
+
+	// 计算总赠送积分 公式 总金额 * n%
+	order.GiftPoints = 0
+	if pointPercent > 0 {
+		order.GiftPoints = order.Total * (float64(pointPercent) / 100)
+	}
+
+	// 计算邮费 积分商品不收邮费
+	order.Postage = 0
+	if order.PointGoodsId == 0 {
+		order.Postage, err = postageRuleService.CalcPostage(*order.ShipmentType, address, order.Total, weight)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 积分抵扣 积分商品不能再使用积分抵扣
+	if order.PointGoodsId != 0 {
+		order.DeductPoints = 0
+	}
+	order.DeductAmount, pricing.DeductErr = common.CalcPointDeduction(order.DeductPoints, order.Total)
+	return pricing, nil
+}
+
+// PreviewOrder 订单预览，计算价格、邮费、赠送积分和库存情况，不会创建订单或修改任何数据
+func (orderService *OrderService) PreviewOrder(order shop.Order) (resp shopResp.OrderPreviewResp, err error) {
+	if order.ShipmentType == nil {
+		order.ShipmentType = utils.Pointer(0)
+	}
+	address, err := loadOrderAddress(order)
+	if err != nil {
+		return
+	}
+	cartList, err := loadOrderCarts(order)
+	if err != nil {
+		return
+	}
+	pricing, err := priceOrder(&order, cartList, address)
+	if err != nil {
+		return
+	}
+	resp.Lines = pricing.Lines
+	resp.Num = order.Num
+	resp.Total = order.Total
+	resp.Postage = order.Postage
+	resp.GiftPoints = order.GiftPoints
+	resp.Available = len(pricing.Problems) == 0
+	if !resp.Available {
+		resp.Message = pricing.Problems[0].Error()
+	}
+	for _, line := range pricing.Lines {
+		resp.Discount += line.Discount
+	}
+	if pricing.DeductErr != nil {
+		order.DeductAmount = 0
+		if resp.Available {
+			resp.Message = pricing.DeductErr.Error()
+		}
+	} else {
+		resp.DeductPoints = order.DeductPoints
+		resp.DeductAmount = order.DeductAmount
+	}
+	resp.PayAmount = order.PayAmount()
+	return
+}
@@ -0,0 +1,185 @@
+package shop
+
+import (
+	"errors"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	sysModel "fresh-shop/server/model/system"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/payment"
+	"fresh-shop/server/utils"
+	"gorm.io/gorm"
+	"time"
+)
+
+// 支付方式 对应 shop_order.payment
+const (
+	PaymentBalance = 1 // 余额
+	PaymentWechat  = 2 // 微信
+	PaymentAlipay  = 3 // 支付宝
+	PaymentPoint   = 4 // 积分
+)
+
+// 退款状态 对应 shop_order.status_refund
+const (
+	RefundStatusNone    = 0 // 未退款
+	RefundStatusPending = 1 // 退款中
+	RefundStatusSuccess = 2 // 已退款
+	RefundStatusFail    = 3 // 退款失败
+)
+
+// refundOrderTx 按原支付方式退还订单实付金额，必须在事务中调用
+// 余额、积分支付直接退回账户并标记已退款；微信、支付宝支付标记为退款中，提交事务后由 applyPayRefund 发起退款
+func refundOrderTx(tx *gorm.DB, order *shop.Order, actor common.OrderActor, remark string) error {
+	return refundAmountTx(tx, order, order.Finish, order.DeductPoints, actor, remark)
+}
+
+// refundAmountTx 按原支付方式退还指定的金额和抵扣积分，订单变更为退款中或已退款，必须在事务中调用
+// 售后退款时 amount、points 为按售后商品比例分摊的金额
+func refundAmountTx(tx *gorm.DB, order *shop.Order, amount, points float64, actor common.OrderActor, remark string) error {
+	if order.Payment == nil {
+		return errors.New("订单支付方式错误")
+	}
+	// 每次退款使用新的退款单号，第三方支付按退款单号幂等，重复的单号只会返回第一次退款的结果
+	// 退款失败后重新发起时沿用原退款单号，避免同一笔退款重复到账
+	if common.OrderState(*order) != common.OrderStateRefundFailed || order.RefundSn == "" {
+		order.RefundSn = utils.GenerateOrderNumber("RF")
+	}
+	refunded, err := refundAccountTx(tx, *order, amount, points, remark)
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{"refund_sn": order.RefundSn}
+	state := common.OrderStateRefunding
+	if refunded {
+		state = common.OrderStateRefunded
+		values["refund_time"] = time.Now()
+	}
+	return common.TransitOrder(tx, order, state, values, actor, remark)
+}
+
+// refundReturnTx 售后部分退款，按原支付方式退还售后的金额和抵扣积分，订单状态不变，必须在事务中调用
+// 余额、积分支付直接退回账户并标记售后已退款；微信、支付宝支付标记售后退款中，提交事务后由 applyReturnRefund 发起退款
+func refundReturnTx(tx *gorm.DB, order shop.Order, orderReturn *shop.OrderReturn, amount, points float64) error {
+	if order.Payment == nil {
+		return errors.New("订单支付方式错误")
+	}
+	orderReturn.RefundSn = utils.GenerateOrderNumber("RF")
+	refunded, err := refundAccountTx(tx, order, amount, points, "售后退款")
+	if err != nil {
+		return err
+	}
+	values := map[string]interface{}{"refund_sn": orderReturn.RefundSn, "refund_status": RefundStatusPending}
+	if refunded {
+		values["refund_status"] = RefundStatusSuccess
+		values["refund_time"] = time.Now()
+	}
+	return tx.Model(&shop.OrderReturn{}).Where("id = ?", orderReturn.ID).Updates(values).Error
+}
+
+// refundAccountTx 抵扣的积分和余额、积分支付的金额直接退回账户，第三方支付的金额需要另外发起退款
+// refunded 为 true 时表示已全部退款
+func refundAccountTx(tx *gorm.DB, order shop.Order, amount, points float64, remark string) (refunded bool, err error) {
+	var user sysModel.SysUser
+	findUser := func() error {
+		if user.ID > 0 {
+			return nil
+		}
+		if err := tx.Where("id = ?", *order.UserId).First(&user).Error; err != nil {
+			return errors.New("用户不存在")
+		}
+		return nil
+	}
+	// 积分抵扣的部分直接退回积分账户
+	if points > 0 && *order.Payment != PaymentPoint {
+		if err = findUser(); err != nil {
+			return
+		}
+		f := common.NewFinance(common.OptionTypeCASH, common.FinanceTypeOrderRefund, user.ID, user.Username, points, order.OrderSn, user.ID, user.Username, remark)
+		if err = common.AccountUnifyDeductionTx(tx, common.POINT, f); err != nil {
+			return
+		}
+	}
+	switch *order.Payment {
+	case PaymentBalance, PaymentPoint:
+		groupId := common.CASH
+		if *order.Payment == PaymentPoint {
+			groupId = common.POINT
+		}
+		if amount > 0 {
+			if err = findUser(); err != nil {
+				return
+			}
+			f := common.NewFinance(common.OptionTypeCASH, common.FinanceTypeOrderRefund, user.ID, user.Username, amount, order.OrderSn, user.ID, user.Username, remark)
+			if err = common.AccountUnifyDeductionTx(tx, groupId, f); err != nil {
+				return
+			}
+		}
+		return true, nil
+	case PaymentWechat, PaymentAlipay:
+		return false, nil
+	}
+	return false, errors.New("暂不支持该支付方式退款")
+}
+
+// orderProvider 获取订单支付方式对应的第三方支付，余额、积分支付或未配置时 ok 为 false
+func orderProvider(order shop.Order) (provider payment.Provider, ok bool) {
+	if order.Payment == nil {
+		return nil, false
+	}
+	provider, err := payment.Get(*order.Payment)
+	return provider, err == nil
+}
+
+// refundProvider 获取退款需要的第三方支付，余额、积分支付返回 nil
+// 微信、支付宝支付未配置时返回错误，需要在标记退款中之前检查，避免订单一直处于退款中
+func refundProvider(order shop.Order) (payment.Provider, error) {
+	if order.Payment == nil || (*order.Payment != PaymentWechat && *order.Payment != PaymentAlipay) {
+		return nil, nil
+	}
+	provider, ok := orderProvider(order)
+	if !ok {
+		return nil, errors.New("支付方式未配置，无法退款")
+	}
+	return provider, nil
+}
+
+// applyPayRefund 向第三方支付申请退款 amount 为退款金额，申请失败时标记为退款失败
+func applyPayRefund(provider payment.Provider, order shop.Order, amount float64, desc string) error {
+	err := provider.Refund(payment.RefundReq{
+		OrderSn:  order.OrderSn,
+		RefundSn: order.RefundSn,
+		Total:    order.Finish,
+		Amount:   amount,
+		Desc:     desc,
+	})
+	if err != nil {
+		txErr := global.DB.Transaction(func(tx *gorm.DB) error {
+			return common.TransitOrder(tx, &order, common.OrderStateRefundFailed, nil, common.OrderActor{Type: common.ActorSystem}, "退款申请失败："+err.Error())
+		})
+		if txErr != nil {
+			global.SugarLog.Errorf("更新退款失败状态失败 orderId:%d, err:%v \n", order.ID, txErr)
+		}
+		return errors.New("退款申请失败，请联系客服")
+	}
+	return nil
+}
+
+// applyReturnRefund 向第三方支付申请售后部分退款，申请失败时标记售后退款失败
+func applyReturnRefund(provider payment.Provider, order shop.Order, orderReturn shop.OrderReturn, amount float64) error {
+	err := provider.Refund(payment.RefundReq{
+		OrderSn:  order.OrderSn,
+		RefundSn: orderReturn.RefundSn,
+		Total:    order.Finish,
+		Amount:   amount,
+		Desc:     "售后退款",
+	})
+	if err != nil {
+		if txErr := global.DB.Model(&shop.OrderReturn{}).Where("id = ? and refund_status = ?", orderReturn.ID, RefundStatusPending).
+			Update("refund_status", RefundStatusFail).Error; txErr != nil {
+			global.SugarLog.Errorf("更新售后退款失败状态失败 returnId:%d, err:%v \n", orderReturn.ID, txErr)
+		}
+		return errors.New("退款申请失败，请联系客服")
+	}
+	return nil
+}
@@ -1,15 +1,35 @@
 package shop
 
 import (
+	"errors"
+	"fmt"
 	"fresh-shop/server/global"
 	"fresh-shop/server/model/common/request"
 	"fresh-shop/server/model/shop"
 	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/payment"
+	"fresh-shop/server/utils"
+	"fresh-shop/server/utils/upload"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"mime/multipart"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type OrderReturnService struct {
 }
 
+// orderReturnProcessColumns 处理售后申请时更新的字段
+var orderReturnProcessColumns = []string{"amount", "points", "status", "refund_status", "refund_sn", "refund_time", "restock", "process_time"}
+
+// maxReturnImages 售后申请最多上传的图片数量
+const maxReturnImages = 6
+
 // CreateOrderReturn 创建OrderReturn记录
 // Author [dalefeng](https://github.com/dalefeng)
 func (orderReturnService *OrderReturnService) CreateOrderReturn(orderReturn shop.OrderReturn) (err error) {
@@ -31,18 +51,23 @@ func (orderReturnService *OrderReturnService) DeleteOrderReturnByIds(ids request
 	return err
 }
 
-// UpdateOrderReturn 更新OrderReturn记录
+// UpdateOrderReturn 更新OrderReturn记录 售后状态和退款金额只能通过处理售后申请修改
 // Author [dalefeng](https://github.com/dalefeng)
 func (orderReturnService *OrderReturnService) UpdateOrderReturn(orderReturn shop.OrderReturn) (err error) {
-	err = global.DB.Save(&orderReturn).Error
+	err = global.DB.Omit(append(orderReturnProcessColumns, clause.Associations)...).Save(&orderReturn).Error
 	return err
 }
 
 // GetOrderReturn 根据id获取OrderReturn记录
 // Author [dalefeng](https://github.com/dalefeng)
 func (orderReturnService *OrderReturnService) GetOrderReturn(id uint) (orderReturn shop.OrderReturn, err error) {
-	err = global.DB.Where("id = ?", id).First(&orderReturn).Error
-	return
+	err = global.DB.Where("id = ?", id).Preload("Details").First(&orderReturn).Error
+	if err != nil {
+		return
+	}
+	returns := []shop.OrderReturn{orderReturn}
+	err = loadReturnDelivery(returns)
+	return returns[0], err
 }
 
 // GetOrderReturnInfoList 分页获取OrderReturn记录
@@ -71,6 +96,333 @@ func (orderReturnService *OrderReturnService) GetOrderReturnInfoList(info shopRe
 		return
 	}
 
-	err = db.Limit(limit).Offset(offset).Find(&orderReturns).Error
+	err = db.Order("id desc").Limit(limit).Offset(offset).Preload("Details").Find(&orderReturns).Error
+	if err != nil {
+		return
+	}
+	err = loadReturnDelivery(orderReturns)
 	return orderReturns, total, err
 }
+
+// loadReturnDelivery 查询售后订单的配送信息 售后审核时查看送达凭证
+func loadReturnDelivery(orderReturns []shop.OrderReturn) error {
+	orderIds := make([]int, 0, len(orderReturns))
+	for _, r := range orderReturns {
+		if r.OrderId != nil {
+			orderIds = append(orderIds, *r.OrderId)
+		}
+	}
+	if len(orderIds) == 0 {
+		return nil
+	}
+	var deliveries []shop.OrderDelivery
+	if err := global.DB.Where("order_id in ?", orderIds).Find(&deliveries).Error; err != nil {
+		return err
+	}
+	for i := range deliveries {
+		for j := range orderReturns {
+			if orderReturns[j].OrderId != nil && deliveries[i].OrderId != nil && *orderReturns[j].OrderId == *deliveries[i].OrderId {
+				orderReturns[j].Delivery = &deliveries[i]
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyOrderReturn 用户申请售后 已收货的订单可以申请，被拒绝后可以重新申请
+// 部分商品售后退款后可以继续对剩余的商品申请售后，同一时间只能有一个待处理的申请
+// 按售后商品数量计算退款金额，管理员同意后退款
+func (orderReturnService *OrderReturnService) ApplyOrderReturn(req shopReq.OrderReturnApplyReq, userId uint) (orderReturn shop.OrderReturn, err error) {
+	if len(req.Images) > maxReturnImages {
+		return orderReturn, errors.New("售后图片最多上传 6 张")
+	}
+	prefix := upload.FileUrlPrefix()
+	for _, img := range req.Images {
+		if !strings.HasPrefix(img, prefix) || len(img) == len(prefix) || strings.Contains(img, "..") || strings.Contains(img, ",") {
+			return orderReturn, errors.New("售后图片地址错误，请重新上传")
+		}
+	}
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		var order shop.Order
+		if errors.Is(tx.Where("id = ? and user_id = ?", req.OrderId, userId).First(&order).Error, gorm.ErrRecordNotFound) {
+			return errors.New("订单不存在")
+		}
+		if common.OrderState(order) != common.OrderStateReceived {
+			return errors.New("只有已收货的订单可以申请售后")
+		}
+		var count int64
+		if err := tx.Model(&shop.OrderReturn{}).Where("order_id = ? and status = ?", order.ID, shop.ReturnStatusPending).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errors.New("订单已申请售后，请勿重复申请")
+		}
+		// 重新申请时使用被拒绝的申请
+		err := tx.Where("order_id = ? and status = ?", order.ID, shop.ReturnStatusRejected).Order("id desc").First(&orderReturn).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		details, amount, points, _, err := calcReturnRefund(tx, order, orderReturn.ID, req.Details)
+		if err != nil {
+			return err
+		}
+		// 重新申请时删除之前的售后商品
+		if orderReturn.ID > 0 {
+			if err = tx.Where("return_id = ?", orderReturn.ID).Delete(&shop.OrderReturnDetails{}).Error; err != nil {
+				return err
+			}
+		}
+		orderReturn.UserId = utils.Pointer(int(userId))
+		orderReturn.OrderId = utils.Pointer(int(order.ID))
+		orderReturn.Reason = req.Reason
+		orderReturn.Images = strings.Join(req.Images, ",")
+		orderReturn.Amount = utils.Pointer(amount)
+		orderReturn.Points = points
+		orderReturn.Status = utils.Pointer(shop.ReturnStatusPending)
+		orderReturn.RefundStatus = utils.Pointer(RefundStatusNone)
+		orderReturn.RefundSn = ""
+		orderReturn.RefundTime = nil
+		orderReturn.Reply = ""
+		orderReturn.ProcessTime = nil
+		orderReturn.Details = nil
+		if err = tx.Omit(clause.Associations).Save(&orderReturn).Error; err != nil {
+			return err
+		}
+		for i := range details {
+			details[i].ReturnId = utils.Pointer(int(orderReturn.ID))
+		}
+		if err = tx.Create(&details).Error; err != nil {
+			return err
+		}
+		orderReturn.Details = details
+		return nil
+	})
+	return
+}
+
+// ProcessOrderReturn 处理售后申请 拒绝时只记录售后说明
+// 同意时重新计算退款金额，按原支付方式退款并可选归还库存
+// 订单商品全部售后时订单变更为退款中或已退款，部分商品售后时订单保持已收货，只记录售后的退款状态
+func (orderReturnService *OrderReturnService) ProcessOrderReturn(req shopReq.OrderReturnProcessReq, actor common.OrderActor) (err error) {
+	var orderReturn shop.OrderReturn
+	if errors.Is(global.DB.Where("id = ?", req.ID).Preload("Details").First(&orderReturn).Error, gorm.ErrRecordNotFound) {
+		return errors.New("售后申请不存在")
+	}
+	if orderReturn.Status == nil || *orderReturn.Status != shop.ReturnStatusPending {
+		return errors.New("售后申请已处理")
+	}
+	now := time.Now()
+	if !req.Approve {
+		result := global.DB.Model(&shop.OrderReturn{}).Where("id = ? and status = ?", orderReturn.ID, shop.ReturnStatusPending).
+			Updates(map[string]interface{}{"status": shop.ReturnStatusRejected, "reply": req.Reply, "process_time": now})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return errors.New("售后申请已处理")
+		}
+		return result.Error
+	}
+
+	var order shop.Order
+	var amount float64
+	var full bool
+	var provider payment.Provider
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		// 以待处理为条件更新，重复处理时只有一个能成功
+		result := tx.Model(&shop.OrderReturn{}).Where("id = ? and status = ?", orderReturn.ID, shop.ReturnStatusPending).
+			Updates(map[string]interface{}{"status": shop.ReturnStatusApproved, "reply": req.Reply, "process_time": now, "restock": boolInt(req.Restock)})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("售后申请已处理")
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", orderReturn.OrderId).First(&order).Error; err != nil {
+			return errors.New("订单不存在")
+		}
+		if common.OrderState(order) != common.OrderStateReceived {
+			return fmt.Errorf("订单%s，不能售后退款", common.OrderStateName(common.OrderState(order)))
+		}
+		// 第三方支付未配置时不能退款，售后保持待处理
+		if provider, err = refundProvider(order); err != nil {
+			return err
+		}
+		lines := make([]shopReq.OrderReturnLine, 0, len(orderReturn.Details))
+		for _, d := range orderReturn.Details {
+			lines = append(lines, shopReq.OrderReturnLine{OrderDetailId: uint(*d.OrderDetailId), Num: *d.Num})
+		}
+		details, refundAmount, points, isFull, err := calcReturnRefund(tx, order, orderReturn.ID, lines)
+		if err != nil {
+			return err
+		}
+		amount, full = refundAmount, isFull
+		for _, d := range details {
+			if err = tx.Model(&shop.OrderReturnDetails{}).Where("return_id = ? and order_detail_id = ?", orderReturn.ID, d.OrderDetailId).
+				Update("amount", d.Amount).Error; err != nil {
+				return err
+			}
+		}
+		if err = tx.Model(&shop.OrderReturn{}).Where("id = ?", orderReturn.ID).
+			Updates(map[string]interface{}{"amount": amount, "points": points}).Error; err != nil {
+			return err
+		}
+		if req.Restock {
+			if err = restoreReturnStock(tx, orderReturn, actor); err != nil {
+				return err
+			}
+		}
+		if !full {
+			return refundReturnTx(tx, order, &orderReturn, amount, points)
+		}
+		// 最后一次售后退还剩余的实付金额，订单变更为退款中或已退款，售后使用订单的退款单号
+		if err = refundAmountTx(tx, &order, amount, points, actor, "售后退款"); err != nil {
+			return err
+		}
+		values := map[string]interface{}{"refund_sn": order.RefundSn, "refund_status": *order.StatusRefund}
+		if *order.StatusRefund == RefundStatusSuccess {
+			values["refund_time"] = now
+		}
+		return tx.Model(&shop.OrderReturn{}).Where("id = ?", orderReturn.ID).Updates(values).Error
+	})
+	if err != nil {
+		global.SugarLog.Errorf("处理售后申请失败 returnId:%d, err:%v \n", orderReturn.ID, err)
+		return err
+	}
+	if provider == nil {
+		return nil
+	}
+	if full {
+		err = applyPayRefund(provider, order, amount, "售后退款")
+	} else {
+		err = applyReturnRefund(provider, order, orderReturn, amount)
+	}
+	if err != nil {
+		return errors.New("已同意售后，" + err.Error())
+	}
+	return nil
+}
+
+// UploadReturnImage 上传售后图片 返回图片地址
+func (orderReturnService *OrderReturnService) UploadReturnImage(header *multipart.FileHeader) (url string, err error) {
+	if !uploadImageExts[strings.ToLower(path.Ext(header.Filename))] {
+		return "", errors.New("售后图片只能上传 jpg、png、webp 格式的图片")
+	}
+	url, _, err = newOss().UploadFile(header)
+	if err != nil {
+		global.SugarLog.Errorf("上传售后图片失败 err:%v \n", err)
+		return "", errors.New("上传图片失败")
+	}
+	return
+}
+
+// calcReturnRefund 按售后商品数量计算退款金额和退还的抵扣积分，returnId 为当前售后申请，不计入已售后的数量
+// 每件商品按 商品金额 / 订单商品总金额 的比例分摊实付金额和抵扣积分，实付金额包含邮费并已扣除积分抵扣金额
+// 订单商品全部售后时 full 为 true，退还剩余的全部实付金额和抵扣积分，lines 为空时为剩余商品全部售后
+func calcReturnRefund(tx *gorm.DB, order shop.Order, returnId uint, lines []shopReq.OrderReturnLine) (details []shop.OrderReturnDetails, amount, points float64, full bool, err error) {
+	var orderDetails []shop.OrderDetails
+	if err = tx.Where("order_id = ?", order.ID).Find(&orderDetails).Error; err != nil {
+		return
+	}
+	// 已同意的售后退还的商品数量、金额和积分
+	var returned []shop.OrderReturn
+	if err = tx.Where("order_id = ? and status = ? and id <> ?", order.ID, shop.ReturnStatusApproved, returnId).Preload("Details").Find(&returned).Error; err != nil {
+		return
+	}
+	returnedNum := make(map[uint]int, len(orderDetails))
+	returnedAmount, returnedPoints := 0.0, 0.0
+	for _, r := range returned {
+		if r.Amount != nil {
+			returnedAmount += *r.Amount
+		}
+		returnedPoints += r.Points
+		for _, d := range r.Details {
+			returnedNum[uint(*d.OrderDetailId)] += *d.Num
+		}
+	}
+	if len(lines) == 0 {
+		for _, d := range orderDetails {
+			if num := d.Num - returnedNum[d.ID]; num > 0 {
+				lines = append(lines, shopReq.OrderReturnLine{OrderDetailId: d.ID, Num: num})
+			}
+		}
+		if len(lines) == 0 {
+			return nil, 0, 0, false, errors.New("订单商品已全部售后")
+		}
+	}
+	detailMap := make(map[uint]shop.OrderDetails, len(orderDetails))
+	for _, d := range orderDetails {
+		detailMap[d.ID] = d
+	}
+	used := make(map[uint]bool, len(lines))
+	returnTotal := 0.0
+	for _, l := range lines {
+		d, ok := detailMap[l.OrderDetailId]
+		if !ok {
+			return nil, 0, 0, false, errors.New("售后商品不属于该订单")
+		}
+		if used[l.OrderDetailId] {
+			return nil, 0, 0, false, errors.New("售后商品重复")
+		}
+		used[l.OrderDetailId] = true
+		if remain := d.Num - returnedNum[d.ID]; l.Num <= 0 || l.Num > remain {
+			return nil, 0, 0, false, fmt.Errorf("商品【%s】售后数量错误，最多可申请 %d 件", d.GoodsName, remain)
+		}
+		returnedNum[d.ID] += l.Num
+		lineTotal := d.Total * float64(l.Num) / float64(d.Num)
+		returnTotal += lineTotal
+		lineAmount := 0.0
+		if order.Total > 0 {
+			lineAmount = roundAmount(order.Finish * lineTotal / order.Total)
+		}
+		details = append(details, shop.OrderReturnDetails{
+			OrderDetailId: utils.Pointer(int(d.ID)),
+			Num:           utils.Pointer(l.Num),
+			GoodsName:     d.GoodsName,
+			Amount:        lineAmount,
+		})
+		amount += lineAmount
+	}
+	full = true
+	for _, d := range orderDetails {
+		if returnedNum[d.ID] < d.Num {
+			full = false
+			break
+		}
+	}
+	remainAmount := roundAmount(order.Finish - returnedAmount)
+	remainPoints := roundAmount(order.DeductPoints - returnedPoints)
+	if full {
+		// 订单商品全部售后 分摊的误差计入最后一件商品
+		details[len(details)-1].Amount = roundAmount(details[len(details)-1].Amount + remainAmount - amount)
+		return details, remainAmount, remainPoints, true, nil
+	}
+	if order.Total > 0 {
+		points = math.Min(roundAmount(order.DeductPoints*returnTotal/order.Total), remainPoints)
+	}
+	return details, math.Min(roundAmount(amount), remainAmount), points, false, nil
+}
+
+// restoreReturnStock 售后退货归还库存
+func restoreReturnStock(tx *gorm.DB, orderReturn shop.OrderReturn, actor common.OrderActor) error {
+	ref := stockRef{Reason: shop.StockReasonReturn, RefId: strconv.Itoa(int(orderReturn.ID)), Actor: actor}
+	for _, rd := range orderReturn.Details {
+		var d shop.OrderDetails
+		if err := tx.Where("id = ?", rd.OrderDetailId).First(&d).Error; err != nil {
+			return err
+		}
+		if err := restoreGoodsStock(tx, d.GoodsId, d.SpecId, *rd.Num, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roundAmount 金额保留两位小数
+func roundAmount(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,256 @@
+package shop
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/payment"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+// createReturnTestOrder 创建已收货的余额支付订单 商品金额 50 邮费 5 积分抵扣 10 积分抵 1 元 实付 54
+func createReturnTestOrder(t *testing.T, userId uint) (shop.Order, []shop.OrderDetails, shop.Goods) {
+	goods := shop.Goods{Name: "草莓", SpecType: utils.Pointer(0), Unit: "盒", Store: utils.Pointer(10), Sale: utils.Pointer(3)}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	order := shop.Order{
+		OrderSn:      "return-1",
+		UserId:       utils.Pointer(int(userId)),
+		GoodsArea:    utils.Pointer(0),
+		Status:       utils.Pointer(3),
+		StatusCancel: utils.Pointer(0),
+		StatusRefund: utils.Pointer(0),
+		Payment:      utils.Pointer(PaymentBalance),
+		ReceiveTime:  utils.Pointer(time.Now()),
+		Num:          3,
+		Total:        50,
+		Postage:      5,
+		DeductPoints: 10,
+		DeductAmount: 1,
+		Finish:       54,
+	}
+	assert.Nil(t, global.DB.Create(&order).Error)
+	details := []shop.OrderDetails{
+		{OrderId: order.ID, GoodsId: goods.ID, GoodsName: "草莓", Num: 2, Price: 10, Total: 20},
+		{OrderId: order.ID, GoodsId: goods.ID, GoodsName: "蓝莓", Num: 1, Price: 30, Total: 30},
+	}
+	assert.Nil(t, global.DB.Create(&details).Error)
+	return order, details, goods
+}
+
+func TestOrderReturnService_PartialReturn(t *testing.T) {
+	setupOrderTestDB(t)
+	userId := createTestUser(t, "return-buyer")
+	createTestAccount(t, userId, common.CASH, 0)
+	createTestAccount(t, userId, common.POINT, 0)
+	order, details, goods := createReturnTestOrder(t, userId)
+	service := OrderReturnService{}
+
+	_, err := service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果",
+		Details: []shopReq.OrderReturnLine{{OrderDetailId: details[0].ID, Num: 3}}}, userId)
+	assert.NotNil(t, err)
+	_, err = service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果"}, userId+1)
+	assert.NotNil(t, err)
+
+	// 售后图片只能使用上传的文件地址
+	localPath := global.Config.Local.Path
+	global.Config.Local.Path = "uploads/file"
+	defer func() { global.Config.Local.Path = localPath }()
+	for _, img := range []string{"https://example.com/a.jpg", "uploads/file/../a.jpg", "uploads/file/", "uploads/filex.jpg"} {
+		_, err = service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果", Images: []string{img},
+			Details: []shopReq.OrderReturnLine{{OrderDetailId: details[0].ID, Num: 1}}}, userId)
+		assert.NotNil(t, err, img)
+	}
+
+	// 退 1 盒草莓 占商品金额的 20%
+	applied, err := service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果", Images: []string{"uploads/file/a.jpg", "uploads/file/b.jpg"},
+		Details: []shopReq.OrderReturnLine{{OrderDetailId: details[0].ID, Num: 1}}}, userId)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.8, *applied.Amount)
+	assert.Equal(t, 2.0, applied.Points)
+	assert.Equal(t, "uploads/file/a.jpg,uploads/file/b.jpg", applied.Images)
+	_, err = service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果"}, userId)
+	assert.NotNil(t, err)
+
+	// 拒绝后可以重新申请
+	assert.Nil(t, service.ProcessOrderReturn(shopReq.OrderReturnProcessReq{ID: applied.ID, Reply: "请提供照片"}, common.OrderActor{Type: common.ActorAdmin}))
+	assert.NotNil(t, service.ProcessOrderReturn(shopReq.OrderReturnProcessReq{ID: applied.ID, Approve: true}, common.OrderActor{Type: common.ActorAdmin}))
+	reapplied, err := service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果",
+		Details: []shopReq.OrderReturnLine{{OrderDetailId: details[0].ID, Num: 1}}}, userId)
+	assert.Nil(t, err)
+	assert.Equal(t, applied.ID, reapplied.ID)
+
+	assert.Nil(t, service.ProcessOrderReturn(shopReq.OrderReturnProcessReq{ID: reapplied.ID, Approve: true, Reply: "同意退款", Restock: true}, common.OrderActor{Type: common.ActorAdmin, Id: 1}))
+	assert.Equal(t, 10.8, getTestAccountAmount(userId, common.CASH))
+	assert.Equal(t, 2.0, getTestAccountAmount(userId, common.POINT))
+
+	global.DB.First(&goods, goods.ID)
+	assert.Equal(t, 11, *goods.Store)
+	assert.Equal(t, 2, *goods.Sale)
+
+	// 部分退款后订单仍为已收货
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStateReceived, common.OrderState(order))
+	assert.Equal(t, "", order.RefundSn)
+	processed, err := service.GetOrderReturn(reapplied.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, shop.ReturnStatusApproved, *processed.Status)
+	assert.Equal(t, RefundStatusSuccess, *processed.RefundStatus)
+	assert.NotEqual(t, "", processed.RefundSn)
+	assert.NotNil(t, processed.RefundTime)
+	assert.Equal(t, 1, *processed.Restock)
+	assert.Len(t, processed.Details, 1)
+	assert.Equal(t, 10.8, processed.Details[0].Amount)
+
+	// 重复处理不会重复退款
+	assert.NotNil(t, service.ProcessOrderReturn(shopReq.OrderReturnProcessReq{ID: reapplied.ID, Approve: true}, common.OrderActor{Type: common.ActorAdmin}))
+	assert.Equal(t, 10.8, getTestAccountAmount(userId, common.CASH))
+
+	// 已售后的商品不能再次申请
+	_, err = service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果",
+		Details: []shopReq.OrderReturnLine{{OrderDetailId: details[0].ID, Num: 2}}}, userId)
+	assert.NotNil(t, err)
+
+	// 剩余商品全部售后 退还剩余的实付金额和抵扣积分，订单变更为已退款
+	rest, err := service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "不想要了"}, userId)
+	assert.Nil(t, err)
+	assert.NotEqual(t, applied.ID, rest.ID)
+	assert.Len(t, rest.Details, 2)
+	assert.Equal(t, 43.2, *rest.Amount)
+	assert.Equal(t, 8.0, rest.Points)
+	assert.Nil(t, service.ProcessOrderReturn(shopReq.OrderReturnProcessReq{ID: rest.ID, Approve: true}, common.OrderActor{Type: common.ActorAdmin}))
+	assert.Equal(t, 54.0, getTestAccountAmount(userId, common.CASH))
+	assert.Equal(t, 10.0, getTestAccountAmount(userId, common.POINT))
+
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStateRefunded, common.OrderState(order))
+	restProcessed, err := service.GetOrderReturn(rest.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, RefundStatusSuccess, *restProcessed.RefundStatus)
+	assert.Equal(t, order.RefundSn, restProcessed.RefundSn)
+	assert.NotEqual(t, processed.RefundSn, restProcessed.RefundSn)
+	// 第一次售后的退款状态不受订单退款影响
+	processed, _ = service.GetOrderReturn(reapplied.ID)
+	assert.Equal(t, RefundStatusSuccess, *processed.RefundStatus)
+
+	_, err = service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果"}, userId)
+	assert.NotNil(t, err)
+}
+
+func TestOrderReturnService_FullReturn(t *testing.T) {
+	setupOrderTestDB(t)
+	userId := createTestUser(t, "return-all")
+	createTestAccount(t, userId, common.CASH, 0)
+	createTestAccount(t, userId, common.POINT, 0)
+	order, _, goods := createReturnTestOrder(t, userId)
+	service := OrderReturnService{}
+
+	applied, err := service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "不想要了"}, userId)
+	assert.Nil(t, err)
+	assert.Equal(t, 54.0, *applied.Amount)
+	assert.Equal(t, 10.0, applied.Points)
+	assert.Len(t, applied.Details, 2)
+	assert.Equal(t, 54.0, applied.Details[0].Amount+applied.Details[1].Amount)
+
+	assert.Nil(t, service.ProcessOrderReturn(shopReq.OrderReturnProcessReq{ID: applied.ID, Approve: true}, common.OrderActor{Type: common.ActorAdmin}))
+	assert.Equal(t, 54.0, getTestAccountAmount(userId, common.CASH))
+	assert.Equal(t, 10.0, getTestAccountAmount(userId, common.POINT))
+	// 未选择归还库存
+	global.DB.First(&goods, goods.ID)
+	assert.Equal(t, 10, *goods.Store)
+
+	var log shop.OrderLog
+	global.DB.Where("order_id = ? and to_state = ?", order.ID, common.OrderStateRefunded).First(&log)
+	assert.Equal(t, "售后退款", log.Reason)
+}
+
+func TestOrderReturnService_PartialReturnWechat(t *testing.T) {
+	setupOrderTestDB(t)
+	mock := payment.NewMock(payment.Wechat, config.PayMock{Result: payment.MockResultSuccess, SkipNotify: true})
+	payment.Register(payment.Wechat, mock)
+	defer payment.Register(payment.Wechat, nil)
+	userId := createTestUser(t, "return-wechat")
+	createTestAccount(t, userId, common.POINT, 0)
+	order, details, _ := createReturnTestOrder(t, userId)
+	global.DB.Model(&order).Updates(map[string]interface{}{"payment": PaymentWechat, "transation_id": "tx-return"})
+	_, err := mock.Prepay(payment.PrepayReq{OrderId: order.ID, OrderSn: order.OrderSn, Amount: order.Finish})
+	assert.Nil(t, err)
+	service := OrderReturnService{}
+
+	applied, err := service.ApplyOrderReturn(shopReq.OrderReturnApplyReq{OrderId: order.ID, Reason: "坏果",
+		Details: []shopReq.OrderReturnLine{{OrderDetailId: details[0].ID, Num: 1}}}, userId)
+	assert.Nil(t, err)
+	// 微信支付未配置时不能同意售后，售后保持待处理，不退还积分
+	payment.Register(payment.Wechat, nil)
+	assert.EqualError(t, service.ProcessOrderReturn(shopReq.OrderReturnProcessReq{ID: applied.ID, Approve: true}, common.OrderActor{Type: common.ActorAdmin}), "支付方式未配置，无法退款")
+	processed, _ := service.GetOrderReturn(applied.ID)
+	assert.Equal(t, shop.ReturnStatusPending, *processed.Status)
+	assert.Equal(t, "", processed.RefundSn)
+	assert.Equal(t, 0.0, getTestAccountAmount(userId, common.POINT))
+
+	payment.Register(payment.Wechat, mock)
+	assert.Nil(t, service.ProcessOrderReturn(shopReq.OrderReturnProcessReq{ID: applied.ID, Approve: true}, common.OrderActor{Type: common.ActorAdmin}))
+	processed, _ = service.GetOrderReturn(applied.ID)
+	assert.Equal(t, RefundStatusPending, *processed.RefundStatus)
+	assert.Equal(t, 2.0, getTestAccountAmount(userId, common.POINT))
+
+	// 售后退款通知只更新售后的退款状态 订单仍为已收货
+	result := payment.RefundResult{OrderSn: order.OrderSn, RefundSn: processed.RefundSn, Status: payment.RefundSuccess, SuccessTime: time.Now()}
+	assert.Nil(t, payment.RefundLogic(payment.Wechat, result))
+	assert.Nil(t, payment.RefundLogic(payment.Wechat, result))
+	processed, _ = service.GetOrderReturn(applied.ID)
+	assert.Equal(t, RefundStatusSuccess, *processed.RefundStatus)
+	assert.NotNil(t, processed.RefundTime)
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStateReceived, common.OrderState(order))
+
+	result.RefundSn = "unknown"
+	assert.NotNil(t, payment.RefundLogic(payment.Wechat, result))
+}
+
+func TestOrderService_OrderWithReturns(t *testing.T) {
+	setupOrderTestDB(t)
+	userId := createTestUser(t, "returns-buyer")
+	order, details, _ := createReturnTestOrder(t, userId)
+	// 第一次售后被拒绝后再次申请
+	for _, status := range []int{shop.ReturnStatusRejected, shop.ReturnStatusPending} {
+		orderReturn := shop.OrderReturn{OrderId: utils.Pointer(int(order.ID)), UserId: utils.Pointer(int(userId)), Reason: "坏果", Status: utils.Pointer(status),
+			Details: []shop.OrderReturnDetails{{OrderDetailId: utils.Pointer(int(details[0].ID)), Num: utils.Pointer(1)}}}
+		assert.Nil(t, global.DB.Create(&orderReturn).Error)
+	}
+	service := OrderService{}
+
+	// 多次售后的订单在列表中只出现一次
+	for _, status := range []*int{nil, utils.Pointer(10)} {
+		list, total, err := service.GetOrderInfoList(shopReq.OrderSearch{Order: shop.Order{Status: status}, PageInfo: request.PageInfo{Page: 1, PageSize: 10}})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), total)
+		assert.Len(t, list, 1)
+		assert.Len(t, list[0].OrderReturns, 2)
+		assert.Equal(t, shop.ReturnStatusPending, *list[0].OrderReturn.Status)
+	}
+
+	info, err := service.GetOrder(order.ID)
+	assert.Nil(t, err)
+	assert.Len(t, info.OrderReturns, 2)
+	assert.Equal(t, shop.ReturnStatusPending, *info.OrderReturn.Status)
+	assert.Equal(t, shop.ReturnStatusRejected, *info.OrderReturns[1].Status)
+	assert.Len(t, info.OrderReturns[1].Details, 1)
+
+	var buf bytes.Buffer
+	assert.Nil(t, service.ExportOrders(shopReq.OrderSearch{Order: shop.Order{Status: utils.Pointer(10)}}, &buf))
+	f, err := excelize.OpenReader(&buf)
+	assert.Nil(t, err)
+	defer f.Close()
+	rows, err := f.GetRows(orderExportSheet)
+	assert.Nil(t, err)
+	assert.Len(t, rows, 2)
+}
@@ -0,0 +1,796 @@
+package shop
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/account"
+	"fresh-shop/server/model/business"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/model/system"
+	systemReq "fresh-shop/server/model/system/request"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/payment"
+	"fresh-shop/server/utils"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupOrderTestDB 使用 sqlite 初始化订单相关的表
+// sqlite 同一时间只允许一个写事务，这里限制为一个连接，事务外的读取仍然会和其他事务交错执行
+func setupOrderTestDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "order.db")), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	err = db.AutoMigrate(
+		system.SysConfig{}, shop.Goods{}, shop.GoodsImage{}, shop.GoodsSpecValue{},
+		shop.Cart{}, shop.Order{}, shop.OrderDetails{}, shop.UserAddress{}, shop.PostageRule{},
+		shop.PickUpSequence{}, shop.OrderDelivery{}, shop.OrderLog{}, shop.OrderReturn{}, shop.OrderReturnDetails{},
+		business.UserDelivery{}, shop.DeliverySlot{}, shop.DeliveryZone{}, shop.PayNotify{},
+		shop.PayBill{}, shop.PayBillItem{}, shop.StockLog{}, shop.PayDuplicate{}, account.UserFinanceType{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Table("sys_users").AutoMigrate(&struct {
+		global.DbModel
+		Username     string
+		Enable       int
+		SafePassword string
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 账户及流水表
+	err = db.Migrator().CreateTable(account.AccountGroup{}, account.Account{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range []account.AccountGroup{{NameEn: "cash", NameCn: "余额"}, {NameEn: "point", NameCn: "积分"}} {
+		db.Create(&g)
+		if err = db.Table("user_finance_" + g.NameEn).Migrator().CreateTable(account.UserFinance{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	global.DB = db
+	global.Log = zap.NewNop()
+	global.SugarLog = global.Log.Sugar()
+	db.Create(&system.SysConfig{Name: "point", Value: "1", Status: utils.Pointer(0)})
+}
+
+func createTestUser(t *testing.T, name string) uint {
+	user := map[string]interface{}{"username": name, "enable": 1}
+	if err := global.DB.Table("sys_users").Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	var id uint
+	global.DB.Table("sys_users").Select("id").Where("username = ?", name).Scan(&id)
+	return id
+}
+
+// createTestAccount 创建用户账户 groupId 为 common.CASH 或 common.POINT
+func createTestAccount(t *testing.T, userId uint, groupId uint, amount float64) {
+	a := account.Account{UserId: &userId, GroupId: &groupId, Amount: &amount}
+	if err := global.DB.Create(&a).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getTestAccountAmount(userId uint, groupId uint) float64 {
+	var a account.Account
+	global.DB.Where("user_id = ? and group_id = ?", userId, groupId).First(&a)
+	return *a.Amount
+}
+
+func TestOrderService_CreateOrder_Concurrent(t *testing.T) {
+	setupOrderTestDB(t)
+	const store = 5
+	const buyers = 20
+
+	goods := shop.Goods{
+		Name:      "冷冻虾仁",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(30.0),
+		Price:     utils.Pointer(25.0),
+		Weight:    utils.Pointer(500),
+		Store:     utils.Pointer(store),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+
+	userIds := make([]uint, buyers)
+	for i := range userIds {
+		userIds[i] = createTestUser(t, "buyer"+string(rune('a'+i)))
+		cart := shop.Cart{
+			GoodsId: utils.Pointer(int(goods.ID)),
+			UserId:  utils.Pointer(int(userIds[i])),
+			Num:     1,
+			Checked: utils.Pointer(1),
+		}
+		assert.Nil(t, global.DB.Create(&cart).Error)
+	}
+
+	service := OrderService{}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	success, shortage := 0, 0
+	for _, id := range userIds {
+		wg.Add(1)
+		go func(userId uint) {
+			defer wg.Done()
+			order := shop.Order{
+				UserId:       utils.Pointer(int(userId)),
+				ShipmentType: utils.Pointer(1),
+			}
+			_, err := service.CreateOrder(order, &systemReq.CustomClaims{}, "127.0.0.1")
+			mu.Lock()
+			defer mu.Unlock()
+			var stockErr *StockNotEnoughError
+			switch {
+			case err == nil:
+				success++
+			case errors.As(err, &stockErr):
+				shortage++
+				assert.Equal(t, goods.ID, stockErr.GoodsId)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	var dbGoods shop.Goods
+	assert.Nil(t, global.DB.First(&dbGoods, goods.ID).Error)
+	assert.Equal(t, store, success)
+	assert.Equal(t, buyers-store, shortage)
+	assert.Equal(t, 0, *dbGoods.Store)
+	assert.Equal(t, store, *dbGoods.Sale)
+
+	var orderCount, detailCount int64
+	global.DB.Model(&shop.Order{}).Count(&orderCount)
+	global.DB.Model(&shop.OrderDetails{}).Count(&detailCount)
+	assert.Equal(t, int64(store), orderCount)
+	assert.Equal(t, int64(store), detailCount)
+}
+
+func TestOrderService_CreateOrder_SpecStock(t *testing.T) {
+	setupOrderTestDB(t)
+	goods := shop.Goods{
+		Name:      "冷冻鸡翅",
+		SpecType:  utils.Pointer(1),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(30.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(0),
+		Store:     utils.Pointer(0),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	small := shop.GoodsSpecValue{GoodsId: goods.ID, KeyName: "重量:500g", CostPrice: utils.Pointer(20.0), Price: utils.Pointer(18.0), Store: utils.Pointer(3), Sale: utils.Pointer(0)}
+	large := shop.GoodsSpecValue{GoodsId: goods.ID, KeyName: "重量:5kg", CostPrice: utils.Pointer(160.0), Price: utils.Pointer(0.0), Store: utils.Pointer(1), Sale: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&small).Error)
+	assert.Nil(t, global.DB.Create(&large).Error)
+
+	userId := createTestUser(t, "spec-buyer")
+	carts := []shop.Cart{
+		{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), SpecType: 1, SpecItemId: int(small.ID), Num: 2, Checked: utils.Pointer(1)},
+		{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), SpecType: 1, SpecItemId: int(large.ID), Num: 1, Checked: utils.Pointer(1)},
+	}
+	assert.Nil(t, global.DB.Create(&carts).Error)
+
+	service := OrderService{}
+	resp, err := service.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}, &systemReq.CustomClaims{}, "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 2*18.0+160.0, resp.Order.Total)
+
+	var details []shop.OrderDetails
+	global.DB.Where("order_id = ?", resp.Order.ID).Order("spec_id").Find(&details)
+	assert.Len(t, details, 2)
+	assert.Equal(t, int(small.ID), details[0].SpecId)
+	assert.Equal(t, "重量:500g", details[0].SpecKeyName)
+	assert.Equal(t, 18.0, details[0].Price)
+
+	var values []shop.GoodsSpecValue
+	global.DB.Order("id").Find(&values)
+	assert.Equal(t, 1, *values[0].Store)
+	assert.Equal(t, 2, *values[0].Sale)
+	assert.Equal(t, 0, *values[1].Store)
+	var dbGoods shop.Goods
+	global.DB.First(&dbGoods, goods.ID)
+	assert.Equal(t, 3, *dbGoods.Sale)
+}
+
+func TestOrderService_CancelTimeoutOrders(t *testing.T) {
+	setupOrderTestDB(t)
+	global.DB.Create(&system.SysConfig{Name: "orderTimeout", Value: "15", Status: utils.Pointer(1)})
+	goods := shop.Goods{
+		Name:      "冷冻带鱼",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(20.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(500),
+		Store:     utils.Pointer(3),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+
+	service := OrderService{}
+	var orderIds []uint
+	for _, name := range []string{"timeout-a", "timeout-b"} {
+		userId := createTestUser(t, name)
+		cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 1, Checked: utils.Pointer(1)}
+		assert.Nil(t, global.DB.Create(&cart).Error)
+		resp, err := service.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}, &systemReq.CustomClaims{}, "127.0.0.1")
+		assert.Nil(t, err)
+		orderIds = append(orderIds, resp.Order.ID)
+	}
+	// 第一笔订单超过支付时间
+	global.DB.Model(&shop.Order{}).Where("id = ?", orderIds[0]).Update("created_at", time.Now().Add(-20*time.Minute))
+
+	service.CancelTimeoutOrders()
+	// 重复执行不会重复归还库存
+	service.CancelTimeoutOrders()
+
+	var orders []shop.Order
+	global.DB.Order("id").Find(&orders)
+	assert.Equal(t, 3, *orders[0].StatusCancel)
+	assert.Equal(t, "system", orders[0].CancelBy)
+	assert.NotNil(t, orders[0].CancelTime)
+	assert.Equal(t, 0, *orders[1].StatusCancel)
+
+	var dbGoods shop.Goods
+	global.DB.First(&dbGoods, goods.ID)
+	assert.Equal(t, 2, *dbGoods.Store)
+	assert.Equal(t, 1, *dbGoods.Sale)
+
+	// 已取消的订单不能再次取消
+	assert.NotNil(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: orderIds[0]}}, uint(*orders[0].UserId), "timeout-a"))
+
+	// 后台取消记录管理员
+	admin := common.OrderActor{Type: common.ActorAdmin, Id: 1, Name: "admin"}
+	assert.Nil(t, service.AdminCancelOrder(shop.Order{DbModel: global.DbModel{ID: orderIds[1]}, CancelReason: "缺货"}, admin))
+	var cancelled shop.Order
+	global.DB.First(&cancelled, orderIds[1])
+	assert.Equal(t, 2, *cancelled.StatusCancel)
+	assert.Equal(t, "admin", cancelled.CancelBy)
+	var logs []shop.OrderLog
+	global.DB.Where("to_state = ?", common.OrderStateCancelled).Order("id").Find(&logs)
+	assert.Len(t, logs, 2)
+	assert.Equal(t, common.ActorTimer, logs[0].Actor)
+	assert.Equal(t, common.ActorAdmin, logs[1].Actor)
+	assert.Equal(t, uint(1), logs[1].OperatorId)
+	assert.Equal(t, "缺货", logs[1].Reason)
+}
+
+func TestOrderService_CancelOrder_Refund(t *testing.T) {
+	setupOrderTestDB(t)
+	goods := shop.Goods{
+		Name:      "冷冻牛排",
+		SpecType:  utils.Pointer(0),
+		Unit:      "盒",
+		CostPrice: utils.Pointer(50.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(200),
+		Store:     utils.Pointer(10),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	userId := createTestUser(t, "refund-buyer")
+	createTestAccount(t, userId, common.CASH, 0)
+	cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 2, Checked: utils.Pointer(1)}
+	assert.Nil(t, global.DB.Create(&cart).Error)
+
+	service := OrderService{}
+	resp, err := service.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}, &systemReq.CustomClaims{}, "127.0.0.1")
+	assert.Nil(t, err)
+	// 模拟余额支付完成
+	global.DB.Model(&shop.Order{}).Where("id = ?", resp.Order.ID).Updates(map[string]interface{}{
+		"status": 1, "payment": PaymentBalance, "finish": 100.0,
+	})
+
+	// 不能取消其他用户的订单
+	stranger := createTestUser(t, "refund-stranger")
+	assert.EqualError(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: resp.Order.ID}}, stranger, "refund-stranger"), "订单不存在")
+	assert.Equal(t, 0.0, getTestAccountAmount(userId, common.CASH))
+
+	assert.Nil(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: resp.Order.ID}}, userId, "refund-buyer"))
+	var order shop.Order
+	global.DB.First(&order, resp.Order.ID)
+	assert.Equal(t, 1, *order.StatusCancel)
+	assert.Equal(t, RefundStatusSuccess, *order.StatusRefund)
+	assert.NotNil(t, order.RefundTime)
+	assert.Equal(t, 100.0, getTestAccountAmount(userId, common.CASH))
+
+	var dbGoods shop.Goods
+	global.DB.First(&dbGoods, goods.ID)
+	assert.Equal(t, 10, *dbGoods.Store)
+
+	// 重复取消不会重复退款
+	assert.NotNil(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: resp.Order.ID}}, userId, "refund-buyer"))
+	assert.Equal(t, 100.0, getTestAccountAmount(userId, common.CASH))
+}
+
+func TestOrderService_CancelOrder_NoProvider(t *testing.T) {
+	setupOrderTestDB(t)
+	payment.Register(payment.Wechat, nil)
+	userId := createTestUser(t, "no-provider-buyer")
+	order := createPayTestOrder(t, "no-provider-1", userId, 30)
+	global.DB.Model(&order).Updates(map[string]interface{}{"status": 1, "finish": 30.0, "transation_id": "tx-1"})
+
+	// 微信支付未配置时不能退款，订单不会进入退款中
+	service := OrderService{}
+	assert.EqualError(t, service.AdminCancelOrder(shop.Order{DbModel: global.DbModel{ID: order.ID}}, common.OrderActor{Type: common.ActorAdmin, Name: "admin"}), "支付方式未配置，无法退款")
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, common.OrderStatePaid, common.OrderState(order))
+	assert.Equal(t, "", order.RefundSn)
+
+	// 未支付的订单不需要退款，可以取消
+	unpaid := createPayTestOrder(t, "no-provider-2", userId, 30)
+	assert.Nil(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: unpaid.ID}}, userId, "no-provider-buyer"))
+	global.DB.First(&unpaid, unpaid.ID)
+	assert.Equal(t, common.OrderStateCancelled, common.OrderState(unpaid))
+}
+
+func TestRefundAmountTx_RefundSn(t *testing.T) {
+	setupOrderTestDB(t)
+	userId := createTestUser(t, "refund-sn")
+	newOrder := func(orderSn string) shop.Order {
+		order := shop.Order{OrderSn: orderSn, UserId: utils.Pointer(int(userId)), Status: utils.Pointer(3), StatusCancel: utils.Pointer(0),
+			StatusRefund: utils.Pointer(0), Payment: utils.Pointer(PaymentWechat), Finish: 30}
+		assert.Nil(t, global.DB.Create(&order).Error)
+		return order
+	}
+	refund := func(order *shop.Order) error {
+		return global.DB.Transaction(func(tx *gorm.DB) error {
+			return refundAmountTx(tx, order, 10, 0, common.OrderActor{Type: common.ActorAdmin}, "售后退款")
+		})
+	}
+	first, second := newOrder("refund-sn-1"), newOrder("refund-sn-2")
+	assert.Nil(t, refund(&first))
+	assert.Nil(t, refund(&second))
+	assert.NotEmpty(t, first.RefundSn)
+	assert.NotEqual(t, first.RefundSn, second.RefundSn)
+
+	// 退款失败后重新发起沿用原退款单号
+	refundSn := first.RefundSn
+	assert.Nil(t, global.DB.Transaction(func(tx *gorm.DB) error {
+		return common.TransitOrder(tx, &first, common.OrderStateRefundFailed, nil, common.OrderActor{Type: common.ActorSystem}, "退款申请失败")
+	}))
+	assert.Nil(t, refund(&first))
+	assert.Equal(t, refundSn, first.RefundSn)
+	global.DB.First(&first, first.ID)
+	assert.Equal(t, refundSn, first.RefundSn)
+	assert.Equal(t, RefundStatusPending, *first.StatusRefund)
+}
+
+func TestEnsureFinanceTypesAndSysConfigs(t *testing.T) {
+	setupOrderTestDB(t)
+	// 已有安装中管理员创建的流水类型占用了程序使用的ID
+	assert.Nil(t, global.DB.Create(&account.UserFinanceType{DbModel: global.DbModel{ID: common.FinanceTypeOrderRefund}, ParentId: utils.Pointer(0), Name: "活动奖励"}).Error)
+	err := common.EnsureFinanceTypes(global.DB)
+	assert.ErrorContains(t, err, "ID 9")
+	var count int64
+	global.DB.Model(&account.UserFinanceType{}).Count(&count)
+	assert.Equal(t, int64(len(common.FinanceTypes)), count)
+	var refundType account.UserFinanceType
+	global.DB.First(&refundType, common.FinanceTypeOrderRefund)
+	assert.Equal(t, "活动奖励", refundType.Name)
+
+	global.DB.Model(&refundType).Update("name", "订单退款")
+	assert.Nil(t, common.EnsureFinanceTypes(global.DB))
+
+	// 已存在的配置参数不修改，缺失的参数使用默认值
+	assert.Nil(t, common.EnsureSysConfigs(global.DB))
+	assert.Nil(t, common.EnsureSysConfigs(global.DB))
+	global.DB.Model(&system.SysConfig{}).Count(&count)
+	assert.Equal(t, int64(len(common.SysConfigs)), count)
+	timeout, enable := common.GetOrderTimeout()
+	assert.True(t, enable)
+	assert.Equal(t, 30*time.Minute, timeout)
+	_, _, enable = common.GetPointDeduction()
+	assert.False(t, enable)
+}
+
+func TestOrderService_OrderPay_Balance(t *testing.T) {
+	setupOrderTestDB(t)
+	goods := shop.Goods{
+		Name:      "冷冻鸡腿",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(40.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(1000),
+		Store:     utils.Pointer(10),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	userId := createTestUser(t, "balance-buyer")
+	createTestAccount(t, userId, common.CASH, 100)
+	cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 2, Checked: utils.Pointer(1)}
+	assert.Nil(t, global.DB.Create(&cart).Error)
+
+	service := OrderService{}
+	claims := &systemReq.CustomClaims{BaseClaims: systemReq.BaseClaims{ID: userId, Username: "balance-buyer"}}
+	created, err := service.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}, claims, "127.0.0.1")
+	assert.Nil(t, err)
+	req := shopReq.OrderPayReq{ID: created.Order.ID, Payment: PaymentBalance, SafePassword: "123456"}
+
+	// 未设置安全密码
+	_, err = service.OrderPay(req, claims, "127.0.0.1")
+	assert.EqualError(t, err, "请先设置安全密码")
+	global.DB.Table("sys_users").Where("id = ?", userId).Update("safe_password", utils.BcryptHash("123456"))
+
+	// 安全密码错误
+	_, err = service.OrderPay(shopReq.OrderPayReq{ID: created.Order.ID, Payment: PaymentBalance, SafePassword: "654321"}, claims, "127.0.0.1")
+	assert.EqualError(t, err, "安全密码错误")
+
+	resp, err := service.OrderPay(req, claims, "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, *resp.Order.Status)
+	assert.Equal(t, 20.0, getTestAccountAmount(userId, common.CASH))
+
+	var finance account.UserFinance
+	global.DB.Table("user_finance_cash").Last(&finance)
+	assert.Equal(t, common.FinanceTypeOrderPay, *finance.TypeId)
+	assert.Equal(t, -80.0, *finance.Amount)
+
+	// 重复支付不会重复扣款
+	_, err = service.OrderPay(req, claims, "127.0.0.1")
+	assert.NotNil(t, err)
+	assert.Equal(t, 20.0, getTestAccountAmount(userId, common.CASH))
+
+	// 取消订单退回余额
+	assert.Nil(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: created.Order.ID}}, userId, "balance-buyer"))
+	assert.Equal(t, 100.0, getTestAccountAmount(userId, common.CASH))
+}
+
+func TestOrderService_OrderPay_BalanceNotEnough(t *testing.T) {
+	setupOrderTestDB(t)
+	goods := shop.Goods{
+		Name:      "冷冻鸭胸",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(40.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(1000),
+		Store:     utils.Pointer(10),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	userId := createTestUser(t, "poor-buyer")
+	createTestAccount(t, userId, common.CASH, 10)
+	global.DB.Table("sys_users").Where("id = ?", userId).Update("safe_password", utils.BcryptHash("123456"))
+	cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 1, Checked: utils.Pointer(1)}
+	assert.Nil(t, global.DB.Create(&cart).Error)
+
+	service := OrderService{}
+	claims := &systemReq.CustomClaims{BaseClaims: systemReq.BaseClaims{ID: userId, Username: "poor-buyer"}}
+	created, err := service.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}, claims, "127.0.0.1")
+	assert.Nil(t, err)
+
+	_, err = service.OrderPay(shopReq.OrderPayReq{ID: created.Order.ID, Payment: PaymentBalance, SafePassword: "123456"}, claims, "127.0.0.1")
+	assert.EqualError(t, err, "余额不足")
+	// 扣款失败时订单保持未支付
+	var order shop.Order
+	global.DB.First(&order, created.Order.ID)
+	assert.Equal(t, 0, *order.Status)
+	assert.Equal(t, 10.0, getTestAccountAmount(userId, common.CASH))
+}
+
+func getTestAccount(userId uint, groupId uint) account.Account {
+	var a account.Account
+	global.DB.Where("user_id = ? and group_id = ?", userId, groupId).First(&a)
+	return a
+}
+
+func TestOrderService_PointDeduction(t *testing.T) {
+	setupOrderTestDB(t)
+	global.DB.Create(&system.SysConfig{Name: "pointDeduction", Value: "10", Status: utils.Pointer(1)})
+	global.DB.Create(&system.SysConfig{Name: "pointDeductionMax", Value: "20", Status: utils.Pointer(1)})
+	goods := shop.Goods{
+		Name:      "冷冻三文鱼",
+		SpecType:  utils.Pointer(0),
+		Unit:      "盒",
+		CostPrice: utils.Pointer(50.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(300),
+		Store:     utils.Pointer(10),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	userId := createTestUser(t, "point-buyer")
+	createTestAccount(t, userId, common.CASH, 100)
+	createTestAccount(t, userId, common.POINT, 1000)
+	global.DB.Table("sys_users").Where("id = ?", userId).Update("safe_password", utils.BcryptHash("123456"))
+	claims := &systemReq.CustomClaims{BaseClaims: systemReq.BaseClaims{ID: userId, Username: "point-buyer"}}
+	service := OrderService{}
+	createOrder := func(points float64) (*shop.Order, error) {
+		cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 2, Checked: utils.Pointer(1)}
+		global.DB.Create(&cart)
+		resp, err := service.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1), DeductPoints: points}, claims, "127.0.0.1")
+		global.DB.Where("user_id = ?", userId).Delete(&shop.Cart{})
+		if err != nil {
+			return nil, err
+		}
+		return &resp.Order, nil
+	}
+
+	// 超过最大抵扣比例 100 * 20% = 20 元
+	_, err := createOrder(300)
+	assert.NotNil(t, err)
+
+	// 200 积分抵扣 20 元 冻结积分
+	order, err := createOrder(200)
+	assert.Nil(t, err)
+	assert.Equal(t, 20.0, order.DeductAmount)
+	assert.Equal(t, 80.0, order.PayAmount())
+	points := getTestAccount(userId, common.POINT)
+	assert.Equal(t, 800.0, *points.Amount)
+	assert.Equal(t, 200.0, *points.FreezeAmount)
+
+	// 余额支付剩余金额 扣除冻结积分
+	_, err = service.OrderPay(shopReq.OrderPayReq{ID: order.ID, Payment: PaymentBalance, SafePassword: "123456"}, claims, "127.0.0.1")
+	assert.Nil(t, err)
+	points = getTestAccount(userId, common.POINT)
+	assert.Equal(t, 800.0, *points.Amount)
+	assert.Equal(t, 0.0, *points.FreezeAmount)
+	assert.Equal(t, 20.0, getTestAccountAmount(userId, common.CASH))
+
+	// 取消已支付订单 退回余额和积分
+	assert.Nil(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: order.ID}}, userId, "point-buyer"))
+	assert.Equal(t, 1000.0, getTestAccountAmount(userId, common.POINT))
+	assert.Equal(t, 100.0, getTestAccountAmount(userId, common.CASH))
+
+	// 未支付订单超时取消 解冻积分
+	global.DB.Create(&system.SysConfig{Name: "orderTimeout", Value: "15", Status: utils.Pointer(1)})
+	order, err = createOrder(100)
+	assert.Nil(t, err)
+	assert.Equal(t, 900.0, getTestAccountAmount(userId, common.POINT))
+	global.DB.Model(&shop.Order{}).Where("id = ?", order.ID).Update("created_at", time.Now().Add(-20*time.Minute))
+	service.CancelTimeoutOrders()
+	points = getTestAccount(userId, common.POINT)
+	assert.Equal(t, 1000.0, *points.Amount)
+	assert.Equal(t, 0.0, *points.FreezeAmount)
+}
+
+func TestOrderService_PreviewOrder(t *testing.T) {
+	setupOrderTestDB(t)
+	global.DB.Model(&system.SysConfig{}).Where("name = ?", "point").Updates(map[string]interface{}{"value": "10", "status": 1})
+	goods := shop.Goods{
+		Name:      "冷冻扇贝",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(30.0),
+		Price:     utils.Pointer(25.0),
+		Weight:    utils.Pointer(500),
+		Store:     utils.Pointer(2),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	userId := createTestUser(t, "preview-buyer")
+	cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 3, Checked: utils.Pointer(1)}
+	assert.Nil(t, global.DB.Create(&cart).Error)
+
+	service := OrderService{}
+	order := shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}
+	// 库存不足时返回提示 不影响预览金额
+	preview, err := service.PreviewOrder(order)
+	assert.Nil(t, err)
+	assert.False(t, preview.Available)
+	assert.Len(t, preview.Lines, 1)
+	assert.False(t, preview.Lines[0].Available)
+	assert.Equal(t, 2, preview.Lines[0].Store)
+	assert.Equal(t, 75.0, preview.Total)
+	assert.Equal(t, 15.0, preview.Discount)
+
+	global.DB.Model(&shop.Cart{}).Where("id = ?", cart.ID).Update("num", 2)
+	preview, err = service.PreviewOrder(order)
+	assert.Nil(t, err)
+	assert.True(t, preview.Available)
+
+	// 预览不修改库存和购物车
+	var dbGoods shop.Goods
+	global.DB.First(&dbGoods, goods.ID)
+	assert.Equal(t, 2, *dbGoods.Store)
+	var cartCount int64
+	global.DB.Model(&shop.Cart{}).Where("user_id = ?", userId).Count(&cartCount)
+	assert.Equal(t, int64(1), cartCount)
+
+	// 预览金额与下单金额一致
+	created, err := service.CreateOrder(order, &systemReq.CustomClaims{}, "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, preview.Total, created.Order.Total)
+	assert.Equal(t, preview.GiftPoints, created.Order.GiftPoints)
+	assert.Equal(t, preview.PayAmount, created.Order.PayAmount())
+	assert.Equal(t, 5.0, created.Order.GiftPoints)
+}
+
+func TestOrderService_PickUpNumber(t *testing.T) {
+	setupOrderTestDB(t)
+	global.DB.Create(&system.SysConfig{Name: "pickUpNumberBase", Value: "1001", Status: utils.Pointer(1)})
+	userId := createTestUser(t, "pickup-buyer")
+	today := time.Now()
+	newOrder := func(pointId int, payTime time.Time) shop.Order {
+		order := shop.Order{
+			UserId:        utils.Pointer(int(userId)),
+			OrderSn:       utils.GenerateOrderNumber("SN"),
+			ShipmentType:  utils.Pointer(1),
+			PickUpPointId: pointId,
+			Status:        utils.Pointer(1),
+			StatusCancel:  utils.Pointer(0),
+			StatusRefund:  utils.Pointer(0),
+			PayTime:       &payTime,
+		}
+		assert.Nil(t, global.DB.Create(&order).Error)
+		return order
+	}
+
+	// 并发支付的订单取餐号码不重复且连续
+	const count = 10
+	orders := make([]shop.Order, count)
+	for i := range orders {
+		orders[i] = newOrder(0, today)
+	}
+	var wg sync.WaitGroup
+	for i := range orders {
+		wg.Add(1)
+		go func(order shop.Order) {
+			defer wg.Done()
+			err := global.DB.Transaction(func(tx *gorm.DB) error {
+				return common.AssignPickUpNumber(tx, &order)
+			})
+			assert.Nil(t, err)
+		}(orders[i])
+	}
+	wg.Wait()
+	var numbers []int
+	global.DB.Model(&shop.Order{}).Order("pick_up_number").Pluck("pick_up_number", &numbers)
+	expected := make([]int, count)
+	for i := range expected {
+		expected[i] = 1001 + i
+	}
+	assert.Equal(t, expected, numbers)
+
+	// 已分配的订单不会重复分配
+	order := orders[0]
+	global.DB.First(&order, order.ID)
+	assigned := order.PickUpNumber
+	assert.Nil(t, common.AssignPickUpNumber(global.DB, &order))
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, assigned, order.PickUpNumber)
+
+	// 不同自提点、不同日期重新计数
+	other := newOrder(2, today)
+	assert.Nil(t, common.AssignPickUpNumber(global.DB, &other))
+	assert.Equal(t, 1001, other.PickUpNumber)
+	tomorrow := newOrder(0, today.AddDate(0, 0, 1))
+	assert.Nil(t, common.AssignPickUpNumber(global.DB, &tomorrow))
+	assert.Equal(t, 1001, tomorrow.PickUpNumber)
+}
+
+func TestOrderService_AutoReceiveOrders(t *testing.T) {
+	setupOrderTestDB(t)
+	global.DB.Create(&system.SysConfig{Name: "autoReceiveDays", Value: "7", Status: utils.Pointer(1)})
+	userId := createTestUser(t, "receive")
+	createTestAccount(t, userId, common.POINT, 0)
+
+	var orderIds []uint
+	for i, shipped := range []time.Time{time.Now().AddDate(0, 0, -8), time.Now().AddDate(0, 0, -1)} {
+		order := shop.Order{
+			OrderSn:      fmt.Sprintf("receive-%d", i),
+			UserId:       utils.Pointer(int(userId)),
+			GoodsArea:    utils.Pointer(0),
+			Status:       utils.Pointer(2),
+			StatusCancel: utils.Pointer(0),
+			StatusRefund: utils.Pointer(0),
+			ShipmentType: utils.Pointer(0),
+			ShipmentTime: utils.Pointer(shipped),
+			Total:        50,
+			GiftPoints:   5,
+		}
+		assert.Nil(t, global.DB.Create(&order).Error)
+		assert.Nil(t, global.DB.Create(&shop.OrderDelivery{OrderId: utils.Pointer(int(order.ID))}).Error)
+		orderIds = append(orderIds, order.ID)
+	}
+
+	service := OrderService{}
+	service.AutoReceiveOrders()
+	// 重复执行不会重复发放积分
+	service.AutoReceiveOrders()
+
+	var orders []shop.Order
+	global.DB.Order("id").Find(&orders)
+	assert.Equal(t, 3, *orders[0].Status)
+	assert.NotNil(t, orders[0].ReceiveTime)
+	assert.Equal(t, 2, *orders[1].Status)
+	assert.Equal(t, 5.0, getTestAccountAmount(userId, common.POINT))
+
+	var delivery shop.OrderDelivery
+	global.DB.Where("order_id = ?", orderIds[0]).First(&delivery)
+	assert.NotNil(t, delivery.ReceiptTime)
+	assert.Equal(t, shop.DeliveryStatusDelivered, *delivery.Status)
+
+	// 已自动确认的订单不能再次确认收货
+	err := (&OrderDeliveryService{}).UpdateOrderDelivery(shop.OrderDelivery{OrderId: utils.Pointer(int(orderIds[0])), ReceiptTime: utils.Pointer(time.Now())}, common.OrderActor{Type: common.ActorUser, Id: userId})
+	assert.NotNil(t, err)
+	assert.Equal(t, 5.0, getTestAccountAmount(userId, common.POINT))
+}
+
+func TestOrderService_OrderLog(t *testing.T) {
+	setupOrderTestDB(t)
+	goods := shop.Goods{
+		Name:      "冷冻虾仁",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(30.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(500),
+		Store:     utils.Pointer(10),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	userId := createTestUser(t, "log-buyer")
+	createTestAccount(t, userId, common.CASH, 100)
+	global.DB.Table("sys_users").Where("id = ?", userId).Update("safe_password", utils.BcryptHash("123456"))
+	cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 1, Checked: utils.Pointer(1)}
+	assert.Nil(t, global.DB.Create(&cart).Error)
+
+	service := OrderService{}
+	claims := &systemReq.CustomClaims{BaseClaims: systemReq.BaseClaims{ID: userId, Username: "log-buyer"}}
+	created, err := service.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}, claims, "127.0.0.1")
+	assert.Nil(t, err)
+	_, err = service.OrderPay(shopReq.OrderPayReq{ID: created.Order.ID, Payment: PaymentBalance, SafePassword: "123456"}, claims, "127.0.0.1")
+	assert.Nil(t, err)
+
+	// 后台修改订单不能修改订单状态
+	order, err := service.GetOrder(created.Order.ID)
+	assert.Nil(t, err)
+	order.Remarks = "少放辣"
+	order.Status = utils.Pointer(3)
+	order.StatusCancel = utils.Pointer(2)
+	assert.Nil(t, service.UpdateOrder(order))
+	order, _ = service.GetOrder(created.Order.ID)
+	assert.Equal(t, "少放辣", order.Remarks)
+	assert.Equal(t, common.OrderStatePaid, common.OrderState(order))
+
+	// 已付款的订单不能直接确认收货
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		return common.OrderReceivedTx(tx, &order, time.Now(), common.OrderActor{Type: common.ActorAdmin}, "确认收货")
+	})
+	assert.NotNil(t, err)
+
+	// 取消类型和操作人不受请求中 statusCancel 的影响
+	assert.Nil(t, service.CancelOrder(shop.Order{DbModel: global.DbModel{ID: created.Order.ID}, StatusCancel: utils.Pointer(3)}, userId, "log-buyer"))
+
+	order, _ = service.GetOrder(created.Order.ID)
+	assert.Equal(t, 1, *order.StatusCancel)
+	var states [][2]string
+	for _, l := range order.Logs {
+		states = append(states, [2]string{l.FromState, l.ToState})
+	}
+	assert.Equal(t, [][2]string{
+		{"", common.OrderStateUnpaid},
+		{common.OrderStateUnpaid, common.OrderStatePaid},
+		{common.OrderStatePaid, common.OrderStateCancelled},
+		{common.OrderStateCancelled, common.OrderStateRefunded},
+	}, states)
+	assert.Equal(t, common.ActorUser, order.Logs[2].Actor)
+	assert.Equal(t, userId, order.Logs[2].OperatorId)
+}
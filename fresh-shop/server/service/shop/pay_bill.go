@@ -0,0 +1,339 @@
+package shop
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/payment"
+	"fresh-shop/server/service/wechat"
+	"gorm.io/gorm"
+	"io"
+	"math"
+	"time"
+)
+
+// PayBillService 微信支付对账 核对微信支付对账单与本地订单的支付和退款
+type PayBillService struct {
+}
+
+// ReconcileWechatBill 下载指定日期的微信支付对账单并与本地订单核对，billDate 格式为 2006-01-02
+func (payBillService *PayBillService) ReconcileWechatBill(billDate string) (bill shop.PayBill, err error) {
+	day, err := parseBillDate(billDate)
+	if err != nil {
+		return
+	}
+	data, err := downloadWechatBill(day.Format("20060102"))
+	if err != nil {
+		return
+	}
+	rows, err := wechat.ParseBill(bytes.NewReader(data))
+	if err != nil {
+		global.SugarLog.Errorf("解析微信支付对账单失败 billDate:%s, err:%v \n", billDate, err)
+		return bill, err
+	}
+	return payBillService.reconcile(day, shop.PayBillSourceDownload, rows)
+}
+
+// ImportWechatBill 上传商户平台导出的微信支付对账单文件并与本地订单核对，用于无法下载对账单时
+func (payBillService *PayBillService) ImportWechatBill(billDate string, file io.Reader) (bill shop.PayBill, err error) {
+	day, err := parseBillDate(billDate)
+	if err != nil {
+		return
+	}
+	rows, err := wechat.ParseBill(file)
+	if err != nil {
+		return bill, err
+	}
+	return payBillService.reconcile(day, shop.PayBillSourceUpload, rows)
+}
+
+// ReconcileYesterday 核对前一天的微信支付对账单，由定时任务调用 无法下载对账单时不处理
+func (payBillService *PayBillService) ReconcileYesterday() {
+	if global.DB == nil || !payBillService.BillDownloadEnabled() {
+		return
+	}
+	billDate := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	bill, err := payBillService.ReconcileWechatBill(billDate)
+	if err != nil {
+		global.SugarLog.Errorf("微信支付对账失败 billDate:%s, err:%v \n", billDate, err)
+		return
+	}
+	if len(bill.Items) > 0 {
+		global.SugarLog.Warnf("微信支付对账存在差异 billDate:%s, missing:%d, mismatch:%d, orphan:%d \n", billDate, bill.MissingCount, bill.MismatchCount, bill.OrphanCount)
+	}
+}
+
+// BillDownloadEnabled 是否可以下载微信支付对账单，使用 API v3 时通过 API v3 下载，否则需要配置 APIv2 密钥
+func (payBillService *PayBillService) BillDownloadEnabled() bool {
+	if provider, err := payment.Get(payment.Wechat); err == nil {
+		if _, ok := provider.(payment.BillDownloader); ok {
+			return true
+		}
+	}
+	return global.WxPay != nil && global.Config.WechatPay.ApiV2Key != ""
+}
+
+// downloadWechatBill 下载微信支付对账单，微信支付使用 API v3 时通过 API v3 下载，否则通过 API v2 下载
+func downloadWechatBill(billDate string) ([]byte, error) {
+	if provider, err := payment.Get(payment.Wechat); err == nil {
+		if downloader, ok := provider.(payment.BillDownloader); ok {
+			return downloader.DownloadBill(billDate)
+		}
+	}
+	return wechat.DownloadBill(billDate)
+}
+
+// GetPayBill 根据id获取对账结果和差异明细
+func (payBillService *PayBillService) GetPayBill(id uint) (bill shop.PayBill, err error) {
+	err = global.DB.Where("id = ?", id).Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("type asc, id asc")
+	}).First(&bill).Error
+	return
+}
+
+// GetPayBillInfoList 分页获取对账结果
+func (payBillService *PayBillService) GetPayBillInfoList(info shopReq.PayBillSearch) (list []shop.PayBill, total int64, err error) {
+	limit := info.PageSize
+	offset := info.PageSize * (info.Page - 1)
+	db := global.DB.Model(&shop.PayBill{})
+	if info.StartDate != "" {
+		db = db.Where("bill_date >= ?", info.StartDate)
+	}
+	if info.EndDate != "" {
+		db = db.Where("bill_date <= ?", info.EndDate)
+	}
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Order("bill_date desc").Limit(limit).Offset(offset).Find(&list).Error
+	return list, total, err
+}
+
+// reconcile 核对对账单与本地订单，保存对账结果，同一日期重复对账时替换之前的结果
+// 支付按商户订单号或微信订单号匹配订单，核对实付金额，重复支付核对重复支付记录的金额；退款按订单、售后或重复支付的退款单号核对退款金额
+// 本地当天支付或退款成功的微信订单不在对账单中时记为 missing
+func (payBillService *PayBillService) reconcile(day time.Time, source string, rows []wechat.BillRow) (bill shop.PayBill, err error) {
+	bill = shop.PayBill{BillDate: day.Format("2006-01-02"), Source: source}
+	orderSns := make([]string, 0, len(rows))
+	transactionIds := make([]string, 0, len(rows))
+	for _, row := range rows {
+		orderSns = append(orderSns, row.OrderSn)
+		transactionIds = append(transactionIds, row.TransactionId)
+	}
+	var orders []shop.Order
+	if len(rows) > 0 {
+		if err = global.DB.Where("order_sn in ? or transation_id in ?", orderSns, transactionIds).Find(&orders).Error; err != nil {
+			return
+		}
+	}
+	// 订单已支付后又收到的重复支付，按微信订单号匹配
+	var duplicates []shop.PayDuplicate
+	if len(rows) > 0 {
+		if err = global.DB.Where("transaction_id in ?", transactionIds).Find(&duplicates).Error; err != nil {
+			return
+		}
+	}
+	duplicateByTransaction := make(map[string]shop.PayDuplicate, len(duplicates))
+	for _, d := range duplicates {
+		duplicateByTransaction[d.TransactionId] = d
+	}
+	bySn := make(map[string]shop.Order, len(orders))
+	byTransaction := make(map[string]shop.Order, len(orders))
+	for _, o := range orders {
+		bySn[o.OrderSn] = o
+		if o.TransationId != "" {
+			byTransaction[o.TransationId] = o
+		}
+	}
+	paidIds := map[uint]bool{}
+	refundedSns := map[string]bool{}
+	addItem := func(itemType, tradeType string, order shop.Order, row wechat.BillRow, billAmount, orderAmount float64, remark string) {
+		item := shop.PayBillItem{Type: itemType, TradeType: tradeType, OrderId: order.ID, OrderSn: row.OrderSn, TransactionId: row.TransactionId,
+			RefundSn: row.RefundSn, BillAmount: billAmount, OrderAmount: orderAmount, Remark: remark}
+		if item.OrderSn == "" {
+			item.OrderSn = order.OrderSn
+		}
+		bill.Items = append(bill.Items, item)
+		switch itemType {
+		case shop.PayBillItemMissing:
+			bill.MissingCount++
+		case shop.PayBillItemMismatch:
+			bill.MismatchCount++
+		case shop.PayBillItemOrphan:
+			bill.OrphanCount++
+		}
+	}
+
+	for _, row := range rows {
+		order, ok := bySn[row.OrderSn]
+		if !ok {
+			order, ok = byTransaction[row.TransactionId]
+		}
+		switch row.TradeState {
+		case wechat.BillTradeSuccess:
+			bill.TradeCount++
+			bill.TradeAmount += row.Amount
+			if !ok {
+				addItem(shop.PayBillItemOrphan, shop.PayNotifyTypePay, order, row, row.Amount, 0, "本地没有对应的订单")
+				continue
+			}
+			if duplicate, ok := duplicateByTransaction[row.TransactionId]; ok && order.TransationId != row.TransactionId {
+				if sameAmount(row.Amount, duplicate.Amount) {
+					bill.MatchedCount++
+				} else {
+					addItem(shop.PayBillItemMismatch, shop.PayNotifyTypePay, order, row, row.Amount, duplicate.Amount, "支付金额不一致")
+				}
+				continue
+			}
+			paidIds[order.ID] = true
+			// 取消后才收到支付的订单状态仍为未支付，记录了微信订单号并原路退款
+			if order.TransationId != row.TransactionId && (order.Status == nil || *order.Status == 0) {
+				addItem(shop.PayBillItemMismatch, shop.PayNotifyTypePay, order, row, row.Amount, 0, "本地订单未支付")
+			} else if !sameAmount(row.Amount, order.Finish) {
+				addItem(shop.PayBillItemMismatch, shop.PayNotifyTypePay, order, row, row.Amount, order.Finish, "支付金额不一致")
+			} else if order.TransationId != row.TransactionId {
+				addItem(shop.PayBillItemMismatch, shop.PayNotifyTypePay, order, row, row.Amount, order.Finish, "微信订单号不一致")
+			} else {
+				bill.MatchedCount++
+			}
+		case wechat.BillTradeRefund:
+			bill.RefundCount++
+			bill.RefundAmount += row.RefundAmount
+			if !ok {
+				addItem(shop.PayBillItemOrphan, shop.PayNotifyTypeRefund, order, row, row.RefundAmount, 0, "本地没有对应的订单")
+				continue
+			}
+			refundedSns[row.RefundSn] = true
+			expected, found := payBillService.refundRecord(order, row.RefundSn)
+			if !found && (order.StatusRefund == nil || *order.StatusRefund == RefundStatusNone) {
+				addItem(shop.PayBillItemMismatch, shop.PayNotifyTypeRefund, order, row, row.RefundAmount, 0, "本地订单未退款")
+			} else if !found {
+				addItem(shop.PayBillItemMismatch, shop.PayNotifyTypeRefund, order, row, row.RefundAmount, payBillService.refundAmount(order), "退款单号不一致")
+			} else if !sameAmount(row.RefundAmount, expected) {
+				addItem(shop.PayBillItemMismatch, shop.PayNotifyTypeRefund, order, row, row.RefundAmount, expected, "退款金额不一致")
+			} else {
+				bill.MatchedCount++
+			}
+		}
+	}
+
+	// 本地当天的微信支付和退款
+	next := day.AddDate(0, 0, 1)
+	var paidOrders []shop.Order
+	if err = global.DB.Where("payment = ? and transation_id <> '' and pay_time >= ? and pay_time < ?", PaymentWechat, day, next).
+		Order("id").Find(&paidOrders).Error; err != nil {
+		return
+	}
+	for _, o := range paidOrders {
+		if !paidIds[o.ID] {
+			addItem(shop.PayBillItemMissing, shop.PayNotifyTypePay, o, wechat.BillRow{OrderSn: o.OrderSn, TransactionId: o.TransationId}, 0, o.Finish, "对账单中没有这笔支付")
+		}
+	}
+	var refundedOrders []shop.Order
+	if err = global.DB.Where("payment = ? and status_refund = ? and refund_time >= ? and refund_time < ?", PaymentWechat, RefundStatusSuccess, day, next).
+		Order("id").Find(&refundedOrders).Error; err != nil {
+		return
+	}
+	for _, o := range refundedOrders {
+		if !refundedSns[o.RefundSn] {
+			addItem(shop.PayBillItemMissing, shop.PayNotifyTypeRefund, o, wechat.BillRow{OrderSn: o.OrderSn, TransactionId: o.TransationId, RefundSn: o.RefundSn},
+				0, payBillService.refundAmount(o), "对账单中没有这笔退款")
+		}
+	}
+	// 售后部分退款使用售后的退款单号
+	var refundedReturns []shop.OrderReturn
+	if err = global.DB.Where("status = ? and refund_status = ? and refund_time >= ? and refund_time < ?", shop.ReturnStatusApproved, RefundStatusSuccess, day, next).
+		Order("id").Find(&refundedReturns).Error; err != nil {
+		return
+	}
+	for _, r := range refundedReturns {
+		if refundedSns[r.RefundSn] || r.OrderId == nil {
+			continue
+		}
+		var o shop.Order
+		if global.DB.Where("id = ? and payment = ? and refund_sn <> ?", *r.OrderId, PaymentWechat, r.RefundSn).First(&o).Error != nil {
+			continue
+		}
+		amount, _ := payBillService.refundRecord(o, r.RefundSn)
+		addItem(shop.PayBillItemMissing, shop.PayNotifyTypeRefund, o, wechat.BillRow{OrderSn: o.OrderSn, TransactionId: o.TransationId, RefundSn: r.RefundSn},
+			0, amount, "对账单中没有这笔退款")
+	}
+	bill.TradeAmount = roundAmount(bill.TradeAmount)
+	bill.RefundAmount = roundAmount(bill.RefundAmount)
+
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		var old shop.PayBill
+		if err := tx.Unscoped().Where("bill_date = ?", bill.BillDate).First(&old).Error; err == nil {
+			if err = tx.Unscoped().Where("bill_id = ?", old.ID).Delete(&shop.PayBillItem{}).Error; err != nil {
+				return err
+			}
+			if err = tx.Unscoped().Delete(&old).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&bill).Error
+	})
+	if err != nil {
+		global.SugarLog.Errorf("保存对账结果失败 billDate:%s, err:%v \n", bill.BillDate, err)
+		return bill, errors.New("保存对账结果失败")
+	}
+	return bill, nil
+}
+
+// refundAmount 订单本地的微信退款金额，售后退款为审核通过的退款金额，取消订单为实付金额
+func (payBillService *PayBillService) refundAmount(order shop.Order) float64 {
+	if global.Config.WechatPay.Debug {
+		return 0.01
+	}
+	var orderReturn shop.OrderReturn
+	err := global.DB.Where("order_id = ? and status = ? and refund_sn = ?", order.ID, shop.ReturnStatusApproved, order.RefundSn).First(&orderReturn).Error
+	if err == nil && orderReturn.Amount != nil {
+		return *orderReturn.Amount
+	}
+	return order.Finish
+}
+
+// refundRecord 退款单号对应的本地退款金额，退款单号为订单的退款单号、售后部分退款或重复支付的退款单号
+func (payBillService *PayBillService) refundRecord(order shop.Order, refundSn string) (amount float64, found bool) {
+	if refundSn == "" {
+		return 0, false
+	}
+	if order.RefundSn == refundSn && order.StatusRefund != nil && *order.StatusRefund != RefundStatusNone {
+		return payBillService.refundAmount(order), true
+	}
+	var orderReturn shop.OrderReturn
+	err := global.DB.Where("order_id = ? and status = ? and refund_sn = ?", order.ID, shop.ReturnStatusApproved, refundSn).First(&orderReturn).Error
+	if err != nil || orderReturn.Amount == nil {
+		// 重复支付的退款，退还重复支付的全部金额
+		var duplicate shop.PayDuplicate
+		if global.DB.Where("order_id = ? and refund_sn = ?", order.ID, refundSn).First(&duplicate).Error != nil {
+			return 0, false
+		}
+		return duplicate.Amount, true
+	}
+	if global.Config.WechatPay.Debug {
+		return 0.01, true
+	}
+	return *orderReturn.Amount, true
+}
+
+// parseBillDate 解析账单日期，只能核对今天之前的账单
+func parseBillDate(billDate string) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", billDate, time.Local)
+	if err != nil {
+		return day, errors.New("账单日期格式错误，格式为 2006-01-02")
+	}
+	now := time.Now()
+	if !day.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)) {
+		return day, fmt.Errorf("只能核对 %s 之前的对账单", now.Format("2006-01-02"))
+	}
+	return day, nil
+}
+
+// sameAmount 判断两个金额是否相等，金额精确到分
+func sameAmount(a, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
@@ -0,0 +1,174 @@
+package shop
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/payment"
+	"fresh-shop/server/service/wechat"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const billHeader = "交易时间,公众账号ID,商户号,特约商户号,设备号,微信订单号,商户订单号,用户标识,交易类型,交易状态,付款银行,货币种类,应结订单金额,代金券金额,微信退款单号,商户退款单号,退款金额,充值券退款金额,退款类型,退款状态,商品名称,商户数据包,手续费,费率,订单金额,申请退款金额,费率备注\n"
+
+// billLine 构造对账单中的一笔交易
+func billLine(day, transactionId, orderSn, state string, amount float64, refundSn string, refund float64) string {
+	fields := []interface{}{day + " 10:00:00", "wx-test-app", "1000000001", "0", "", transactionId, orderSn, "openid", "JSAPI", state, "CMB", "CNY",
+		amount, 0.0, "", refundSn, refund, 0.0, "", "", "商品", "", 0.0, "0.60%", amount, refund, ""}
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		if v, ok := f.(float64); ok {
+			parts[i] = fmt.Sprintf("`%.2f", v)
+		} else {
+			parts[i] = fmt.Sprintf("`%v", f)
+		}
+	}
+	return strings.Join(parts, ",") + "\n"
+}
+
+func TestPayBillService_Reconcile(t *testing.T) {
+	setupOrderTestDB(t)
+	day := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	payTime, _ := time.ParseInLocation("2006-01-02 15:04:05", day+" 10:00:00", time.Local)
+	createOrder := func(orderSn, transactionId string, status int, finish float64) shop.Order {
+		order := shop.Order{OrderSn: orderSn, TransationId: transactionId, Status: utils.Pointer(status), StatusCancel: utils.Pointer(0),
+			StatusRefund: utils.Pointer(0), Payment: utils.Pointer(PaymentWechat), Finish: finish}
+		if status > 0 {
+			order.PayTime = utils.Pointer(payTime)
+		}
+		assert.Nil(t, global.DB.Create(&order).Error)
+		return order
+	}
+	createOrder("bill-a", "tx-a", 1, 35.5)
+	mismatch := createOrder("bill-b", "tx-b", 1, 20)
+	missing := createOrder("bill-m", "tx-m", 2, 12)
+	refunded := createOrder("bill-c", "tx-c", 1, 20)
+	global.DB.Model(&refunded).Updates(map[string]interface{}{"status_refund": RefundStatusSuccess, "refund_sn": "bill-cR", "refund_time": payTime})
+	unpaid := createOrder("bill-u", "", 0, 0)
+
+	csv := billHeader +
+		billLine(day, "tx-a", "bill-a", "SUCCESS", 35.5, "", 0) +
+		billLine(day, "tx-b", "bill-b", "SUCCESS", 19, "", 0) +
+		billLine(day, "tx-x", "bill-x", "SUCCESS", 8, "", 0) +
+		billLine(day, "tx-c", "bill-c", "SUCCESS", 20, "", 0) +
+		billLine(day, "tx-c", "bill-c", "REFUND", 20, "bill-cR", 20) +
+		billLine(day, "tx-u", "bill-u", "SUCCESS", 5, "", 0) +
+		"总交易单数,应结订单总金额,退款总金额,充值券退款总金额,手续费总金额,订单总金额,申请退款总金额\n`5,`87.50,`20.00,`0.00,`0.52,`87.50,`20.00\n"
+
+	service := PayBillService{}
+	_, err := service.ImportWechatBill(time.Now().Format("2006-01-02"), strings.NewReader(csv))
+	assert.NotNil(t, err)
+
+	bill, err := service.ImportWechatBill(day, strings.NewReader(csv))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, bill.TradeCount)
+	assert.Equal(t, 87.5, bill.TradeAmount)
+	assert.Equal(t, 1, bill.RefundCount)
+	assert.Equal(t, 20.0, bill.RefundAmount)
+	assert.Equal(t, 3, bill.MatchedCount)
+	assert.Equal(t, 1, bill.MissingCount)
+	assert.Equal(t, 2, bill.MismatchCount)
+	assert.Equal(t, 1, bill.OrphanCount)
+
+	saved, err := service.GetPayBill(bill.ID)
+	assert.Nil(t, err)
+	items := map[string]shop.PayBillItem{}
+	for _, item := range saved.Items {
+		items[item.OrderSn] = item
+	}
+	assert.Equal(t, shop.PayBillItemMismatch, items["bill-b"].Type)
+	assert.Equal(t, mismatch.ID, items["bill-b"].OrderId)
+	assert.Equal(t, 19.0, items["bill-b"].BillAmount)
+	assert.Equal(t, 20.0, items["bill-b"].OrderAmount)
+	assert.Equal(t, shop.PayBillItemMismatch, items["bill-u"].Type)
+	assert.Equal(t, unpaid.ID, items["bill-u"].OrderId)
+	assert.Equal(t, shop.PayBillItemOrphan, items["bill-x"].Type)
+	assert.Equal(t, shop.PayBillItemMissing, items["bill-m"].Type)
+	assert.Equal(t, missing.ID, items["bill-m"].OrderId)
+
+	// 重新下载对账单核对时替换之前的结果
+	download := wechat.DownloadBill
+	wechat.DownloadBill = func(billDate string) ([]byte, error) {
+		assert.Equal(t, strings.ReplaceAll(day, "-", ""), billDate)
+		return nil, nil
+	}
+	defer func() { wechat.DownloadBill = download }()
+	bill, err = service.ReconcileWechatBill(day)
+	assert.Nil(t, err)
+	assert.Equal(t, shop.PayBillSourceDownload, bill.Source)
+	assert.Equal(t, 0, bill.TradeCount)
+	// 本地当天的支付和退款都不在空的对账单中
+	assert.Equal(t, 5, bill.MissingCount)
+
+	list, total, err := service.GetPayBillInfoList(shopReq.PayBillSearch{PageInfo: request.PageInfo{Page: 1, PageSize: 10}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, bill.ID, list[0].ID)
+	var itemCount int64
+	global.DB.Model(&shop.PayBillItem{}).Count(&itemCount)
+	assert.Equal(t, int64(5), itemCount)
+}
+
+func TestPayBillService_ReconcileLatePaid(t *testing.T) {
+	setupOrderTestDB(t)
+	day := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	payTime, _ := time.ParseInLocation("2006-01-02 15:04:05", day+" 10:00:00", time.Local)
+	// 超时取消后才收到支付，订单仍为未支付并原路退款
+	cancelled := shop.Order{OrderSn: "late-c", TransationId: "tx-late", Status: utils.Pointer(0), StatusCancel: utils.Pointer(3), StatusRefund: utils.Pointer(RefundStatusPending),
+		Payment: utils.Pointer(PaymentWechat), Finish: 20, PayTime: utils.Pointer(payTime), RefundSn: "late-cR"}
+	assert.Nil(t, global.DB.Create(&cancelled).Error)
+	// 余额支付后又完成了微信支付
+	paid := shop.Order{OrderSn: "late-d", Status: utils.Pointer(1), StatusCancel: utils.Pointer(0), StatusRefund: utils.Pointer(0),
+		Payment: utils.Pointer(PaymentBalance), Finish: 30, PayTime: utils.Pointer(payTime)}
+	assert.Nil(t, global.DB.Create(&paid).Error)
+	duplicate := shop.PayDuplicate{OrderId: paid.ID, OrderSn: paid.OrderSn, Payment: PaymentWechat, TransactionId: "tx-dup", Amount: 30,
+		PayTime: utils.Pointer(payTime), RefundSn: "late-dR", RefundStatus: utils.Pointer(RefundStatusSuccess), RefundTime: utils.Pointer(payTime)}
+	assert.Nil(t, global.DB.Create(&duplicate).Error)
+
+	csv := billHeader +
+		billLine(day, "tx-late", "late-c", "SUCCESS", 20, "", 0) +
+		billLine(day, "tx-dup", "late-d", "SUCCESS", 30, "", 0) +
+		billLine(day, "tx-dup", "late-d", "REFUND", 30, "late-dR", 30)
+	bill, err := (&PayBillService{}).ImportWechatBill(day, strings.NewReader(csv))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, bill.MatchedCount)
+	assert.Equal(t, 0, bill.MismatchCount)
+	assert.Equal(t, 0, bill.MissingCount)
+	assert.Equal(t, 0, bill.OrphanCount)
+}
+
+// billDownloader 支持下载对账单的模拟支付
+type billDownloader struct {
+	*payment.Mock
+	bill string
+}
+
+func (d billDownloader) DownloadBill(billDate string) ([]byte, error) {
+	return []byte(d.bill), nil
+}
+
+func TestPayBillService_DownloadByProvider(t *testing.T) {
+	setupOrderTestDB(t)
+	day := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	service := PayBillService{}
+	// 未配置 APIv2 密钥且支付方式不支持下载对账单时不自动对账
+	payment.Register(payment.Wechat, payment.NewMock(payment.Wechat, config.PayMock{}))
+	defer payment.Register(payment.Wechat, nil)
+	assert.False(t, service.BillDownloadEnabled())
+
+	// 微信支付 API v3 通过支付方式下载对账单
+	payment.Register(payment.Wechat, billDownloader{Mock: payment.NewMock(payment.Wechat, config.PayMock{}), bill: billHeader + billLine(day, "tx-v3", "bill-v3", "SUCCESS", 10, "", 0)})
+	assert.True(t, service.BillDownloadEnabled())
+	bill, err := service.ReconcileWechatBill(day)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, bill.TradeCount)
+	assert.Equal(t, 1, bill.OrphanCount)
+}
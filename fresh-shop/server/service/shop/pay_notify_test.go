@@ -0,0 +1,208 @@
+package shop
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"fresh-shop/server/config"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/account"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	systemReq "fresh-shop/server/model/system/request"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/payment"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func createPayTestOrder(t *testing.T, orderSn string, userId uint, total float64) shop.Order {
+	order := shop.Order{
+		OrderSn:      orderSn,
+		UserId:       utils.Pointer(int(userId)),
+		GoodsArea:    utils.Pointer(0),
+		Status:       utils.Pointer(0),
+		StatusCancel: utils.Pointer(0),
+		StatusRefund: utils.Pointer(0),
+		Payment:      utils.Pointer(PaymentWechat),
+		ShipmentType: utils.Pointer(0),
+		Total:        total,
+	}
+	assert.Nil(t, global.DB.Create(&order).Error)
+	return order
+}
+
+func TestPaidLogic_OrderPaid(t *testing.T) {
+	setupOrderTestDB(t)
+	mock := payment.NewMock(payment.Wechat, config.PayMock{Result: payment.MockResultSuccess, SkipNotify: true})
+	payment.Register(payment.Wechat, mock)
+	defer payment.Register(payment.Wechat, nil)
+
+	userId := createTestUser(t, "wechat-buyer")
+	createTestAccount(t, userId, common.CASH, 20)
+	order := createPayTestOrder(t, "wxpay-1", userId, 35.5)
+	_, err := mock.Prepay(payment.PrepayReq{OrderId: order.ID, OrderSn: order.OrderSn, Amount: order.PayAmount()})
+	assert.Nil(t, err)
+	trade, _ := mock.Query(order.OrderSn)
+
+	notify := func(trade payment.Trade) error {
+		body, _ := json.Marshal(trade)
+		_, err := payment.HandlePayNotify(payment.Wechat, nil, body)
+		return err
+	}
+	// 金额与订单不一致时拒绝处理
+	tampered := trade
+	tampered.Amount = 35.49
+	assert.NotNil(t, notify(tampered))
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, 0, *order.Status)
+
+	assert.Nil(t, notify(trade))
+	// 重复通知不会重复处理
+	assert.Nil(t, notify(trade))
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, 1, *order.Status)
+	assert.Equal(t, 35.5, order.Finish)
+	assert.Equal(t, trade.TransactionId, order.TransationId)
+	// 已支付的订单收到其他交易的支付结果，记录为重复支付后原路退款，订单不变
+	other := trade
+	other.TransactionId = "tx-2"
+	assert.Nil(t, payment.PaidLogic(payment.Wechat, other, common.OrderActor{Type: common.ActorSystem}, "微信支付成功"))
+	global.DB.First(&order, order.ID)
+	assert.Equal(t, trade.TransactionId, order.TransationId)
+	var duplicate shop.PayDuplicate
+	assert.Nil(t, global.DB.Where("transaction_id = ?", "tx-2").First(&duplicate).Error)
+	assert.Equal(t, 1, *duplicate.RefundStatus)
+
+	// 只记录流水 不变动余额
+	var finances []account.UserFinance
+	global.DB.Table("user_finance_cash").Where("from_id = ?", order.OrderSn).Find(&finances)
+	assert.Len(t, finances, 1)
+	assert.Equal(t, common.FinanceTypeWechatPay, *finances[0].TypeId)
+	assert.Equal(t, -35.5, *finances[0].Amount)
+	assert.Equal(t, 20.0, *finances[0].Balance)
+	assert.Equal(t, 20.0, getTestAccountAmount(userId, common.CASH))
+
+	var logs int64
+	global.DB.Model(&shop.OrderLog{}).Where("order_id = ? and to_state = ?", order.ID, common.OrderStatePaid).Count(&logs)
+	assert.Equal(t, int64(1), logs)
+
+	// 每次通知都保存原始报文和处理结果
+	var records []shop.PayNotify
+	global.DB.Where("payment = ? and type = ?", payment.Wechat, shop.PayNotifyTypePay).Order("id").Find(&records)
+	assert.Len(t, records, 3)
+	assert.Equal(t, shop.PayNotifyStatusFail, *records[0].Status)
+	assert.Equal(t, shop.PayNotifyStatusSuccess, *records[1].Status)
+	assert.Equal(t, "wxpay-1", records[1].OrderSn)
+	assert.Equal(t, trade.TransactionId, records[1].TransactionId)
+}
+
+func TestOrderService_OrderPayAlipay(t *testing.T) {
+	setupOrderTestDB(t)
+	mock := payment.NewMock(payment.Alipay, config.PayMock{Result: payment.MockResultSuccess})
+	payment.Register(payment.Alipay, mock)
+	defer payment.Register(payment.Alipay, nil)
+
+	userId := createTestUser(t, "alipay-buyer")
+	createTestAccount(t, userId, common.CASH, 0)
+	claims := &systemReq.CustomClaims{BaseClaims: systemReq.BaseClaims{ID: userId, Username: "alipay-buyer"}}
+	service := OrderService{}
+
+	// 发起支付时切换订单的支付方式
+	order := createPayTestOrder(t, "alipay-1", userId, 35.5)
+	_, err := service.OrderPay(shopReq.OrderPayReq{ID: order.ID, Payment: PaymentAlipay, BuyerId: "2088000000000001"}, claims, "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		global.DB.First(&order, order.ID)
+		return *order.Status == 1
+	}, 3*time.Second, 20*time.Millisecond)
+	assert.Equal(t, PaymentAlipay, *order.Payment)
+	assert.Equal(t, "2088000000000001", order.PaymentOpenid)
+	assert.True(t, strings.HasPrefix(order.TransationId, "MOCK"))
+
+	var finance account.UserFinance
+	global.DB.Table("user_finance_cash").Where("from_id = ?", order.OrderSn).First(&finance)
+	assert.Equal(t, common.FinanceTypeAlipayPay, *finance.TypeId)
+	assert.Equal(t, -35.5, *finance.Amount)
+
+	// 已支付的订单不能再次支付
+	_, err = service.OrderPay(shopReq.OrderPayReq{ID: order.ID, Payment: PaymentAlipay}, claims, "127.0.0.1")
+	assert.NotNil(t, err)
+
+	// 取消已支付的订单 按支付宝原路退款
+	assert.Nil(t, service.cancelOrder(order, common.OrderActor{Type: common.ActorAdmin, Name: "admin"}, "缺货退款"))
+	assert.Eventually(t, func() bool {
+		global.DB.First(&order, order.ID)
+		return *order.StatusRefund == RefundStatusSuccess
+	}, 3*time.Second, 20*time.Millisecond)
+	assert.NotNil(t, order.RefundTime)
+	var log shop.OrderLog
+	global.DB.Where("order_id = ? and to_state = ?", order.ID, common.OrderStateRefunded).First(&log)
+	assert.Equal(t, common.ActorSystem, log.Actor)
+
+	// 取消未支付的订单关闭支付宝交易
+	mock.Config.Result = payment.MockResultFail
+	unpaid := createPayTestOrder(t, "alipay-2", userId, 10)
+	_, err = service.OrderPay(shopReq.OrderPayReq{ID: unpaid.ID, Payment: PaymentAlipay, BuyerId: "2088000000000001"}, claims, "127.0.0.1")
+	assert.Nil(t, err)
+	global.DB.First(&unpaid, unpaid.ID)
+	assert.Nil(t, service.cancelOrder(unpaid, common.OrderActor{Type: common.ActorUser, Id: userId, Name: "alipay-buyer"}, "不想要了"))
+	trade, err := mock.Query(unpaid.OrderSn)
+	assert.Nil(t, err)
+	assert.Equal(t, payment.TradeClosed, trade.State)
+}
+
+func TestPayment_MockProvider(t *testing.T) {
+	setupOrderTestDB(t)
+	mock := payment.NewMock(payment.Wechat, config.PayMock{Result: payment.MockResultSuccess})
+	payment.Register(payment.Wechat, mock)
+	defer payment.Register(payment.Wechat, nil)
+
+	userId := createTestUser(t, "mock-buyer")
+	createTestAccount(t, userId, common.CASH, 0)
+	order := createPayTestOrder(t, "mock-1", userId, 20)
+
+	// 没有发起过支付的交易不接受通知
+	body, _ := json.Marshal(payment.Trade{OrderSn: order.OrderSn, TransactionId: "MOCK-fake", State: payment.TradeSuccess, Amount: 20})
+	_, err := payment.HandlePayNotify(payment.Wechat, nil, body)
+	assert.NotNil(t, err)
+
+	// 支付成功后异步发送支付通知
+	_, err = mock.Prepay(payment.PrepayReq{OrderId: order.ID, OrderSn: order.OrderSn, Amount: order.PayAmount()})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		global.DB.First(&order, order.ID)
+		return *order.Status == 1
+	}, 3*time.Second, 20*time.Millisecond)
+	assert.Equal(t, 20.0, order.Finish)
+	assert.True(t, strings.HasPrefix(order.TransationId, "MOCK"))
+
+	// 取消已支付的订单 异步通知退款结果
+	assert.Nil(t, (&OrderService{}).cancelOrder(order, common.OrderActor{Type: common.ActorAdmin, Name: "admin"}, "测试退款"))
+	assert.Eventually(t, func() bool {
+		global.DB.First(&order, order.ID)
+		return *order.StatusRefund == RefundStatusSuccess
+	}, 3*time.Second, 20*time.Millisecond)
+	assert.NotNil(t, order.RefundTime)
+
+	// 支付失败时不发送通知 订单保持待支付
+	mock.Config.Result = payment.MockResultFail
+	failed := createPayTestOrder(t, "mock-2", userId, 20)
+	_, err = mock.Prepay(payment.PrepayReq{OrderId: failed.ID, OrderSn: failed.OrderSn, Amount: failed.PayAmount()})
+	assert.Nil(t, err)
+	trade, err := mock.Query(failed.OrderSn)
+	assert.Nil(t, err)
+	assert.Equal(t, payment.TradeFail, trade.State)
+	assert.Nil(t, mock.Close(failed.OrderSn))
+	trade, _ = mock.Query(failed.OrderSn)
+	assert.Equal(t, payment.TradeClosed, trade.State)
+
+	var records []shop.PayNotify
+	global.DB.Where("payment = ?", payment.Wechat).Order("id").Find(&records)
+	assert.Len(t, records, 3)
+	assert.Equal(t, shop.PayNotifyStatusFail, *records[0].Status)
+	assert.Equal(t, shop.PayNotifyTypeRefund, records[2].Type)
+	assert.Equal(t, shop.PayNotifyStatusSuccess, *records[2].Status)
+}
@@ -0,0 +1,190 @@
+package shop
+
+import (
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/common"
+	"math"
+	"strings"
+)
+
+type PostageRuleService struct {
+}
+
+// CreatePostageRule 创建PostageRule记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (postageRuleService *PostageRuleService) CreatePostageRule(postageRule shop.PostageRule) (err error) {
+	err = global.DB.Create(&postageRule).Error
+	return err
+}
+
+// DeletePostageRule 删除PostageRule记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (postageRuleService *PostageRuleService) DeletePostageRule(postageRule shop.PostageRule) (err error) {
+	err = global.DB.Delete(&postageRule).Error
+	return err
+}
+
+// DeletePostageRuleByIds 批量删除PostageRule记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (postageRuleService *PostageRuleService) DeletePostageRuleByIds(ids request.IdsReq) (err error) {
+	err = global.DB.Delete(&[]shop.PostageRule{}, "id in ?", ids.Ids).Error
+	return err
+}
+
+// UpdatePostageRule 更新PostageRule记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (postageRuleService *PostageRuleService) UpdatePostageRule(postageRule shop.PostageRule) (err error) {
+	err = global.DB.Save(&postageRule).Error
+	return err
+}
+
+// GetPostageRule 根据id获取PostageRule记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (postageRuleService *PostageRuleService) GetPostageRule(id uint) (postageRule shop.PostageRule, err error) {
+	err = global.DB.Where("id = ?", id).First(&postageRule).Error
+	return
+}
+
+// GetPostageRuleInfoList 分页获取PostageRule记录
+// Author [dalefeng](https://github.com/dalefeng)
+func (postageRuleService *PostageRuleService) GetPostageRuleInfoList(info shopReq.PostageRuleSearch) (list []shop.PostageRule, total int64, err error) {
+	limit := info.PageSize
+	offset := info.PageSize * (info.Page - 1)
+	// 创建db
+	db := global.DB.Model(&shop.PostageRule{})
+	var postageRules []shop.PostageRule
+	// 如果有条件搜索 下方会自动创建搜索语句
+	if info.StartCreatedAt != nil && info.EndCreatedAt != nil {
+		db = db.Where("created_at BETWEEN ? AND ?", info.StartCreatedAt, info.EndCreatedAt)
+	}
+	if info.Name != "" {
+		db = db.Where("name LIKE ?", "%"+info.Name+"%")
+	}
+	if info.Type != nil {
+		db = db.Where("type = ?", info.Type)
+	}
+	if info.Status != nil {
+		db = db.Where("status = ?", info.Status)
+	}
+	err = db.Count(&total).Error
+	if err != nil {
+		return
+	}
+
+	err = db.Order("type asc, sort asc, id asc").Limit(limit).Offset(offset).Find(&postageRules).Error
+	return postageRules, total, err
+}
+
+// CalcPostage 计算订单邮费
+// 自提订单不收邮费；配送订单按 指定地区 > 距离区间 > 默认规则 匹配运费规则，没有匹配的规则时不收邮费
+// total 为商品总金额，weight 为商品总重量(克)
+func (postageRuleService *PostageRuleService) CalcPostage(shipmentType int, address shop.UserAddress, total float64, weight int) (float64, error) {
+	if shipmentType == 1 {
+		return 0, nil
+	}
+	var rules []shop.PostageRule
+	if err := global.DB.Where("status = 1").Order("sort asc, id asc").Find(&rules).Error; err != nil {
+		global.SugarLog.Errorf("查询运费规则失败, err:%v \n", err)
+		return 0, err
+	}
+	rule := matchPostageRule(rules, address)
+	if rule == nil {
+		return 0, nil
+	}
+	return postageFee(*rule, total, weight), nil
+}
+
+// matchPostageRule 根据收货地址匹配运费规则
+func matchPostageRule(rules []shop.PostageRule, address shop.UserAddress) *shop.PostageRule {
+	// 指定地区 按地区编码前缀匹配 匹配最长的编码
+	var areaRule *shop.PostageRule
+	areaLen := 0
+	if address.Area != "" {
+		for i, r := range rules {
+			if r.Type == nil || *r.Type != shop.PostageRuleArea {
+				continue
+			}
+			for _, area := range strings.Split(r.Area, ",") {
+				area = strings.TrimSpace(area)
+				if area != "" && strings.HasPrefix(address.Area, area) && len(area) > areaLen {
+					areaRule, areaLen = &rules[i], len(area)
+				}
+			}
+		}
+	}
+	if areaRule != nil {
+		return areaRule
+	}
+	// 距离区间 需要店铺位置和收货地址经纬度
+	if distance, ok := addressDistance(address); ok {
+		for i, r := range rules {
+			if r.Type == nil || *r.Type != shop.PostageRuleDistance {
+				continue
+			}
+			min, max := 0.0, 0.0
+			if r.MinDistance != nil {
+				min = *r.MinDistance
+			}
+			if r.MaxDistance != nil {
+				max = *r.MaxDistance
+			}
+			if distance >= min && (max <= 0 || distance < max) {
+				return &rules[i]
+			}
+		}
+	}
+	for i, r := range rules {
+		if r.Type == nil || *r.Type == shop.PostageRuleDefault {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// postageFee 按运费规则计算邮费 满包邮金额免邮，否则 首重费用 + 超出首重部分按续重向上取整计费
+func postageFee(rule shop.PostageRule, total float64, weight int) float64 {
+	if rule.FreeAmount != nil && *rule.FreeAmount > 0 && total >= *rule.FreeAmount {
+		return 0
+	}
+	fee := 0.0
+	if rule.FirstFee != nil {
+		fee = *rule.FirstFee
+	}
+	firstWeight, additionalWeight := 0, 0
+	if rule.FirstWeight != nil {
+		firstWeight = *rule.FirstWeight
+	}
+	if rule.AdditionalWeight != nil {
+		additionalWeight = *rule.AdditionalWeight
+	}
+	if weight > firstWeight && additionalWeight > 0 && rule.AdditionalFee != nil {
+		n := math.Ceil(float64(weight-firstWeight) / float64(additionalWeight))
+		fee += n * *rule.AdditionalFee
+	}
+	return math.Round(fee*100) / 100
+}
+
+// addressDistance 计算收货地址到店铺的距离(公里)，缺少经纬度时 ok 返回 false
+func addressDistance(address shop.UserAddress) (distance float64, ok bool) {
+	if address.Longitude == nil || address.Latitude == nil || (*address.Longitude == 0 && *address.Latitude == 0) {
+		return 0, false
+	}
+	lng, lat, ok := common.GetShopLocation()
+	if !ok {
+		return 0, false
+	}
+	return sphereDistance(lat, lng, *address.Latitude, *address.Longitude), true
+}
+
+// sphereDistance 使用 haversine 公式计算两个经纬度之间的距离(公里)
+func sphereDistance(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadius = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLng := (lng2 - lng1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
@@ -0,0 +1,85 @@
+package shop
+
+import (
+	"testing"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	"fresh-shop/server/model/system"
+	systemReq "fresh-shop/server/model/system/request"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostageRuleService_CalcPostage(t *testing.T) {
+	setupOrderTestDB(t)
+	// 店铺位置 深圳市民中心
+	global.DB.Create(&system.SysConfig{Name: "shopLocation", Value: "114.0596,22.5429", Status: utils.Pointer(1)})
+	rules := []shop.PostageRule{
+		{Name: "默认", Type: utils.Pointer(shop.PostageRuleDefault), FreeAmount: utils.Pointer(199.0), FirstWeight: utils.Pointer(1000), FirstFee: utils.Pointer(8.0), AdditionalWeight: utils.Pointer(500), AdditionalFee: utils.Pointer(2.0)},
+		{Name: "广州", Type: utils.Pointer(shop.PostageRuleArea), Area: "4401", FirstWeight: utils.Pointer(1000), FirstFee: utils.Pointer(12.0)},
+		{Name: "5公里内", Type: utils.Pointer(shop.PostageRuleDistance), MinDistance: utils.Pointer(0.0), MaxDistance: utils.Pointer(5.0), FreeAmount: utils.Pointer(49.0), FirstFee: utils.Pointer(3.0)},
+	}
+	assert.Nil(t, global.DB.Create(&rules).Error)
+	service := PostageRuleService{}
+
+	near := shop.UserAddress{Area: "440304", Longitude: utils.Pointer(114.0700), Latitude: utils.Pointer(22.5500)}
+	far := shop.UserAddress{Area: "440307", Longitude: utils.Pointer(114.2500), Latitude: utils.Pointer(22.7200)}
+	guangzhou := shop.UserAddress{Area: "440106", Longitude: utils.Pointer(113.3612), Latitude: utils.Pointer(23.1247)}
+	cases := []struct {
+		name         string
+		shipmentType int
+		address      shop.UserAddress
+		total        float64
+		weight       int
+		postage      float64
+	}{
+		{"自提", 1, near, 10, 3000, 0},
+		{"距离区间", 0, near, 10, 3000, 3},
+		{"距离区间满额包邮", 0, near, 49, 3000, 0},
+		{"默认首重", 0, far, 10, 800, 8},
+		{"默认续重", 0, far, 10, 2100, 8 + 3*2},
+		{"默认满额包邮", 0, far, 199, 2100, 0},
+		{"指定地区优先", 0, guangzhou, 10, 2100, 12},
+		{"无经纬度使用默认规则", 0, shop.UserAddress{Area: "440304"}, 10, 1000, 8},
+	}
+	for _, c := range cases {
+		postage, err := service.CalcPostage(c.shipmentType, c.address, c.total, c.weight)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.postage, postage, c.name)
+	}
+}
+
+func TestOrderService_CreateOrder_Postage(t *testing.T) {
+	setupOrderTestDB(t)
+	global.DB.Create(&shop.PostageRule{Name: "默认", Type: utils.Pointer(shop.PostageRuleDefault), FirstWeight: utils.Pointer(1000), FirstFee: utils.Pointer(8.0), AdditionalWeight: utils.Pointer(1000), AdditionalFee: utils.Pointer(3.0)})
+	goods := shop.Goods{
+		Name:      "冷冻龙利鱼",
+		SpecType:  utils.Pointer(0),
+		Unit:      "袋",
+		CostPrice: utils.Pointer(20.0),
+		Price:     utils.Pointer(0.0),
+		Weight:    utils.Pointer(800),
+		Store:     utils.Pointer(10),
+		Sale:      utils.Pointer(0),
+	}
+	assert.Nil(t, global.DB.Create(&goods).Error)
+	userId := createTestUser(t, "postage-buyer")
+	address := shop.UserAddress{UserId: utils.Pointer(int(userId)), Area: "440304", Sex: utils.Pointer(1)}
+	assert.Nil(t, global.DB.Create(&address).Error)
+	cart := shop.Cart{GoodsId: utils.Pointer(int(goods.ID)), UserId: utils.Pointer(int(userId)), Num: 3, Checked: utils.Pointer(1)}
+	assert.Nil(t, global.DB.Create(&cart).Error)
+
+	service := OrderService{}
+	order := shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(0), AddressId: int(address.ID)}
+	preview, err := service.PreviewOrder(order)
+	assert.Nil(t, err)
+	// 2400g 首重 1000g 8 元 续重 2 * 3 元
+	assert.Equal(t, 14.0, preview.Postage)
+	assert.Equal(t, 74.0, preview.PayAmount)
+
+	created, err := service.CreateOrder(order, &systemReq.CustomClaims{}, "127.0.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, 14.0, created.Order.Postage)
+	assert.Equal(t, preview.PayAmount, created.Order.PayAmount())
+}
@@ -0,0 +1,251 @@
+package shop
+
+import (
+	"fmt"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	"fresh-shop/server/service/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StockNotEnoughError 库存不足错误，记录具体库存不足的商品
+type StockNotEnoughError struct {
+	GoodsId     uint   // 商品id
+	SpecValueId int    // 规格明细id 单规格商品为 0
+	Name        string // 商品名称 多规格商品包含规格名称
+	Num         int    // 购买数量
+	Store       int    // 当前库存
+}
+
+func (e *StockNotEnoughError) Error() string {
+	return fmt.Sprintf("商品【%s】库存不足，剩余库存 %d，购买数量 %d", e.Name, e.Store, e.Num)
+}
+
+// deductGoodsStock 扣减商品库存并增加销量，必须在事务中调用
+// 使用 store >= num 作为更新条件，并发下单时不会出现负库存，库存不足时返回 *StockNotEnoughError
+// specValueId > 0 时扣减规格明细库存，商品只累加总销量，扣减后记录库存流水
+func deductGoodsStock(tx *gorm.DB, goodsId uint, specValueId int, num int, name string, ref stockRef) error {
+	if specValueId > 0 {
+		result := tx.Model(&shop.GoodsSpecValue{}).
+			Where("id = ? and goods_id = ? and store >= ?", specValueId, goodsId, num).
+			Updates(map[string]interface{}{
+				"store": gorm.Expr("store - ?", num),
+				"sale":  gorm.Expr("sale + ?", num),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return newStockNotEnoughError(tx, goodsId, specValueId, num, name)
+		}
+		if err := tx.Model(&shop.Goods{}).Where("id = ?", goodsId).
+			Update("sale", gorm.Expr("sale + ?", num)).Error; err != nil {
+			return err
+		}
+		return recordStockChange(tx, goodsId, specValueId, -num, ref)
+	}
+	result := tx.Model(&shop.Goods{}).
+		Where("id = ? and store >= ?", goodsId, num).
+		Updates(map[string]interface{}{
+			"store": gorm.Expr("store - ?", num),
+			"sale":  gorm.Expr("sale + ?", num),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return newStockNotEnoughError(tx, goodsId, specValueId, num, name)
+	}
+	return recordStockChange(tx, goodsId, specValueId, -num, ref)
+}
+
+// newStockNotEnoughError 查询当前库存并组织库存不足错误
+func newStockNotEnoughError(tx *gorm.DB, goodsId uint, specValueId int, num int, name string) error {
+	var store int
+	if specValueId > 0 {
+		tx.Model(&shop.GoodsSpecValue{}).Select("store").Where("id = ?", specValueId).Scan(&store)
+	} else {
+		tx.Model(&shop.Goods{}).Select("store").Where("id = ?", goodsId).Scan(&store)
+	}
+	global.SugarLog.Errorf("扣减库存失败, 库存不足 goodsId:%d, specValueId:%d, 购买数量:%d, 库存数量:%d \n", goodsId, specValueId, num, store)
+	return &StockNotEnoughError{
+		GoodsId:     goodsId,
+		SpecValueId: specValueId,
+		Name:        name,
+		Num:         num,
+		Store:       store,
+	}
+}
+
+// restoreGoodsStock 归还商品库存并扣减销量，必须在事务中调用
+// 用于订单取消、售后退货等场景，specValueId > 0 时归还规格明细库存
+// 商品或规格明细已删除时不归还，也不记录库存流水
+func restoreGoodsStock(tx *gorm.DB, goodsId uint, specValueId int, num int, ref stockRef) error {
+	if num <= 0 {
+		return nil
+	}
+	if specValueId > 0 {
+		result := tx.Model(&shop.GoodsSpecValue{}).Where("id = ?", specValueId).Updates(map[string]interface{}{
+			"store": gorm.Expr("store + ?", num),
+			"sale":  gorm.Expr("CASE WHEN sale >= ? THEN sale - ? ELSE 0 END", num, num),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := tx.Model(&shop.Goods{}).Where("id = ?", goodsId).
+			Update("sale", gorm.Expr("CASE WHEN sale >= ? THEN sale - ? ELSE 0 END", num, num)).Error; err != nil {
+			return err
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		return recordStockChange(tx, goodsId, specValueId, num, ref)
+	}
+	result := tx.Model(&shop.Goods{}).Where("id = ?", goodsId).Updates(map[string]interface{}{
+		"store": gorm.Expr("store + ?", num),
+		"sale":  gorm.Expr("CASE WHEN sale >= ? THEN sale - ? ELSE 0 END", num, num),
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+	return recordStockChange(tx, goodsId, 0, num, ref)
+}
+
+// restoreOrderStock 取消订单时归还订单中所有商品的库存
+func restoreOrderStock(tx *gorm.DB, order shop.Order, actor common.OrderActor) error {
+	var details []shop.OrderDetails
+	if err := tx.Where("order_id = ?", order.ID).Find(&details).Error; err != nil {
+		return err
+	}
+	ref := stockRef{Reason: shop.StockReasonCancel, RefId: order.OrderSn, Actor: actor}
+	for _, d := range details {
+		if err := restoreGoodsStock(tx, d.GoodsId, d.SpecId, d.Num, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stockRef 库存变更的原因、关联单号和操作人，记录在库存流水中
+type stockRef struct {
+	Reason string
+	RefId  string
+	Actor  common.OrderActor
+	Remark string
+}
+
+// stockItem 商品或规格明细的名称和当前库存
+type stockItem struct {
+	GoodsId     uint
+	SpecValueId int
+	GoodsName   string
+	KeyName     string
+	Store       int
+}
+
+// getStockItem 查询商品或规格明细的当前库存，已删除的商品也能查到，用于记录流水
+func getStockItem(tx *gorm.DB, goodsId uint, specValueId int) (item stockItem, err error) {
+	item = stockItem{GoodsId: goodsId, SpecValueId: specValueId}
+	var goods shop.Goods
+	if err = tx.Unscoped().Select("id, name, store").Where("id = ?", goodsId).First(&goods).Error; err != nil {
+		return
+	}
+	item.GoodsName = goods.Name
+	if specValueId == 0 {
+		if goods.Store != nil {
+			item.Store = *goods.Store
+		}
+		return
+	}
+	var value shop.GoodsSpecValue
+	if err = tx.Unscoped().Select("id, key_name, store").Where("id = ?", specValueId).First(&value).Error; err != nil {
+		return
+	}
+	item.KeyName = value.KeyName
+	if value.Store != nil {
+		item.Store = *value.Store
+	}
+	return
+}
+
+// recordStockChange 库存变更后记录库存流水，必须在变更库存的事务中调用
+// 读取变更后的库存，变更前的库存由变更数量倒推，delta 为 0 时不记录
+func recordStockChange(tx *gorm.DB, goodsId uint, specValueId int, delta int, ref stockRef) error {
+	if delta == 0 {
+		return nil
+	}
+	item, err := getStockItem(tx, goodsId, specValueId)
+	if err != nil {
+		return err
+	}
+	return addStockLog(tx, item, item.Store-delta, item.Store, ref)
+}
+
+// getSpecValueStock 按规格项组合查询规格明细的当前库存
+func getSpecValueStock(tx *gorm.DB, goodsId uint, itemIds string) (item stockItem, err error) {
+	var value shop.GoodsSpecValue
+	if err = tx.Select("id, key_name, store").Where("goods_id = ? and item_ids = ?", goodsId, itemIds).First(&value).Error; err != nil {
+		return
+	}
+	item = stockItem{GoodsId: goodsId, SpecValueId: int(value.ID), KeyName: value.KeyName}
+	if value.Store != nil {
+		item.Store = *value.Store
+	}
+	return
+}
+
+// recordStockUpdate 编辑或导入商品直接覆盖库存后记录库存流水，before 为覆盖前的库存，新建时库存为 0
+func recordStockUpdate(tx *gorm.DB, before stockItem, ref stockRef) error {
+	item, err := getStockItem(tx, before.GoodsId, before.SpecValueId)
+	if err != nil {
+		return err
+	}
+	return addStockLog(tx, item, before.Store, item.Store, ref)
+}
+
+// lockStock 锁定读取库存的查询，覆盖库存前读取的库存与保存之间不会被下单、取消订单等修改
+// Session 避免同一个 tx 多次查询时条件累加
+func lockStock(tx *gorm.DB) *gorm.DB {
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).Session(&gorm.Session{})
+}
+
+// setGoodsStock 将商品或规格明细的库存设置为 store 并记录库存流水，用于盘点
+// 锁定读取盘点前的库存，防止与下单、取消订单等同时变更库存时流水的变更数量错误
+func setGoodsStock(tx *gorm.DB, goodsId uint, specValueId int, store int, ref stockRef) error {
+	item, err := getStockItem(lockStock(tx), goodsId, specValueId)
+	if err != nil {
+		return err
+	}
+	if specValueId > 0 {
+		err = tx.Model(&shop.GoodsSpecValue{}).Where("id = ? and goods_id = ?", specValueId, goodsId).Update("store", store).Error
+	} else {
+		err = tx.Model(&shop.Goods{}).Where("id = ?", goodsId).Update("store", store).Error
+	}
+	if err != nil {
+		return err
+	}
+	return addStockLog(tx, item, item.Store, store, ref)
+}
+
+// addStockLog 保存库存流水，库存没有变化时不记录
+func addStockLog(tx *gorm.DB, item stockItem, before, after int, ref stockRef) error {
+	if before == after {
+		return nil
+	}
+	return tx.Create(&shop.StockLog{
+		GoodsId:     item.GoodsId,
+		SpecValueId: item.SpecValueId,
+		GoodsName:   item.GoodsName,
+		KeyName:     item.KeyName,
+		Delta:       after - before,
+		Before:      before,
+		After:       after,
+		Reason:      ref.Reason,
+		RefId:       ref.RefId,
+		Actor:       ref.Actor.Type,
+		OperatorId:  ref.Actor.Id,
+		Operator:    ref.Actor.Name,
+		Remark:      ref.Remark,
+	}).Error
+}
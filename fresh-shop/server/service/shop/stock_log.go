@@ -0,0 +1,147 @@
+package shop
+
+import (
+	"errors"
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/utils"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"io"
+)
+
+type StockLogService struct {
+}
+
+const (
+	stockLogExportSheet     = "库存流水"
+	stockLogExportBatchSize = 1000
+)
+
+var excelStockLogHeader = []interface{}{
+	"时间", "商品id", "商品名称", "规格明细id", "规格", "变更原因", "变更数量", "变更前库存", "变更后库存",
+	"关联单号", "操作人类型", "操作人", "备注",
+}
+
+var stockReasonNames = map[string]string{
+	shop.StockReasonOrder:     "下单",
+	shop.StockReasonCancel:    "取消订单",
+	shop.StockReasonReturn:    "售后退货",
+	shop.StockReasonImport:    "导入",
+	shop.StockReasonManual:    "编辑商品",
+	shop.StockReasonStocktake: "盘点",
+}
+
+// GetStockLogInfoList 分页获取库存流水
+func (stockLogService *StockLogService) GetStockLogInfoList(info shopReq.StockLogSearch) (list []shop.StockLog, total int64, err error) {
+	limit := info.PageSize
+	offset := info.PageSize * (info.Page - 1)
+	db := global.DB.Model(&shop.StockLog{}).Scopes(stockLogSearchScope(info))
+	if err = db.Count(&total).Error; err != nil {
+		return
+	}
+	err = db.Order("id desc").Limit(limit).Offset(offset).Find(&list).Error
+	return list, total, err
+}
+
+// ExportStockLogs 按库存流水的搜索条件导出 Excel，分批查询并使用 StreamWriter 写入
+func (stockLogService *StockLogService) ExportStockLogs(info shopReq.StockLogSearch, w io.Writer) (err error) {
+	ex := excelize.NewFile()
+	defer func() {
+		if err := ex.Close(); err != nil {
+			global.SugarLog.Errorf("excelize.close %v", err)
+		}
+	}()
+	if err = ex.SetSheetName(Sheet1, stockLogExportSheet); err != nil {
+		return err
+	}
+	writer, err := ex.NewStreamWriter(stockLogExportSheet)
+	if err != nil {
+		return err
+	}
+	if err = writer.SetRow("A1", excelStockLogHeader); err != nil {
+		return err
+	}
+	rowIndex := 2
+	var logs []shop.StockLog
+	result := global.DB.Model(&shop.StockLog{}).Scopes(stockLogSearchScope(info)).
+		FindInBatches(&logs, stockLogExportBatchSize, func(tx *gorm.DB, batch int) error {
+			for _, l := range logs {
+				cell, _ := excelize.CoordinatesToCellName(1, rowIndex)
+				row := []interface{}{
+					timeCell(&l.CreatedAt), l.GoodsId, l.GoodsName, l.SpecValueId, l.KeyName, stockReasonNames[l.Reason],
+					l.Delta, l.Before, l.After, l.RefId, l.Actor, l.Operator, l.Remark,
+				}
+				if err := writer.SetRow(cell, row); err != nil {
+					return err
+				}
+				rowIndex++
+			}
+			return nil
+		})
+	if result.Error != nil {
+		global.SugarLog.Errorf("导出库存流水失败 %v", result.Error)
+		return errors.New("导出库存流水失败")
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	return ex.Write(w)
+}
+
+// Stocktake 盘点 按实际盘点数量修改库存，同一次盘点的流水使用相同的盘点单号，返回盘点单号
+func (stockLogService *StockLogService) Stocktake(req shopReq.StocktakeReq, actor common.OrderActor) (refId string, err error) {
+	if len(req.Items) == 0 {
+		return "", errors.New("请选择盘点商品")
+	}
+	for _, item := range req.Items {
+		if item.GoodsId == 0 || item.Store < 0 {
+			return "", errors.New("盘点商品或数量错误")
+		}
+	}
+	refId = utils.GenerateOrderNumber("ST")
+	ref := stockRef{Reason: shop.StockReasonStocktake, RefId: refId, Actor: actor, Remark: req.Remark}
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		for _, item := range req.Items {
+			if err := setGoodsStock(tx, item.GoodsId, item.SpecValueId, item.Store, ref); err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errors.New("盘点商品不存在")
+				}
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		global.SugarLog.Errorf("盘点失败 refId:%s, err:%v \n", refId, err)
+		return "", err
+	}
+	return refId, nil
+}
+
+// stockLogSearchScope 库存流水列表和导出共用的搜索条件
+func stockLogSearchScope(info shopReq.StockLogSearch) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if info.GoodsId > 0 {
+			db = db.Where("goods_id = ?", info.GoodsId)
+		}
+		if info.SpecValueId != nil {
+			db = db.Where("spec_value_id = ?", info.SpecValueId)
+		}
+		if info.GoodsName != "" {
+			db = db.Where("goods_name LIKE ?", "%"+info.GoodsName+"%")
+		}
+		if info.Reason != "" {
+			db = db.Where("reason = ?", info.Reason)
+		}
+		if info.RefId != "" {
+			db = db.Where("ref_id = ?", info.RefId)
+		}
+		if info.StartCreatedAt != nil && info.EndCreatedAt != nil {
+			db = db.Where("created_at BETWEEN ? AND ?", info.StartCreatedAt, info.EndCreatedAt)
+		}
+		return db
+	}
+}
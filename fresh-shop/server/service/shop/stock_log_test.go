@@ -0,0 +1,128 @@
+package shop
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"fresh-shop/server/global"
+	"fresh-shop/server/model/common/request"
+	"fresh-shop/server/model/shop"
+	shopReq "fresh-shop/server/model/shop/request"
+	systemReq "fresh-shop/server/model/system/request"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestStockLogService_OrderAndStocktake(t *testing.T) {
+	setupOrderTestDB(t)
+	fish := shop.Goods{Name: "冷冻带鱼", SpecType: utils.Pointer(0), Unit: "袋", CostPrice: utils.Pointer(20.0), Price: utils.Pointer(0.0), Weight: utils.Pointer(500), Store: utils.Pointer(5), Sale: utils.Pointer(0)}
+	wing := shop.Goods{Name: "冷冻鸡翅", SpecType: utils.Pointer(1), Unit: "袋", CostPrice: utils.Pointer(30.0), Price: utils.Pointer(0.0), Weight: utils.Pointer(0), Store: utils.Pointer(0), Sale: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&fish).Error)
+	assert.Nil(t, global.DB.Create(&wing).Error)
+	small := shop.GoodsSpecValue{GoodsId: wing.ID, KeyName: "重量:500g", CostPrice: utils.Pointer(20.0), Price: utils.Pointer(0.0), Store: utils.Pointer(3), Sale: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&small).Error)
+
+	userId := createTestUser(t, "stock-buyer")
+	carts := []shop.Cart{
+		{GoodsId: utils.Pointer(int(fish.ID)), UserId: utils.Pointer(int(userId)), Num: 2, Checked: utils.Pointer(1)},
+		{GoodsId: utils.Pointer(int(wing.ID)), UserId: utils.Pointer(int(userId)), SpecType: 1, SpecItemId: int(small.ID), Num: 1, Checked: utils.Pointer(1)},
+	}
+	assert.Nil(t, global.DB.Create(&carts).Error)
+	orderService := OrderService{}
+	resp, err := orderService.CreateOrder(shop.Order{UserId: utils.Pointer(int(userId)), ShipmentType: utils.Pointer(1)}, &systemReq.CustomClaims{}, "127.0.0.1")
+	assert.Nil(t, err)
+
+	// 下单扣减的库存按商品和规格明细分别记录
+	var logs []shop.StockLog
+	global.DB.Where("reason = ?", shop.StockReasonOrder).Order("goods_id").Find(&logs)
+	assert.Len(t, logs, 2)
+	assert.Equal(t, []int{-2, 5, 3}, []int{logs[0].Delta, logs[0].Before, logs[0].After})
+	assert.Equal(t, "冷冻带鱼", logs[0].GoodsName)
+	assert.Equal(t, resp.Order.OrderSn, logs[0].RefId)
+	assert.Equal(t, common.ActorUser, logs[0].Actor)
+	assert.Equal(t, []int{-1, 3, 2}, []int{logs[1].Delta, logs[1].Before, logs[1].After})
+	assert.Equal(t, int(small.ID), logs[1].SpecValueId)
+	assert.Equal(t, "重量:500g", logs[1].KeyName)
+
+	// 商品删除后取消订单不归还库存，也不记录流水
+	assert.Nil(t, global.DB.Delete(&small).Error)
+	assert.Nil(t, orderService.cancelOrder(resp.Order, common.OrderActor{Type: common.ActorAdmin, Name: "admin"}, "缺货"))
+	logs = nil
+	global.DB.Where("reason = ?", shop.StockReasonCancel).Find(&logs)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, []int{2, 3, 5}, []int{logs[0].Delta, logs[0].Before, logs[0].After})
+	assert.Equal(t, common.ActorAdmin, logs[0].Actor)
+
+	// 盘点只记录有差异的商品
+	stockLogService := StockLogService{}
+	admin := common.OrderActor{Type: common.ActorAdmin, Id: 1, Name: "admin"}
+	refId, err := stockLogService.Stocktake(shopReq.StocktakeReq{Items: []shopReq.StocktakeItem{
+		{GoodsId: fish.ID, Store: 4},
+		{GoodsId: wing.ID, Store: 0},
+	}, Remark: "月末盘点"}, admin)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(refId, "ST"))
+	var dbFish shop.Goods
+	global.DB.First(&dbFish, fish.ID)
+	assert.Equal(t, 4, *dbFish.Store)
+	_, err = stockLogService.Stocktake(shopReq.StocktakeReq{Items: []shopReq.StocktakeItem{{GoodsId: 999, Store: 1}}}, admin)
+	assert.NotNil(t, err)
+
+	list, total, err := stockLogService.GetStockLogInfoList(shopReq.StockLogSearch{RefId: refId, PageInfo: request.PageInfo{Page: 1, PageSize: 10}})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []int{-1, 5, 4}, []int{list[0].Delta, list[0].Before, list[0].After})
+	assert.Equal(t, "admin", list[0].Operator)
+	assert.Equal(t, "月末盘点", list[0].Remark)
+
+	// 每一次库存变化都能由流水还原
+	_, total, _ = stockLogService.GetStockLogInfoList(shopReq.StockLogSearch{GoodsId: fish.ID, PageInfo: request.PageInfo{Page: 1, PageSize: 10}})
+	assert.Equal(t, int64(3), total)
+	var sum int
+	global.DB.Model(&shop.StockLog{}).Where("goods_id = ? and spec_value_id = 0", fish.ID).Select("sum(delta)").Scan(&sum)
+	assert.Equal(t, *dbFish.Store-5, sum)
+
+	var buf bytes.Buffer
+	assert.Nil(t, stockLogService.ExportStockLogs(shopReq.StockLogSearch{GoodsId: fish.ID}, &buf))
+	f, err := excelize.OpenReader(&buf)
+	assert.Nil(t, err)
+	rows, err := f.GetRows(stockLogExportSheet)
+	assert.Nil(t, err)
+	assert.Len(t, rows, 4)
+	assert.Equal(t, "冷冻带鱼", rows[1][2])
+	assert.Equal(t, "下单", rows[1][5])
+	assert.Equal(t, "-2", rows[1][6])
+	assert.Equal(t, "盘点", rows[3][5])
+}
+
+func TestStockLogService_StocktakeSpecValue(t *testing.T) {
+	setupOrderTestDB(t)
+	wing := shop.Goods{Name: "冷冻鸡翅", SpecType: utils.Pointer(1), Unit: "袋", Store: utils.Pointer(0), Sale: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&wing).Error)
+	small := shop.GoodsSpecValue{GoodsId: wing.ID, KeyName: "重量:500g", Store: utils.Pointer(3), Sale: utils.Pointer(0)}
+	large := shop.GoodsSpecValue{GoodsId: wing.ID, KeyName: "重量:1kg", Store: utils.Pointer(6), Sale: utils.Pointer(0)}
+	assert.Nil(t, global.DB.Create(&small).Error)
+	assert.Nil(t, global.DB.Create(&large).Error)
+
+	// 盘点前的库存在同一事务中锁定读取，每个规格明细按各自的库存计算变更数量
+	refId, err := (&StockLogService{}).Stocktake(shopReq.StocktakeReq{Items: []shopReq.StocktakeItem{
+		{GoodsId: wing.ID, SpecValueId: int(small.ID), Store: 1},
+		{GoodsId: wing.ID, SpecValueId: int(large.ID), Store: 8},
+	}}, common.OrderActor{Type: common.ActorAdmin, Id: 1, Name: "admin"})
+	assert.Nil(t, err)
+	global.DB.First(&small, small.ID)
+	global.DB.First(&large, large.ID)
+	assert.Equal(t, 1, *small.Store)
+	assert.Equal(t, 8, *large.Store)
+
+	var logs []shop.StockLog
+	global.DB.Where("ref_id = ?", refId).Order("spec_value_id").Find(&logs)
+	assert.Len(t, logs, 2)
+	assert.Equal(t, []int{-2, 3, 1}, []int{logs[0].Delta, logs[0].Before, logs[0].After})
+	assert.Equal(t, "重量:500g", logs[0].KeyName)
+	assert.Equal(t, []int{2, 6, 8}, []int{logs[1].Delta, logs[1].Before, logs[1].After})
+	assert.Equal(t, "重量:1kg", logs[1].KeyName)
+}
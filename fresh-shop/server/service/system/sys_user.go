@@ -207,6 +207,22 @@ func (userService *UserService) ChangePassword(u *system.SysUser, newPassword st
 
 }
 
+//@function: SetSafePassword
+//@description: 设置用户安全密码，已设置过安全密码时需要验证原安全密码
+//@param: id uint, oldSafePassword string, safePassword string
+//@return: err error
+
+func (userService *UserService) SetSafePassword(id uint, oldSafePassword, safePassword string) (err error) {
+	var user system.SysUser
+	if err = global.DB.Where("id = ?", id).First(&user).Error; err != nil {
+		return err
+	}
+	if user.SafePassword != "" && !utils.BcryptCheck(oldSafePassword, user.SafePassword) {
+		return errors.New("原安全密码错误")
+	}
+	return global.DB.Model(&user).Update("safe_password", utils.BcryptHash(safePassword)).Error
+}
+
 //@author: [dalefeng](https://github.com/dalefeng)
 //@function: GetUserInfoList
 //@description: 分页获取数据
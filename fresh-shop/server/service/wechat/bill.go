@@ -0,0 +1,180 @@
+package wechat
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"fresh-shop/server/global"
+	"github.com/silenceper/wechat/v2/util"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var downloadBillGateway = "https://api.mch.weixin.qq.com/pay/downloadbill"
+
+// 对账单中的交易状态
+const (
+	BillTradeSuccess = "SUCCESS" // 支付成功
+	BillTradeRefund  = "REFUND"  // 转入退款
+)
+
+// BillRow 微信支付对账单中的一笔交易，金额单位为元
+type BillRow struct {
+	TradeTime     string  // 交易时间
+	TransactionId string  // 微信订单号
+	OrderSn       string  // 商户订单号
+	TradeState    string  // 交易状态 SUCCESS 支付成功 REFUND 退款
+	Amount        float64 // 订单金额
+	RefundId      string  // 微信退款单号
+	RefundSn      string  // 商户退款单号
+	RefundAmount  float64 // 退款金额
+}
+
+// downloadBillRequest 下载对账单接口请求参数
+type downloadBillRequest struct {
+	XMLName  xml.Name `xml:"xml"`
+	AppID    string   `xml:"appid"`
+	MchID    string   `xml:"mch_id"`
+	NonceStr string   `xml:"nonce_str"`
+	Sign     string   `xml:"sign"`
+	BillDate string   `xml:"bill_date"`
+	BillType string   `xml:"bill_type"`
+}
+
+// downloadBillError 下载对账单失败时返回的 xml
+type downloadBillError struct {
+	ReturnCode string `xml:"return_code"`
+	ReturnMsg  string `xml:"return_msg"`
+	ErrorCode  string `xml:"error_code"`
+}
+
+// DownloadBill 下载指定日期的微信支付交易对账单，billDate 格式为 20060102
+// 当天没有交易时返回空的对账单，测试时可替换为本地实现
+var DownloadBill = func(billDate string) ([]byte, error) {
+	if global.WxPay == nil {
+		return nil, errors.New("未配置微信支付")
+	}
+	params := map[string]string{
+		"appid":     global.Config.Wechat.Appid,
+		"mch_id":    global.Config.WechatPay.MchId,
+		"nonce_str": util.RandomStr(32),
+		"bill_date": billDate,
+		"bill_type": "ALL",
+	}
+	sign, err := util.ParamSign(params, global.Config.WechatPay.ApiV2Key)
+	if err != nil {
+		return nil, err
+	}
+	req := downloadBillRequest{
+		AppID:    params["appid"],
+		MchID:    params["mch_id"],
+		NonceStr: params["nonce_str"],
+		Sign:     sign,
+		BillDate: billDate,
+		BillType: params["bill_type"],
+	}
+	data, err := util.PostXML(downloadBillGateway, req)
+	if err != nil {
+		global.SugarLog.Errorf("微信支付 - 下载对账单发生错误 billDate:%s, err:%s", billDate, err.Error())
+		return nil, err
+	}
+	// 下载失败时返回 xml，成功时返回文本格式的对账单
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<xml>")) {
+		var rsp downloadBillError
+		if err = xml.Unmarshal(data, &rsp); err != nil {
+			return nil, err
+		}
+		if rsp.ErrorCode == "20002" || strings.Contains(rsp.ReturnMsg, "No Bill Exist") {
+			return nil, nil
+		}
+		global.SugarLog.Errorf("微信支付 - 下载对账单失败 billDate:%s, rsp:%#v", billDate, rsp)
+		return nil, fmt.Errorf("下载对账单失败: %s", rsp.ReturnMsg)
+	}
+	return data, nil
+}
+
+// ParseBill 解析微信支付交易对账单，按表头名称读取需要的列，兼容下载和商户平台导出的文件
+// 每个字段以 ` 开头，交易明细之后的汇总数据会被忽略
+func ParseBill(r io.Reader) (rows []BillRow, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")] = i
+	}
+	index := func(names ...string) int {
+		for _, name := range names {
+			if i, ok := columns[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	var (
+		tradeTime     = index("交易时间")
+		transactionId = index("微信订单号")
+		orderSn       = index("商户订单号")
+		tradeState    = index("交易状态")
+		amount        = index("订单金额", "应结订单金额", "总金额")
+		refundId      = index("微信退款单号")
+		refundSn      = index("商户退款单号")
+		refundAmount  = index("申请退款金额", "退款金额")
+	)
+	if orderSn < 0 || tradeState < 0 || amount < 0 {
+		return nil, errors.New("对账单格式错误，缺少商户订单号、交易状态或订单金额")
+	}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimPrefix(strings.TrimSpace(record[i]), "`")
+		}
+		// 交易明细之后是汇总数据
+		if !strings.HasPrefix(strings.TrimSpace(record[0]), "`") && len(record) < len(header) {
+			break
+		}
+		row := BillRow{
+			TradeTime:     field(tradeTime),
+			TransactionId: field(transactionId),
+			OrderSn:       field(orderSn),
+			TradeState:    field(tradeState),
+			RefundId:      field(refundId),
+			RefundSn:      field(refundSn),
+		}
+		if row.Amount, err = parseBillAmount(field(amount)); err != nil {
+			return nil, fmt.Errorf("对账单第 %d 行订单金额错误: %s", line, field(amount))
+		}
+		if row.RefundAmount, err = parseBillAmount(field(refundAmount)); err != nil {
+			return nil, fmt.Errorf("对账单第 %d 行退款金额错误: %s", line, field(refundAmount))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseBillAmount 解析对账单中的金额，空值为 0
+func parseBillAmount(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
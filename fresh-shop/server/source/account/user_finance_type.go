@@ -0,0 +1,69 @@
+package account
+
+import (
+	"context"
+	"fresh-shop/server/model/account"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/system"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const initOrderFinanceType = system.InitOrderInternal + 1
+
+type initFinanceType struct{}
+
+// auto run
+func init() {
+	system.RegisterInit(initOrderFinanceType, &initFinanceType{})
+}
+
+func (i *initFinanceType) MigrateTable(ctx context.Context) (context.Context, error) {
+	db, ok := ctx.Value("db").(*gorm.DB)
+	if !ok {
+		return ctx, system.ErrMissingDBContext
+	}
+	return ctx, db.AutoMigrate(&account.UserFinanceType{})
+}
+
+func (i *initFinanceType) TableCreated(ctx context.Context) bool {
+	db, ok := ctx.Value("db").(*gorm.DB)
+	if !ok {
+		return false
+	}
+	return db.Migrator().HasTable(&account.UserFinanceType{})
+}
+
+func (i initFinanceType) InitializerName() string {
+	return account.UserFinanceType{}.TableName()
+}
+
+// InitializeData 按程序中固定的ID写入流水类型
+func (i *initFinanceType) InitializeData(ctx context.Context) (context.Context, error) {
+	db, ok := ctx.Value("db").(*gorm.DB)
+	if !ok {
+		return ctx, system.ErrMissingDBContext
+	}
+	if err := common.EnsureFinanceTypes(db); err != nil {
+		return ctx, errors.Wrap(err, account.UserFinanceType{}.TableName()+"表数据初始化失败!")
+	}
+	return ctx, nil
+}
+
+func (i *initFinanceType) DataInserted(ctx context.Context) bool {
+	db, ok := ctx.Value("db").(*gorm.DB)
+	if !ok {
+		return false
+	}
+	var count int64
+	db.Model(&account.UserFinanceType{}).Where("id in ?", financeTypeIds()).Count(&count)
+	return int(count) == len(common.FinanceTypes)
+}
+
+func financeTypeIds() []uint {
+	ids := make([]uint, 0, len(common.FinanceTypes))
+	for _, t := range common.FinanceTypes {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
@@ -55,6 +55,7 @@ func (i *initApi) InitializeData(ctx context.Context) (context.Context, error) {
 		{ApiGroup: "系统用户", Method: "GET", Path: "/user/getUserInfo", Description: "获取自身信息(必选)"},
 		{ApiGroup: "系统用户", Method: "POST", Path: "/user/setUserAuthorities", Description: "设置权限组"},
 		{ApiGroup: "系统用户", Method: "POST", Path: "/user/changePassword", Description: "修改密码（建议选择)"},
+		{ApiGroup: "系统用户", Method: "POST", Path: "/user/setSafePassword", Description: "设置安全密码"},
 		{ApiGroup: "系统用户", Method: "POST", Path: "/user/setUserAuthority", Description: "修改用户角色(必选)"},
 		{ApiGroup: "系统用户", Method: "POST", Path: "/user/resetPassword", Description: "重置用户密码"},
 
@@ -151,6 +152,13 @@ func (i *initApi) InitializeData(ctx context.Context) (context.Context, error) {
 		{ApiGroup: "按钮权限", Method: "POST", Path: "/authorityBtn/setAuthorityBtn", Description: "设置按钮权限"},
 		{ApiGroup: "按钮权限", Method: "POST", Path: "/authorityBtn/getAuthorityBtn", Description: "获取已有按钮权限"},
 		{ApiGroup: "按钮权限", Method: "POST", Path: "/authorityBtn/canRemoveAuthorityBtn", Description: "删除按钮"},
+
+		{ApiGroup: "送货员", Method: "GET", Path: "/deliverer/getDeliveryTaskList", Description: "获取配送任务列表"},
+		{ApiGroup: "送货员", Method: "GET", Path: "/deliverer/findDeliveryTask", Description: "查看配送任务"},
+		{ApiGroup: "送货员", Method: "POST", Path: "/deliverer/pickUpDelivery", Description: "确认取货"},
+		{ApiGroup: "送货员", Method: "POST", Path: "/deliverer/arriveDelivery", Description: "确认到达"},
+		{ApiGroup: "送货员", Method: "POST", Path: "/deliverer/deliverDelivery", Description: "确认送达"},
+		{ApiGroup: "送货员", Method: "POST", Path: "/deliverer/uploadDeliveryProof", Description: "上传送达凭证"},
 	}
 	if err := db.Create(&entities).Error; err != nil {
 		return ctx, errors.Wrap(err, sysModel.SysApi{}.TableName()+"表数据初始化失败!")
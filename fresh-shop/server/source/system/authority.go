@@ -47,6 +47,7 @@ func (i *initAuthority) InitializeData(ctx context.Context) (context.Context, er
 		{AuthorityId: 888, AuthorityName: "普通用户", ParentId: utils.Pointer[uint](0), DefaultRouter: "dashboard"},
 		{AuthorityId: 9528, AuthorityName: "测试角色", ParentId: utils.Pointer[uint](0), DefaultRouter: "dashboard"},
 		{AuthorityId: 8881, AuthorityName: "普通用户子角色", ParentId: utils.Pointer[uint](888), DefaultRouter: "dashboard"},
+		{AuthorityId: 2000, AuthorityName: "送货员", ParentId: utils.Pointer[uint](0), DefaultRouter: "dashboard"},
 	}
 
 	if err := db.Create(&entities).Error; err != nil {
@@ -58,6 +59,7 @@ func (i *initAuthority) InitializeData(ctx context.Context) (context.Context, er
 			{AuthorityId: 888},
 			{AuthorityId: 9528},
 			{AuthorityId: 8881},
+			{AuthorityId: 2000},
 		}); err != nil {
 		return ctx, errors.Wrapf(err, "%s表数据初始化失败!",
 			db.Model(&entities[0]).Association("DataAuthorityId").Relationship.JoinTable.Name)
@@ -79,6 +79,7 @@ func (i *initCasbin) InitializeData(ctx context.Context) (context.Context, error
 		{Ptype: "p", V0: "888", V1: "/user/getUserList", V2: "POST"},
 		{Ptype: "p", V0: "888", V1: "/user/deleteUser", V2: "DELETE"},
 		{Ptype: "p", V0: "888", V1: "/user/changePassword", V2: "POST"},
+		{Ptype: "p", V0: "888", V1: "/user/setSafePassword", V2: "POST"},
 		{Ptype: "p", V0: "888", V1: "/user/setUserAuthority", V2: "POST"},
 		{Ptype: "p", V0: "888", V1: "/user/setUserAuthorities", V2: "POST"},
 		{Ptype: "p", V0: "888", V1: "/user/resetPassword", V2: "POST"},
@@ -234,6 +235,16 @@ func (i *initCasbin) InitializeData(ctx context.Context) (context.Context, error
 		{Ptype: "p", V0: "9528", V1: "/customer/customerList", V2: "GET"},
 		{Ptype: "p", V0: "9528", V1: "/autoCode/createTemp", V2: "POST"},
 		{Ptype: "p", V0: "9528", V1: "/user/getUserInfo", V2: "GET"},
+
+		{Ptype: "p", V0: "2000", V1: "/user/getUserInfo", V2: "GET"},
+		{Ptype: "p", V0: "2000", V1: "/user/changePassword", V2: "POST"},
+		{Ptype: "p", V0: "2000", V1: "/jwt/jsonInBlacklist", V2: "POST"},
+		{Ptype: "p", V0: "2000", V1: "/deliverer/getDeliveryTaskList", V2: "GET"},
+		{Ptype: "p", V0: "2000", V1: "/deliverer/findDeliveryTask", V2: "GET"},
+		{Ptype: "p", V0: "2000", V1: "/deliverer/pickUpDelivery", V2: "POST"},
+		{Ptype: "p", V0: "2000", V1: "/deliverer/arriveDelivery", V2: "POST"},
+		{Ptype: "p", V0: "2000", V1: "/deliverer/deliverDelivery", V2: "POST"},
+		{Ptype: "p", V0: "2000", V1: "/deliverer/uploadDeliveryProof", V2: "POST"},
 	}
 	if err := db.Create(&entities).Error; err != nil {
 		return ctx, errors.Wrap(err, "Casbin 表 ("+i.InitializerName()+") 数据初始化失败!")
@@ -0,0 +1,61 @@
+package system
+
+import (
+	"context"
+	sysModel "fresh-shop/server/model/system"
+	"fresh-shop/server/service/common"
+	"fresh-shop/server/service/system"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const initOrderSysConfig = system.InitOrderInternal + 1
+
+type initSysConfig struct{}
+
+// auto run
+func init() {
+	system.RegisterInit(initOrderSysConfig, &initSysConfig{})
+}
+
+func (i *initSysConfig) MigrateTable(ctx context.Context) (context.Context, error) {
+	db, ok := ctx.Value("db").(*gorm.DB)
+	if !ok {
+		return ctx, system.ErrMissingDBContext
+	}
+	return ctx, db.AutoMigrate(&sysModel.SysConfig{})
+}
+
+func (i *initSysConfig) TableCreated(ctx context.Context) bool {
+	db, ok := ctx.Value("db").(*gorm.DB)
+	if !ok {
+		return false
+	}
+	return db.Migrator().HasTable(&sysModel.SysConfig{})
+}
+
+func (i initSysConfig) InitializerName() string {
+	return sysModel.SysConfig{}.TableName()
+}
+
+// InitializeData 写入程序中使用的配置参数及默认值
+func (i *initSysConfig) InitializeData(ctx context.Context) (context.Context, error) {
+	db, ok := ctx.Value("db").(*gorm.DB)
+	if !ok {
+		return ctx, system.ErrMissingDBContext
+	}
+	if err := common.EnsureSysConfigs(db); err != nil {
+		return ctx, errors.Wrap(err, sysModel.SysConfig{}.TableName()+"表数据初始化失败!")
+	}
+	return ctx, nil
+}
+
+func (i *initSysConfig) DataInserted(ctx context.Context) bool {
+	db, ok := ctx.Value("db").(*gorm.DB)
+	if !ok {
+		return false
+	}
+	var count int64
+	db.Model(&sysModel.SysConfig{}).Where("name = ?", "orderTimeout").Count(&count)
+	return count > 0
+}
@@ -35,3 +35,21 @@ func NewOss() OSS {
 		return &Local{}
 	}
 }
+
+// FileUrlPrefix 当前对象存储上传文件返回的访问地址前缀，用于校验客户端提交的文件地址
+func FileUrlPrefix() string {
+	switch global.Config.System.OssType {
+	case "qiniu":
+		return global.Config.Qiniu.ImgPath + "/"
+	case "tencent-cos":
+		return global.Config.TencentCOS.BaseURL + "/" + global.Config.TencentCOS.PathPrefix + "/"
+	case "aliyun-oss":
+		return global.Config.AliyunOSS.BucketUrl + "/"
+	case "huawei-obs":
+		return global.Config.HuaWeiObs.Path + "/"
+	case "aws-s3":
+		return global.Config.AwsS3.BaseURL + "/"
+	default:
+		return global.Config.Local.Path + "/"
+	}
+}